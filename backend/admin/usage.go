@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/audit"
+	"backend/handlers"
+	db "backend/database"
+)
+
+// UsageReport returns model usage, tokens and cost by day and model for
+// every user, or for one with ?user_id=
+func UsageReport(c *gin.Context) {
+	var userID int64
+	if v := c.Query("user_id"); v != "" {
+		id, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || id <= 0 {
+			handlers.ErrorResponse(c, http.StatusBadRequest, "Invalid user ID")
+			return
+		}
+		userID = id
+	}
+	from, to, ok := handlers.UsageRange(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	report, err := handlers.UsageReport(ctx, db.UsageFilter{UserID: userID, From: from, To: to})
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch usage")
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID:  userID,
+		ActorID: actorID(c),
+		Action:  audit.ActionUsageReported,
+		Detail:  map[string]any{"from": report["from"], "to": report["to"]},
+		IP:      c.ClientIP(),
+	})
+
+	handlers.SuccessResponse(c, report)
+}
+
+// UsageHistory returns a page of the user's generation log, as they see it
+// at /api/usage/history, so support can tell where their quota went
+func UsageHistory(c *gin.Context) {
+	userID, ok := userParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := getUser(ctx, userID); err == sql.ErrNoRows {
+		handlers.ErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	} else if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	history, ok := handlers.UsageHistory(c, userID)
+	if !ok {
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID:  userID,
+		ActorID: actorID(c),
+		Action:  audit.ActionUsageHistoryViewed,
+		Detail:  map[string]any{"from": history["from"], "to": history["to"], "bucket": history["bucket"]},
+		IP:      c.ClientIP(),
+	})
+
+	handlers.SuccessResponse(c, history)
+}
@@ -0,0 +1,579 @@
+// Package admin implements the /admin API used by support staff and admins
+// to inspect and manage user accounts. Every handler writes an audit entry.
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/audit"
+	"backend/auth"
+	"backend/handlers"
+	db "backend/database"
+)
+
+// Usage is the account's meal quota as last recorded. Used counts the
+// window the user was last active in.
+type Usage struct {
+	Used  int `json:"used"`
+	Limit int `json:"limit"`
+}
+
+// User is an account as shown to staff
+type User struct {
+	ID            int64   `json:"id"`
+	Email         string  `json:"email"`
+	Role          string  `json:"role"`
+	EmailVerified bool    `json:"email_verified"`
+	DisabledAt    *string `json:"disabled_at"`
+	LockedUntil   *string `json:"locked_until"`
+	CreatedAt     string  `json:"created_at"`
+	Plan          string  `json:"plan"`
+	Usage         Usage   `json:"usage"`
+}
+
+// UserDetail adds security state to User
+type UserDetail struct {
+	User
+	TwoFactorEnabled bool `json:"two_factor_enabled"`
+	ActiveSessions   int  `json:"active_sessions"`
+	ActiveAPIKeys    int  `json:"active_api_keys"`
+}
+
+// QuotaRequest overrides the plan's limits per window for one user.
+// Fields left out keep their current value.
+type QuotaRequest struct {
+	MaxMeals   *int     `json:"max_meals" binding:"omitempty,min=0"`
+	MaxTokens  *int64   `json:"max_tokens" binding:"omitempty,min=0"`
+	MaxCostUSD *float64 `json:"max_cost_usd" binding:"omitempty,min=0"`
+}
+
+type PlanRequest struct {
+	Plan string `json:"plan" binding:"required"`
+}
+
+type RoleRequest struct {
+	Role string `json:"role" binding:"required"`
+}
+
+type DisableRequest struct {
+	Reason string `json:"reason" binding:"max=500"`
+}
+
+// userColumns selects the fields scanned by scanUser from users u left
+// joined to users_tracking t and plans p
+const userColumns = `u.id, u.email, u.role, u.email_verified_at IS NOT NULL, u.disabled_at,
+	CASE WHEN u.locked_until > datetime('now') THEN u.locked_until END, u.created_at, u.plan_id,
+	COALESCE(t.meal_count, 0), COALESCE(t.meals_override, p.meals_per_period, 0) + COALESCE(t.rollover, 0)`
+
+const userFrom = `FROM users u LEFT JOIN users_tracking t ON t.user_id = u.id
+	LEFT JOIN plans p ON p.id = u.plan_id`
+
+func scanUser(row interface{ Scan(...any) error }) (*User, error) {
+	var u User
+	err := row.Scan(&u.ID, &u.Email, &u.Role, &u.EmailVerified, &u.DisabledAt,
+		&u.LockedUntil, &u.CreatedAt, &u.Plan, &u.Usage.Used, &u.Usage.Limit)
+	if err != nil {
+		return nil, err
+	}
+	return &u, nil
+}
+
+// userParam parses the :id route parameter
+func userParam(c *gin.Context) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Invalid user ID")
+		return 0, false
+	}
+	return id, true
+}
+
+// actorID is the staff member making the request
+func actorID(c *gin.Context) int64 {
+	id, _ := c.Get("user_id")
+	actor, _ := id.(int64)
+	return actor
+}
+
+// getUser loads a single account
+func getUser(ctx context.Context, userID int64) (*User, error) {
+	return scanUser(db.DB.QueryRowContext(ctx,
+		"SELECT "+userColumns+" "+userFrom+" WHERE u.id = ?",
+		userID,
+	))
+}
+
+// respondWithUser re-reads an account after a change and returns it
+func respondWithUser(c *gin.Context, ctx context.Context, userID int64) {
+	user, err := getUser(ctx, userID)
+	if err == sql.ErrNoRows {
+		handlers.ErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	handlers.SuccessResponse(c, gin.H{"user": user})
+}
+
+// ListUsers returns a page of accounts. ?q= searches email addresses,
+// ?role= and ?status=disabled|locked filter the results.
+func ListUsers(c *gin.Context) {
+	page, pageSize := handlers.Pagination(c)
+	query := strings.TrimSpace(c.Query("q"))
+	role := c.Query("role")
+	status := c.Query("status")
+
+	if role != "" && !auth.ValidRole(role) {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	where := []string{"1 = 1"}
+	var args []any
+	if query != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(query)
+		where = append(where, `u.email LIKE ? ESCAPE '\'`)
+		args = append(args, "%"+escaped+"%")
+	}
+	if role != "" {
+		where = append(where, "u.role = ?")
+		args = append(args, role)
+	}
+	switch status {
+	case "":
+	case "disabled":
+		where = append(where, "u.disabled_at IS NOT NULL")
+	case "locked":
+		where = append(where, "u.locked_until > datetime('now')")
+	default:
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Invalid status")
+		return
+	}
+	whereSQL := " WHERE " + strings.Join(where, " AND ")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var total int
+	if err := db.DB.QueryRowContext(ctx, "SELECT COUNT(*) "+userFrom+whereSQL, args...).Scan(&total); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	pageArgs := append(args, pageSize, (page-1)*pageSize)
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT "+userColumns+" "+userFrom+whereSQL+" ORDER BY u.id LIMIT ? OFFSET ?",
+		pageArgs...,
+	)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+
+	users := []*User{}
+	for rows.Next() {
+		u, err := scanUser(rows)
+		if err != nil {
+			handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to read users")
+			return
+		}
+		users = append(users, u)
+	}
+	if err := rows.Err(); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{
+		ActorID: actorID(c),
+		Action:  audit.ActionUsersSearched,
+		Detail:  map[string]any{"q": query, "role": role, "status": status, "page": page},
+		IP:      c.ClientIP(),
+	})
+
+	handlers.SuccessResponse(c, gin.H{
+		"users": users,
+		"pagination": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+			"total":     total,
+		},
+	})
+}
+
+// GetUser returns an account with its usage and security state
+func GetUser(c *gin.Context) {
+	userID, ok := userParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := getUser(ctx, userID)
+	if err == sql.ErrNoRows {
+		handlers.ErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	detail := UserDetail{User: *user}
+	err = db.DB.QueryRowContext(ctx,
+		`SELECT
+		   EXISTS(SELECT 1 FROM user_totp WHERE user_id = ? AND enabled_at IS NOT NULL),
+		   (SELECT COUNT(*) FROM sessions WHERE user_id = ? AND revoked_at IS NULL AND expires_at > datetime('now')),
+		   (SELECT COUNT(*) FROM api_keys WHERE user_id = ? AND revoked_at IS NULL
+		      AND (expires_at IS NULL OR expires_at > datetime('now')))`,
+		userID, userID, userID,
+	).Scan(&detail.TwoFactorEnabled, &detail.ActiveSessions, &detail.ActiveAPIKeys)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID:  userID,
+		ActorID: actorID(c),
+		Action:  audit.ActionUserViewed,
+		IP:      c.ClientIP(),
+	})
+
+	handlers.SuccessResponse(c, gin.H{"user": detail})
+}
+
+// SetQuota changes how many meals, tokens or dollars the user may use per
+// window, overriding their plan's limits
+func SetQuota(c *gin.Context) {
+	var req QuotaRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.MaxMeals == nil && req.MaxTokens == nil && req.MaxCostUSD == nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Set at least one of max_meals, max_tokens and max_cost_usd")
+		return
+	}
+
+	userID, ok := userParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	before, err := getUser(ctx, userID)
+	if err == sql.ErrNoRows {
+		handlers.ErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	var maxCost *db.Micros
+	if req.MaxCostUSD != nil {
+		cost := db.USD(*req.MaxCostUSD)
+		maxCost = &cost
+	}
+	if _, err := db.DB.ExecContext(ctx,
+		`INSERT INTO users_tracking (user_id, meal_count, meals_override, tokens_override, cost_override)
+		 VALUES (?, 0, ?, ?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET
+		   meals_override = COALESCE(excluded.meals_override, meals_override),
+		   tokens_override = COALESCE(excluded.tokens_override, tokens_override),
+		   cost_override = COALESCE(excluded.cost_override, cost_override),
+		   updated_at = datetime('now')`,
+		userID, req.MaxMeals, req.MaxTokens, maxCost,
+	); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to update quota")
+		return
+	}
+
+	detail := map[string]any{}
+	if req.MaxMeals != nil {
+		detail["from"], detail["to"] = before.Usage.Limit, *req.MaxMeals
+	}
+	if req.MaxTokens != nil {
+		detail["max_tokens"] = *req.MaxTokens
+	}
+	if req.MaxCostUSD != nil {
+		detail["max_cost_usd"] = *req.MaxCostUSD
+	}
+	audit.Record(ctx, audit.Entry{
+		UserID:  userID,
+		ActorID: actorID(c),
+		Action:  audit.ActionQuotaChanged,
+		Detail:  detail,
+		IP:      c.ClientIP(),
+	})
+
+	respondWithUser(c, ctx, userID)
+}
+
+// SetPlan moves the user to another subscription plan
+func SetPlan(c *gin.Context) {
+	var req PlanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, ok := userParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	before, err := getUser(ctx, userID)
+	if err == sql.ErrNoRows {
+		handlers.ErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if err := db.SetUserPlan(ctx, userID, req.Plan); err != nil {
+		if errors.Is(err, db.ErrUnknownPlan) {
+			handlers.ErrorResponse(c, http.StatusBadRequest, "Unknown plan")
+			return
+		}
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to update plan")
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID:  userID,
+		ActorID: actorID(c),
+		Action:  audit.ActionPlanChanged,
+		Detail:  map[string]any{"from": before.Plan, "to": req.Plan, "via": "admin"},
+		IP:      c.ClientIP(),
+	})
+
+	respondWithUser(c, ctx, userID)
+}
+
+// SetRole changes the user's role. Admins cannot change their own role, so
+// the last admin cannot lock everyone out by accident.
+func SetRole(c *gin.Context) {
+	var req RoleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !auth.ValidRole(req.Role) {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Invalid role")
+		return
+	}
+
+	userID, ok := userParam(c)
+	if !ok {
+		return
+	}
+	if userID == actorID(c) {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "You cannot change your own role")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	previous, err := auth.UserRole(ctx, userID)
+	if errors.Is(err, auth.ErrUserNotFound) {
+		handlers.ErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if err := auth.SetUserRole(ctx, userID, req.Role); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to update role")
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID:  userID,
+		ActorID: actorID(c),
+		Action:  audit.ActionRoleChanged,
+		Detail:  map[string]any{"from": previous, "to": req.Role},
+		IP:      c.ClientIP(),
+	})
+
+	respondWithUser(c, ctx, userID)
+}
+
+// DisableUser blocks the account and signs it out everywhere
+func DisableUser(c *gin.Context) {
+	var req DisableRequest
+	if err := c.ShouldBindJSON(&req); err != nil && !errors.Is(err, io.EOF) {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, ok := userParam(c)
+	if !ok {
+		return
+	}
+	if userID == actorID(c) {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "You cannot disable your own account")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := auth.DisableAccount(ctx, userID); err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			handlers.ErrorResponse(c, http.StatusNotFound, "User not found")
+			return
+		}
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to disable account")
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID:  userID,
+		ActorID: actorID(c),
+		Action:  audit.ActionAccountDisabled,
+		Detail:  map[string]any{"reason": req.Reason},
+		IP:      c.ClientIP(),
+	})
+
+	respondWithUser(c, ctx, userID)
+}
+
+// EnableUser lifts DisableUser
+func EnableUser(c *gin.Context) {
+	userID, ok := userParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := auth.EnableAccount(ctx, userID); err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			handlers.ErrorResponse(c, http.StatusNotFound, "User not found")
+			return
+		}
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to enable account")
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID:  userID,
+		ActorID: actorID(c),
+		Action:  audit.ActionAccountEnabled,
+		IP:      c.ClientIP(),
+	})
+
+	respondWithUser(c, ctx, userID)
+}
+
+// UnlockUser lifts a brute-force lockout
+func UnlockUser(c *gin.Context) {
+	userID, ok := userParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := auth.UnlockAccount(ctx, userID); err != nil {
+		if errors.Is(err, auth.ErrUserNotFound) {
+			handlers.ErrorResponse(c, http.StatusNotFound, "User not found")
+			return
+		}
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to unlock account")
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID:  userID,
+		ActorID: actorID(c),
+		Action:  audit.ActionAccountUnlocked,
+		Detail:  map[string]any{"via": "admin"},
+		IP:      c.ClientIP(),
+	})
+
+	respondWithUser(c, ctx, userID)
+}
+
+// Impersonate signs the caller in as a regular user for auth.ImpersonationTTL
+// so support can see what they see. The session is marked with the staff
+// member's ID and cannot reach account settings.
+func Impersonate(c *gin.Context) {
+	userID, ok := userParam(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	user, err := getUser(ctx, userID)
+	if err == sql.ErrNoRows {
+		handlers.ErrorResponse(c, http.StatusNotFound, "User not found")
+		return
+	}
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if user.Role != auth.RoleUser {
+		handlers.ErrorResponse(c, http.StatusForbidden, "Staff accounts cannot be impersonated")
+		return
+	}
+	if user.DisabledAt != nil {
+		handlers.ErrorResponse(c, http.StatusConflict, "Account is disabled")
+		return
+	}
+
+	// Record before handing over the session so it is never untraced
+	if err := audit.Record(ctx, audit.Entry{
+		UserID:  userID,
+		ActorID: actorID(c),
+		Action:  audit.ActionImpersonated,
+		Detail:  map[string]any{"ttl": auth.ImpersonationTTL.String()},
+		IP:      c.ClientIP(),
+	}); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to record impersonation")
+		return
+	}
+
+	if err := auth.StartImpersonation(c, userID, user.Email, actorID(c)); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to start session")
+		return
+	}
+
+	handlers.SuccessResponse(c, gin.H{
+		"message":    "Now signed in as " + user.Email + ". Log out to end impersonation.",
+		"user":       user,
+		"expires_in": int(auth.ImpersonationTTL.Seconds()),
+	})
+}
@@ -0,0 +1,75 @@
+package audit
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	db "backend/database"
+)
+
+// Actions recorded in audit_log
+const (
+	ActionAccountLocked      = "account_locked"
+	ActionAccountUnlocked    = "account_unlocked"
+	ActionTwoFactorEnabled   = "two_factor_enabled"
+	ActionTwoFactorDisabled  = "two_factor_disabled"
+	ActionAccountDisabled    = "account_disabled"
+	ActionAccountEnabled     = "account_enabled"
+	ActionQuotaChanged       = "quota_changed"
+	ActionRoleChanged        = "role_changed"
+	ActionImpersonated       = "impersonation_started"
+	ActionUsersSearched      = "users_searched"
+	ActionUserViewed         = "user_viewed"
+	ActionIdentityLinked     = "identity_linked"
+	ActionIdentityUnlinked   = "identity_unlinked"
+	ActionPasswordChanged    = "password_changed"
+	ActionEmailChanged       = "email_changed"
+	ActionAccountDeleted     = "account_deleted"
+	ActionDataExported       = "data_exported"
+	ActionPlanChanged        = "plan_changed"
+	ActionUsageReported      = "usage_reported"
+	ActionUsageHistoryViewed = "usage_history_viewed"
+)
+
+// Entry is a single audit_log row. UserID is the account affected and
+// ActorID the account that performed the action, when they differ.
+type Entry struct {
+	UserID  int64
+	ActorID int64
+	Action  string
+	Detail  map[string]any
+	IP      string
+}
+
+// nullID stores zero IDs as NULL
+func nullID(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+// Record writes an entry to audit_log. Failures are logged and returned so
+// callers can decide whether the action must be aborted.
+func Record(ctx context.Context, e Entry) error {
+	detail := ""
+	if len(e.Detail) > 0 {
+		data, err := json.Marshal(e.Detail)
+		if err != nil {
+			return err
+		}
+		detail = string(data)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+
+	_, err := db.DB.ExecContext(ctx,
+		"INSERT INTO audit_log (user_id, actor_id, action, detail, ip_address) VALUES (?, ?, ?, ?, ?)",
+		nullID(e.UserID), nullID(e.ActorID), e.Action, detail, e.IP,
+	)
+	if err != nil {
+		log.Printf("Failed to write audit entry %s for user %d: %v", e.Action, e.UserID, err)
+	}
+	return err
+}
@@ -0,0 +1,365 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/audit"
+	"backend/billing"
+	"backend/handlers"
+	"backend/mail"
+	db "backend/database"
+)
+
+type ChangePasswordRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+	NewPassword     string `json:"new_password" binding:"required,min=6"`
+}
+
+type ChangeEmailRequest struct {
+	Email           string `json:"email" binding:"required,email"`
+	CurrentPassword string `json:"current_password" binding:"required"`
+}
+
+type DeleteAccountRequest struct {
+	CurrentPassword string `json:"current_password" binding:"required"`
+}
+
+// userDataDeletes erase everything stored about a user, children first.
+// They do not rely on ON DELETE CASCADE, which is only enforced when the
+// database has foreign keys switched on. New per-user tables must be added
+// here.
+var userDataDeletes = []string{
+	"DELETE FROM messages WHERE conversation_id IN (SELECT id FROM conversations WHERE user_id = ?)",
+	"DELETE FROM conversations WHERE user_id = ?",
+	"DELETE FROM recipes WHERE user_id = ?",
+	"DELETE FROM user_preference WHERE user_id = ?",
+	"DELETE FROM users_tracking WHERE user_id = ?",
+	"DELETE FROM quota_reservations WHERE user_id = ?",
+	"DELETE FROM llm_usage_events WHERE user_id = ?",
+	"DELETE FROM generation_log WHERE user_id = ?",
+	"DELETE FROM checkout_sessions WHERE user_id = ?",
+	"DELETE FROM subscriptions WHERE user_id = ?",
+	"DELETE FROM billing_events WHERE user_id = ?",
+	"DELETE FROM sessions WHERE user_id = ?",
+	"DELETE FROM password_resets WHERE user_id = ?",
+	"DELETE FROM email_verifications WHERE user_id = ?",
+	"DELETE FROM user_recovery_codes WHERE user_id = ?",
+	"DELETE FROM user_totp WHERE user_id = ?",
+	"DELETE FROM api_keys WHERE user_id = ?",
+	"DELETE FROM user_identities WHERE user_id = ?",
+	"DELETE FROM oidc_states WHERE link_user_id = ?",
+	"DELETE FROM data_exports WHERE user_id = ?",
+	// Entries about the user go; entries about others they acted on as
+	// staff stay, without naming them
+	"DELETE FROM audit_log WHERE user_id = ?",
+	"UPDATE audit_log SET actor_id = NULL WHERE actor_id = ?",
+	"DELETE FROM users WHERE id = ?",
+}
+
+// checkCurrentPassword re-verifies the logged in user's password before a
+// sensitive change, with the same throttling as Login. It writes the error
+// response and returns false when the change must not go ahead.
+func checkCurrentPassword(c *gin.Context, ctx context.Context, userID int64, password string) (email string, ok bool) {
+	var hashedPassword string
+	if err := db.DB.QueryRowContext(ctx,
+		"SELECT email, hashed_password FROM users WHERE id = ?",
+		userID,
+	).Scan(&email, &hashedPassword); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return "", false
+	}
+
+	// Accounts created through an identity provider have no password
+	if hashedPassword == "" {
+		handlers.ErrorResponse(c, http.StatusConflict, "This account has no password. Set one with Forgot Password first.")
+		return "", false
+	}
+
+	ip := c.ClientIP()
+	wait, err := Guard.Check(ctx, ip, email)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to check login attempts")
+		return "", false
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		handlers.ErrorResponse(c, http.StatusTooManyRequests, "Too many attempts. Please try again later.")
+		return "", false
+	}
+
+	if err := VerifyPassword(hashedPassword, password); err != nil {
+		Guard.Fail(ctx, ip, email, userID)
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "Current password is incorrect")
+		return "", false
+	}
+	Guard.Succeed(ctx, email)
+	return email, true
+}
+
+// notifyAccountChange tells the user about a security relevant change. A
+// mail failure does not undo the change, so it is only logged.
+func notifyAccountChange(ctx context.Context, to, subject, body string) {
+	err := mail.Send(ctx, mail.Message{
+		To:      to,
+		Subject: subject,
+		Body:    body + "\n\nIf this wasn't you, reset your password and contact support.\n",
+	})
+	if err != nil {
+		log.Printf("Failed to send %q notification: %v", subject, err)
+	}
+}
+
+// ChangePassword sets a new password after re-verifying the current one.
+// Every session is signed out and the caller gets a fresh one.
+func ChangePassword(c *gin.Context) {
+	var req ChangePasswordRequest
+
+	// 1. Validate input
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	// 2. Re-verify the current password
+	email, ok := checkCurrentPassword(c, ctx, userID.(int64), req.CurrentPassword)
+	if !ok {
+		return
+	}
+
+	// 3. Hash the new password
+	hashedPassword, err := HashPassword(req.NewPassword)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+
+	// 4. Update it and sign out everywhere
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET hashed_password = ?, updated_at = datetime('now') WHERE id = ?",
+		hashedPassword, userID,
+	); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to update password")
+		return
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = datetime('now') WHERE user_id = ? AND revoked_at IS NULL",
+		userID,
+	); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to update password")
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{UserID: userID.(int64), Action: audit.ActionPasswordChanged, IP: c.ClientIP()})
+	notifyAccountChange(ctx, email, "Your password was changed", "The password for your account was just changed.")
+
+	// 5. Keep the caller logged in with a new session
+	if err := StartSession(c, userID.(int64), email); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	handlers.SuccessResponse(c, gin.H{
+		"message": "Password changed. Other devices have been signed out.",
+	})
+}
+
+// ChangeEmail moves the account to a new email address after re-verifying
+// the password. The new address must be verified again, the old one is
+// told about the change and every session is signed out.
+func ChangeEmail(c *gin.Context) {
+	var req ChangeEmailRequest
+
+	// 1. Validate input
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	// 2. Re-verify the current password
+	oldEmail, ok := checkCurrentPassword(c, ctx, userID.(int64), req.CurrentPassword)
+	if !ok {
+		return
+	}
+	if strings.EqualFold(req.Email, oldEmail) {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "New email is the same as the current one")
+		return
+	}
+
+	// 3. The address must be free
+	var taken bool
+	if err := db.DB.QueryRowContext(ctx, "SELECT EXISTS(SELECT 1 FROM users WHERE email = ?)", req.Email).Scan(&taken); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if taken {
+		handlers.ErrorResponse(c, http.StatusConflict, "Email already in use")
+		return
+	}
+
+	// 4. Update it and sign out everywhere
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET email = ?, email_verified_at = NULL, updated_at = datetime('now') WHERE id = ?",
+		req.Email, userID,
+	); err != nil {
+		if strings.Contains(err.Error(), "UNIQUE") {
+			handlers.ErrorResponse(c, http.StatusConflict, "Email already in use")
+			return
+		}
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to update email")
+		return
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = datetime('now') WHERE user_id = ? AND revoked_at IS NULL",
+		userID,
+	); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to update email")
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID: userID.(int64),
+		Action: audit.ActionEmailChanged,
+		Detail: map[string]any{"from": oldEmail, "to": req.Email},
+		IP:     c.ClientIP(),
+	})
+	notifyAccountChange(ctx, oldEmail, "Your email address was changed",
+		"The email address for your account was changed to "+req.Email+".")
+	if err := sendVerificationEmail(ctx, userID.(int64), req.Email); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", userID, err)
+	}
+
+	// 5. Keep the caller logged in; the access token carries the email
+	if err := StartSession(c, userID.(int64), req.Email); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	handlers.SuccessResponse(c, gin.H{
+		"message": "Email changed. Please confirm the new address.",
+		"user": gin.H{
+			"id":             userID,
+			"email":          req.Email,
+			"email_verified": false,
+		},
+	})
+}
+
+// DeleteAccount permanently erases the user and everything stored about
+// them after re-verifying the password. A paid subscription is cancelled
+// first; if that fails nothing is deleted.
+func DeleteAccount(c *gin.Context) {
+	var req DeleteAccountRequest
+
+	// 1. Validate input
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	// 2. Re-verify the current password
+	email, ok := checkCurrentPassword(c, ctx, userID.(int64), req.CurrentPassword)
+	if !ok {
+		return
+	}
+
+	// 3. Stop billing before the subscription is forgotten
+	if err := billing.CancelSubscription(ctx, userID.(int64)); err != nil {
+		log.Printf("Failed to cancel the subscription of user %d: %v", userID, err)
+		if errors.Is(err, billing.ErrNotConfigured) {
+			handlers.ErrorResponse(c, http.StatusServiceUnavailable, "Your subscription cannot be cancelled right now")
+			return
+		}
+		handlers.ErrorResponse(c, http.StatusBadGateway, "Failed to cancel your subscription, please try again")
+		return
+	}
+
+	// 4. Erase everything in one transaction
+	if err := deleteUserData(ctx, userID.(int64)); err != nil {
+		log.Printf("Failed to delete user %d: %v", userID, err)
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to delete account")
+		return
+	}
+
+	// Only the ID is kept, as proof the erasure happened
+	audit.Record(ctx, audit.Entry{UserID: userID.(int64), Action: audit.ActionAccountDeleted, IP: c.ClientIP()})
+	notifyAccountChange(ctx, email, "Your account was deleted", "Your account and all of its data have been deleted.")
+
+	clearAuthCookies(c)
+
+	handlers.SuccessResponse(c, gin.H{
+		"message": "Account deleted",
+	})
+}
+
+// deleteUserData runs userDataDeletes for a user
+func deleteUserData(ctx context.Context, userID int64) error {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range userDataDeletes {
+		if _, err := tx.ExecContext(ctx, stmt, userID); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"slices"
+
+	db "backend/database"
+)
+
+// Roles a user can hold. Support staff can inspect and impersonate users;
+// admins can also change quotas, roles and account status.
+const (
+	RoleUser    = "user"
+	RoleSupport = "support"
+	RoleAdmin   = "admin"
+)
+
+// Roles lists every valid role
+var Roles = []string{RoleUser, RoleSupport, RoleAdmin}
+
+// ValidRole reports whether role is one of Roles
+func ValidRole(role string) bool {
+	return slices.Contains(Roles, role)
+}
+
+// UserRole returns the user's current role
+func UserRole(ctx context.Context, userID int64) (string, error) {
+	var role string
+	err := db.DB.QueryRowContext(ctx, "SELECT role FROM users WHERE id = ?", userID).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", ErrUserNotFound
+	}
+	return role, err
+}
+
+// SetUserRole changes the user's role
+func SetUserRole(ctx context.Context, userID int64, role string) error {
+	result, err := db.DB.ExecContext(ctx,
+		"UPDATE users SET role = ?, updated_at = datetime('now') WHERE id = ?",
+		role, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
+
+// SetRoleByEmail changes a role by email address, for bootstrapping the
+// first admin from the command line
+func SetRoleByEmail(ctx context.Context, email, role string) error {
+	var userID int64
+	err := db.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE email = ?", email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return SetUserRole(ctx, userID, role)
+}
+
+// DisableAccount blocks logins and API keys for the user and signs them out
+// everywhere
+func DisableAccount(ctx context.Context, userID int64) error {
+	result, err := db.DB.ExecContext(ctx,
+		`UPDATE users SET disabled_at = COALESCE(disabled_at, datetime('now')),
+		 updated_at = datetime('now') WHERE id = ?`,
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return RevokeUserSessions(ctx, userID)
+}
+
+// EnableAccount reverses DisableAccount. Revoked sessions stay revoked.
+func EnableAccount(ctx context.Context, userID int64) error {
+	result, err := db.DB.ExecContext(ctx,
+		"UPDATE users SET disabled_at = NULL, updated_at = datetime('now') WHERE id = ?",
+		userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return ErrUserNotFound
+	}
+	return nil
+}
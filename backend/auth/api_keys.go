@@ -0,0 +1,315 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/handlers"
+	db "backend/database"
+)
+
+// Scopes an API key can be granted. Cookie sessions have every scope.
+const (
+	ScopeLLM                = "llm"
+	ScopeProfileRead        = "profile:read"
+	ScopePreferencesRead    = "preferences:read"
+	ScopePreferencesWrite   = "preferences:write"
+	ScopeUsageRead          = "usage:read"
+	ScopeConversationsRead  = "conversations:read"
+	ScopeConversationsWrite = "conversations:write"
+	ScopeRecipesRead        = "recipes:read"
+	ScopeRecipesWrite       = "recipes:write"
+)
+
+// APIKeyScopes lists every valid scope
+var APIKeyScopes = []string{
+	ScopeLLM,
+	ScopeProfileRead,
+	ScopePreferencesRead,
+	ScopePreferencesWrite,
+	ScopeUsageRead,
+	ScopeConversationsRead,
+	ScopeConversationsWrite,
+	ScopeRecipesRead,
+	ScopeRecipesWrite,
+}
+
+const (
+	// apiKeyPrefix marks our keys so they are easy to spot in leaked logs
+	// and can be told apart from other bearer tokens
+	apiKeyPrefix = "aik_"
+	// MaxAPIKeysPerUser bounds the number of active keys a user may hold
+	MaxAPIKeysPerUser = 25
+	// MaxAPIKeyLifetimeDays is the longest expiry a key can be created with
+	MaxAPIKeyLifetimeDays = 365
+	// apiKeyTouchInterval limits how often last_used_at is written for a
+	// busy key
+	apiKeyTouchInterval = time.Minute
+)
+
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+type CreateAPIKeyRequest struct {
+	Name   string   `json:"name" binding:"required,max=100"`
+	Scopes []string `json:"scopes" binding:"required,min=1"`
+	// ExpiresInDays is optional; zero means the key never expires
+	ExpiresInDays int `json:"expires_in_days" binding:"min=0"`
+}
+
+// APIKey is a key as shown to its owner. The secret is never stored.
+type APIKey struct {
+	ID         int64    `json:"id"`
+	Name       string   `json:"name"`
+	Prefix     string   `json:"prefix"`
+	Scopes     []string `json:"scopes"`
+	ExpiresAt  *string  `json:"expires_at"`
+	LastUsedAt *string  `json:"last_used_at"`
+	CreatedAt  string   `json:"created_at"`
+}
+
+// APIKeyIdentity is who a valid key authenticates as
+type APIKeyIdentity struct {
+	KeyID  int64
+	UserID int64
+	Email  string
+	Scopes []string
+}
+
+// HasScope reports whether the key was granted scope
+func (k *APIKeyIdentity) HasScope(scope string) bool {
+	return slices.Contains(k.Scopes, scope)
+}
+
+// IsAPIKey reports whether a bearer token looks like one of our API keys
+func IsAPIKey(token string) bool {
+	return strings.HasPrefix(token, apiKeyPrefix)
+}
+
+// newAPIKey returns a key of the form aik_<id>_<secret>, its visible prefix
+// (aik_<id>) and its hash
+func newAPIKey() (key, prefix, hash string, err error) {
+	id := make([]byte, 6)
+	secret := make([]byte, 32)
+	if _, err := rand.Read(id); err != nil {
+		return "", "", "", err
+	}
+	if _, err := rand.Read(secret); err != nil {
+		return "", "", "", err
+	}
+
+	prefix = apiKeyPrefix + hex.EncodeToString(id)
+	key = prefix + "_" + base64.RawURLEncoding.EncodeToString(secret)
+	return key, prefix, hashToken(key), nil
+}
+
+// AuthenticateAPIKey resolves a presented key to its user, rejecting revoked
+// and expired keys and disabled accounts, and records when it was last used
+func AuthenticateAPIKey(ctx context.Context, key string) (*APIKeyIdentity, error) {
+	var identity APIKeyIdentity
+	var scopes string
+	var stale bool
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT k.id, k.user_id, u.email, k.scopes,
+		        COALESCE(k.last_used_at < datetime('now', ?), 1)
+		 FROM api_keys k JOIN users u ON u.id = k.user_id
+		 WHERE k.key_hash = ? AND k.revoked_at IS NULL
+		   AND (k.expires_at IS NULL OR k.expires_at > datetime('now'))
+		   AND u.disabled_at IS NULL`,
+		sqlOffset(-apiKeyTouchInterval), hashToken(key),
+	).Scan(&identity.KeyID, &identity.UserID, &identity.Email, &scopes, &stale)
+	if err == sql.ErrNoRows {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	identity.Scopes = strings.Fields(scopes)
+
+	if stale {
+		if _, err := db.DB.ExecContext(ctx,
+			"UPDATE api_keys SET last_used_at = datetime('now') WHERE id = ?",
+			identity.KeyID,
+		); err != nil {
+			return nil, err
+		}
+	}
+
+	return &identity, nil
+}
+
+// validateScopes rejects unknown scopes and removes duplicates
+func validateScopes(scopes []string) ([]string, error) {
+	var valid []string
+	for _, scope := range scopes {
+		if !slices.Contains(APIKeyScopes, scope) {
+			return nil, errors.New("unknown scope: " + scope)
+		}
+		if !slices.Contains(valid, scope) {
+			valid = append(valid, scope)
+		}
+	}
+	return valid, nil
+}
+
+// CreateAPIKey issues a new API key for the current user. The full key is
+// only returned in this response.
+func CreateAPIKey(c *gin.Context) {
+	var req CreateAPIKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	scopes, err := validateScopes(req.Scopes)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.ExpiresInDays > MaxAPIKeyLifetimeDays {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "expires_in_days must be at most "+strconv.Itoa(MaxAPIKeyLifetimeDays))
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var active int
+	if err := db.DB.QueryRowContext(ctx,
+		`SELECT COUNT(*) FROM api_keys WHERE user_id = ? AND revoked_at IS NULL
+		 AND (expires_at IS NULL OR expires_at > datetime('now'))`,
+		userID,
+	).Scan(&active); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if active >= MaxAPIKeysPerUser {
+		handlers.ErrorResponse(c, http.StatusConflict, "API key limit reached. Revoke an unused key first.")
+		return
+	}
+
+	key, prefix, hash, err := newAPIKey()
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate API key")
+		return
+	}
+
+	var expiresAt sql.NullString
+	if req.ExpiresInDays > 0 {
+		expiresAt = sql.NullString{String: sqlOffset(time.Duration(req.ExpiresInDays) * 24 * time.Hour), Valid: true}
+	}
+
+	var apiKey APIKey
+	err = db.DB.QueryRowContext(ctx,
+		`INSERT INTO api_keys (user_id, name, prefix, key_hash, scopes, expires_at)
+		 VALUES (?, ?, ?, ?, ?, CASE WHEN ? IS NULL THEN NULL ELSE datetime('now', ?) END)
+		 RETURNING id, created_at, expires_at`,
+		userID, req.Name, prefix, hash, strings.Join(scopes, " "), expiresAt, expiresAt,
+	).Scan(&apiKey.ID, &apiKey.CreatedAt, &apiKey.ExpiresAt)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to create API key")
+		return
+	}
+	apiKey.Name = req.Name
+	apiKey.Prefix = prefix
+	apiKey.Scopes = scopes
+
+	handlers.SuccessResponse(c, gin.H{
+		"api_key": apiKey,
+		"key":     key,
+		"message": "Store this key now; it will not be shown again",
+	})
+}
+
+// ListAPIKeys returns the current user's active keys, without secrets
+func ListAPIKeys(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT id, name, prefix, scopes, expires_at, last_used_at, created_at
+		 FROM api_keys WHERE user_id = ? AND revoked_at IS NULL
+		 ORDER BY created_at DESC, id DESC`,
+		userID,
+	)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		var k APIKey
+		var scopes string
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &scopes, &k.ExpiresAt, &k.LastUsedAt, &k.CreatedAt); err != nil {
+			handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to read API keys")
+			return
+		}
+		k.Scopes = strings.Fields(scopes)
+		keys = append(keys, k)
+	}
+	if err := rows.Err(); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	handlers.SuccessResponse(c, gin.H{
+		"api_keys": keys,
+		"scopes":   APIKeyScopes,
+	})
+}
+
+// RevokeAPIKey permanently disables one of the current user's keys
+func RevokeAPIKey(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	keyID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || keyID <= 0 {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Invalid API key ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx,
+		"UPDATE api_keys SET revoked_at = datetime('now') WHERE id = ? AND user_id = ? AND revoked_at IS NULL",
+		keyID, userID,
+	)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke API key")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		handlers.ErrorResponse(c, http.StatusNotFound, "API key not found")
+		return
+	}
+
+	handlers.SuccessResponse(c, gin.H{"message": "API key revoked"})
+}
@@ -0,0 +1,129 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/audit"
+	"backend/handlers"
+	db "backend/database"
+)
+
+// Identity is an external account linked to the user
+type Identity struct {
+	ID          int64   `json:"id"`
+	Provider    string  `json:"provider"`
+	Email       *string `json:"email"`
+	CreatedAt   string  `json:"created_at"`
+	LastLoginAt *string `json:"last_login_at"`
+}
+
+// ListIdentities returns the current user's linked external accounts
+func ListIdentities(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT id, provider, email, created_at, last_login_at FROM user_identities
+		 WHERE user_id = ? ORDER BY created_at, id`,
+		userID,
+	)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+
+	identities := []Identity{}
+	for rows.Next() {
+		var i Identity
+		if err := rows.Scan(&i.ID, &i.Provider, &i.Email, &i.CreatedAt, &i.LastLoginAt); err != nil {
+			handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to read identities")
+			return
+		}
+		identities = append(identities, i)
+	}
+	if err := rows.Err(); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	handlers.SuccessResponse(c, gin.H{"identities": identities})
+}
+
+// UnlinkIdentity removes a linked external account. The last one cannot be
+// removed from an account without a password, which would lock the user out.
+func UnlinkIdentity(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	identityID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || identityID <= 0 {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Invalid identity ID")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer tx.Rollback()
+
+	var provider string
+	var hasPassword bool
+	var count int
+	err = tx.QueryRowContext(ctx,
+		`SELECT i.provider, u.hashed_password != '',
+		        (SELECT COUNT(*) FROM user_identities WHERE user_id = u.id)
+		 FROM user_identities i JOIN users u ON u.id = i.user_id
+		 WHERE i.id = ? AND i.user_id = ?`,
+		identityID, userID,
+	).Scan(&provider, &hasPassword, &count)
+	if err == sql.ErrNoRows {
+		handlers.ErrorResponse(c, http.StatusNotFound, "Identity not found")
+		return
+	}
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if !hasPassword && count == 1 {
+		handlers.ErrorResponse(c, http.StatusConflict, "Set a password before removing your only sign-in method")
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_identities WHERE id = ?", identityID); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to unlink identity")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to unlink identity")
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID: userID.(int64),
+		Action: audit.ActionIdentityUnlinked,
+		Detail: map[string]any{"provider": provider},
+		IP:     c.ClientIP(),
+	})
+
+	handlers.SuccessResponse(c, gin.H{"message": "Identity unlinked"})
+}
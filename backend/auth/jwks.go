@@ -0,0 +1,22 @@
+package auth
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"backend/handlers"
+)
+
+// JWKS publishes the public keys tokens may be signed with so other
+// services can verify them. The body is a bare RFC 7517 key set rather than
+// the usual status envelope.
+func JWKS(c *gin.Context) {
+	ring, err := keyring()
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Signing keys unavailable")
+		return
+	}
+
+	c.Header("Cache-Control", "public, max-age=300")
+	c.JSON(http.StatusOK, ring.JWKS())
+}
@@ -2,7 +2,6 @@ package auth
 
 import (
 	"errors"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
@@ -10,31 +9,52 @@ import (
 
 // Custom claims structure
 type Claims struct {
-	UserID int64  `json:"user_id"`
-	Email  string `json:"email"`
+	UserID    int64  `json:"user_id"`
+	Email     string `json:"email"`
+	SessionID int64  `json:"sid"`
 	jwt.RegisteredClaims
 }
 
-// Get JWT secret from environment (fallback to default for dev)
-func getJWTSecret() []byte {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		// Default secret for development only
-		// In production, JWT_SECRET MUST be set in environment
-		secret = "dev-secret-change-in-production"
+// AccessTokenTTL is the lifetime of an access token. Clients renew it with
+// the refresh token via POST /auth/refresh.
+const AccessTokenTTL = 15 * time.Minute
+
+// signClaims signs claims with the current key from the keyring
+func signClaims(claims jwt.Claims) (string, error) {
+	ring, err := keyring()
+	if err != nil {
+		return "", err
 	}
-	return []byte(secret)
+	return ring.Sign(claims)
 }
 
-// GenerateToken creates a new JWT token for a user
-func GenerateToken(userID int64, email string) (string, error) {
-	// Token expires in 24 hours
-	expirationTime := time.Now().Add(24 * time.Hour)
+// parseClaims verifies a token against every key in the keyring
+func parseClaims(tokenString string, options ...jwt.ParserOption) (*Claims, error) {
+	ring, err := keyring()
+	if err != nil {
+		return nil, err
+	}
+
+	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, ring.Keyfunc, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
+		return claims, nil
+	}
+	return nil, errors.New("invalid token")
+}
+
+// GenerateToken creates a new short-lived JWT access token bound to a session
+func GenerateToken(userID int64, email string, sessionID int64) (string, error) {
+	expirationTime := time.Now().Add(AccessTokenTTL)
 
 	// Create claims
 	claims := &Claims{
-		UserID: userID,
-		Email:  email,
+		UserID:    userID,
+		Email:     email,
+		SessionID: sessionID,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
@@ -42,37 +62,51 @@ func GenerateToken(userID int64, email string) (string, error) {
 		},
 	}
 
-	// Create token with claims
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Sign token with secret
-	tokenString, err := token.SignedString(getJWTSecret())
-	if err != nil {
-		return "", err
-	}
-
-	return tokenString, nil
+	return signClaims(claims)
 }
 
 // VerifyToken validates a JWT token and returns the claims
 func VerifyToken(tokenString string) (*Claims, error) {
-	// Parse token
-	token, err := jwt.ParseWithClaims(tokenString, &Claims{}, func(token *jwt.Token) (interface{}, error) {
-		// Validate signing method
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, errors.New("invalid signing method")
-		}
-		return getJWTSecret(), nil
-	})
-
+	claims, err := parseClaims(tokenString)
 	if err != nil {
 		return nil, err
 	}
 
-	// Extract claims
-	if claims, ok := token.Claims.(*Claims); ok && token.Valid {
-		return claims, nil
+	// Challenge tokens carry an audience and must not be accepted in place
+	// of an access token
+	if len(claims.Audience) > 0 {
+		return nil, errors.New("invalid token")
 	}
+	return claims, nil
+}
 
-	return nil, errors.New("invalid token")
+// ChallengeTokenTTL is how long a user has to enter their second factor
+// after a correct password
+const ChallengeTokenTTL = 5 * time.Minute
+
+// challengeAudience marks tokens that only prove the password step of a
+// two-factor login
+const challengeAudience = "2fa-challenge"
+
+// GenerateChallengeToken creates a token proving the user passed the password
+// step. It cannot be used as an access token.
+func GenerateChallengeToken(userID int64, email string) (string, error) {
+	now := time.Now()
+	claims := &Claims{
+		UserID: userID,
+		Email:  email,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ExpiresAt: jwt.NewNumericDate(now.Add(ChallengeTokenTTL)),
+			IssuedAt:  jwt.NewNumericDate(now),
+			Issuer:    "startup-backend",
+			Audience:  jwt.ClaimStrings{challengeAudience},
+		},
+	}
+
+	return signClaims(claims)
+}
+
+// VerifyChallengeToken validates a token from GenerateChallengeToken
+func VerifyChallengeToken(tokenString string) (*Claims, error) {
+	return parseClaims(tokenString, jwt.WithAudience(challengeAudience))
 }
@@ -0,0 +1,372 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Supported signing algorithms
+const (
+	AlgHS256 = "HS256"
+	AlgRS256 = "RS256"
+	AlgEdDSA = "EdDSA"
+)
+
+// devSecret is only used outside production when no key is configured
+const devSecret = "dev-secret-change-in-production"
+
+// minSecretLength is the shortest HS256 secret accepted in production
+const minSecretLength = 32
+
+var (
+	// ErrNoSigningKey is returned in production when no key is configured
+	ErrNoSigningKey = errors.New("no JWT signing key configured")
+	// ErrUnknownKey is returned for tokens signed with a key not in the ring
+	ErrUnknownKey = errors.New("unknown signing key")
+)
+
+// KeyringConfig selects where signing keys come from. File takes
+// precedence over Secret.
+type KeyringConfig struct {
+	// File is a JSON keyring, see keyringFile
+	File string
+	// Secret and KID configure a single HS256 key
+	Secret string
+	KID    string
+	// Production refuses to start without a configured key
+	Production bool
+}
+
+// Production reports whether the server runs in production mode, set with
+// APP_ENV=production or GIN_MODE=release
+func Production() bool {
+	return os.Getenv("APP_ENV") == "production" || os.Getenv("GIN_MODE") == "release"
+}
+
+// KeyringConfigFromEnv reads JWT_KEYRING_FILE, JWT_SECRET and JWT_KID
+func KeyringConfigFromEnv() KeyringConfig {
+	kid := os.Getenv("JWT_KID")
+	if kid == "" {
+		kid = "default"
+	}
+	return KeyringConfig{
+		File:       os.Getenv("JWT_KEYRING_FILE"),
+		Secret:     os.Getenv("JWT_SECRET"),
+		KID:        kid,
+		Production: Production(),
+	}
+}
+
+// Key is one signing or verification key
+type Key struct {
+	ID        string
+	Algorithm string
+	method    jwt.SigningMethod
+	// signKey is nil for keys that only verify tokens
+	signKey   any
+	verifyKey any
+}
+
+// Keyring holds the key new tokens are signed with and every key tokens
+// are still accepted from. During rotation the previous keys stay in the
+// ring until tokens signed with them have expired.
+type Keyring struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+var (
+	keysMu sync.Mutex
+	keys   *Keyring
+)
+
+// InitKeyring builds the keyring used for access and challenge tokens
+func InitKeyring(cfg KeyringConfig) error {
+	ring, err := NewKeyring(cfg)
+	if err != nil {
+		return err
+	}
+	SetKeyring(ring)
+	return nil
+}
+
+// SetKeyring replaces the keyring, e.g. after rotating keys
+func SetKeyring(ring *Keyring) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+	keys = ring
+}
+
+// keyring returns the installed keyring. Without InitKeyring, it is built
+// from the environment on first use.
+func keyring() (*Keyring, error) {
+	keysMu.Lock()
+	defer keysMu.Unlock()
+
+	if keys == nil {
+		ring, err := NewKeyring(KeyringConfigFromEnv())
+		if err != nil {
+			return nil, err
+		}
+		keys = ring
+	}
+	return keys, nil
+}
+
+// keyringFile is the JSON format read from JWT_KEYRING_FILE. Relative key
+// paths are resolved against the file's directory.
+//
+//	{
+//	  "signing_kid": "2026-10",
+//	  "keys": [
+//	    {"kid": "2026-10", "alg": "EdDSA", "private_key_file": "2026-10.pem"},
+//	    {"kid": "2026-07", "alg": "RS256", "public_key_file": "2026-07.pub.pem"},
+//	    {"kid": "legacy", "alg": "HS256", "secret_env": "JWT_SECRET_OLD"}
+//	  ]
+//	}
+type keyringFile struct {
+	SigningKID string         `json:"signing_kid"`
+	Keys       []keyringEntry `json:"keys"`
+}
+
+type keyringEntry struct {
+	KID            string `json:"kid"`
+	Alg            string `json:"alg"`
+	Secret         string `json:"secret"`
+	SecretEnv      string `json:"secret_env"`
+	PrivateKeyFile string `json:"private_key_file"`
+	PublicKeyFile  string `json:"public_key_file"`
+}
+
+// NewKeyring loads keys as described by cfg
+func NewKeyring(cfg KeyringConfig) (*Keyring, error) {
+	if cfg.File != "" {
+		return loadKeyringFile(cfg.File, cfg.Production)
+	}
+
+	secret := cfg.Secret
+	if secret == "" {
+		if cfg.Production {
+			return nil, fmt.Errorf("%w: set JWT_KEYRING_FILE or JWT_SECRET", ErrNoSigningKey)
+		}
+		secret = devSecret
+	}
+	key, err := hmacKey(cfg.KID, secret, cfg.Production)
+	if err != nil {
+		return nil, err
+	}
+	return &Keyring{signing: key, keys: map[string]*Key{key.ID: key}}, nil
+}
+
+func loadKeyringFile(path string, production bool) (*Keyring, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read keyring: %w", err)
+	}
+	var file keyringFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("failed to parse keyring %s: %w", path, err)
+	}
+
+	dir := filepath.Dir(path)
+	resolve := func(p string) string {
+		if p == "" || filepath.IsAbs(p) {
+			return p
+		}
+		return filepath.Join(dir, p)
+	}
+
+	ring := &Keyring{keys: map[string]*Key{}}
+	for _, entry := range file.Keys {
+		if entry.KID == "" {
+			return nil, fmt.Errorf("keyring %s: every key needs a kid", path)
+		}
+		if _, dup := ring.keys[entry.KID]; dup {
+			return nil, fmt.Errorf("keyring %s: duplicate kid %q", path, entry.KID)
+		}
+
+		var key *Key
+		switch entry.Alg {
+		case AlgHS256:
+			secret := entry.Secret
+			if entry.SecretEnv != "" {
+				secret = os.Getenv(entry.SecretEnv)
+			}
+			if secret == "" {
+				return nil, fmt.Errorf("keyring %s: key %q has no secret", path, entry.KID)
+			}
+			key, err = hmacKey(entry.KID, secret, production)
+		case AlgRS256, AlgEdDSA:
+			key, err = asymmetricKey(entry.KID, entry.Alg, resolve(entry.PrivateKeyFile), resolve(entry.PublicKeyFile))
+		default:
+			err = fmt.Errorf("unsupported alg %q (expected %s, %s or %s)", entry.Alg, AlgHS256, AlgRS256, AlgEdDSA)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("keyring %s: key %q: %w", path, entry.KID, err)
+		}
+		ring.keys[key.ID] = key
+	}
+
+	signing, ok := ring.keys[file.SigningKID]
+	if !ok {
+		return nil, fmt.Errorf("%w: keyring %s has no key %q", ErrNoSigningKey, path, file.SigningKID)
+	}
+	if signing.signKey == nil {
+		return nil, fmt.Errorf("keyring %s: signing key %q has no private key", path, file.SigningKID)
+	}
+	ring.signing = signing
+	return ring, nil
+}
+
+func hmacKey(kid, secret string, production bool) (*Key, error) {
+	if production {
+		if secret == devSecret {
+			return nil, fmt.Errorf("%w: the development secret cannot be used in production", ErrNoSigningKey)
+		}
+		if len(secret) < minSecretLength {
+			return nil, fmt.Errorf("HS256 secret must be at least %d bytes in production", minSecretLength)
+		}
+	} else if secret == devSecret {
+		log.Println("Warning: JWT_SECRET not set, using the development secret")
+	}
+	return &Key{
+		ID:        kid,
+		Algorithm: AlgHS256,
+		method:    jwt.SigningMethodHS256,
+		signKey:   []byte(secret),
+		verifyKey: []byte(secret),
+	}, nil
+}
+
+// asymmetricKey loads a PEM private key, which can also verify, or a PEM
+// public key for a key that is only kept to verify older tokens
+func asymmetricKey(kid, alg, privateFile, publicFile string) (*Key, error) {
+	key := &Key{ID: kid, Algorithm: alg}
+
+	file := privateFile
+	if file == "" {
+		file = publicFile
+	}
+	if file == "" {
+		return nil, errors.New("private_key_file or public_key_file required")
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	switch alg {
+	case AlgRS256:
+		key.method = jwt.SigningMethodRS256
+		if privateFile != "" {
+			private, err := jwt.ParseRSAPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			key.signKey, key.verifyKey = private, &private.PublicKey
+		} else {
+			key.verifyKey, err = jwt.ParseRSAPublicKeyFromPEM(data)
+		}
+	case AlgEdDSA:
+		key.method = jwt.SigningMethodEdDSA
+		if privateFile != "" {
+			parsed, err := jwt.ParseEdPrivateKeyFromPEM(data)
+			if err != nil {
+				return nil, err
+			}
+			private, ok := parsed.(ed25519.PrivateKey)
+			if !ok {
+				return nil, errors.New("not an Ed25519 private key")
+			}
+			key.signKey, key.verifyKey = private, private.Public()
+		} else {
+			key.verifyKey, err = jwt.ParseEdPublicKeyFromPEM(data)
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// Sign signs claims with the current key and sets the kid header
+func (r *Keyring) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(r.signing.method, claims)
+	token.Header["kid"] = r.signing.ID
+	return token.SignedString(r.signing.signKey)
+}
+
+// Keyfunc picks the verification key named by the token's kid and makes
+// sure the token uses that key's algorithm, so a public key can never be
+// used as an HMAC secret
+func (r *Keyring) Keyfunc(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := r.keys[kid]
+	if !ok {
+		return nil, ErrUnknownKey
+	}
+	if token.Method.Alg() != key.method.Alg() {
+		return nil, errors.New("invalid signing method")
+	}
+	return key.verifyKey, nil
+}
+
+// SigningKeyID is the kid new tokens are signed with
+func (r *Keyring) SigningKeyID() string {
+	return r.signing.ID
+}
+
+// JWK is a public key in RFC 7517 format
+type JWK struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	// RSA
+	N string `json:"n,omitempty"`
+	E string `json:"e,omitempty"`
+	// Ed25519
+	Curve string `json:"crv,omitempty"`
+	X     string `json:"x,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// JWKS returns the public keys in the ring. HS256 keys are secret and are
+// never published.
+func (r *Keyring) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range r.keys {
+		jwk := JWK{KeyID: key.ID, Algorithm: key.Algorithm, Use: "sig"}
+		switch public := key.verifyKey.(type) {
+		case *rsa.PublicKey:
+			jwk.KeyType = "RSA"
+			jwk.N = base64.RawURLEncoding.EncodeToString(public.N.Bytes())
+			jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes())
+		case ed25519.PublicKey:
+			jwk.KeyType = "OKP"
+			jwk.Curve = "Ed25519"
+			jwk.X = base64.RawURLEncoding.EncodeToString(public)
+		default:
+			continue
+		}
+		set.Keys = append(set.Keys, jwk)
+	}
+	sort.Slice(set.Keys, func(i, j int) bool { return set.Keys[i].KeyID < set.Keys[j].KeyID })
+	return set
+}
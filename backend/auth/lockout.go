@@ -0,0 +1,171 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strings"
+	"time"
+
+	"backend/audit"
+	"backend/ratelimit"
+	db "backend/database"
+)
+
+// LoginGuard throttles failed logins per client IP and per account.
+// Repeated failures on an account first add an exponential delay between
+// attempts, then lock the account for LockFor.
+type LoginGuard struct {
+	Store ratelimit.Store
+
+	// Window is the sliding window failures are counted in
+	Window time.Duration
+	// MaxPerIP is the number of failures one IP may make within Window
+	MaxPerIP int
+	// FreeAttempts is the number of failures an account may have before
+	// backoff starts
+	FreeAttempts int
+	// BaseBackoff doubles with every failure after FreeAttempts, up to
+	// MaxBackoff
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// LockAfter failures within Window lock the account for LockFor
+	LockAfter int
+	LockFor   time.Duration
+
+	now func() time.Time
+}
+
+// NewLoginGuard returns a guard with the default policy
+func NewLoginGuard(store ratelimit.Store) *LoginGuard {
+	return &LoginGuard{
+		Store:        store,
+		Window:       15 * time.Minute,
+		MaxPerIP:     50,
+		FreeAttempts: 3,
+		BaseBackoff:  time.Second,
+		MaxBackoff:   time.Minute,
+		LockAfter:    10,
+		LockFor:      15 * time.Minute,
+		now:          time.Now,
+	}
+}
+
+// ErrUserNotFound is returned when an account operation targets a missing user
+var ErrUserNotFound = errors.New("user not found")
+
+// Guard is the login guard used by Login. Replace Guard.Store to share
+// counters between instances.
+var Guard = NewLoginGuard(ratelimit.NewMemoryStore())
+
+func ipKey(ip string) string {
+	return "login:ip:" + ip
+}
+
+func accountKey(email string) string {
+	return "login:account:" + strings.ToLower(email)
+}
+
+// Check returns how long the caller must wait before another attempt, or
+// zero if the attempt may proceed
+func (g *LoginGuard) Check(ctx context.Context, ip, email string) (time.Duration, error) {
+	now := g.now()
+
+	count, last, err := g.Store.Recent(ctx, ipKey(ip), now, g.Window)
+	if err != nil {
+		return 0, err
+	}
+	if count >= g.MaxPerIP {
+		// Wait until the oldest counted failure leaves the window; the
+		// latest is a conservative upper bound
+		return last.Add(g.Window).Sub(now), nil
+	}
+
+	count, last, err = g.Store.Recent(ctx, accountKey(email), now, g.Window)
+	if err != nil {
+		return 0, err
+	}
+	if count >= g.FreeAttempts {
+		wait := g.BaseBackoff << (count - g.FreeAttempts)
+		if wait > g.MaxBackoff || wait <= 0 {
+			wait = g.MaxBackoff
+		}
+		if remaining := last.Add(wait).Sub(now); remaining > 0 {
+			return remaining, nil
+		}
+	}
+
+	return 0, nil
+}
+
+// Fail records a failed attempt. When userID is known and the account has
+// reached LockAfter failures, the account is locked and an audit entry is
+// written; locked reports whether that happened.
+func (g *LoginGuard) Fail(ctx context.Context, ip, email string, userID int64) (locked bool, err error) {
+	now := g.now()
+
+	if err := g.Store.Hit(ctx, ipKey(ip), now, g.Window); err != nil {
+		return false, err
+	}
+	if err := g.Store.Hit(ctx, accountKey(email), now, g.Window); err != nil {
+		return false, err
+	}
+
+	if userID == 0 {
+		return false, nil
+	}
+
+	count, _, err := g.Store.Recent(ctx, accountKey(email), now, g.Window)
+	if err != nil || count < g.LockAfter {
+		return false, err
+	}
+
+	if err := LockAccount(ctx, userID, g.LockFor); err != nil {
+		return false, err
+	}
+	// The lock replaces the backoff, so start counting afresh afterwards
+	if err := g.Store.Reset(ctx, accountKey(email)); err != nil {
+		return true, err
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID: userID,
+		Action: audit.ActionAccountLocked,
+		Detail: map[string]any{
+			"failed_attempts": count,
+			"locked_for":      g.LockFor.String(),
+		},
+		IP: ip,
+	})
+	return true, nil
+}
+
+// Succeed clears the account's failure count after a successful login
+func (g *LoginGuard) Succeed(ctx context.Context, email string) error {
+	return g.Store.Reset(ctx, accountKey(email))
+}
+
+// LockAccount prevents logins to the account for d
+func LockAccount(ctx context.Context, userID int64, d time.Duration) error {
+	_, err := db.DB.ExecContext(ctx,
+		"UPDATE users SET locked_until = datetime('now', ?) WHERE id = ?",
+		sqlOffset(d), userID,
+	)
+	return err
+}
+
+// UnlockAccount clears a lock and any pending backoff for the account
+func UnlockAccount(ctx context.Context, userID int64) error {
+	var email string
+	err := db.DB.QueryRowContext(ctx,
+		"UPDATE users SET locked_until = NULL WHERE id = ? RETURNING email",
+		userID,
+	).Scan(&email)
+	if err == sql.ErrNoRows {
+		return ErrUserNotFound
+	}
+	if err != nil {
+		return err
+	}
+	return Guard.Succeed(ctx, email)
+}
@@ -3,7 +3,9 @@ package auth
 import (
 	"context"
 	"database/sql"
+	"math"
 	"net/http"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -25,15 +27,34 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	// 2. Find user by email
+	// 2. Throttle repeated failures from this IP or against this account
 	ctx_1, cancel_1 := context.WithTimeout(c.Request.Context(), 5*time.Second)
 	defer cancel_1()
 
+	ip := c.ClientIP()
+	wait, err := Guard.Check(ctx_1, ip, req.Email)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to check login attempts")
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		handlers.ErrorResponse(c, http.StatusTooManyRequests, "Too many login attempts. Please try again later.")
+		return
+	}
+
+	// 3. Find user by email
 	var userID int64
 	var hashedPassword string
-	err := db.DB.QueryRowContext(ctx_1, "SELECT id, hashed_password FROM users WHERE email = ?", req.Email).Scan(&userID, &hashedPassword)
+	var locked, disabled bool
+	err = db.DB.QueryRowContext(ctx_1,
+		`SELECT id, hashed_password, COALESCE(locked_until > datetime('now'), 0), disabled_at IS NOT NULL
+		 FROM users WHERE email = ?`,
+		req.Email,
+	).Scan(&userID, &hashedPassword, &locked, &disabled)
 	if err != nil {
 		if err == sql.ErrNoRows {
+			Guard.Fail(ctx_1, ip, req.Email, 0)
 			// Don't reveal whether email exists or not (security best practice)
 			handlers.ErrorResponse(c, http.StatusUnauthorized, "Invalid credentials")
 			return
@@ -42,31 +63,63 @@ func Login(c *gin.Context) {
 		return
 	}
 
-	// 3. Verify password
+	// 4. Locked accounts can only be recovered by waiting, an admin unlock
+	// or a password reset. Checked before the password so a lock cannot be
+	// used to test guesses.
+	if locked {
+		handlers.ErrorResponse(c, http.StatusLocked, "Account temporarily locked. Try again later or reset your password.")
+		return
+	}
+
+	// 5. Verify password
 	if err := VerifyPassword(hashedPassword, req.Password); err != nil {
+		if nowLocked, _ := Guard.Fail(ctx_1, ip, req.Email, userID); nowLocked {
+			handlers.ErrorResponse(c, http.StatusLocked, "Account temporarily locked. Try again later or reset your password.")
+			return
+		}
 		handlers.ErrorResponse(c, http.StatusUnauthorized, "Invalid credentials")
 		return
 	}
 
-	// 4. Generate JWT token
-	token, err := GenerateToken(userID, req.Email)
+	// Only reveal that an account is disabled to someone who knows the
+	// password
+	if disabled {
+		handlers.ErrorResponse(c, http.StatusForbidden, "Account disabled. Please contact support.")
+		return
+	}
+
+	// 6. With 2FA enabled the password only earns a short-lived challenge
+	// token, exchanged for a session at /auth/login/2fa. Failures are not
+	// reset until the second factor succeeds.
+	twoFactor, err := TwoFactorEnabled(ctx_1, userID)
 	if err != nil {
-		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
 		return
 	}
+	if twoFactor {
+		challenge, err := GenerateChallengeToken(userID, req.Email)
+		if err != nil {
+			handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+		handlers.SuccessResponse(c, gin.H{
+			"message":             "Two-factor authentication required",
+			"two_factor_required": true,
+			"challenge_token":     challenge,
+			"expires_in":          int(ChallengeTokenTTL.Seconds()),
+		})
+		return
+	}
+	Guard.Succeed(ctx_1, req.Email)
 
-	// 5. Set httpOnly cookie (expires in 24 hours, same as JWT)
-	c.SetCookie(
-		"token",           // name
-		token,             // value
-		86400,             // maxAge in seconds (24 hours)
-		"/",               // path
-		"",                // domain (empty for same-origin)
-		false,             // secure (set to true in production with HTTPS)
-		true,              // httpOnly
-	)
+	// 7. Start a session: short-lived access token plus rotating refresh
+	// token, both as httpOnly cookies
+	if err := StartSession(c, userID, req.Email); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
 
-	// 6. Return success without token in body
+	// 8. Return success without token in body
 	handlers.SuccessResponse(c, gin.H{
 		"message": "Login successful",
 		"user": gin.H{
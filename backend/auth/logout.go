@@ -1,23 +1,66 @@
 package auth
 
 import (
+	"context"
+	"net/http"
+	"time"
+
 	"github.com/gin-gonic/gin"
 	"backend/handlers"
+	db "backend/database"
 )
 
+// Logout revokes the current session and clears the auth cookies
 func Logout(c *gin.Context) {
-	// Clear the token cookie by setting maxAge to -1
-	c.SetCookie(
-		"token",  // name
-		"",       // value (empty)
-		-1,       // maxAge (negative value deletes the cookie)
-		"/",      // path
-		"",       // domain
-		false,    // secure
-		true,     // httpOnly
-	)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	// Prefer the refresh token, which still identifies the session after
+	// the access token has expired
+	if refreshToken, err := c.Cookie(refreshCookie); err == nil && refreshToken != "" {
+		if _, err := db.DB.ExecContext(ctx,
+			"UPDATE sessions SET revoked_at = datetime('now') WHERE refresh_token_hash = ? AND revoked_at IS NULL",
+			hashToken(refreshToken),
+		); err != nil {
+			handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke session")
+			return
+		}
+	} else if token, err := c.Cookie(accessCookie); err == nil {
+		if claims, err := VerifyToken(token); err == nil {
+			if err := RevokeSession(ctx, claims.SessionID); err != nil {
+				handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke session")
+				return
+			}
+		}
+	}
+
+	clearAuthCookies(c)
 
 	handlers.SuccessResponse(c, gin.H{
 		"message": "Logged out successfully",
 	})
 }
+
+// LogoutAll revokes every session of the authenticated user, signing them
+// out on all devices
+func LogoutAll(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if err := RevokeUserSessions(ctx, userID.(int64)); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	clearAuthCookies(c)
+
+	handlers.SuccessResponse(c, gin.H{
+		"message": "Logged out of all devices",
+	})
+}
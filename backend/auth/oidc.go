@@ -0,0 +1,424 @@
+package auth
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/audit"
+	"backend/handlers"
+	"backend/oidc"
+	db "backend/database"
+)
+
+// OIDCStateTTL is how long a user has to finish logging in at the identity
+// provider
+const OIDCStateTTL = 10 * time.Minute
+
+const (
+	// oidcStateCookie binds the callback to the browser that started the
+	// login, so a callback URL cannot be used to log someone else in
+	oidcStateCookie = "oidc_state"
+	oidcCookiePath  = "/auth/oidc"
+)
+
+var (
+	errOIDCState = errors.New("invalid or expired login state")
+	// errIdentityTaken means the external account is linked to another user
+	errIdentityTaken = errors.New("identity linked to another user")
+	// errEmailTaken means a local account owns the email but cannot be
+	// linked automatically
+	errEmailTaken = errors.New("email belongs to an existing account")
+	errNoEmail    = errors.New("identity provider did not share an email")
+)
+
+// pendingLogin is an oidc_states row
+type pendingLogin struct {
+	nonce      string
+	verifier   string
+	linkUserID int64
+	returnTo   string
+}
+
+// ListOIDCProviders returns the configured provider names for the login page
+func ListOIDCProviders(c *gin.Context) {
+	handlers.SuccessResponse(c, gin.H{"providers": oidc.Names()})
+}
+
+// OIDCLogin redirects the browser to the identity provider to log in or,
+// for new users, sign up
+func OIDCLogin(c *gin.Context) {
+	beginOIDC(c, 0)
+}
+
+// OIDCLink redirects a logged in user to the identity provider to link that
+// account to theirs
+func OIDCLink(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	beginOIDC(c, userID.(int64))
+}
+
+func beginOIDC(c *gin.Context, linkUserID int64) {
+	provider, err := oidc.Lookup(c.Param("provider"))
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusNotFound, "Unknown identity provider")
+		return
+	}
+
+	// 1. Generate the state, nonce and PKCE verifier for this attempt
+	state, stateHash, err := newOpaqueToken()
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	nonce, _, err := newOpaqueToken()
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+	verifier, err := oidc.NewCodeVerifier()
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	// 2. Build the provider URL first so an unreachable provider leaves no
+	// state behind
+	authURL, err := provider.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		log.Printf("Failed to start %s login: %v", provider.Name(), err)
+		handlers.ErrorResponse(c, http.StatusBadGateway, "Identity provider unavailable")
+		return
+	}
+
+	// 3. Store the attempt, clearing out abandoned ones
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.DB.ExecContext(ctx, "DELETE FROM oidc_states WHERE expires_at <= datetime('now')"); err != nil {
+		log.Printf("Failed to delete expired login states: %v", err)
+	}
+	_, err = db.DB.ExecContext(ctx,
+		`INSERT INTO oidc_states (state_hash, provider, nonce, code_verifier, link_user_id, return_to, expires_at)
+		 VALUES (?, ?, ?, ?, ?, ?, datetime('now', ?))`,
+		stateHash, provider.Name(), nonce, verifier,
+		sql.NullInt64{Int64: linkUserID, Valid: linkUserID != 0},
+		safeReturnPath(c.Query("return_to")), sqlOffset(OIDCStateTTL),
+	)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to start login")
+		return
+	}
+
+	// 4. Send the browser to the provider
+	c.SetCookie(oidcStateCookie, state, int(OIDCStateTTL.Seconds()), oidcCookiePath, "", false, true)
+	c.Redirect(http.StatusFound, authURL)
+}
+
+// OIDCCallback completes a login started by OIDCLogin or OIDCLink. The
+// provider redirects the browser here with an authorization code.
+func OIDCCallback(c *gin.Context) {
+	provider, err := oidc.Lookup(c.Param("provider"))
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusNotFound, "Unknown identity provider")
+		return
+	}
+
+	// 1. The provider reports refused consent and similar errors in the query
+	if reason := c.Query("error"); reason != "" {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "Login failed at the identity provider: "+reason)
+		return
+	}
+
+	// 2. The state must match the cookie set when the login started, and
+	// can only be used once
+	state := c.Query("state")
+	cookie, _ := c.Cookie(oidcStateCookie)
+	c.SetCookie(oidcStateCookie, "", -1, oidcCookiePath, "", false, true)
+	if state == "" || subtle.ConstantTimeCompare([]byte(state), []byte(cookie)) != 1 {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Invalid login state")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	pending, err := consumeOIDCState(ctx, state, provider.Name())
+	if errors.Is(err, errOIDCState) {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Invalid or expired login state. Please try again.")
+		return
+	}
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	// 3. Redeem the code with the PKCE verifier and validate the ID token
+	tokens, err := provider.Exchange(c.Request.Context(), c.Query("code"), pending.verifier)
+	if err != nil {
+		log.Printf("OIDC code exchange failed: %v", err)
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "Failed to complete login")
+		return
+	}
+	claims, err := provider.VerifyIDToken(c.Request.Context(), tokens.IDToken, pending.nonce)
+	if err != nil {
+		log.Printf("OIDC ID token rejected: %v", err)
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "Failed to complete login")
+		return
+	}
+
+	ctx_2, cancel_2 := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel_2()
+
+	// 4. Linking from account settings keeps the current session
+	if pending.linkUserID != 0 {
+		err := linkIdentity(ctx_2, pending.linkUserID, provider.Name(), claims)
+		if errors.Is(err, errIdentityTaken) {
+			handlers.ErrorResponse(c, http.StatusConflict, "This account is already linked to another user")
+			return
+		}
+		if err != nil {
+			handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to link account")
+			return
+		}
+		audit.Record(ctx_2, audit.Entry{
+			UserID: pending.linkUserID,
+			Action: audit.ActionIdentityLinked,
+			Detail: map[string]any{"provider": provider.Name(), "via": "settings"},
+			IP:     c.ClientIP(),
+		})
+		c.Redirect(http.StatusFound, appBaseURL()+pending.returnTo)
+		return
+	}
+
+	// 5. Find, link or create the user this identity logs in as
+	userID, email, err := oidcUser(ctx_2, provider.Name(), claims, c.ClientIP())
+	switch {
+	case errors.Is(err, errEmailTaken):
+		handlers.ErrorResponse(c, http.StatusConflict, "An account with this email already exists. Log in with your password and link this provider in your account settings.")
+		return
+	case errors.Is(err, errNoEmail):
+		handlers.ErrorResponse(c, http.StatusBadRequest, "The identity provider did not share an email address")
+		return
+	case err != nil:
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to complete login")
+		return
+	}
+
+	// A lock from failed password logins applies here too, or signing in
+	// with a linked provider would get around it
+	var locked, disabled bool
+	if err := db.DB.QueryRowContext(ctx_2,
+		"SELECT COALESCE(locked_until > datetime('now'), 0), disabled_at IS NOT NULL FROM users WHERE id = ?",
+		userID,
+	).Scan(&locked, &disabled); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if locked {
+		handlers.ErrorResponse(c, http.StatusLocked, "Account temporarily locked. Try again later or reset your password.")
+		return
+	}
+	if disabled {
+		handlers.ErrorResponse(c, http.StatusForbidden, "Account disabled. Please contact support.")
+		return
+	}
+
+	// 6. The provider replaces the password, not our second factor. The
+	// challenge goes in the fragment so it stays out of server logs.
+	twoFactor, err := TwoFactorEnabled(ctx_2, userID)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if twoFactor {
+		challenge, err := GenerateChallengeToken(userID, email)
+		if err != nil {
+			handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+			return
+		}
+		c.Redirect(http.StatusFound, appBaseURL()+"/login/2fa#challenge_token="+challenge)
+		return
+	}
+
+	// 7. Start a session and return to the app
+	if err := StartSession(c, userID, email); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+	c.Redirect(http.StatusFound, appBaseURL()+pending.returnTo)
+}
+
+// consumeOIDCState returns and deletes the login started with state
+func consumeOIDCState(ctx context.Context, state, provider string) (*pendingLogin, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var id int64
+	var p pendingLogin
+	var linkUserID sql.NullInt64
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, nonce, code_verifier, link_user_id, return_to FROM oidc_states
+		 WHERE state_hash = ? AND provider = ? AND expires_at > datetime('now')`,
+		hashToken(state), provider,
+	).Scan(&id, &p.nonce, &p.verifier, &linkUserID, &p.returnTo)
+	if err == sql.ErrNoRows {
+		return nil, errOIDCState
+	}
+	if err != nil {
+		return nil, err
+	}
+	p.linkUserID = linkUserID.Int64
+
+	// The delete check keeps the state single-use under races
+	result, err := tx.ExecContext(ctx, "DELETE FROM oidc_states WHERE id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return nil, errOIDCState
+	}
+	return &p, tx.Commit()
+}
+
+// oidcUser returns the user an external identity logs in as. Unknown
+// identities are linked to the local account with the same email only when
+// both sides have verified it; otherwise someone who registered the address
+// without owning it could take over the real owner's login. Identities with
+// a new email get a new account without a password.
+func oidcUser(ctx context.Context, provider string, claims *oidc.IDToken, ip string) (userID int64, email string, err error) {
+	// 1. A known identity
+	err = db.DB.QueryRowContext(ctx,
+		`SELECT u.id, u.email FROM user_identities i JOIN users u ON u.id = i.user_id
+		 WHERE i.provider = ? AND i.subject = ?`,
+		provider, claims.Subject,
+	).Scan(&userID, &email)
+	if err == nil {
+		if _, err := db.DB.ExecContext(ctx,
+			"UPDATE user_identities SET last_login_at = datetime('now'), email = ? WHERE provider = ? AND subject = ?",
+			claims.Email, provider, claims.Subject,
+		); err != nil {
+			log.Printf("Failed to record login for identity of user %d: %v", userID, err)
+		}
+		return userID, email, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, "", err
+	}
+
+	if claims.Email == "" {
+		return 0, "", errNoEmail
+	}
+	email = claims.Email
+
+	// 2. An existing account with the same verified email
+	var localVerified bool
+	err = db.DB.QueryRowContext(ctx,
+		"SELECT id, email_verified_at IS NOT NULL FROM users WHERE email = ?",
+		email,
+	).Scan(&userID, &localVerified)
+	if err == nil {
+		if !localVerified || !claims.EmailVerified {
+			return 0, "", errEmailTaken
+		}
+		if err := linkIdentity(ctx, userID, provider, claims); err != nil {
+			return 0, "", err
+		}
+		audit.Record(ctx, audit.Entry{
+			UserID: userID,
+			Action: audit.ActionIdentityLinked,
+			Detail: map[string]any{"provider": provider, "via": "email"},
+			IP:     ip,
+		})
+		return userID, email, nil
+	}
+	if err != sql.ErrNoRows {
+		return 0, "", err
+	}
+
+	// 3. A new account. An empty hash never matches a password, so the
+	// user logs in through the provider until they set one with a reset.
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, "", err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRowContext(ctx,
+		`INSERT INTO users (email, hashed_password, email_verified_at, created_at, updated_at)
+		 VALUES (?, '', CASE WHEN ? THEN datetime('now') END, datetime('now'), datetime('now'))
+		 RETURNING id`,
+		email, claims.EmailVerified,
+	).Scan(&userID)
+	if err != nil {
+		return 0, "", err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+		 VALUES (?, ?, ?, ?, datetime('now'))`,
+		userID, provider, claims.Subject, claims.Email,
+	); err != nil {
+		return 0, "", err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, "", err
+	}
+
+	if !claims.EmailVerified {
+		if err := sendVerificationEmail(ctx, userID, email); err != nil {
+			log.Printf("Failed to send verification email to user %d: %v", userID, err)
+		}
+	}
+	return userID, email, nil
+}
+
+// linkIdentity attaches an external identity to a user. Linking an identity
+// the user already has is a no-op.
+func linkIdentity(ctx context.Context, userID int64, provider string, claims *oidc.IDToken) error {
+	var owner int64
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT user_id FROM user_identities WHERE provider = ? AND subject = ?",
+		provider, claims.Subject,
+	).Scan(&owner)
+	if err == nil {
+		if owner != userID {
+			return errIdentityTaken
+		}
+		return nil
+	}
+	if err != sql.ErrNoRows {
+		return err
+	}
+
+	_, err = db.DB.ExecContext(ctx,
+		`INSERT INTO user_identities (user_id, provider, subject, email, last_login_at)
+		 VALUES (?, ?, ?, ?, datetime('now'))`,
+		userID, provider, claims.Subject, claims.Email,
+	)
+	if err != nil && strings.Contains(err.Error(), "UNIQUE") {
+		return errIdentityTaken
+	}
+	return err
+}
+
+// safeReturnPath only allows paths on our own app, so the login cannot be
+// used as an open redirect
+func safeReturnPath(path string) string {
+	if !strings.HasPrefix(path, "/") || strings.HasPrefix(path, "//") || strings.HasPrefix(path, "/\\") {
+		return "/"
+	}
+	return path
+}
@@ -0,0 +1,274 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/handlers"
+	"backend/mail"
+	"backend/ratelimit"
+	db "backend/database"
+)
+
+// PasswordResetTTL is how long a reset link stays valid
+const PasswordResetTTL = time.Hour
+
+type ForgotPasswordRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=6"`
+}
+
+// ResetLimiter caps forgot-password requests per client IP and per email,
+// so the endpoint cannot be used to flood an inbox with reset links
+type ResetLimiter struct {
+	Store ratelimit.Store
+
+	// Window is the sliding window requests are counted in
+	Window time.Duration
+	// MaxPerIP is the number of requests one IP may make within Window
+	MaxPerIP int
+	// MaxPerEmail is the number of requests for one email within Window
+	MaxPerEmail int
+
+	now func() time.Time
+}
+
+// NewResetLimiter returns a limiter with the default policy
+func NewResetLimiter(store ratelimit.Store) *ResetLimiter {
+	return &ResetLimiter{
+		Store:       store,
+		Window:      time.Hour,
+		MaxPerIP:    20,
+		MaxPerEmail: 5,
+		now:         time.Now,
+	}
+}
+
+// ResetGuard is the limiter used by ForgotPassword. Replace
+// ResetGuard.Store to share counters between instances.
+var ResetGuard = NewResetLimiter(ratelimit.NewMemoryStore())
+
+// Allow returns how long the caller must wait before asking again, or zero
+// if the request may proceed, in which case it is counted. Requests are
+// limited whether or not the email is registered.
+func (l *ResetLimiter) Allow(ctx context.Context, ip, email string) (time.Duration, error) {
+	now := l.now()
+	keys := []struct {
+		key string
+		max int
+	}{
+		{"reset:ip:" + ip, l.MaxPerIP},
+		{"reset:email:" + strings.ToLower(email), l.MaxPerEmail},
+	}
+
+	for _, k := range keys {
+		count, last, err := l.Store.Recent(ctx, k.key, now, l.Window)
+		if err != nil {
+			return 0, err
+		}
+		if count >= k.max {
+			// The latest request is a conservative upper bound for when
+			// the oldest leaves the window
+			return last.Add(l.Window).Sub(now), nil
+		}
+	}
+
+	for _, k := range keys {
+		if err := l.Store.Hit(ctx, k.key, now, l.Window); err != nil {
+			return 0, err
+		}
+	}
+	return 0, nil
+}
+
+// appBaseURL is the frontend origin used in links sent by email
+func appBaseURL() string {
+	if url := os.Getenv("APP_BASE_URL"); url != "" {
+		return url
+	}
+	return "http://localhost:3000"
+}
+
+// ForgotPassword emails a single-use reset link. The response is the same
+// whether or not the email is registered. Requests are limited by
+// ResetGuard.
+func ForgotPassword(c *gin.Context) {
+	var req ForgotPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	wait, err := ResetGuard.Allow(ctx, c.ClientIP(), req.Email)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to check reset requests")
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		handlers.ErrorResponse(c, http.StatusTooManyRequests, "Too many reset requests. Please try again later.")
+		return
+	}
+
+	// Don't reveal whether email exists or not (security best practice)
+	respond := func() {
+		handlers.SuccessResponse(c, gin.H{
+			"message": "If that email is registered, a reset link has been sent",
+		})
+	}
+
+	var userID int64
+	err = db.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE email = ?", req.Email).Scan(&userID)
+	if err == sql.ErrNoRows {
+		respond()
+		return
+	}
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate reset token")
+		return
+	}
+
+	// Only the most recent link works
+	if _, err := db.DB.ExecContext(ctx,
+		"UPDATE password_resets SET used_at = datetime('now') WHERE user_id = ? AND used_at IS NULL",
+		userID,
+	); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if _, err := db.DB.ExecContext(ctx,
+		"INSERT INTO password_resets (user_id, token_hash, expires_at) VALUES (?, ?, datetime('now', ?))",
+		userID, hash, sqlOffset(PasswordResetTTL),
+	); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to create reset token")
+		return
+	}
+
+	err = mail.Send(ctx, mail.Message{
+		To:      req.Email,
+		Subject: "Reset your password",
+		Body: fmt.Sprintf(
+			"Someone asked to reset the password for your account.\n\n"+
+				"Use this link within %d minutes to choose a new password:\n\n%s/reset-password?token=%s\n\n"+
+				"If this wasn't you, you can ignore this email.\n",
+			int(PasswordResetTTL.Minutes()), appBaseURL(), token,
+		),
+	})
+	if err != nil {
+		// An error here would reveal that the email is registered
+		log.Printf("Failed to send reset email to user %d: %v", userID, err)
+	}
+
+	respond()
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword and
+// signs the user out everywhere
+func ResetPassword(c *gin.Context) {
+	var req ResetPasswordRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	hashedPassword, err := HashPassword(req.Password)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to hash password")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer tx.Rollback()
+
+	var resetID, userID int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, user_id FROM password_resets
+		 WHERE token_hash = ? AND used_at IS NULL AND expires_at > datetime('now')`,
+		hashToken(req.Token),
+	).Scan(&resetID, &userID)
+	if err == sql.ErrNoRows {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	// Consume the token; the used_at check keeps it single-use under races
+	result, err := tx.ExecContext(ctx,
+		"UPDATE password_resets SET used_at = datetime('now') WHERE id = ? AND used_at IS NULL",
+		resetID,
+	)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Invalid or expired reset token")
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET hashed_password = ?, locked_until = NULL, updated_at = datetime('now') WHERE id = ?",
+		hashedPassword, userID,
+	); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to update password")
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = datetime('now') WHERE user_id = ? AND revoked_at IS NULL",
+		userID,
+	); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to revoke sessions")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to update password")
+		return
+	}
+
+	// A password reset also lifts any brute-force lockout
+	var email string
+	if err := db.DB.QueryRowContext(ctx, "SELECT email FROM users WHERE id = ?", userID).Scan(&email); err == nil {
+		Guard.Succeed(ctx, email)
+	}
+
+	clearAuthCookies(c)
+
+	handlers.SuccessResponse(c, gin.H{
+		"message": "Password has been reset. Please log in again.",
+	})
+}
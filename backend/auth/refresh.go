@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/handlers"
+)
+
+// Refresh exchanges the refresh token cookie for a new access token and a
+// new refresh token. Each refresh token can be used once.
+func Refresh(c *gin.Context) {
+	refreshToken, err := c.Cookie(refreshCookie)
+	if err != nil || refreshToken == "" {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "Refresh token required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	sessionID, userID, email, newRefreshToken, err := rotateSession(ctx, refreshToken)
+	if err != nil {
+		clearAuthCookies(c)
+		switch {
+		case errors.Is(err, ErrSessionNotFound), errors.Is(err, ErrSessionRevoked):
+			handlers.ErrorResponse(c, http.StatusUnauthorized, "Invalid or expired refresh token")
+		case errors.Is(err, ErrRefreshTokenReused):
+			handlers.ErrorResponse(c, http.StatusUnauthorized, "Refresh token already used; session revoked")
+		default:
+			handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to refresh session")
+		}
+		return
+	}
+
+	accessToken, err := GenerateToken(userID, email, sessionID)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	setAuthCookies(c, accessToken, newRefreshToken)
+
+	handlers.SuccessResponse(c, gin.H{
+		"message": "Session refreshed",
+		"user": gin.H{
+			"id":    userID,
+			"email": email,
+		},
+	})
+}
@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"log"
 	"net/http"
 	"time"
 
@@ -66,30 +67,26 @@ func Register(c *gin.Context) {
 		return
 	}
 
-	// 6. Generate JWT token (auto-login after registration)
-	token, err := GenerateToken(userID, req.Email)
-	if err != nil {
+	// 6. Send the verification email; the account works without it, so a
+	// mail failure only gets logged
+	if err := sendVerificationEmail(ctx_2, userID, req.Email); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", userID, err)
+	}
+
+	// 7. Start a session: short-lived access token plus rotating refresh
+	// token, both as httpOnly cookies
+	if err := StartSession(c, userID, req.Email); err != nil {
 		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
 		return
 	}
 
-	// 7. Set httpOnly cookie (expires in 24 hours, same as JWT)
-	c.SetCookie(
-		"token",           // name
-		token,             // value
-		86400,             // maxAge in seconds (24 hours)
-		"/",               // path
-		"",                // domain (empty for same-origin)
-		false,             // secure (set to true in production with HTTPS)
-		true,              // httpOnly
-	)
-
 	// 8. Return success without token in body
 	handlers.SuccessResponse(c, gin.H{
 		"message": "User registered successfully",
 		"user": gin.H{
-			"id":    userID,
-			"email": req.Email,
+			"id":             userID,
+			"email":          req.Email,
+			"email_verified": false,
 		},
 	})
 }
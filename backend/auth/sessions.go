@@ -0,0 +1,246 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	db "backend/database"
+)
+
+// RefreshTokenTTL is how long a session lasts without being refreshed
+const RefreshTokenTTL = 30 * 24 * time.Hour
+
+// ImpersonationTTL is the fixed lifetime of a session an admin starts as
+// another user. Refreshing does not extend it.
+const ImpersonationTTL = time.Hour
+
+const (
+	accessCookie  = "token"
+	refreshCookie = "refresh_token"
+	// The refresh cookie is only sent to the auth endpoints that need it
+	refreshCookiePath = "/auth"
+)
+
+var (
+	ErrSessionNotFound = errors.New("session not found")
+	ErrSessionRevoked  = errors.New("session revoked")
+	// ErrRefreshTokenReused means an already rotated refresh token was
+	// presented again, which suggests it was stolen
+	ErrRefreshTokenReused = errors.New("refresh token reused")
+)
+
+// newOpaqueToken returns a random URL-safe token and its SHA-256 hash
+func newOpaqueToken() (token, hash string, err error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", "", err
+	}
+	token = base64.RawURLEncoding.EncodeToString(buf)
+	return token, hashToken(token), nil
+}
+
+// hashToken is how opaque tokens are stored at rest
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// sqlOffset formats a duration as a SQLite datetime modifier
+func sqlOffset(d time.Duration) string {
+	return fmt.Sprintf("%+d seconds", int64(d.Seconds()))
+}
+
+// createSession stores a new session and returns its ID and refresh token.
+// impersonatorID is the admin acting as the user, or zero.
+func createSession(ctx context.Context, userID int64, userAgent, ip string, impersonatorID int64) (int64, string, error) {
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return 0, "", err
+	}
+
+	ttl := RefreshTokenTTL
+	impersonator := sql.NullInt64{Int64: impersonatorID, Valid: impersonatorID != 0}
+	if impersonator.Valid {
+		ttl = ImpersonationTTL
+	}
+
+	result, err := db.DB.ExecContext(ctx,
+		`INSERT INTO sessions (user_id, refresh_token_hash, user_agent, ip_address, expires_at, impersonator_id)
+		 VALUES (?, ?, ?, ?, datetime('now', ?), ?)`,
+		userID, hash, userAgent, ip, sqlOffset(ttl), impersonator,
+	)
+	if err != nil {
+		return 0, "", err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, "", err
+	}
+	return id, token, nil
+}
+
+// rotateSession swaps a valid refresh token for a new one and returns the
+// session's user. Presenting a token that has already been rotated revokes
+// the session.
+func rotateSession(ctx context.Context, refreshToken string) (sessionID, userID int64, email, newToken string, err error) {
+	hash := hashToken(refreshToken)
+
+	var revokedAt sql.NullString
+	var expired bool
+	err = db.DB.QueryRowContext(ctx,
+		`SELECT s.id, s.user_id, u.email, s.revoked_at, s.expires_at <= datetime('now')
+		 FROM sessions s JOIN users u ON u.id = s.user_id
+		 WHERE s.refresh_token_hash = ?`,
+		hash,
+	).Scan(&sessionID, &userID, &email, &revokedAt, &expired)
+
+	if err == sql.ErrNoRows {
+		// A rotated token being replayed: revoke the session it belonged to
+		result, rerr := db.DB.ExecContext(ctx,
+			`UPDATE sessions SET revoked_at = datetime('now')
+			 WHERE previous_token_hash = ? AND revoked_at IS NULL`,
+			hash,
+		)
+		if rerr == nil {
+			if n, _ := result.RowsAffected(); n > 0 {
+				return 0, 0, "", "", ErrRefreshTokenReused
+			}
+		}
+		return 0, 0, "", "", ErrSessionNotFound
+	}
+	if err != nil {
+		return 0, 0, "", "", err
+	}
+	if revokedAt.Valid || expired {
+		return 0, 0, "", "", ErrSessionRevoked
+	}
+
+	newToken, newHash, err := newOpaqueToken()
+	if err != nil {
+		return 0, 0, "", "", err
+	}
+
+	// Only rotate if nobody else rotated the same token concurrently.
+	// Impersonation sessions keep their original expiry.
+	result, err := db.DB.ExecContext(ctx,
+		`UPDATE sessions
+		 SET previous_token_hash = refresh_token_hash,
+		     refresh_token_hash = ?,
+		     last_used_at = datetime('now'),
+		     expires_at = CASE WHEN impersonator_id IS NULL THEN datetime('now', ?) ELSE expires_at END
+		 WHERE id = ? AND refresh_token_hash = ? AND revoked_at IS NULL`,
+		newHash, sqlOffset(RefreshTokenTTL), sessionID, hash,
+	)
+	if err != nil {
+		return 0, 0, "", "", err
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return 0, 0, "", "", ErrSessionRevoked
+	}
+
+	return sessionID, userID, email, newToken, nil
+}
+
+// RevokeSession ends a single session
+func RevokeSession(ctx context.Context, sessionID int64) error {
+	_, err := db.DB.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = datetime('now') WHERE id = ? AND revoked_at IS NULL",
+		sessionID,
+	)
+	return err
+}
+
+// RevokeUserSessions ends every active session of a user
+func RevokeUserSessions(ctx context.Context, userID int64) error {
+	_, err := db.DB.ExecContext(ctx,
+		"UPDATE sessions SET revoked_at = datetime('now') WHERE user_id = ? AND revoked_at IS NULL",
+		userID,
+	)
+	return err
+}
+
+// SessionActive reports whether a session exists, has not expired, has not
+// been revoked and belongs to an enabled account. impersonatorID is the admin
+// acting as the user, or zero.
+func SessionActive(ctx context.Context, sessionID int64) (active bool, impersonatorID int64, err error) {
+	var impersonator sql.NullInt64
+	err = db.DB.QueryRowContext(ctx,
+		`SELECT s.impersonator_id FROM sessions s JOIN users u ON u.id = s.user_id
+		 WHERE s.id = ? AND s.revoked_at IS NULL AND s.expires_at > datetime('now')
+		   AND u.disabled_at IS NULL`,
+		sessionID,
+	).Scan(&impersonator)
+	if err == sql.ErrNoRows {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	return true, impersonator.Int64, nil
+}
+
+// setAuthCookies writes the access and refresh token cookies
+func setAuthCookies(c *gin.Context, accessToken, refreshToken string) {
+	c.SetCookie(
+		accessCookie,                  // name
+		accessToken,                   // value
+		int(AccessTokenTTL.Seconds()), // maxAge in seconds, same as JWT
+		"/",                           // path
+		"",                            // domain (empty for same-origin)
+		false,                         // secure (set to true in production with HTTPS)
+		true,                          // httpOnly
+	)
+	c.SetCookie(
+		refreshCookie,
+		refreshToken,
+		int(RefreshTokenTTL.Seconds()),
+		refreshCookiePath,
+		"",
+		false,
+		true,
+	)
+}
+
+// clearAuthCookies deletes both auth cookies
+func clearAuthCookies(c *gin.Context) {
+	c.SetCookie(accessCookie, "", -1, "/", "", false, true)
+	c.SetCookie(refreshCookie, "", -1, refreshCookiePath, "", false, true)
+}
+
+// StartSession creates a session for the user and sets the auth cookies
+func StartSession(c *gin.Context, userID int64, email string) error {
+	return startSession(c, userID, email, 0)
+}
+
+// StartImpersonation replaces the caller's cookies with a short-lived
+// session for another user, marked with the admin who started it
+func StartImpersonation(c *gin.Context, userID int64, email string, adminID int64) error {
+	return startSession(c, userID, email, adminID)
+}
+
+func startSession(c *gin.Context, userID int64, email string, impersonatorID int64) error {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	sessionID, refreshToken, err := createSession(ctx, userID, c.Request.UserAgent(), c.ClientIP(), impersonatorID)
+	if err != nil {
+		return err
+	}
+
+	accessToken, err := GenerateToken(userID, email, sessionID)
+	if err != nil {
+		return err
+	}
+
+	setAuthCookies(c, accessToken, refreshToken)
+	return nil
+}
@@ -0,0 +1,439 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/audit"
+	"backend/handlers"
+	"backend/totp"
+	db "backend/database"
+)
+
+// RecoveryCodeCount is how many recovery codes are issued when 2FA is enabled
+const RecoveryCodeCount = 10
+
+type TwoFactorCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+type DisableTwoFactorRequest struct {
+	Password string `json:"password" binding:"required"`
+	// Code is a current TOTP code or an unused recovery code
+	Code string `json:"code" binding:"required"`
+}
+
+type TwoFactorLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	// Code is a current TOTP code or an unused recovery code
+	Code string `json:"code" binding:"required"`
+}
+
+// totpIssuer is the account issuer shown in authenticator apps
+func totpIssuer() string {
+	if issuer := os.Getenv("TOTP_ISSUER"); issuer != "" {
+		return issuer
+	}
+	return "AI CEO"
+}
+
+// TwoFactorEnabled reports whether the user has confirmed TOTP enrolment
+func TwoFactorEnabled(ctx context.Context, userID int64) (bool, error) {
+	var enabled bool
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT EXISTS(SELECT 1 FROM user_totp WHERE user_id = ? AND enabled_at IS NOT NULL)",
+		userID,
+	).Scan(&enabled)
+	return enabled, err
+}
+
+// checkTOTP validates a code against the user's secret. Each time step can
+// only be used once, so an observed code cannot be replayed.
+func checkTOTP(ctx context.Context, userID int64, code string, requireEnabled bool) (bool, error) {
+	query := "SELECT secret FROM user_totp WHERE user_id = ?"
+	if requireEnabled {
+		query += " AND enabled_at IS NOT NULL"
+	}
+
+	var secret string
+	err := db.DB.QueryRowContext(ctx, query, userID).Scan(&secret)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	result, err := db.DB.ExecContext(ctx,
+		"UPDATE user_totp SET last_used_step = ? WHERE user_id = ? AND last_used_step < ?",
+		step, userID, step,
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n == 1, nil
+}
+
+// normalizeRecoveryCode ignores case and the separators users tend to type
+func normalizeRecoveryCode(code string) string {
+	code = strings.ToLower(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// useRecoveryCode consumes an unused recovery code
+func useRecoveryCode(ctx context.Context, userID int64, code string) (bool, error) {
+	result, err := db.DB.ExecContext(ctx,
+		`UPDATE user_recovery_codes SET used_at = datetime('now')
+		 WHERE user_id = ? AND code_hash = ? AND used_at IS NULL`,
+		userID, hashToken(normalizeRecoveryCode(code)),
+	)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// checkSecondFactor accepts either a TOTP code or a recovery code
+func checkSecondFactor(ctx context.Context, userID int64, code string) (bool, error) {
+	ok, err := checkTOTP(ctx, userID, code, true)
+	if err != nil || ok {
+		return ok, err
+	}
+	return useRecoveryCode(ctx, userID, code)
+}
+
+// newRecoveryCodes returns RecoveryCodeCount random codes formatted as
+// xxxxx-xxxxx
+func newRecoveryCodes() ([]string, error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	codes := make([]string, RecoveryCodeCount)
+	for i := range codes {
+		buf := make([]byte, 7)
+		if _, err := rand.Read(buf); err != nil {
+			return nil, err
+		}
+		raw := strings.ToLower(encoding.EncodeToString(buf))[:10]
+		codes[i] = raw[:5] + "-" + raw[5:]
+	}
+	return codes, nil
+}
+
+// SetupTwoFactor starts TOTP enrolment by generating a new secret. 2FA is
+// not enabled until a code from the secret is confirmed via
+// /auth/2fa/verify.
+func SetupTwoFactor(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	email, _ := c.Get("user_email")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	enabled, err := TwoFactorEnabled(ctx, userID.(int64))
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if enabled {
+		handlers.ErrorResponse(c, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate secret")
+		return
+	}
+
+	// Restarting setup replaces any unconfirmed secret
+	if _, err := db.DB.ExecContext(ctx,
+		`INSERT INTO user_totp (user_id, secret) VALUES (?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET secret = excluded.secret, last_used_step = 0,
+		     created_at = datetime('now')
+		 WHERE user_totp.enabled_at IS NULL`,
+		userID, secret,
+	); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to start two-factor setup")
+		return
+	}
+
+	account, _ := email.(string)
+	handlers.SuccessResponse(c, gin.H{
+		"secret":      secret,
+		"otpauth_uri": totp.ProvisioningURI(secret, totpIssuer(), account),
+	})
+}
+
+// VerifyTwoFactor confirms enrolment with a code from the authenticator app,
+// enables 2FA and returns the recovery codes. The codes are only shown once.
+func VerifyTwoFactor(c *gin.Context) {
+	var req TwoFactorCodeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	enabled, err := TwoFactorEnabled(ctx, userID.(int64))
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if enabled {
+		handlers.ErrorResponse(c, http.StatusConflict, "Two-factor authentication is already enabled")
+		return
+	}
+
+	ok, err := checkTOTP(ctx, userID.(int64), req.Code, false)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to verify code")
+		return
+	}
+	if !ok {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Invalid code. Run setup first if you have not.")
+		return
+	}
+
+	codes, err := newRecoveryCodes()
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate recovery codes")
+		return
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+	for _, code := range codes {
+		if _, err := tx.ExecContext(ctx,
+			"INSERT INTO user_recovery_codes (user_id, code_hash) VALUES (?, ?)",
+			userID, hashToken(normalizeRecoveryCode(code)),
+		); err != nil {
+			handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+			return
+		}
+	}
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE user_totp SET enabled_at = datetime('now') WHERE user_id = ?",
+		userID,
+	); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to enable two-factor authentication")
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID:  userID.(int64),
+		ActorID: userID.(int64),
+		Action:  audit.ActionTwoFactorEnabled,
+		IP:      c.ClientIP(),
+	})
+
+	handlers.SuccessResponse(c, gin.H{
+		"message":        "Two-factor authentication enabled",
+		"recovery_codes": codes,
+	})
+}
+
+// DisableTwoFactor turns 2FA off after re-checking the password and a
+// second factor
+func DisableTwoFactor(c *gin.Context) {
+	var req DisableTwoFactorRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var hashedPassword string
+	if err := db.DB.QueryRowContext(ctx,
+		"SELECT hashed_password FROM users WHERE id = ?",
+		userID,
+	).Scan(&hashedPassword); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if err := VerifyPassword(hashedPassword, req.Password); err != nil {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "Invalid password")
+		return
+	}
+
+	enabled, err := TwoFactorEnabled(ctx, userID.(int64))
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if !enabled {
+		handlers.ErrorResponse(c, http.StatusConflict, "Two-factor authentication is not enabled")
+		return
+	}
+
+	ok, err := checkSecondFactor(ctx, userID.(int64), req.Code)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to verify code")
+		return
+	}
+	if !ok {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_recovery_codes WHERE user_id = ?", userID); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM user_totp WHERE user_id = ?", userID); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+	if err := tx.Commit(); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to disable two-factor authentication")
+		return
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID:  userID.(int64),
+		ActorID: userID.(int64),
+		Action:  audit.ActionTwoFactorDisabled,
+		IP:      c.ClientIP(),
+	})
+
+	handlers.SuccessResponse(c, gin.H{
+		"message": "Two-factor authentication disabled",
+	})
+}
+
+// LoginTwoFactor completes a login started by Login for an account with 2FA
+// enabled, exchanging the challenge token and a code for a session
+func LoginTwoFactor(c *gin.Context) {
+	var req TwoFactorLoginRequest
+
+	// 1. Validate input
+	if err := c.ShouldBindJSON(&req); err != nil {
+		handlers.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// 2. The challenge token proves the password step succeeded
+	claims, err := VerifyChallengeToken(req.ChallengeToken)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "Invalid or expired challenge token")
+		return
+	}
+
+	// 3. Codes are throttled with the same guard as passwords
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	ip := c.ClientIP()
+	wait, err := Guard.Check(ctx, ip, claims.Email)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to check login attempts")
+		return
+	}
+	if wait > 0 {
+		c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		handlers.ErrorResponse(c, http.StatusTooManyRequests, "Too many login attempts. Please try again later.")
+		return
+	}
+
+	var locked, disabled bool
+	if err := db.DB.QueryRowContext(ctx,
+		"SELECT COALESCE(locked_until > datetime('now'), 0), disabled_at IS NOT NULL FROM users WHERE id = ?",
+		claims.UserID,
+	).Scan(&locked, &disabled); err != nil {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "Invalid or expired challenge token")
+		return
+	}
+	if locked {
+		handlers.ErrorResponse(c, http.StatusLocked, "Account temporarily locked. Try again later or reset your password.")
+		return
+	}
+	if disabled {
+		handlers.ErrorResponse(c, http.StatusForbidden, "Account disabled. Please contact support.")
+		return
+	}
+
+	// 4. Verify the TOTP or recovery code
+	ok, err := checkSecondFactor(ctx, claims.UserID, req.Code)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to verify code")
+		return
+	}
+	if !ok {
+		if nowLocked, _ := Guard.Fail(ctx, ip, claims.Email, claims.UserID); nowLocked {
+			handlers.ErrorResponse(c, http.StatusLocked, "Account temporarily locked. Try again later or reset your password.")
+			return
+		}
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "Invalid code")
+		return
+	}
+	Guard.Succeed(ctx, claims.Email)
+
+	// 5. Start the session exactly as a password-only login would
+	if err := StartSession(c, claims.UserID, claims.Email); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to generate token")
+		return
+	}
+
+	handlers.SuccessResponse(c, gin.H{
+		"message": "Login successful",
+		"user": gin.H{
+			"id":    claims.UserID,
+			"email": claims.Email,
+		},
+	})
+}
@@ -0,0 +1,165 @@
+package auth
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/handlers"
+	"backend/mail"
+	db "backend/database"
+)
+
+// EmailVerificationTTL is how long a verification link stays valid
+const EmailVerificationTTL = 24 * time.Hour
+
+// EmailVerificationRequired reports whether unverified accounts are blocked
+// from generating meals. Set REQUIRE_EMAIL_VERIFICATION=false to allow them.
+func EmailVerificationRequired() bool {
+	return os.Getenv("REQUIRE_EMAIL_VERIFICATION") != "false"
+}
+
+// EmailVerified reports whether the user has confirmed their email address
+func EmailVerified(ctx context.Context, userID int64) (bool, error) {
+	var verifiedAt sql.NullString
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT email_verified_at FROM users WHERE id = ?",
+		userID,
+	).Scan(&verifiedAt)
+	if err != nil {
+		return false, err
+	}
+	return verifiedAt.Valid, nil
+}
+
+// sendVerificationEmail issues a new verification token, invalidating any
+// earlier ones, and emails the link to the user
+func sendVerificationEmail(ctx context.Context, userID int64, email string) error {
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.DB.ExecContext(ctx,
+		"UPDATE email_verifications SET used_at = datetime('now') WHERE user_id = ? AND used_at IS NULL",
+		userID,
+	); err != nil {
+		return err
+	}
+
+	if _, err := db.DB.ExecContext(ctx,
+		"INSERT INTO email_verifications (user_id, token_hash, expires_at) VALUES (?, ?, datetime('now', ?))",
+		userID, hash, sqlOffset(EmailVerificationTTL),
+	); err != nil {
+		return err
+	}
+
+	return mail.Send(ctx, mail.Message{
+		To:      email,
+		Subject: "Confirm your email address",
+		Body: fmt.Sprintf(
+			"Welcome! Please confirm your email address by opening this link within %d hours:\n\n%s/verify-email?token=%s\n",
+			int(EmailVerificationTTL.Hours()), appBaseURL(), token,
+		),
+	})
+}
+
+// VerifyEmail confirms an email address using the token from the
+// verification email
+func VerifyEmail(c *gin.Context) {
+	token := c.Query("token")
+	if token == "" {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Verification token required")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer tx.Rollback()
+
+	var verificationID, userID int64
+	err = tx.QueryRowContext(ctx,
+		`SELECT id, user_id FROM email_verifications
+		 WHERE token_hash = ? AND used_at IS NULL AND expires_at > datetime('now')`,
+		hashToken(token),
+	).Scan(&verificationID, &userID)
+	if err == sql.ErrNoRows {
+		handlers.ErrorResponse(c, http.StatusBadRequest, "Invalid or expired verification token")
+		return
+	}
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE email_verifications SET used_at = datetime('now') WHERE id = ?",
+		verificationID,
+	); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE users SET email_verified_at = COALESCE(email_verified_at, datetime('now')),
+		 updated_at = datetime('now') WHERE id = ?`,
+		userID,
+	); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to verify email")
+		return
+	}
+
+	handlers.SuccessResponse(c, gin.H{
+		"message": "Email verified successfully",
+	})
+}
+
+// ResendVerification emails a fresh verification link to the
+// authenticated user
+func ResendVerification(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	email, _ := c.Get("user_email")
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	verified, err := EmailVerified(ctx, userID.(int64))
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	if verified {
+		handlers.ErrorResponse(c, http.StatusConflict, "Email already verified")
+		return
+	}
+
+	if err := sendVerificationEmail(ctx, userID.(int64), email.(string)); err != nil {
+		log.Printf("Failed to send verification email to user %d: %v", userID, err)
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to send verification email")
+		return
+	}
+
+	handlers.SuccessResponse(c, gin.H{
+		"message": "Verification email sent",
+	})
+}
@@ -0,0 +1,140 @@
+// Package billing lets users pay for a plan. A PaymentProvider hosts the
+// checkout and reports subscription changes through signed webhook events,
+// which Apply turns into plan changes.
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+)
+
+// Webhook event types
+const (
+	// EventCheckoutCompleted starts a subscription to the plan bought
+	EventCheckoutCompleted = "checkout.completed"
+	// EventSubscriptionUpdated moves a subscription to another plan
+	EventSubscriptionUpdated = "subscription.updated"
+	// EventSubscriptionCanceled ends a subscription; the user returns to
+	// the default plan
+	EventSubscriptionCanceled = "subscription.canceled"
+)
+
+// CheckoutRequest asks the provider for a hosted checkout page
+type CheckoutRequest struct {
+	UserID     int64
+	Email      string
+	PlanID     string
+	PriceID    string
+	SuccessURL string
+	CancelURL  string
+}
+
+// CheckoutSession is a checkout page the user is sent to
+type CheckoutSession struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+}
+
+// Event is a verified webhook event
+type Event struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	// Created is when the provider created the event, in Unix seconds
+	Created int64     `json:"created"`
+	Data    EventData `json:"data"`
+}
+
+// EventData describes the subscription an event is about
+type EventData struct {
+	CheckoutID     string `json:"checkout_id,omitempty"`
+	UserID         int64  `json:"user_id,omitempty"`
+	PlanID         string `json:"plan_id"`
+	CustomerID     string `json:"customer_id"`
+	SubscriptionID string `json:"subscription_id"`
+}
+
+// PaymentProvider is implemented by every payment backend
+type PaymentProvider interface {
+	// Name identifies the provider in stored sessions and events
+	Name() string
+	// CreateCheckout starts a hosted checkout for req
+	CreateCheckout(ctx context.Context, req CheckoutRequest) (*CheckoutSession, error)
+	// ParseWebhook authenticates a webhook delivery and decodes its event.
+	// It returns ErrInvalidSignature for deliveries that are not genuine.
+	ParseWebhook(header http.Header, body []byte) (*Event, error)
+	// CancelSubscription ends a subscription at the provider so it is no
+	// longer charged. Cancelling one that has already ended is not an
+	// error.
+	CancelSubscription(ctx context.Context, subscriptionID string) error
+}
+
+// Config selects and configures a provider
+type Config struct {
+	Provider      string
+	WebhookSecret string
+	// WebhookURL is where the fake provider delivers its events
+	WebhookURL string
+	// CheckoutBaseURL prefixes the fake provider's checkout pages
+	CheckoutBaseURL string
+}
+
+const (
+	ProviderFake = "fake"
+
+	DefaultWebhookURL      = "http://localhost:8080/billing/webhook"
+	DefaultCheckoutBaseURL = "http://localhost:8080/billing/fake/checkout"
+)
+
+// ErrNotConfigured is returned when no provider has been initialised
+var ErrNotConfigured = errors.New("billing provider not configured")
+
+// Default is the provider used for checkouts and webhooks
+var Default PaymentProvider
+
+// ConfigFromEnv builds a Config from BILLING_PROVIDER,
+// BILLING_WEBHOOK_SECRET, BILLING_WEBHOOK_URL and BILLING_CHECKOUT_BASE_URL.
+// Billing is disabled when BILLING_PROVIDER is empty.
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Provider:        os.Getenv("BILLING_PROVIDER"),
+		WebhookSecret:   os.Getenv("BILLING_WEBHOOK_SECRET"),
+		WebhookURL:      os.Getenv("BILLING_WEBHOOK_URL"),
+		CheckoutBaseURL: os.Getenv("BILLING_CHECKOUT_BASE_URL"),
+	}
+	if cfg.WebhookURL == "" {
+		cfg.WebhookURL = DefaultWebhookURL
+	}
+	if cfg.CheckoutBaseURL == "" {
+		cfg.CheckoutBaseURL = DefaultCheckoutBaseURL
+	}
+	return cfg
+}
+
+// NewProvider creates the provider named in cfg, or returns nil when
+// billing is disabled
+func NewProvider(cfg Config) (PaymentProvider, error) {
+	switch cfg.Provider {
+	case "":
+		return nil, nil
+	case ProviderFake:
+		if cfg.WebhookSecret == "" {
+			return nil, errors.New("BILLING_WEBHOOK_SECRET must be set")
+		}
+		return NewFakeProvider(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown billing provider %q", cfg.Provider)
+	}
+}
+
+// InitProvider creates the provider named in cfg and makes it the default
+func InitProvider(cfg Config) error {
+	p, err := NewProvider(cfg)
+	if err != nil {
+		return err
+	}
+	Default = p
+	return nil
+}
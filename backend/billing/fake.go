@@ -0,0 +1,199 @@
+package billing
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ErrUnknownSession is returned by the fake provider for checkout sessions
+// and subscriptions it did not create
+var ErrUnknownSession = errors.New("unknown checkout session or subscription")
+
+// FakeProvider is a payment provider for tests and local development. Its
+// checkouts are completed by calling CompleteCheckout instead of paying,
+// and every change is delivered as a signed webhook event, just like a
+// real provider would.
+type FakeProvider struct {
+	mu            sync.Mutex
+	secret        string
+	webhookURL    string
+	checkoutURL   string
+	sessions      map[string]CheckoutRequest
+	subscriptions map[string]EventData
+
+	// Deliver sends a signed event. It posts to the configured webhook URL
+	// unless replaced, e.g. by tests that serve the webhook in process.
+	Deliver func(ctx context.Context, header http.Header, body []byte) error
+}
+
+// NewFakeProvider creates a fake provider signing events with
+// cfg.WebhookSecret
+func NewFakeProvider(cfg Config) *FakeProvider {
+	p := &FakeProvider{
+		secret:        cfg.WebhookSecret,
+		webhookURL:    cfg.WebhookURL,
+		checkoutURL:   cfg.CheckoutBaseURL,
+		sessions:      map[string]CheckoutRequest{},
+		subscriptions: map[string]EventData{},
+	}
+	p.Deliver = p.post
+	return p
+}
+
+func (p *FakeProvider) Name() string {
+	return ProviderFake
+}
+
+func (p *FakeProvider) CreateCheckout(ctx context.Context, req CheckoutRequest) (*CheckoutSession, error) {
+	id, err := fakeID("cs")
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	p.sessions[id] = req
+	p.mu.Unlock()
+
+	return &CheckoutSession{ID: id, URL: p.checkoutURL + "/" + id}, nil
+}
+
+func (p *FakeProvider) ParseWebhook(header http.Header, body []byte) (*Event, error) {
+	if err := VerifySignature(p.secret, header.Get(SignatureHeader), body, time.Now()); err != nil {
+		return nil, err
+	}
+	var e Event
+	if err := json.Unmarshal(body, &e); err != nil {
+		return nil, fmt.Errorf("invalid event: %w", err)
+	}
+	return &e, nil
+}
+
+// CompleteCheckout pays for a checkout session and emits
+// EventCheckoutCompleted
+func (p *FakeProvider) CompleteCheckout(ctx context.Context, sessionID string) (*Event, error) {
+	customer, err := fakeID("cus")
+	if err != nil {
+		return nil, err
+	}
+	subscription, err := fakeID("sub")
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	req, ok := p.sessions[sessionID]
+	delete(p.sessions, sessionID)
+	data := EventData{
+		CheckoutID:     sessionID,
+		UserID:         req.UserID,
+		PlanID:         req.PlanID,
+		CustomerID:     customer,
+		SubscriptionID: subscription,
+	}
+	if ok {
+		p.subscriptions[subscription] = data
+	}
+	p.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownSession
+	}
+
+	return p.emit(ctx, EventCheckoutCompleted, data)
+}
+
+// ChangePlan moves a subscription to another plan and emits
+// EventSubscriptionUpdated
+func (p *FakeProvider) ChangePlan(ctx context.Context, subscriptionID, planID string) (*Event, error) {
+	p.mu.Lock()
+	data, ok := p.subscriptions[subscriptionID]
+	data.PlanID = planID
+	if ok {
+		p.subscriptions[subscriptionID] = data
+	}
+	p.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownSession
+	}
+
+	data.CheckoutID = ""
+	return p.emit(ctx, EventSubscriptionUpdated, data)
+}
+
+// Cancel ends a subscription and emits EventSubscriptionCanceled
+func (p *FakeProvider) Cancel(ctx context.Context, subscriptionID string) (*Event, error) {
+	p.mu.Lock()
+	data, ok := p.subscriptions[subscriptionID]
+	delete(p.subscriptions, subscriptionID)
+	p.mu.Unlock()
+	if !ok {
+		return nil, ErrUnknownSession
+	}
+
+	data.CheckoutID = ""
+	return p.emit(ctx, EventSubscriptionCanceled, data)
+}
+
+func (p *FakeProvider) CancelSubscription(ctx context.Context, subscriptionID string) error {
+	_, err := p.Cancel(ctx, subscriptionID)
+	if errors.Is(err, ErrUnknownSession) {
+		return nil
+	}
+	return err
+}
+
+// Emit signs e and delivers it, e.g. to deliver an event again
+func (p *FakeProvider) Emit(ctx context.Context, e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	header := http.Header{}
+	header.Set("Content-Type", "application/json")
+	header.Set(SignatureHeader, Sign(p.secret, body, time.Now()))
+	return p.Deliver(ctx, header, body)
+}
+
+func (p *FakeProvider) emit(ctx context.Context, eventType string, data EventData) (*Event, error) {
+	id, err := fakeID("evt")
+	if err != nil {
+		return nil, err
+	}
+	e := &Event{ID: id, Type: eventType, Created: time.Now().Unix(), Data: data}
+	return e, p.Emit(ctx, e)
+}
+
+// post delivers an event to the webhook URL
+func (p *FakeProvider) post(ctx context.Context, header http.Header, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header = header
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// fakeID returns a random ID such as cs_fake_1f2e...
+func fakeID(prefix string) (string, error) {
+	buf := make([]byte, 12)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return prefix + "_fake_" + hex.EncodeToString(buf), nil
+}
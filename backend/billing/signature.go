@@ -0,0 +1,68 @@
+package billing
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SignatureHeader carries the webhook signature:
+// t=<unix seconds>,v1=<hex HMAC-SHA256 of "<t>.<body>">. Several v1 values
+// may be sent while a secret is being rotated.
+const SignatureHeader = "Billing-Signature"
+
+// SignatureTolerance is how old a signature may be, limiting replays
+var SignatureTolerance = 5 * time.Minute
+
+// ErrInvalidSignature is returned for webhook deliveries that are not
+// signed with the shared secret, or were signed too long ago
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign returns the SignatureHeader value for body signed at at
+func Sign(secret string, body []byte, at time.Time) string {
+	t := strconv.FormatInt(at.Unix(), 10)
+	return fmt.Sprintf("t=%s,v1=%s", t, signature(secret, t, body))
+}
+
+// VerifySignature checks header, a SignatureHeader value, against body
+func VerifySignature(secret, header string, body []byte, now time.Time) error {
+	var t string
+	var candidates []string
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			t = value
+		case "v1":
+			candidates = append(candidates, value)
+		}
+	}
+
+	at, err := strconv.ParseInt(t, 10, 64)
+	if err != nil || len(candidates) == 0 {
+		return ErrInvalidSignature
+	}
+	if age := now.Sub(time.Unix(at, 0)); age > SignatureTolerance || age < -SignatureTolerance {
+		return ErrInvalidSignature
+	}
+
+	expected := signature(secret, t, body)
+	for _, candidate := range candidates {
+		if hmac.Equal([]byte(candidate), []byte(expected)) {
+			return nil
+		}
+	}
+	return ErrInvalidSignature
+}
+
+func signature(secret, t string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(t + "."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
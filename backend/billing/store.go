@@ -0,0 +1,305 @@
+package billing
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"backend/audit"
+	db "backend/database"
+)
+
+var (
+	// ErrNotForSale is returned for plans without a price
+	ErrNotForSale = errors.New("plan cannot be bought")
+	// ErrAlreadySubscribed is returned when the user has an active
+	// subscription, which must be changed rather than bought again
+	ErrAlreadySubscribed = errors.New("already subscribed")
+	// ErrCheckoutInProgress is returned while the user has an open checkout,
+	// since completing two would start two subscriptions
+	ErrCheckoutInProgress = errors.New("checkout in progress")
+	// ErrSubscriptionConflict is returned for a completed checkout when the
+	// user already has another active subscription
+	ErrSubscriptionConflict = errors.New("another subscription is active")
+)
+
+// CheckoutTTL is how long an open checkout blocks new ones. Providers
+// should be set up to expire their sessions by then.
+var CheckoutTTL = time.Hour
+
+// Subscription is the user's subscription as last reported by the provider
+type Subscription struct {
+	Provider  string `json:"provider"`
+	PlanID    string `json:"plan_id"`
+	Status    string `json:"status"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// Subscription statuses
+const (
+	StatusActive   = "active"
+	StatusCanceled = "canceled"
+)
+
+// GetSubscription returns the user's subscription, or nil if they never
+// had one
+func GetSubscription(ctx context.Context, userID int64) (*Subscription, error) {
+	var s Subscription
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT provider, plan_id, status, created_at, updated_at FROM subscriptions WHERE user_id = ?",
+		userID,
+	).Scan(&s.Provider, &s.PlanID, &s.Status, &s.CreatedAt, &s.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// CancelSubscription ends the user's active subscription at the provider,
+// e.g. before the account is deleted and the subscription forgotten. Users
+// without one are left alone.
+func CancelSubscription(ctx context.Context, userID int64) error {
+	var provider, subscriptionID string
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT provider, subscription_id FROM subscriptions WHERE user_id = ? AND status = ?",
+		userID, StatusActive,
+	).Scan(&provider, &subscriptionID)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if Default == nil || Default.Name() != provider {
+		return fmt.Errorf("%w: the subscription is with %s", ErrNotConfigured, provider)
+	}
+	if err := Default.CancelSubscription(ctx, subscriptionID); err != nil {
+		return fmt.Errorf("failed to cancel subscription %s: %w", subscriptionID, err)
+	}
+	return nil
+}
+
+// StartCheckout creates a checkout session for the user to buy planID
+func StartCheckout(ctx context.Context, userID int64, planID, successURL, cancelURL string) (*CheckoutSession, error) {
+	if Default == nil {
+		return nil, ErrNotConfigured
+	}
+
+	var priceID sql.NullString
+	err := db.DB.QueryRowContext(ctx, "SELECT price_id FROM plans WHERE id = ?", planID).Scan(&priceID)
+	if err == sql.ErrNoRows {
+		return nil, db.ErrUnknownPlan
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !priceID.Valid {
+		return nil, ErrNotForSale
+	}
+
+	var email string
+	var subscribed, checkingOut bool
+	err = db.DB.QueryRowContext(ctx,
+		`SELECT email,
+		   EXISTS(SELECT 1 FROM subscriptions WHERE user_id = u.id AND status = ?),
+		   EXISTS(SELECT 1 FROM checkout_sessions WHERE user_id = u.id AND status = 'open'
+		            AND created_at > datetime('now', ?))
+		 FROM users u WHERE id = ?`,
+		StatusActive, fmt.Sprintf("-%d seconds", int64(CheckoutTTL.Seconds())), userID,
+	).Scan(&email, &subscribed, &checkingOut)
+	if err != nil {
+		return nil, err
+	}
+	if subscribed {
+		return nil, ErrAlreadySubscribed
+	}
+	if checkingOut {
+		return nil, ErrCheckoutInProgress
+	}
+
+	session, err := Default.CreateCheckout(ctx, CheckoutRequest{
+		UserID:     userID,
+		Email:      email,
+		PlanID:     planID,
+		PriceID:    priceID.String,
+		SuccessURL: successURL,
+		CancelURL:  cancelURL,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create checkout: %w", err)
+	}
+
+	if _, err := db.DB.ExecContext(ctx,
+		"INSERT INTO checkout_sessions (id, user_id, provider, plan_id) VALUES (?, ?, ?, ?)",
+		session.ID, userID, Default.Name(), planID,
+	); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
+
+// Result describes what Apply did with an event
+type Result struct {
+	// Duplicate is set for events that were already processed
+	Duplicate bool
+	// Stale is set for events older than the last one applied to the
+	// subscription, which are recorded but not applied
+	Stale  bool
+	UserID int64
+	// FromPlan and ToPlan are set when the user's plan changed
+	FromPlan string
+	ToPlan   string
+}
+
+// Apply processes a verified webhook event exactly once. Redelivered
+// events are recognised by their ID and change nothing. A plan change
+// replaces any quota set by an admin and caps the rollover to the new
+// plan's.
+func Apply(ctx context.Context, provider string, e *Event) (*Result, error) {
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res := &Result{}
+	inserted, err := tx.ExecContext(ctx,
+		`INSERT INTO billing_events (provider, event_id, type) VALUES (?, ?, ?)
+		 ON CONFLICT(provider, event_id) DO NOTHING`,
+		provider, e.ID, e.Type,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to record event: %w", err)
+	}
+	if n, _ := inserted.RowsAffected(); n == 0 {
+		res.Duplicate = true
+		return res, nil
+	}
+
+	created := time.Unix(e.Created, 0).UTC().Format("2006-01-02 15:04:05")
+	var planID, status string
+	switch e.Type {
+	case EventCheckoutCompleted:
+		err = tx.QueryRowContext(ctx,
+			`UPDATE checkout_sessions SET status = 'completed', completed_at = datetime('now')
+			 WHERE id = ? AND provider = ? AND status = 'open'
+			 RETURNING user_id, plan_id`,
+			e.Data.CheckoutID, provider,
+		).Scan(&res.UserID, &planID)
+		if err == sql.ErrNoRows {
+			// Unknown or deleted with the account: nothing to apply
+			return res, tx.Commit()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to complete checkout: %w", err)
+		}
+		status = StatusActive
+
+		// Never replace another active subscription, which would leave it
+		// billed but untracked. Failing makes the provider deliver the event
+		// again, and the log shows it needs attention.
+		var active string
+		err = tx.QueryRowContext(ctx,
+			"SELECT subscription_id FROM subscriptions WHERE user_id = ? AND status = ?",
+			res.UserID, StatusActive,
+		).Scan(&active)
+		if err == nil && active != e.Data.SubscriptionID {
+			return nil, fmt.Errorf("%w: user %d has %s", ErrSubscriptionConflict, res.UserID, active)
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return nil, err
+		}
+
+		_, err = tx.ExecContext(ctx,
+			`INSERT INTO subscriptions (user_id, provider, customer_id, subscription_id, plan_id, status, last_event_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?)
+			 ON CONFLICT(user_id) DO UPDATE SET provider = excluded.provider, customer_id = excluded.customer_id,
+			   subscription_id = excluded.subscription_id, plan_id = excluded.plan_id, status = excluded.status,
+			   last_event_at = excluded.last_event_at, updated_at = datetime('now')`,
+			res.UserID, provider, e.Data.CustomerID, e.Data.SubscriptionID, planID, status, created,
+		)
+
+	case EventSubscriptionUpdated, EventSubscriptionCanceled:
+		planID, status = e.Data.PlanID, StatusActive
+		if e.Type == EventSubscriptionCanceled {
+			planID, status = db.DefaultPlan, StatusCanceled
+		}
+		err = tx.QueryRowContext(ctx,
+			`UPDATE subscriptions SET plan_id = ?, status = ?, last_event_at = ?, updated_at = datetime('now')
+			 WHERE provider = ? AND subscription_id = ? AND last_event_at <= ?
+			 RETURNING user_id`,
+			planID, status, created, provider, e.Data.SubscriptionID, created,
+		).Scan(&res.UserID)
+		if err == sql.ErrNoRows {
+			// Unknown subscription, or a newer event was applied already
+			res.Stale = true
+			return res, tx.Commit()
+		}
+
+	default:
+		// Other event types are acknowledged and ignored
+		return res, tx.Commit()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update subscription: %w", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE billing_events SET user_id = ? WHERE provider = ? AND event_id = ?",
+		res.UserID, provider, e.ID); err != nil {
+		return nil, err
+	}
+
+	if err := tx.QueryRowContext(ctx, "SELECT plan_id FROM users WHERE id = ?", res.UserID).Scan(&res.FromPlan); err != nil {
+		return nil, err
+	}
+	if err := changePlan(ctx, tx, res.UserID, planID); err != nil {
+		return nil, err
+	}
+	res.ToPlan = planID
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	audit.Record(ctx, audit.Entry{
+		UserID: res.UserID,
+		Action: audit.ActionPlanChanged,
+		Detail: map[string]any{"from": res.FromPlan, "to": res.ToPlan, "via": "billing", "event": e.ID},
+	})
+	return res, nil
+}
+
+// changePlan moves the user to planID and resets their quota to the plan's
+func changePlan(ctx context.Context, tx *sql.Tx, userID int64, planID string) error {
+	var rolloverMax int
+	err := tx.QueryRowContext(ctx, "SELECT rollover_max FROM plans WHERE id = ?", planID).Scan(&rolloverMax)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%w: %s", db.ErrUnknownPlan, planID)
+	}
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx,
+		"UPDATE users SET plan_id = ?, updated_at = datetime('now') WHERE id = ?",
+		planID, userID,
+	); err != nil {
+		return fmt.Errorf("failed to change plan: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx,
+		`UPDATE users_tracking SET meals_override = NULL, tokens_override = NULL, cost_override = NULL,
+		   rollover = MIN(rollover, ?), updated_at = datetime('now')
+		 WHERE user_id = ?`,
+		rolloverMax, userID,
+	); err != nil {
+		return fmt.Errorf("failed to reset quota: %w", err)
+	}
+	return nil
+}
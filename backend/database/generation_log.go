@@ -0,0 +1,185 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Outcomes of a generation in the generation log
+const (
+	GenerationOK = "ok"
+	// GenerationRejected is a model answer that failed validation
+	GenerationRejected = "rejected"
+	GenerationFailed   = "failed"
+)
+
+// History buckets
+const (
+	BucketHour = "hour"
+	BucketDay  = "day"
+	BucketWeek = "week"
+)
+
+// ErrUnknownBucket is returned for a bucket other than hour, day or week
+var ErrUnknownBucket = errors.New("unknown bucket")
+
+// bucketStarts are the SQL expressions for the start of the bucket a row
+// falls in. Weeks start on Monday.
+var bucketStarts = map[string]string{
+	BucketHour: "strftime('%Y-%m-%d %H:00:00', created_at)",
+	BucketDay:  "strftime('%Y-%m-%d 00:00:00', created_at)",
+	BucketWeek: "strftime('%Y-%m-%d 00:00:00', created_at, 'weekday 0', '-6 days')",
+}
+
+// bucketEnd is the end of the bucket starting at start
+func bucketEnd(bucket string, start time.Time) time.Time {
+	switch bucket {
+	case BucketHour:
+		return start.Add(time.Hour)
+	case BucketWeek:
+		return start.AddDate(0, 0, 7)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Generation is one meal generation in the generation log. Model and the
+// token counts are only set when the model answered.
+type Generation struct {
+	ID             int64     `json:"id"`
+	UserID         int64     `json:"-"`
+	Endpoint       string    `json:"endpoint"`
+	ConversationID *int64    `json:"conversation_id"`
+	PromptSummary  string    `json:"prompt_summary"`
+	Model          *string   `json:"model"`
+	InputTokens    int64     `json:"input_tokens"`
+	OutputTokens   int64     `json:"output_tokens"`
+	LatencyMs      int64     `json:"latency_ms"`
+	Status         string    `json:"status"`
+	Error          *string   `json:"error"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// RecordGeneration appends g to the generation log. CreatedAt is when the
+// generation started.
+func RecordGeneration(ctx context.Context, g Generation) error {
+	_, err := DB.ExecContext(ctx,
+		`INSERT INTO generation_log (user_id, endpoint, conversation_id, prompt_summary, model,
+		   input_tokens, output_tokens, latency_ms, status, error, created_at)
+		 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		g.UserID, g.Endpoint, g.ConversationID, g.PromptSummary, g.Model,
+		g.InputTokens, g.OutputTokens, g.LatencyMs, g.Status, g.Error,
+		g.CreatedAt.UTC().Format(sqlTimeLayout),
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record generation: %w", err)
+	}
+	return nil
+}
+
+// GenerationBucket is a period of the generation log. The totals cover
+// every generation of the bucket in the requested range, Generations only
+// those on the requested page.
+type GenerationBucket struct {
+	Start        time.Time    `json:"start"`
+	End          time.Time    `json:"end"`
+	Requests     int          `json:"requests"`
+	Succeeded    int          `json:"succeeded"`
+	Failed       int          `json:"failed"`
+	InputTokens  int64        `json:"input_tokens"`
+	OutputTokens int64        `json:"output_tokens"`
+	AvgLatencyMs int64        `json:"avg_latency_ms"`
+	Generations  []Generation `json:"generations"`
+}
+
+// GenerationHistory returns one page of the user's generation log in
+// [f.From, f.To), newest first, grouped into buckets, along with the number
+// of generations in the range
+func GenerationHistory(ctx context.Context, f UsageFilter, bucket string, limit, offset int) ([]*GenerationBucket, int, error) {
+	startExpr, ok := bucketStarts[bucket]
+	if !ok {
+		return nil, 0, ErrUnknownBucket
+	}
+	where := "WHERE user_id = ? AND created_at >= ? AND created_at < ?"
+	args := []any{f.UserID, f.From.UTC().Format(sqlTimeLayout), f.To.UTC().Format(sqlTimeLayout)}
+
+	var total int
+	if err := DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM generation_log "+where, args...).Scan(&total); err != nil {
+		return nil, 0, err
+	}
+
+	rows, err := DB.QueryContext(ctx,
+		`SELECT id, endpoint, conversation_id, prompt_summary, model, input_tokens, output_tokens,
+		        latency_ms, status, error, created_at, `+startExpr+`
+		 FROM generation_log `+where+`
+		 ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?`,
+		append(args, limit, offset)...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	buckets := []*GenerationBucket{}
+	byStart := map[string]*GenerationBucket{}
+	for rows.Next() {
+		var g Generation
+		var createdAt, start string
+		if err := rows.Scan(&g.ID, &g.Endpoint, &g.ConversationID, &g.PromptSummary, &g.Model,
+			&g.InputTokens, &g.OutputTokens, &g.LatencyMs, &g.Status, &g.Error, &createdAt, &start); err != nil {
+			return nil, 0, err
+		}
+		if g.CreatedAt, err = time.Parse(sqlTimeLayout, createdAt); err != nil {
+			return nil, 0, err
+		}
+		g.UserID = f.UserID
+
+		b := byStart[start]
+		if b == nil {
+			s, err := time.Parse(sqlTimeLayout, start)
+			if err != nil {
+				return nil, 0, err
+			}
+			b = &GenerationBucket{Start: s, End: bucketEnd(bucket, s), Generations: []Generation{}}
+			byStart[start] = b
+			buckets = append(buckets, b)
+		}
+		b.Generations = append(b.Generations, g)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+	if len(buckets) == 0 {
+		return buckets, total, nil
+	}
+
+	// Totals for the buckets on this page, which may hold generations on
+	// neighbouring pages too
+	oldest, newest := buckets[len(buckets)-1], buckets[0]
+	totals, err := DB.QueryContext(ctx,
+		`SELECT `+startExpr+`, COUNT(*), SUM(status = 'ok'), SUM(input_tokens), SUM(output_tokens),
+		        CAST(AVG(latency_ms) AS INTEGER)
+		 FROM generation_log `+where+` AND created_at >= ? AND created_at < ?
+		 GROUP BY 1`,
+		append(args, oldest.Start.Format(sqlTimeLayout), newest.End.Format(sqlTimeLayout))...,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer totals.Close()
+
+	for totals.Next() {
+		var start string
+		var t GenerationBucket
+		if err := totals.Scan(&start, &t.Requests, &t.Succeeded,
+			&t.InputTokens, &t.OutputTokens, &t.AvgLatencyMs); err != nil {
+			return nil, 0, err
+		}
+		if b := byStart[start]; b != nil {
+			b.Requests, b.Succeeded, b.Failed = t.Requests, t.Succeeded, t.Requests-t.Succeeded
+			b.InputTokens, b.OutputTokens, b.AvgLatencyMs = t.InputTokens, t.OutputTokens, t.AvgLatencyMs
+		}
+	}
+	return buckets, total, totals.Err()
+}
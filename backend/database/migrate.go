@@ -0,0 +1,346 @@
+package database
+
+import (
+	"context"
+	"crypto/rand"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// Migration is a single versioned schema change with its rollback
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationState reports whether a migration has been applied
+type MigrationState struct {
+	Migration
+	AppliedAt string
+}
+
+func (s MigrationState) Applied() bool {
+	return s.AppliedAt != ""
+}
+
+// MigrationLockTimeout bounds how long we wait for another instance to
+// finish migrating
+var MigrationLockTimeout = 60 * time.Second
+
+const (
+	// lockStaleAfter releases locks left behind by a crashed instance
+	lockStaleAfter = 5 * time.Minute
+	// migrationTimeout bounds a single migration
+	migrationTimeout = 30 * time.Second
+)
+
+// ErrMigrationLocked is returned when another instance holds the lock for
+// longer than MigrationLockTimeout
+var ErrMigrationLocked = errors.New("migrations locked by another instance")
+
+// LoadMigrations parses the embedded migrations/NNNN_name.{up,down}.sql
+// files, ordered by version
+func LoadMigrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		name := entry.Name()
+
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			return nil, fmt.Errorf("unexpected migration file %s", name)
+		}
+
+		base := strings.TrimSuffix(name, "."+direction+".sql")
+		versionStr, label, ok := strings.Cut(base, "_")
+		if !ok {
+			return nil, fmt.Errorf("migration file %s must be named NNNN_name.%s.sql", name, direction)
+		}
+		version, err := strconv.Atoi(versionStr)
+		if err != nil {
+			return nil, fmt.Errorf("migration file %s has invalid version: %w", name, err)
+		}
+
+		body, err := fs.ReadFile(migrationFiles, path.Join("migrations", name))
+		if err != nil {
+			return nil, err
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: label}
+			byVersion[version] = m
+		} else if m.Name != label {
+			return nil, fmt.Errorf("migration %d has conflicting names %q and %q", version, m.Name, label)
+		}
+
+		if direction == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+
+	return migrations, nil
+}
+
+// ensureMigrationTables creates the bookkeeping tables used by the runner
+func ensureMigrationTables(ctx context.Context) error {
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		name TEXT NOT NULL,
+		applied_at TEXT NOT NULL DEFAULT (datetime('now'))
+	);
+	CREATE TABLE IF NOT EXISTS schema_migrations_lock (
+		id INTEGER PRIMARY KEY CHECK (id = 1),
+		owner TEXT NOT NULL,
+		locked_at TEXT NOT NULL DEFAULT (datetime('now'))
+	);
+	`
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	_, err := DB.ExecContext(ctx, query)
+	return err
+}
+
+// acquireMigrationLock takes the single-row lock so that two instances
+// starting at once do not apply the same migration twice
+func acquireMigrationLock(ctx context.Context) (string, error) {
+	buf := make([]byte, 8)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	host, _ := os.Hostname()
+	owner := fmt.Sprintf("%s-%d-%s", host, os.Getpid(), hex.EncodeToString(buf))
+
+	deadline := time.Now().Add(MigrationLockTimeout)
+	for {
+		// Clear locks abandoned by a crashed instance
+		_, err := DB.ExecContext(ctx,
+			"DELETE FROM schema_migrations_lock WHERE locked_at < datetime('now', ?)",
+			fmt.Sprintf("-%d seconds", int(lockStaleAfter.Seconds())),
+		)
+		if err != nil {
+			return "", fmt.Errorf("failed to clear stale migration lock: %w", err)
+		}
+
+		result, err := DB.ExecContext(ctx,
+			"INSERT OR IGNORE INTO schema_migrations_lock (id, owner) VALUES (1, ?)",
+			owner,
+		)
+		if err != nil {
+			return "", fmt.Errorf("failed to acquire migration lock: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 1 {
+			return owner, nil
+		}
+
+		if time.Now().After(deadline) {
+			return "", ErrMigrationLocked
+		}
+		select {
+		case <-ctx.Done():
+			return "", ctx.Err()
+		case <-time.After(500 * time.Millisecond):
+		}
+	}
+}
+
+func releaseMigrationLock(owner string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := DB.ExecContext(ctx, "DELETE FROM schema_migrations_lock WHERE owner = ?", owner); err != nil {
+		log.Printf("Warning: Failed to release migration lock: %v", err)
+	}
+}
+
+// withMigrationLock runs fn while holding the migration lock
+func withMigrationLock(ctx context.Context, fn func() error) error {
+	if err := ensureMigrationTables(ctx); err != nil {
+		return fmt.Errorf("failed to create migration tables: %w", err)
+	}
+
+	owner, err := acquireMigrationLock(ctx)
+	if err != nil {
+		return err
+	}
+	defer releaseMigrationLock(owner)
+
+	return fn()
+}
+
+// appliedMigrations returns applied_at keyed by version
+func appliedMigrations(ctx context.Context) (map[int]string, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT version, applied_at FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]string{}
+	for rows.Next() {
+		var version int
+		var appliedAt string
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// runMigration executes one migration body and records the result in the
+// same transaction
+func runMigration(ctx context.Context, m Migration, up bool) error {
+	ctx, cancel := context.WithTimeout(ctx, migrationTimeout)
+	defer cancel()
+
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	body := m.Up
+	if !up {
+		body = m.Down
+	}
+	if strings.TrimSpace(body) != "" {
+		if _, err := tx.ExecContext(ctx, body); err != nil {
+			return err
+		}
+	}
+
+	if up {
+		_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES (?, ?)", m.Version, m.Name)
+	} else {
+		_, err = tx.ExecContext(ctx, "DELETE FROM schema_migrations WHERE version = ?", m.Version)
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// MigrateUp applies every pending migration in version order and returns
+// the migrations it applied
+func MigrateUp(ctx context.Context) ([]Migration, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	err = withMigrationLock(ctx, func() error {
+		applied, err := appliedMigrations(ctx)
+		if err != nil {
+			return err
+		}
+
+		for _, m := range migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if err := runMigration(ctx, m, true); err != nil {
+				return fmt.Errorf("migration %04d_%s failed: %w", m.Version, m.Name, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// MigrateDown rolls back the most recently applied steps migrations and
+// returns the migrations it rolled back
+func MigrateDown(ctx context.Context, steps int) ([]Migration, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	err = withMigrationLock(ctx, func() error {
+		applied, err := appliedMigrations(ctx)
+		if err != nil {
+			return err
+		}
+
+		for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			m := migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+			if err := runMigration(ctx, m, false); err != nil {
+				return fmt.Errorf("rollback of %04d_%s failed: %w", m.Version, m.Name, err)
+			}
+			done = append(done, m)
+		}
+		return nil
+	})
+
+	return done, err
+}
+
+// MigrationStatus lists every known migration and when it was applied
+func MigrationStatus(ctx context.Context) ([]MigrationState, error) {
+	migrations, err := LoadMigrations()
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureMigrationTables(ctx); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	states := make([]MigrationState, 0, len(migrations))
+	for _, m := range migrations {
+		states = append(states, MigrationState{Migration: m, AppliedAt: applied[m.Version]})
+	}
+	return states, nil
+}
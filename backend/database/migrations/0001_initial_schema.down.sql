@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS users_tracking;
+DROP TABLE IF EXISTS user_preference;
+DROP TABLE IF EXISTS users;
@@ -0,0 +1,4 @@
+ALTER TABLE sessions DROP COLUMN impersonator_id;
+DROP INDEX IF EXISTS idx_users_role;
+ALTER TABLE users DROP COLUMN disabled_at;
+ALTER TABLE users DROP COLUMN role;
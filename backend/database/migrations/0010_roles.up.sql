@@ -0,0 +1,6 @@
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
+ALTER TABLE users ADD COLUMN disabled_at TEXT;
+CREATE INDEX IF NOT EXISTS idx_users_role ON users(role);
+
+-- Set on sessions an admin started as another user
+ALTER TABLE sessions ADD COLUMN impersonator_id INTEGER;
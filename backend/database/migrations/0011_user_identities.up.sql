@@ -0,0 +1,27 @@
+-- External OpenID Connect accounts linked to users
+CREATE TABLE IF NOT EXISTS user_identities (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	provider TEXT NOT NULL,
+	subject TEXT NOT NULL,
+	email TEXT,
+	created_at TEXT NOT NULL DEFAULT (datetime('now')),
+	last_login_at TEXT,
+	UNIQUE (provider, subject),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_user_identities_user_id ON user_identities(user_id);
+
+-- Logins in progress at an identity provider, consumed by the callback
+CREATE TABLE IF NOT EXISTS oidc_states (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	state_hash TEXT NOT NULL UNIQUE,
+	provider TEXT NOT NULL,
+	nonce TEXT NOT NULL,
+	code_verifier TEXT NOT NULL,
+	link_user_id INTEGER,
+	return_to TEXT NOT NULL DEFAULT '/',
+	created_at TEXT NOT NULL DEFAULT (datetime('now')),
+	expires_at TEXT NOT NULL,
+	FOREIGN KEY (link_user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,15 @@
+-- Personal data exports built in the background for large accounts
+CREATE TABLE IF NOT EXISTS data_exports (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	status TEXT NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'running', 'ready', 'failed')),
+	archive BLOB,
+	size_bytes INTEGER NOT NULL DEFAULT 0,
+	error TEXT,
+	created_at TEXT NOT NULL DEFAULT (datetime('now')),
+	started_at TEXT,
+	completed_at TEXT,
+	expires_at TEXT,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_data_exports_user_id ON data_exports(user_id);
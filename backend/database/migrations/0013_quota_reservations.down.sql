@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_quota_reservations_user_id;
+DROP TABLE IF EXISTS quota_reservations;
@@ -0,0 +1,10 @@
+-- Meal generations in flight. Each holds one unit of the user's quota
+-- until it is committed (counted in users_tracking) or released.
+CREATE TABLE IF NOT EXISTS quota_reservations (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	created_at TEXT NOT NULL DEFAULT (datetime('now')),
+	expires_at TEXT NOT NULL,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_quota_reservations_user_id ON quota_reservations(user_id, expires_at);
@@ -0,0 +1,9 @@
+ALTER TABLE users_tracking ADD COLUMN max_meals INTEGER NOT NULL DEFAULT 20;
+UPDATE users_tracking SET max_meals = meals_override WHERE meals_override IS NOT NULL;
+ALTER TABLE users_tracking DROP COLUMN meals_override;
+ALTER TABLE users_tracking DROP COLUMN rollover;
+ALTER TABLE users_tracking DROP COLUMN period_start;
+
+ALTER TABLE users DROP COLUMN plan_id;
+
+DROP TABLE IF EXISTS plans;
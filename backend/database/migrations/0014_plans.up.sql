@@ -0,0 +1,28 @@
+-- Subscription plans. Each grants meals_per_period meal generations per
+-- window; windows restart daily or monthly, anchored to the user's signup.
+-- Up to rollover_max unused meals carry over into the next window.
+CREATE TABLE IF NOT EXISTS plans (
+	id TEXT PRIMARY KEY,
+	name TEXT NOT NULL,
+	meals_per_period INTEGER NOT NULL CHECK (meals_per_period >= 0),
+	period TEXT NOT NULL CHECK (period IN ('daily', 'monthly')),
+	rollover_max INTEGER NOT NULL DEFAULT 0 CHECK (rollover_max >= 0),
+	created_at TEXT NOT NULL DEFAULT (datetime('now')),
+	updated_at TEXT NOT NULL DEFAULT (datetime('now'))
+);
+
+INSERT INTO plans (id, name, meals_per_period, period, rollover_max) VALUES
+	('free', 'Free', 20, 'monthly', 0),
+	('pro', 'Pro', 300, 'monthly', 150),
+	('team', 'Team', 100, 'daily', 0);
+
+ALTER TABLE users ADD COLUMN plan_id TEXT NOT NULL DEFAULT 'free';
+
+-- meal_count now counts the current window, which starts at period_start.
+-- meals_override replaces the plan's allowance for one user and keeps
+-- quotas set by admins; the old lifetime max_meals goes.
+ALTER TABLE users_tracking ADD COLUMN period_start TEXT;
+ALTER TABLE users_tracking ADD COLUMN rollover INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users_tracking ADD COLUMN meals_override INTEGER;
+UPDATE users_tracking SET meals_override = max_meals WHERE max_meals != 20;
+ALTER TABLE users_tracking DROP COLUMN max_meals;
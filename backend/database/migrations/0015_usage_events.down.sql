@@ -0,0 +1,8 @@
+ALTER TABLE users_tracking DROP COLUMN cost_override;
+ALTER TABLE users_tracking DROP COLUMN tokens_override;
+ALTER TABLE plans DROP COLUMN cost_per_period;
+ALTER TABLE plans DROP COLUMN tokens_per_period;
+
+DROP INDEX IF EXISTS idx_llm_usage_events_created_at;
+DROP INDEX IF EXISTS idx_llm_usage_events_user_id;
+DROP TABLE IF EXISTS llm_usage_events;
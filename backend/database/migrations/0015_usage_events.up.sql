@@ -0,0 +1,23 @@
+-- Ledger of model calls with the tokens they consumed. cost_micros is in
+-- millionths of a US dollar, priced when the call was made.
+CREATE TABLE IF NOT EXISTS llm_usage_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	endpoint TEXT NOT NULL,
+	model TEXT NOT NULL,
+	input_tokens INTEGER NOT NULL DEFAULT 0,
+	output_tokens INTEGER NOT NULL DEFAULT 0,
+	cost_micros INTEGER NOT NULL DEFAULT 0,
+	succeeded INTEGER NOT NULL DEFAULT 1,
+	created_at TEXT NOT NULL DEFAULT (datetime('now')),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_llm_usage_events_user_id ON llm_usage_events(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_llm_usage_events_created_at ON llm_usage_events(created_at);
+
+-- Optional token and cost limits per window, next to the meal allowance.
+-- NULL means unlimited; the users_tracking overrides win over the plan.
+ALTER TABLE plans ADD COLUMN tokens_per_period INTEGER;
+ALTER TABLE plans ADD COLUMN cost_per_period INTEGER;
+ALTER TABLE users_tracking ADD COLUMN tokens_override INTEGER;
+ALTER TABLE users_tracking ADD COLUMN cost_override INTEGER;
@@ -0,0 +1,7 @@
+DROP INDEX IF EXISTS idx_billing_events_user_id;
+DROP TABLE IF EXISTS billing_events;
+DROP TABLE IF EXISTS subscriptions;
+DROP INDEX IF EXISTS idx_checkout_sessions_user_id;
+DROP TABLE IF EXISTS checkout_sessions;
+
+ALTER TABLE plans DROP COLUMN price_id;
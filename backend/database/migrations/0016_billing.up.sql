@@ -0,0 +1,47 @@
+-- price_id is the payment provider's price for a plan; plans without one
+-- cannot be bought
+ALTER TABLE plans ADD COLUMN price_id TEXT;
+UPDATE plans SET price_id = 'price_pro_monthly' WHERE id = 'pro';
+UPDATE plans SET price_id = 'price_team_daily' WHERE id = 'team';
+
+-- Checkouts started by users, keyed by the provider's session ID
+CREATE TABLE IF NOT EXISTS checkout_sessions (
+	id TEXT PRIMARY KEY,
+	user_id INTEGER NOT NULL,
+	provider TEXT NOT NULL,
+	plan_id TEXT NOT NULL,
+	status TEXT NOT NULL DEFAULT 'open' CHECK (status IN ('open', 'completed')),
+	created_at TEXT NOT NULL DEFAULT (datetime('now')),
+	completed_at TEXT,
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_checkout_sessions_user_id ON checkout_sessions(user_id);
+
+-- One subscription per user. last_event_at is the creation time of the
+-- newest webhook event applied, so late deliveries of older events are
+-- ignored.
+CREATE TABLE IF NOT EXISTS subscriptions (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL UNIQUE,
+	provider TEXT NOT NULL,
+	customer_id TEXT NOT NULL,
+	subscription_id TEXT NOT NULL UNIQUE,
+	plan_id TEXT NOT NULL,
+	status TEXT NOT NULL CHECK (status IN ('active', 'canceled')),
+	last_event_at TEXT NOT NULL,
+	created_at TEXT NOT NULL DEFAULT (datetime('now')),
+	updated_at TEXT NOT NULL DEFAULT (datetime('now')),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+-- Every webhook event processed. The unique key makes redeliveries no-ops.
+CREATE TABLE IF NOT EXISTS billing_events (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	provider TEXT NOT NULL,
+	event_id TEXT NOT NULL,
+	type TEXT NOT NULL,
+	user_id INTEGER,
+	received_at TEXT NOT NULL DEFAULT (datetime('now')),
+	UNIQUE (provider, event_id)
+);
+CREATE INDEX IF NOT EXISTS idx_billing_events_user_id ON billing_events(user_id);
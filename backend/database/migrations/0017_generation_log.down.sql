@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_generation_log_user_id;
+DROP TABLE IF EXISTS generation_log;
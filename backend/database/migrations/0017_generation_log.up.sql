@@ -0,0 +1,19 @@
+-- One row per meal generation that reached the model, successful or not,
+-- so users and support can see where quota went. created_at is when the
+-- generation started.
+CREATE TABLE IF NOT EXISTS generation_log (
+	id INTEGER PRIMARY KEY AUTOINCREMENT,
+	user_id INTEGER NOT NULL,
+	endpoint TEXT NOT NULL,
+	conversation_id INTEGER,
+	prompt_summary TEXT NOT NULL,
+	model TEXT,
+	input_tokens INTEGER NOT NULL DEFAULT 0,
+	output_tokens INTEGER NOT NULL DEFAULT 0,
+	latency_ms INTEGER NOT NULL,
+	status TEXT NOT NULL CHECK (status IN ('ok', 'rejected', 'failed')),
+	error TEXT,
+	created_at TEXT NOT NULL DEFAULT (datetime('now')),
+	FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS idx_generation_log_user_id ON generation_log(user_id, created_at);
@@ -0,0 +1,130 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// How often a plan's allowance is renewed
+const (
+	PeriodDaily   = "daily"
+	PeriodMonthly = "monthly"
+)
+
+// DefaultPlan is the plan every account starts on
+const DefaultPlan = "free"
+
+// ErrUnknownPlan is returned for plan IDs missing from the plans table
+var ErrUnknownPlan = errors.New("unknown plan")
+
+// sqlTimeLayout is the format of SQLite's datetime('now')
+const sqlTimeLayout = "2006-01-02 15:04:05"
+
+// Plan is a row of plans
+type Plan struct {
+	ID             string `json:"id"`
+	Name           string `json:"name"`
+	MealsPerPeriod int    `json:"meals_per_period"`
+	Period         string `json:"period"`
+	// RolloverMax caps how many unused meals carry into the next window
+	RolloverMax int `json:"rollover_max"`
+	// TokensPerPeriod and CostPerPeriod also limit each window when set
+	TokensPerPeriod *int64  `json:"tokens_per_period"`
+	CostPerPeriod   *Micros `json:"cost_per_period_usd"`
+	// Purchasable plans have a price with the payment provider
+	Purchasable bool `json:"purchasable"`
+}
+
+const planColumns = `id, name, meals_per_period, period, rollover_max, tokens_per_period, cost_per_period,
+	price_id IS NOT NULL`
+
+func scanPlan(row interface{ Scan(...any) error }) (*Plan, error) {
+	var p Plan
+	if err := row.Scan(&p.ID, &p.Name, &p.MealsPerPeriod, &p.Period, &p.RolloverMax,
+		&p.TokensPerPeriod, &p.CostPerPeriod, &p.Purchasable); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// ListPlans returns every plan, smallest allowance first
+func ListPlans(ctx context.Context) ([]*Plan, error) {
+	rows, err := DB.QueryContext(ctx, "SELECT "+planColumns+" FROM plans ORDER BY meals_per_period, id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	plans := []*Plan{}
+	for rows.Next() {
+		p, err := scanPlan(rows)
+		if err != nil {
+			return nil, err
+		}
+		plans = append(plans, p)
+	}
+	return plans, rows.Err()
+}
+
+// GetPlan returns one plan, or ErrUnknownPlan
+func GetPlan(ctx context.Context, id string) (*Plan, error) {
+	p, err := scanPlan(DB.QueryRowContext(ctx, "SELECT "+planColumns+" FROM plans WHERE id = ?", id))
+	if err == sql.ErrNoRows {
+		return nil, ErrUnknownPlan
+	}
+	return p, err
+}
+
+// SetUserPlan moves the user to another plan. The current window and what
+// was used in it are kept; the new allowance applies straight away.
+func SetUserPlan(ctx context.Context, userID int64, planID string) error {
+	if _, err := GetPlan(ctx, planID); err != nil {
+		return err
+	}
+	res, err := DB.ExecContext(ctx,
+		"UPDATE users SET plan_id = ?, updated_at = datetime('now') WHERE id = ?",
+		planID, userID,
+	)
+	if err != nil {
+		return err
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return sql.ErrNoRows
+	}
+	return nil
+}
+
+// Window returns the quota window containing now for an account created at
+// anchor. Daily windows start at the signup time of day. Monthly windows
+// start on the signup day of the month, or the month's last day when it is
+// shorter.
+func Window(anchor, now time.Time, period string) (start, end time.Time) {
+	anchor, now = anchor.UTC(), now.UTC()
+	if now.Before(anchor) {
+		now = anchor
+	}
+
+	if period == PeriodDaily {
+		days := now.Sub(anchor) / (24 * time.Hour)
+		start = anchor.Add(days * 24 * time.Hour)
+		return start, start.Add(24 * time.Hour)
+	}
+
+	months := (now.Year()-anchor.Year())*12 + int(now.Month()-anchor.Month())
+	start = addMonths(anchor, months)
+	if start.After(now) {
+		months--
+		start = addMonths(anchor, months)
+	}
+	return start, addMonths(anchor, months+1)
+}
+
+// addMonths moves t by n months, clamping the day to the target month
+func addMonths(t time.Time, n int) time.Time {
+	first := time.Date(t.Year(), t.Month()+time.Month(n), 1, 0, 0, 0, 0, time.UTC)
+	lastDay := first.AddDate(0, 1, -1).Day()
+	return time.Date(first.Year(), first.Month(), min(t.Day(), lastDay),
+		t.Hour(), t.Minute(), t.Second(), 0, time.UTC)
+}
@@ -0,0 +1,323 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ReservationTTL is how long a reservation holds quota. Generations are
+// cut off before it ends; a reservation left behind by a crash stops
+// counting once it expires.
+var ReservationTTL = 5 * time.Minute
+
+var (
+	ErrQuotaExceeded = errors.New("quota exceeded")
+	// ErrReservationNotFound is returned for reservations that were
+	// committed, released or have expired. An expired unit may already be
+	// held by another request, so it can no longer be counted.
+	ErrReservationNotFound = errors.New("reservation not found or expired")
+)
+
+// Usage is a user's meal quota for the current window. Reserved counts
+// generations in flight, which already count against the limit.
+type Usage struct {
+	Used     int
+	Reserved int
+	// Allowance is what the window grants: the plan's meals per period,
+	// unless an admin overrode it for this user
+	Allowance int
+	// Rollover is what was carried over unused from earlier windows
+	Rollover int
+	// Limit is Allowance plus Rollover
+	Limit int
+
+	// Tokens and Cost are what the window's model calls consumed.
+	// TokenLimit and CostLimit are nil when the plan does not limit them.
+	Tokens     int64
+	TokenLimit *int64
+	Cost       Micros
+	CostLimit  *Micros
+
+	Plan        Plan
+	WindowStart time.Time
+	WindowEnd   time.Time
+}
+
+// Remaining is how many meals the user can still start, never below
+// zero. It is zero once the token or cost limit is reached.
+func (u Usage) Remaining() int {
+	if limit := u.Exhausted(); limit == LimitTokens || limit == LimitCost {
+		return 0
+	}
+	return max(u.Limit-u.Used-u.Reserved, 0)
+}
+
+// Limits that can stop a user generating meals
+const (
+	LimitMeals  = "meals"
+	LimitTokens = "tokens"
+	LimitCost   = "cost"
+)
+
+// Exhausted names the limit that has been reached, or "" if none has
+func (u Usage) Exhausted() string {
+	switch {
+	case u.Used+u.Reserved >= u.Limit:
+		return LimitMeals
+	case u.TokenLimit != nil && u.Tokens >= *u.TokenLimit:
+		return LimitTokens
+	case u.CostLimit != nil && u.Cost >= *u.CostLimit:
+		return LimitCost
+	}
+	return ""
+}
+
+// Reservation holds one unit of a user's quota until it is committed or
+// released
+type Reservation struct {
+	ID     int64
+	UserID int64
+}
+
+// sqlSeconds formats d as a datetime() modifier
+func sqlSeconds(d time.Duration) string {
+	return fmt.Sprintf("%+d seconds", int64(d.Seconds()))
+}
+
+// ensureTracking creates the user's users_tracking row if it is missing
+func ensureTracking(ctx context.Context, userID int64) error {
+	_, err := DB.ExecContext(ctx,
+		`INSERT INTO users_tracking (user_id, meal_count) VALUES (?, 0)
+		 ON CONFLICT(user_id) DO NOTHING`,
+		userID,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to create tracking record: %w", err)
+	}
+	return nil
+}
+
+// usageQuery reads everything rollWindow needs; reservations count until
+// they expire
+const usageQuery = `
+	SELECT u.created_at, t.period_start, t.meal_count, t.rollover,
+	       COALESCE(t.meals_override, p.meals_per_period),
+	       COALESCE(t.tokens_override, p.tokens_per_period),
+	       COALESCE(t.cost_override, p.cost_per_period),
+	       p.id, p.name, p.meals_per_period, p.period, p.rollover_max,
+	       p.tokens_per_period, p.cost_per_period, p.price_id IS NOT NULL,
+	       (SELECT COUNT(*) FROM quota_reservations r
+	        WHERE r.user_id = t.user_id AND r.expires_at > datetime('now'))
+	FROM users_tracking t
+	JOIN users u ON u.id = t.user_id
+	JOIN plans p ON p.id = u.plan_id
+	WHERE t.user_id = ?`
+
+// querier is the part of *sql.DB and *sql.Tx that rollWindow needs
+type querier interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// rollWindow returns the user's usage for the window containing now. When
+// the stored window has ended, the counter restarts and unused meals carry
+// over, up to the plan's rollover_max. Concurrent callers agree on the
+// result: only one of them moves period_start on.
+func rollWindow(ctx context.Context, q querier, userID int64) (*Usage, error) {
+	var u Usage
+	var createdAt string
+	var periodStart sql.NullString
+	err := q.QueryRowContext(ctx, usageQuery, userID).Scan(
+		&createdAt, &periodStart, &u.Used, &u.Rollover, &u.Allowance, &u.TokenLimit, &u.CostLimit,
+		&u.Plan.ID, &u.Plan.Name, &u.Plan.MealsPerPeriod, &u.Plan.Period, &u.Plan.RolloverMax,
+		&u.Plan.TokensPerPeriod, &u.Plan.CostPerPeriod, &u.Plan.Purchasable, &u.Reserved,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch usage: %w", err)
+	}
+
+	anchor, err := time.Parse(sqlTimeLayout, createdAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signup time: %w", err)
+	}
+	u.WindowStart, u.WindowEnd = Window(anchor, time.Now(), u.Plan.Period)
+	start := u.WindowStart.Format(sqlTimeLayout)
+
+	switch {
+	case !periodStart.Valid:
+		// First use since plans were introduced: what was used so far
+		// counts towards the current window
+		_, err = q.ExecContext(ctx,
+			"UPDATE users_tracking SET period_start = ? WHERE user_id = ? AND period_start IS NULL",
+			start, userID,
+		)
+
+	case periodStart.String < start:
+		previous, perr := time.Parse(sqlTimeLayout, periodStart.String)
+		if perr != nil {
+			return nil, fmt.Errorf("failed to parse window start: %w", perr)
+		}
+		u.Rollover = carryOver(anchor, previous, u.WindowStart, u)
+		u.Used = 0
+		_, err = q.ExecContext(ctx,
+			`UPDATE users_tracking SET period_start = ?, meal_count = 0, rollover = ?, updated_at = datetime('now')
+			 WHERE user_id = ? AND period_start = ?`,
+			start, u.Rollover, userID, periodStart.String,
+		)
+
+	case periodStart.String > start:
+		// The plan moved to a longer period: keep counting in the
+		// window that now contains the old one
+		_, err = q.ExecContext(ctx,
+			"UPDATE users_tracking SET period_start = ? WHERE user_id = ? AND period_start = ?",
+			start, userID, periodStart.String,
+		)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to start quota window: %w", err)
+	}
+
+	err = q.QueryRowContext(ctx,
+		`SELECT COALESCE(SUM(input_tokens + output_tokens), 0), COALESCE(SUM(cost_micros), 0)
+		 FROM llm_usage_events WHERE user_id = ? AND created_at >= ?`,
+		userID, start,
+	).Scan(&u.Tokens, &u.Cost)
+	if err != nil {
+		return nil, fmt.Errorf("failed to fetch token usage: %w", err)
+	}
+
+	u.Limit = u.Allowance + u.Rollover
+	return &u, nil
+}
+
+// carryOver returns the rollover going into the window starting at start,
+// given the stored usage of the window starting at previous. Windows
+// skipped entirely went unused, so they carry their allowance too.
+func carryOver(anchor, previous, start time.Time, u Usage) int {
+	carry := min(max(u.Allowance+u.Rollover-u.Used, 0), u.Plan.RolloverMax)
+	_, next := Window(anchor, previous, u.Plan.Period)
+	for ; next.Before(start); _, next = Window(anchor, next, u.Plan.Period) {
+		rolled := min(u.Allowance+carry, u.Plan.RolloverMax)
+		if rolled == carry {
+			break
+		}
+		carry = rolled
+	}
+	return carry
+}
+
+// QuotaUsage returns the user's quota for the current window
+func QuotaUsage(ctx context.Context, userID int64) (*Usage, error) {
+	if err := ensureTracking(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	return rollWindow(ctx, DB, userID)
+}
+
+// ReserveQuota holds one unit of the user's quota for ReservationTTL. The
+// check and the reservation are a single statement, so concurrent callers
+// can never reserve more meals than the limit between them. Token and cost
+// limits are only known after the model answers: a request may start while
+// they are not yet reached, so calls in flight can overshoot them. It
+// returns ErrQuotaExceeded when any limit is reached.
+func ReserveQuota(ctx context.Context, userID int64) (*Reservation, error) {
+	if err := ensureTracking(ctx, userID); err != nil {
+		return nil, err
+	}
+
+	if _, err := DB.ExecContext(ctx,
+		"DELETE FROM quota_reservations WHERE user_id = ? AND expires_at <= datetime('now')",
+		userID,
+	); err != nil {
+		return nil, fmt.Errorf("failed to clean up reservations: %w", err)
+	}
+
+	// Start a new window first if the last one has ended
+	if _, err := rollWindow(ctx, DB, userID); err != nil {
+		return nil, err
+	}
+
+	r := Reservation{UserID: userID}
+	err := DB.QueryRowContext(ctx,
+		`INSERT INTO quota_reservations (user_id, expires_at)
+		 SELECT t.user_id, datetime('now', ?)
+		 FROM users_tracking t
+		 JOIN users u ON u.id = t.user_id
+		 JOIN plans p ON p.id = u.plan_id
+		 WHERE t.user_id = ?
+		   AND t.meal_count + (SELECT COUNT(*) FROM quota_reservations r
+		                       WHERE r.user_id = t.user_id AND r.expires_at > datetime('now'))
+		       < COALESCE(t.meals_override, p.meals_per_period) + t.rollover
+		   AND (COALESCE(t.tokens_override, p.tokens_per_period) IS NULL
+		        OR (SELECT COALESCE(SUM(e.input_tokens + e.output_tokens), 0) FROM llm_usage_events e
+		            WHERE e.user_id = t.user_id AND e.created_at >= t.period_start)
+		           < COALESCE(t.tokens_override, p.tokens_per_period))
+		   AND (COALESCE(t.cost_override, p.cost_per_period) IS NULL
+		        OR (SELECT COALESCE(SUM(e.cost_micros), 0) FROM llm_usage_events e
+		            WHERE e.user_id = t.user_id AND e.created_at >= t.period_start)
+		           < COALESCE(t.cost_override, p.cost_per_period))
+		 RETURNING id`,
+		sqlSeconds(ReservationTTL), userID,
+	).Scan(&r.ID)
+	if err == sql.ErrNoRows {
+		return nil, ErrQuotaExceeded
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to reserve quota: %w", err)
+	}
+	return &r, nil
+}
+
+// CommitQuota turns a reservation into a counted use and returns the
+// resulting usage. Each reservation can be committed once, and only
+// before it expires. A reservation made just before a window ended counts
+// towards the new window.
+func CommitQuota(ctx context.Context, r *Reservation) (*Usage, error) {
+	tx, err := DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// Close the old window first, so the use is not carried over with it
+	if _, err := rollWindow(ctx, tx, r.UserID); err != nil {
+		return nil, err
+	}
+
+	// Count the use before the reservation disappears, so the unit is
+	// never free in between
+	res, err := tx.ExecContext(ctx,
+		`UPDATE users_tracking SET meal_count = meal_count + 1, updated_at = datetime('now')
+		 WHERE user_id = ?
+		   AND EXISTS (SELECT 1 FROM quota_reservations WHERE id = ? AND expires_at > datetime('now'))`,
+		r.UserID, r.ID,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to increment usage: %w", err)
+	}
+	if n, _ := res.RowsAffected(); n == 0 {
+		return nil, ErrReservationNotFound
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM quota_reservations WHERE id = ?", r.ID); err != nil {
+		return nil, fmt.Errorf("failed to delete reservation: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return rollWindow(ctx, DB, r.UserID)
+}
+
+// ReleaseQuota gives a reservation back without counting it, for
+// generations that failed. Releasing twice is harmless.
+func ReleaseQuota(ctx context.Context, r *Reservation) error {
+	_, err := DB.ExecContext(ctx, "DELETE FROM quota_reservations WHERE id = ?", r.ID)
+	if err != nil {
+		return fmt.Errorf("failed to release reservation: %w", err)
+	}
+	return nil
+}
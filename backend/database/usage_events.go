@@ -0,0 +1,113 @@
+package database
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"time"
+)
+
+// Micros is an amount in millionths of a US dollar. Costs are stored as
+// integers so sums are exact, and reported in dollars.
+type Micros int64
+
+// USD converts dollars to Micros
+func USD(dollars float64) Micros {
+	return Micros(math.Round(dollars * 1e6))
+}
+
+// Dollars is m in US dollars
+func (m Micros) Dollars() float64 {
+	return float64(m) / 1e6
+}
+
+func (m Micros) MarshalJSON() ([]byte, error) {
+	return json.Marshal(m.Dollars())
+}
+
+// UsageEvent is one model call in the llm_usage_events ledger
+type UsageEvent struct {
+	UserID       int64
+	Endpoint     string
+	Model        string
+	InputTokens  int64
+	OutputTokens int64
+	Cost         Micros
+	Succeeded    bool
+}
+
+// RecordUsageEvent appends e to the ledger
+func RecordUsageEvent(ctx context.Context, e UsageEvent) error {
+	_, err := DB.ExecContext(ctx,
+		`INSERT INTO llm_usage_events (user_id, endpoint, model, input_tokens, output_tokens, cost_micros, succeeded)
+		 VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		e.UserID, e.Endpoint, e.Model, e.InputTokens, e.OutputTokens, e.Cost, e.Succeeded,
+	)
+	if err != nil {
+		return fmt.Errorf("failed to record usage event: %w", err)
+	}
+	return nil
+}
+
+// UsageTotals sums a set of ledger rows
+type UsageTotals struct {
+	Requests     int    `json:"requests"`
+	InputTokens  int64  `json:"input_tokens"`
+	OutputTokens int64  `json:"output_tokens"`
+	Cost         Micros `json:"cost_usd"`
+}
+
+// Add accumulates o into t
+func (t *UsageTotals) Add(o UsageTotals) {
+	t.Requests += o.Requests
+	t.InputTokens += o.InputTokens
+	t.OutputTokens += o.OutputTokens
+	t.Cost += o.Cost
+}
+
+// DailyUsage is the usage of one model on one UTC day
+type DailyUsage struct {
+	Day   string `json:"day"`
+	Model string `json:"model"`
+	UsageTotals
+}
+
+// UsageFilter selects ledger rows created in [From, To). UserID 0 selects
+// every user.
+type UsageFilter struct {
+	UserID int64
+	From   time.Time
+	To     time.Time
+}
+
+// UsageByDay aggregates the ledger by day and model, oldest day first
+func UsageByDay(ctx context.Context, f UsageFilter) ([]DailyUsage, error) {
+	query := `SELECT date(created_at), model, COUNT(*),
+	                 SUM(input_tokens), SUM(output_tokens), SUM(cost_micros)
+	          FROM llm_usage_events
+	          WHERE created_at >= ? AND created_at < ?`
+	args := []any{f.From.UTC().Format(sqlTimeLayout), f.To.UTC().Format(sqlTimeLayout)}
+	if f.UserID != 0 {
+		query += " AND user_id = ?"
+		args = append(args, f.UserID)
+	}
+	query += " GROUP BY date(created_at), model ORDER BY date(created_at), model"
+
+	rows, err := DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	days := []DailyUsage{}
+	for rows.Next() {
+		var d DailyUsage
+		if err := rows.Scan(&d.Day, &d.Model, &d.Requests,
+			&d.InputTokens, &d.OutputTokens, &d.Cost); err != nil {
+			return nil, err
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
@@ -1,26 +0,0 @@
-package database
-
-import (
-	"context"
-	"time"
-)
-
-func CreateUsersTable(ctx context.Context) error {
-	query := `
-	CREATE TABLE IF NOT EXISTS users (
-		id INTEGER PRIMARY KEY AUTOINCREMENT,
-		email TEXT NOT NULL UNIQUE,
-		hashed_password TEXT NOT NULL,
-		created_at TEXT NOT NULL DEFAULT (datetime('now')),
-		updated_at TEXT NOT NULL DEFAULT (datetime('now'))
-	);
-	CREATE INDEX IF NOT EXISTS idx_users_email ON users(email);
-	`
-
-	// Set timeout for table creation
-	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
-	defer cancel()
-
-	_, err := DB.ExecContext(ctx, query)
-	return err
-}
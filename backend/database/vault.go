@@ -5,25 +5,46 @@ import (
 	"database/sql"
 	"fmt"
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/tursodatabase/libsql-client-go/libsql"
+	_ "modernc.org/sqlite"
 )
 
 var DB *sql.DB
 
+// sqlitePragmas are applied to every local SQLite connection so that
+// foreign keys and lock contention behave like Turso
+const sqlitePragmas = "_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)"
+
 func InitDB() error {
 	url := os.Getenv("TURSO_DATABASE_URL")
 	if url == "" {
 		return fmt.Errorf("TURSO_DATABASE_URL not set")
 	}
 
+	return Open(url)
+}
+
+// Open connects DB to url. Remote libsql://, http(s):// and ws(s):// URLs
+// use the Turso client; file: paths and :memory: use an embedded SQLite
+// driver so the server and tests can run offline.
+func Open(url string) error {
+	driver, dsn, memory := driverFor(url)
+
 	var err error
-	DB, err = sql.Open("libsql", url)
+	DB, err = sql.Open(driver, dsn)
 	if err != nil {
 		return fmt.Errorf("failed to open db: %w", err)
 	}
 
+	// Every connection to :memory: is a separate database, so pin the pool
+	// to a single connection
+	if memory {
+		DB.SetMaxOpenConns(1)
+	}
+
 	// Test connection with timeout
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
@@ -34,3 +55,19 @@ func InitDB() error {
 
 	return nil
 }
+
+// driverFor picks the sql driver and DSN for a database URL
+func driverFor(url string) (driver, dsn string, memory bool) {
+	switch {
+	case url == ":memory:" || url == "file::memory:":
+		return "sqlite", "file::memory:?" + sqlitePragmas, true
+	case strings.HasPrefix(url, "file:"):
+		sep := "?"
+		if strings.Contains(url, "?") {
+			sep = "&"
+		}
+		return "sqlite", url + sep + sqlitePragmas, false
+	default:
+		return "libsql", url, false
+	}
+}
@@ -0,0 +1,320 @@
+package export
+
+import (
+	"archive/zip"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	db "backend/database"
+)
+
+// FormatVersion identifies the archive layout and is bumped whenever a
+// file changes shape
+const FormatVersion = 1
+
+// ManifestName is the file describing the rest of the archive
+const ManifestName = "manifest.json"
+
+// File describes one file in the archive
+type File struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Records     int    `json:"records"`
+	SHA256      string `json:"sha256"`
+}
+
+// Manifest is written to manifest.json
+type Manifest struct {
+	FormatVersion int    `json:"format_version"`
+	GeneratedAt   string `json:"generated_at"`
+	UserID        int64  `json:"user_id"`
+	Files         []File `json:"files"`
+}
+
+// section is one JSON file in the archive. Most are a single query whose
+// rows become a list of objects; collect overrides that for nested data.
+type section struct {
+	name        string
+	description string
+	query       string
+	// jsonColumns hold JSON documents that are embedded rather than quoted
+	jsonColumns []string
+	// single sections hold one object, or null, instead of a list
+	single  bool
+	collect func(ctx context.Context, userID int64) (data any, records int, err error)
+}
+
+// sections lists everything stored about a user. Secrets such as password
+// hashes, token hashes and 2FA secrets are never exported.
+var sections = []section{
+	{
+		name:        "profile.json",
+		description: "Account details",
+		query: `SELECT u.id, u.email, u.email_verified_at, u.role, u.plan_id AS plan, u.disabled_at, u.created_at, u.updated_at,
+		               t.enabled_at AS two_factor_enabled_at
+		        FROM users u LEFT JOIN user_totp t ON t.user_id = u.id WHERE u.id = ?`,
+		single: true,
+	},
+	{
+		name:        "preferences.json",
+		description: "Meal preferences",
+		query:       "SELECT user_preference AS preferences, created_at, updated_at FROM user_preference WHERE user_id = ?",
+		jsonColumns: []string{"preferences"},
+		single:      true,
+	},
+	{
+		name:        "usage.json",
+		description: "Meal generation counters for the current quota window",
+		query: `SELECT meal_count, period_start, rollover, meals_override, tokens_override,
+		               cost_override AS cost_override_micros, created_at, updated_at
+		        FROM users_tracking WHERE user_id = ?`,
+		single: true,
+	},
+	{
+		name:        "usage_events.json",
+		description: "Every model call with the tokens it used and its cost",
+		query: `SELECT endpoint, model, input_tokens, output_tokens, cost_micros, succeeded, created_at
+		        FROM llm_usage_events WHERE user_id = ? ORDER BY id`,
+	},
+	{
+		name:        "generation_log.json",
+		description: "Every meal generation with its prompt, latency and outcome",
+		query: `SELECT endpoint, conversation_id, prompt_summary, model, input_tokens, output_tokens,
+		               latency_ms, status, error, created_at
+		        FROM generation_log WHERE user_id = ? ORDER BY id`,
+	},
+	{
+		name:        "subscription.json",
+		description: "Paid subscription, if any",
+		query: `SELECT provider, plan_id AS plan, status, created_at, updated_at
+		        FROM subscriptions WHERE user_id = ?`,
+		single: true,
+	},
+	{
+		name:        "conversations.json",
+		description: "Conversations with every message",
+		collect:     collectConversations,
+	},
+	{
+		name:        "recipes.json",
+		description: "Saved recipes",
+		query: `SELECT id, title, recipe, is_favourite AS favourite, created_at, updated_at
+		        FROM recipes WHERE user_id = ? ORDER BY id`,
+		jsonColumns: []string{"recipe"},
+	},
+	{
+		name:        "sessions.json",
+		description: "Logins and the devices they came from",
+		query: `SELECT id, user_agent, ip_address, created_at, last_used_at, expires_at, revoked_at
+		        FROM sessions WHERE user_id = ? ORDER BY id`,
+	},
+	{
+		name:        "api_keys.json",
+		description: "Personal API keys, without the secret part",
+		query: `SELECT id, name, prefix, scopes, expires_at, last_used_at, revoked_at, created_at
+		        FROM api_keys WHERE user_id = ? ORDER BY id`,
+	},
+	{
+		name:        "identities.json",
+		description: "Linked identity provider accounts",
+		query: `SELECT id, provider, subject, email, created_at, last_login_at
+		        FROM user_identities WHERE user_id = ? ORDER BY id`,
+	},
+	{
+		name:        "audit_log.json",
+		description: "Security events on the account",
+		query: `SELECT action, detail, ip_address, created_at
+		        FROM audit_log WHERE user_id = ? ORDER BY id`,
+		jsonColumns: []string{"detail"},
+	},
+}
+
+// RecordCount is the number of stored messages and recipes, used to decide
+// whether an export is small enough to build during the request
+func RecordCount(ctx context.Context, userID int64) (int, error) {
+	var n int
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT (SELECT COUNT(*) FROM messages m JOIN conversations c ON c.id = m.conversation_id WHERE c.user_id = ?)
+		      + (SELECT COUNT(*) FROM recipes WHERE user_id = ?)`,
+		userID, userID,
+	).Scan(&n)
+	return n, err
+}
+
+// Write builds the archive for a user and writes it to w. Every file is
+// assembled before anything is written, so a failure leaves w untouched.
+func Write(ctx context.Context, w io.Writer, userID int64) (*Manifest, error) {
+	manifest := &Manifest{
+		FormatVersion: FormatVersion,
+		GeneratedAt:   time.Now().UTC().Format(time.RFC3339),
+		UserID:        userID,
+		Files:         []File{},
+	}
+
+	contents := make([][]byte, len(sections))
+	for i, s := range sections {
+		var data any
+		var records int
+		var err error
+		if s.collect != nil {
+			data, records, err = s.collect(ctx, userID)
+		} else {
+			data, records, err = s.run(ctx, userID)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", s.name, err)
+		}
+
+		content, err := json.MarshalIndent(data, "", "  ")
+		if err != nil {
+			return nil, fmt.Errorf("export %s: %w", s.name, err)
+		}
+		sum := sha256.Sum256(content)
+		contents[i] = content
+		manifest.Files = append(manifest.Files, File{
+			Name:        s.name,
+			Description: s.description,
+			Records:     records,
+			SHA256:      hex.EncodeToString(sum[:]),
+		})
+	}
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	zw := zip.NewWriter(w)
+	if err := writeZipFile(zw, ManifestName, manifestJSON); err != nil {
+		return nil, err
+	}
+	for i, s := range sections {
+		if err := writeZipFile(zw, s.name, contents[i]); err != nil {
+			return nil, err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func writeZipFile(zw *zip.Writer, name string, content []byte) error {
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	return err
+}
+
+// run executes a section's query
+func (s section) run(ctx context.Context, userID int64) (any, int, error) {
+	rows, err := db.DB.QueryContext(ctx, s.query, userID)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer rows.Close()
+
+	objects, err := scanObjects(rows, s.jsonColumns)
+	if err != nil {
+		return nil, 0, err
+	}
+	if s.single {
+		if len(objects) == 0 {
+			return nil, 0, nil
+		}
+		return objects[0], 1, nil
+	}
+	return objects, len(objects), nil
+}
+
+// scanObjects turns rows into one map per row, keyed by column name
+func scanObjects(rows *sql.Rows, jsonColumns []string) ([]map[string]any, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	isJSON := map[string]bool{}
+	for _, c := range jsonColumns {
+		isJSON[c] = true
+	}
+
+	objects := []map[string]any{}
+	for rows.Next() {
+		values := make([]any, len(columns))
+		ptrs := make([]any, len(columns))
+		for i := range values {
+			ptrs[i] = &values[i]
+		}
+		if err := rows.Scan(ptrs...); err != nil {
+			return nil, err
+		}
+
+		obj := make(map[string]any, len(columns))
+		for i, col := range columns {
+			v := values[i]
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			if s, ok := v.(string); ok && isJSON[col] && json.Valid([]byte(s)) {
+				v = json.RawMessage(s)
+			}
+			obj[col] = v
+		}
+		objects = append(objects, obj)
+	}
+	return objects, rows.Err()
+}
+
+// collectConversations nests each conversation's messages inside it
+func collectConversations(ctx context.Context, userID int64) (any, int, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT id, title, created_at, updated_at FROM conversations WHERE user_id = ? ORDER BY id",
+		userID,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	conversations, err := scanObjects(rows, nil)
+	rows.Close()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	rows, err = db.DB.QueryContext(ctx,
+		`SELECT m.conversation_id, m.id, m.role, m.content, m.created_at
+		 FROM messages m JOIN conversations c ON c.id = m.conversation_id
+		 WHERE c.user_id = ? ORDER BY m.id`,
+		userID,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+	messages, err := scanObjects(rows, nil)
+	rows.Close()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	byConversation := map[any][]map[string]any{}
+	for _, m := range messages {
+		id := m["conversation_id"]
+		delete(m, "conversation_id")
+		byConversation[id] = append(byConversation[id], m)
+	}
+	for _, c := range conversations {
+		msgs := byConversation[c["id"]]
+		if msgs == nil {
+			msgs = []map[string]any{}
+		}
+		c["messages"] = msgs
+	}
+	return conversations, len(conversations), nil
+}
@@ -0,0 +1,204 @@
+package export
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	db "backend/database"
+)
+
+// Statuses of a background export
+const (
+	StatusPending = "pending"
+	StatusRunning = "running"
+	StatusReady   = "ready"
+	StatusFailed  = "failed"
+)
+
+// SyncLimit is the number of stored messages and recipes up to which an
+// export is built during the request. Larger accounts get a background job.
+var SyncLimit = 500
+
+// ArchiveTTL is how long a finished background export can be downloaded
+const ArchiveTTL = 7 * 24 * time.Hour
+
+// jobTimeout bounds how long building one archive may take
+const jobTimeout = 5 * time.Minute
+
+var (
+	ErrNotFound = errors.New("export not found")
+	ErrNotReady = errors.New("export not ready")
+	ErrExpired  = errors.New("export expired")
+)
+
+// Job is a data_exports row, without the archive
+type Job struct {
+	ID          int64   `json:"id"`
+	Status      string  `json:"status"`
+	SizeBytes   int64   `json:"size_bytes"`
+	Error       *string `json:"error"`
+	CreatedAt   string  `json:"created_at"`
+	CompletedAt *string `json:"completed_at"`
+	ExpiresAt   *string `json:"expires_at"`
+}
+
+const jobColumns = "id, status, size_bytes, error, created_at, completed_at, expires_at"
+
+func scanJob(row interface{ Scan(...any) error }) (*Job, error) {
+	var j Job
+	err := row.Scan(&j.ID, &j.Status, &j.SizeBytes, &j.Error, &j.CreatedAt, &j.CompletedAt, &j.ExpiresAt)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &j, nil
+}
+
+// Enqueue starts a background export for the user. While one is already
+// pending or running it is returned instead, with created set to false.
+func Enqueue(ctx context.Context, userID int64) (job *Job, created bool, err error) {
+	// Expired archives are only kept until the next request
+	if _, err := db.DB.ExecContext(ctx,
+		"DELETE FROM data_exports WHERE expires_at <= datetime('now')",
+	); err != nil {
+		log.Printf("Failed to delete expired exports: %v", err)
+	}
+
+	job, err = scanJob(db.DB.QueryRowContext(ctx,
+		"SELECT "+jobColumns+" FROM data_exports WHERE user_id = ? AND status IN (?, ?) ORDER BY id DESC LIMIT 1",
+		userID, StatusPending, StatusRunning,
+	))
+	if err == nil {
+		return job, false, nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return nil, false, err
+	}
+
+	job, err = scanJob(db.DB.QueryRowContext(ctx,
+		"INSERT INTO data_exports (user_id, status) VALUES (?, ?) RETURNING "+jobColumns,
+		userID, StatusPending,
+	))
+	if err != nil {
+		return nil, false, err
+	}
+
+	go Run(job.ID)
+	return job, true, nil
+}
+
+// Get returns one of the user's exports
+func Get(ctx context.Context, userID, jobID int64) (*Job, error) {
+	return scanJob(db.DB.QueryRowContext(ctx,
+		"SELECT "+jobColumns+" FROM data_exports WHERE id = ? AND user_id = ?",
+		jobID, userID,
+	))
+}
+
+// Archive returns the ZIP built by a finished export
+func Archive(ctx context.Context, userID, jobID int64) ([]byte, error) {
+	var status string
+	var expired bool
+	var archive []byte
+	err := db.DB.QueryRowContext(ctx,
+		`SELECT status, COALESCE(expires_at <= datetime('now'), 0), archive
+		 FROM data_exports WHERE id = ? AND user_id = ?`,
+		jobID, userID,
+	).Scan(&status, &expired, &archive)
+	if err == sql.ErrNoRows {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	if status != StatusReady {
+		return nil, ErrNotReady
+	}
+	if expired {
+		return nil, ErrExpired
+	}
+	return archive, nil
+}
+
+// Run builds the archive for a pending export and stores the result. It is
+// safe to call for a job another instance already picked up: only one
+// caller moves it from pending to running.
+func Run(jobID int64) {
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+
+	var userID int64
+	err := db.DB.QueryRowContext(ctx,
+		"UPDATE data_exports SET status = ?, started_at = datetime('now') WHERE id = ? AND status = ? RETURNING user_id",
+		StatusRunning, jobID, StatusPending,
+	).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to start export %d: %v", jobID, err)
+		return
+	}
+
+	var buf bytes.Buffer
+	if _, err := Write(ctx, &buf, userID); err != nil {
+		log.Printf("Export %d for user %d failed: %v", jobID, userID, err)
+		if _, err := db.DB.ExecContext(context.Background(),
+			"UPDATE data_exports SET status = ?, error = ?, completed_at = datetime('now') WHERE id = ?",
+			StatusFailed, "Failed to build export", jobID,
+		); err != nil {
+			log.Printf("Failed to mark export %d as failed: %v", jobID, err)
+		}
+		return
+	}
+
+	if _, err := db.DB.ExecContext(ctx,
+		`UPDATE data_exports SET status = ?, archive = ?, size_bytes = ?,
+		        completed_at = datetime('now'), expires_at = datetime('now', ?)
+		 WHERE id = ?`,
+		StatusReady, buf.Bytes(), buf.Len(), fmt.Sprintf("%+d seconds", int64(ArchiveTTL.Seconds())), jobID,
+	); err != nil {
+		log.Printf("Failed to store export %d: %v", jobID, err)
+	}
+}
+
+// ResumePending restarts exports interrupted by a restart. Jobs still
+// marked running after jobTimeout were abandoned and start over.
+func ResumePending(ctx context.Context) error {
+	if _, err := db.DB.ExecContext(ctx,
+		"UPDATE data_exports SET status = ? WHERE status = ? AND started_at <= datetime('now', ?)",
+		StatusPending, StatusRunning, fmt.Sprintf("%+d seconds", -int64(jobTimeout.Seconds())),
+	); err != nil {
+		return err
+	}
+
+	rows, err := db.DB.QueryContext(ctx, "SELECT id FROM data_exports WHERE status = ?", StatusPending)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		go Run(id)
+	}
+	return nil
+}
@@ -0,0 +1,165 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/billing"
+	db "backend/database"
+)
+
+// maxWebhookBody bounds webhook deliveries
+const maxWebhookBody = 64 << 10
+
+type CheckoutRequest struct {
+	Plan string `json:"plan" binding:"required"`
+}
+
+// billingReturnURL is the frontend page the checkout returns to
+func billingReturnURL(result string) string {
+	base := os.Getenv("APP_BASE_URL")
+	if base == "" {
+		base = "http://localhost:3000"
+	}
+	return base + "/billing?checkout=" + result
+}
+
+// CreateCheckout starts a checkout for a paid plan and returns the URL of
+// the provider's payment page. The plan changes once the provider confirms
+// the payment through the webhook.
+func CreateCheckout(c *gin.Context) {
+	var req CheckoutRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	session, err := billing.StartCheckout(ctx, userID.(int64), req.Plan,
+		billingReturnURL("success"), billingReturnURL("canceled"))
+	switch {
+	case errors.Is(err, billing.ErrNotConfigured):
+		ErrorResponse(c, http.StatusServiceUnavailable, "Billing is not configured")
+	case errors.Is(err, db.ErrUnknownPlan):
+		ErrorResponse(c, http.StatusBadRequest, "Unknown plan")
+	case errors.Is(err, billing.ErrNotForSale):
+		ErrorResponse(c, http.StatusBadRequest, "This plan cannot be bought")
+	case errors.Is(err, billing.ErrAlreadySubscribed):
+		ErrorResponse(c, http.StatusConflict, "You already have a subscription")
+	case errors.Is(err, billing.ErrCheckoutInProgress):
+		ErrorResponse(c, http.StatusConflict, "A checkout is already in progress")
+	case err != nil:
+		log.Printf("Failed to start checkout for user %d: %v", userID, err)
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to start checkout")
+	default:
+		SuccessResponse(c, gin.H{"checkout": session})
+	}
+}
+
+// GetSubscription returns the user's plan and subscription, if any
+func GetSubscription(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	subscription, err := billing.GetSubscription(ctx, userID.(int64))
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch subscription")
+		return
+	}
+	usage, err := db.QuotaUsage(ctx, userID.(int64))
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch plan")
+		return
+	}
+
+	SuccessResponse(c, gin.H{
+		"plan":         usage.Plan,
+		"subscription": subscription,
+	})
+}
+
+// BillingWebhook receives signed events from the payment provider. Any
+// response other than 200 makes the provider deliver the event again, so
+// events that cannot be applied yet are answered with 500. Events already
+// processed are acknowledged without changing anything.
+func BillingWebhook(c *gin.Context) {
+	provider := billing.Default
+	if provider == nil {
+		ErrorResponse(c, http.StatusServiceUnavailable, "Billing is not configured")
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxWebhookBody))
+	if err != nil {
+		ErrorResponse(c, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	event, err := provider.ParseWebhook(c.Request.Header, body)
+	if errors.Is(err, billing.ErrInvalidSignature) {
+		ErrorResponse(c, http.StatusBadRequest, "Invalid signature")
+		return
+	}
+	if err != nil {
+		ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	result, err := billing.Apply(ctx, provider.Name(), event)
+	if err != nil {
+		log.Printf("Failed to apply billing event %s: %v", event.ID, err)
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to process event")
+		return
+	}
+
+	SuccessResponse(c, gin.H{
+		"received":  true,
+		"duplicate": result.Duplicate,
+	})
+}
+
+// CompleteFakeCheckout pays for a checkout with the fake provider, which
+// then delivers the webhook event. It only exists for local development.
+func CompleteFakeCheckout(c *gin.Context) {
+	fake, ok := billing.Default.(*billing.FakeProvider)
+	if !ok {
+		ErrorResponse(c, http.StatusNotFound, "Not found")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+	defer cancel()
+
+	event, err := fake.CompleteCheckout(ctx, c.Param("id"))
+	if errors.Is(err, billing.ErrUnknownSession) {
+		ErrorResponse(c, http.StatusNotFound, "Checkout session not found")
+		return
+	}
+	if err != nil {
+		ErrorResponse(c, http.StatusBadGateway, "Webhook delivery failed: "+err.Error())
+		return
+	}
+	SuccessResponse(c, gin.H{"event": event})
+}
@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/llm"
+	db "backend/database"
+)
+
+type CreateConversationRequest struct {
+	Title string `json:"title"`
+}
+
+type Conversation struct {
+	ID        int64  `json:"id"`
+	Title     string `json:"title"`
+	CreatedAt string `json:"created_at"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+type ConversationMessage struct {
+	ID        int64  `json:"id"`
+	Role      string `json:"role"`
+	Content   string `json:"content"`
+	CreatedAt string `json:"created_at"`
+}
+
+// maxTitleLength caps titles derived from the first message of a conversation
+const maxTitleLength = 60
+
+// getConversation fetches a conversation if it belongs to the user
+func getConversation(ctx context.Context, conversationID, userID int64) (*Conversation, error) {
+	var conv Conversation
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT id, title, created_at, updated_at FROM conversations WHERE id = ? AND user_id = ?",
+		conversationID, userID,
+	).Scan(&conv.ID, &conv.Title, &conv.CreatedAt, &conv.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return &conv, nil
+}
+
+// getConversationMessages returns every message in a conversation, oldest first
+func getConversationMessages(ctx context.Context, conversationID int64) ([]ConversationMessage, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT id, role, content, created_at FROM messages WHERE conversation_id = ? ORDER BY id",
+		conversationID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	messages := []ConversationMessage{}
+	for rows.Next() {
+		var m ConversationMessage
+		if err := rows.Scan(&m.ID, &m.Role, &m.Content, &m.CreatedAt); err != nil {
+			return nil, err
+		}
+		messages = append(messages, m)
+	}
+	return messages, rows.Err()
+}
+
+// historyTokenBudget reads LLM_HISTORY_TOKEN_BUDGET, falling back to the
+// llm package default
+func historyTokenBudget() int {
+	if v, err := strconv.Atoi(os.Getenv("LLM_HISTORY_TOKEN_BUDGET")); err == nil && v > 0 {
+		return v
+	}
+	return llm.DefaultHistoryTokenBudget
+}
+
+// conversationHistory loads the prior turns of a conversation as llm
+// messages, truncated so that they plus the next prompt fit the budget
+func conversationHistory(ctx context.Context, conversationID int64, nextPrompt string) ([]llm.Message, error) {
+	stored, err := getConversationMessages(ctx, conversationID)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make([]llm.Message, 0, len(stored))
+	for _, m := range stored {
+		history = append(history, llm.Message{Role: m.Role, Content: m.Content})
+	}
+
+	budget := historyTokenBudget() - llm.EstimateTokens(nextPrompt)
+	if budget <= 0 {
+		return nil, nil
+	}
+	return llm.TruncateHistory(history, budget), nil
+}
+
+// saveConversationTurn stores a user message and the assistant's reply,
+// titling the conversation after its first message if it has no title yet
+func saveConversationTurn(conversationID int64, userMessage, assistantMessage string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO messages (conversation_id, role, content) VALUES (?, ?, ?), (?, ?, ?)",
+		conversationID, llm.RoleUser, userMessage,
+		conversationID, llm.RoleAssistant, assistantMessage,
+	)
+	if err != nil {
+		return err
+	}
+
+	title := userMessage
+	if runes := []rune(title); len(runes) > maxTitleLength {
+		title = string(runes[:maxTitleLength])
+	}
+	_, err = tx.ExecContext(ctx,
+		`UPDATE conversations
+		 SET title = CASE WHEN title = '' THEN ? ELSE title END,
+		     updated_at = datetime('now')
+		 WHERE id = ?`,
+		title, conversationID,
+	)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// CreateConversation starts a new, empty conversation
+func CreateConversation(c *gin.Context) {
+	var req CreateConversationRequest
+	// Body is optional; an empty title is filled from the first message
+	if c.Request.ContentLength > 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx,
+		"INSERT INTO conversations (user_id, title) VALUES (?, ?)",
+		userID, req.Title,
+	)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to create conversation")
+		return
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to get conversation ID")
+		return
+	}
+
+	conv, err := getConversation(ctx, id, userID.(int64))
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	SuccessResponse(c, gin.H{"conversation": conv})
+}
+
+// ListConversations returns the user's conversations, most recent first
+func ListConversations(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT id, title, created_at, updated_at FROM conversations
+		 WHERE user_id = ? ORDER BY updated_at DESC, id DESC`,
+		userID,
+	)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+
+	conversations := []Conversation{}
+	for rows.Next() {
+		var conv Conversation
+		if err := rows.Scan(&conv.ID, &conv.Title, &conv.CreatedAt, &conv.UpdatedAt); err != nil {
+			ErrorResponse(c, http.StatusInternalServerError, "Database error")
+			return
+		}
+		conversations = append(conversations, conv)
+	}
+	if err := rows.Err(); err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	SuccessResponse(c, gin.H{"conversations": conversations})
+}
+
+// GetConversation returns a conversation with all of its messages
+func GetConversation(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	id, ok := idParam(c, "conversation")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	conv, err := getConversation(ctx, id, userID.(int64))
+	if err == sql.ErrNoRows {
+		ErrorResponse(c, http.StatusNotFound, "Conversation not found")
+		return
+	}
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	messages, err := getConversationMessages(ctx, id)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	SuccessResponse(c, gin.H{
+		"conversation": conv,
+		"messages":     messages,
+	})
+}
+
+// DeleteConversation removes a conversation and its messages
+func DeleteConversation(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	id, ok := idParam(c, "conversation")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx,
+		"DELETE FROM conversations WHERE id = ? AND user_id = ?",
+		id, userID,
+	)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to delete conversation")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		ErrorResponse(c, http.StatusNotFound, "Conversation not found")
+		return
+	}
+
+	// Delete messages explicitly in case foreign keys are not enforced
+	if _, err := tx.ExecContext(ctx, "DELETE FROM messages WHERE conversation_id = ?", id); err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to delete conversation")
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to delete conversation")
+		return
+	}
+
+	SuccessResponse(c, gin.H{"message": "Conversation deleted"})
+}
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 	"github.com/gin-gonic/gin"
 	db "backend/database"
 )
@@ -22,6 +23,16 @@ func SuccessResponse(c *gin.Context, data gin.H) {
 	c.JSON(http.StatusOK, response)
 }
 
+// idParam parses the :id route parameter, naming the resource in the error
+func idParam(c *gin.Context, resource string) (int64, bool) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil || id <= 0 {
+		ErrorResponse(c, http.StatusBadRequest, "Invalid "+resource+" ID")
+		return 0, false
+	}
+	return id, true
+}
+
 func HealthCheck(c *gin.Context) {
 	SuccessResponse(c, gin.H{})
 }
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/audit"
+	"backend/export"
+)
+
+// exportFilename names the downloaded archive
+func exportFilename(userID int64) string {
+	return fmt.Sprintf("ai-ceo-export-%d-%s.zip", userID, time.Now().UTC().Format("2006-01-02"))
+}
+
+// sendArchive writes a ZIP as a download
+func sendArchive(c *gin.Context, userID int64, archive []byte) {
+	c.Header("Content-Disposition", `attachment; filename="`+exportFilename(userID)+`"`)
+	c.Header("Cache-Control", "no-store")
+	c.Data(http.StatusOK, "application/zip", archive)
+}
+
+// ExportAccount returns everything stored about the user as a ZIP of JSON
+// files with a manifest. Small accounts get the archive straight away;
+// larger ones, or ?async=true, get 202 and a background export to poll.
+func ExportAccount(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	id := userID.(int64)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	// 1. Decide whether the archive can be built during the request
+	async, _ := strconv.ParseBool(c.Query("async"))
+	if !async {
+		records, err := export.RecordCount(ctx, id)
+		if err != nil {
+			ErrorResponse(c, http.StatusInternalServerError, "Database error")
+			return
+		}
+		async = records > export.SyncLimit
+	}
+
+	// 2. Large accounts: start (or reuse) a background export
+	if async {
+		job, created, err := export.Enqueue(ctx, id)
+		if err != nil {
+			ErrorResponse(c, http.StatusInternalServerError, "Failed to start export")
+			return
+		}
+		if created {
+			audit.Record(ctx, audit.Entry{UserID: id, Action: audit.ActionDataExported, Detail: map[string]any{"export_id": job.ID}, IP: c.ClientIP()})
+		}
+		c.Header("Location", fmt.Sprintf("/api/account/exports/%d", job.ID))
+		c.JSON(http.StatusAccepted, gin.H{
+			"status":  "ok",
+			"message": "Your export is being prepared",
+			"export":  job,
+		})
+		return
+	}
+
+	// 3. Small accounts: build and send it now
+	var buf bytes.Buffer
+	if _, err := export.Write(ctx, &buf, id); err != nil {
+		log.Printf("Export for user %d failed: %v", id, err)
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to build export")
+		return
+	}
+	audit.Record(ctx, audit.Entry{UserID: id, Action: audit.ActionDataExported, IP: c.ClientIP()})
+	sendArchive(c, id, buf.Bytes())
+}
+
+// GetExport reports the status of a background export
+func GetExport(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	jobID, ok := idParam(c, "export")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	job, err := export.Get(ctx, userID.(int64), jobID)
+	if errors.Is(err, export.ErrNotFound) {
+		ErrorResponse(c, http.StatusNotFound, "Export not found")
+		return
+	}
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	SuccessResponse(c, gin.H{"export": job})
+}
+
+// DownloadExport sends the archive of a finished background export
+func DownloadExport(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	jobID, ok := idParam(c, "export")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 30*time.Second)
+	defer cancel()
+
+	archive, err := export.Archive(ctx, userID.(int64), jobID)
+	switch {
+	case errors.Is(err, export.ErrNotFound):
+		ErrorResponse(c, http.StatusNotFound, "Export not found")
+	case errors.Is(err, export.ErrNotReady):
+		ErrorResponse(c, http.StatusConflict, "Export is not ready yet")
+	case errors.Is(err, export.ErrExpired):
+		ErrorResponse(c, http.StatusGone, "Export has expired. Please request a new one.")
+	case err != nil:
+		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+	default:
+		sendArchive(c, userID.(int64), archive)
+	}
+}
@@ -3,205 +3,400 @@ package handlers
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"backend/llm"
+	"backend/preferences"
 	db "backend/database"
 )
 
 type LLMRequest struct {
-	Message string `json:"message" binding:"required"`
-	UserID  string `json:"user_id,omitempty"`
+	Message        string `json:"message" binding:"required"`
+	UserID         string `json:"user_id,omitempty"`
+	ConversationID int64  `json:"conversation_id,omitempty"`
 }
 
 type LLMResponse struct {
 	Response string `json:"response"`
 }
 
-type UserPreferences struct {
-	DietaryRestrictions string `json:"dietary_restrictions"`
-	MaxCookingTime      int    `json:"max_cooking_time"`
+// mealRequest holds everything needed to call the model for one meal
+// generation once the request has been validated and the quota reserved
+type mealRequest struct {
+	UserID         int64
+	ConversationID int64
+	Message        string
+	Reservation    *db.Reservation
+	LLM            llm.Request
 }
 
-type UserUsage struct {
-	MealCount int
-	MaxMeals  int
-}
-
-// getUserPreferences fetches user preferences from database
-func getUserPreferences(userID int64) (*UserPreferences, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// prepareMealRequest binds the request body, reserves one unit of the
+// user's quota and builds the prompt. It writes an error response and
+// returns false when the request cannot proceed. Otherwise the caller must
+// finish the reservation with commitMeal or releaseMeal.
+func prepareMealRequest(c *gin.Context) (meal *mealRequest, ok bool) {
+	var req LLMRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return nil, false
+	}
 
-	var prefJSON string
-	err := db.DB.QueryRowContext(ctx,
-		"SELECT user_preference FROM user_preference WHERE user_id = ?",
-		userID,
-	).Scan(&prefJSON)
+	// Get user ID from JWT token (set by AuthMiddleware)
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return nil, false
+	}
 
-	if err == sql.ErrNoRows {
-		// No preferences set, return nil
-		return nil, nil
+	// Reserve quota up front so concurrent requests cannot overshoot the
+	// limit. The reservation is handed back if anything below fails.
+	reserveCtx, reserveCancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	reservation, err := db.ReserveQuota(reserveCtx, userID.(int64))
+	reserveCancel()
+	if errors.Is(err, db.ErrQuotaExceeded) {
+		usageLimitReached(c, userID.(int64))
+		return nil, false
 	}
 	if err != nil {
-		return nil, err
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to check usage limits")
+		return nil, false
+	}
+	defer func() {
+		if !ok {
+			releaseMeal(&mealRequest{UserID: userID.(int64), Reservation: reservation})
+		}
+	}()
+
+	// Fetch user preferences
+	prefsCtx, prefsCancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	prefs, err := preferences.Load(prefsCtx, userID.(int64))
+	prefsCancel()
+	if err != nil {
+		log.Printf("Failed to load preferences for user %d: %v", userID, err)
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch preferences")
+		return nil, false
+	}
+
+	// Load system prompt from environment
+	systemPrompt := os.Getenv("LLM_SYSTEM_PROMPT")
+	if systemPrompt == "" {
+		systemPrompt = "You are a helpful meal planning assistant."
+	}
+
+	// Build user message with preferences
+	userMessage := buildMealPrompt(req.Message, prefs)
+
+	// Replay prior turns when continuing a conversation
+	var history []llm.Message
+	if req.ConversationID != 0 {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		defer cancel()
+
+		if _, err := getConversation(ctx, req.ConversationID, userID.(int64)); err != nil {
+			if err == sql.ErrNoRows {
+				ErrorResponse(c, http.StatusNotFound, "Conversation not found")
+				return nil, false
+			}
+			ErrorResponse(c, http.StatusInternalServerError, "Failed to load conversation")
+			return nil, false
+		}
+
+		history, err = conversationHistory(ctx, req.ConversationID, userMessage)
+		if err != nil {
+			ErrorResponse(c, http.StatusInternalServerError, "Failed to load conversation")
+			return nil, false
+		}
 	}
 
-	// Parse JSON preferences
-	var prefs UserPreferences
-	if err := json.Unmarshal([]byte(prefJSON), &prefs); err != nil {
-		return nil, err
+	return &mealRequest{
+		UserID:         userID.(int64),
+		ConversationID: req.ConversationID,
+		Message:        req.Message,
+		Reservation:    reservation,
+		LLM: llm.Request{
+			System:   systemPrompt,
+			Messages: append(history, llm.Message{Role: llm.RoleUser, Content: userMessage}),
+		},
+	}, true
+}
+
+// recordTurn appends the exchange to the request's conversation, if any
+func recordTurn(meal *mealRequest, reply string) {
+	if meal.ConversationID == 0 {
+		return
+	}
+	if err := saveConversationTurn(meal.ConversationID, meal.Message, reply); err != nil {
+		log.Printf("Warning: Failed to save conversation %d: %v", meal.ConversationID, err)
 	}
+}
 
-	return &prefs, nil
+// recipeAttempts reads LLM_RECIPE_MAX_ATTEMPTS, falling back to the llm
+// package default
+func recipeAttempts() int {
+	if v, err := strconv.Atoi(os.Getenv("LLM_RECIPE_MAX_ATTEMPTS")); err == nil && v > 0 {
+		return v
+	}
+	return llm.DefaultRecipeAttempts
 }
 
-func getUserUsage(userID int64) (*UserUsage, error) {
+// generationContext bounds the model call of a generation so it ends
+// before the quota reservation expires. After that the unit may be held by
+// another request and the generation could no longer be counted.
+func generationContext(c *gin.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(c.Request.Context(), db.ReservationTTL*4/5)
+}
+
+// usageLimitReached rejects a generation once the quota is used up
+func usageLimitReached(c *gin.Context, userID int64) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	usage, err := db.QuotaUsage(ctx, userID)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to check usage limits")
+		return
+	}
+	var used string
+	switch usage.Exhausted() {
+	case db.LimitTokens:
+		used = fmt.Sprintf("%d/%d tokens", usage.Tokens, *usage.TokenLimit)
+	case db.LimitCost:
+		used = fmt.Sprintf("$%.2f/$%.2f of model usage", usage.Cost.Dollars(), usage.CostLimit.Dollars())
+	default:
+		used = fmt.Sprintf("%d/%d meal generations", usage.Used+usage.Reserved, usage.Limit)
+	}
+	ErrorResponse(c, http.StatusForbidden, fmt.Sprintf(
+		"Usage limit reached. You've used %s on the %s plan. More become available at %s.",
+		used, usage.Plan.Name, usage.WindowEnd.Format(time.RFC3339),
+	))
+}
+
+// usageBlock reports usage the way /api/usage does
+func usageBlock(usage *db.Usage) gin.H {
+	return gin.H{
+		"used":      usage.Used,
+		"remaining": usage.Remaining(),
+		"limit":     usage.Limit,
+		"resets_at": usage.WindowEnd.Format(time.RFC3339),
+	}
+}
+
+// commitMeal counts a successful generation against the quota and returns
+// the usage block for the response
+func commitMeal(meal *mealRequest) gin.H {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
-	var usage UserUsage
-	err := db.DB.QueryRowContext(ctx,
-		"SELECT meal_count, max_meals FROM users_tracking WHERE user_id = ?",
-		userID,
-	).Scan(&usage.MealCount, &usage.MaxMeals)
-
-	if err == sql.ErrNoRows {
-		_, err = db.DB.ExecContext(ctx,
-			"INSERT INTO users_tracking (user_id, meal_count, max_meals) VALUES (?, 0, 20)",
-			userID,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("failed to create tracking record: %w", err)
+	usage, err := db.CommitQuota(ctx, meal.Reservation)
+	if err != nil {
+		// Log error but don't fail the request since user got their response
+		log.Printf("Warning: Failed to record usage for user %d: %v", meal.UserID, err)
+		if usage, err = db.QuotaUsage(ctx, meal.UserID); err != nil {
+			return nil
 		}
-		return &UserUsage{MealCount: 0, MaxMeals: 20}, nil
 	}
-	
+	return usageBlock(usage)
+}
+
+// recordModelUsage writes the tokens a model call consumed, and what they
+// cost, to the usage ledger
+func recordModelUsage(meal *mealRequest, endpoint string, resp *llm.Response, succeeded bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	err := db.RecordUsageEvent(ctx, db.UsageEvent{
+		UserID:       meal.UserID,
+		Endpoint:     endpoint,
+		Model:        resp.Model,
+		InputTokens:  resp.Usage.InputTokens,
+		OutputTokens: resp.Usage.OutputTokens,
+		Cost:         db.Micros(llm.CostMicros(resp.Model, resp.Usage)),
+		Succeeded:    succeeded,
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to fetch usage: %w", err)
+		log.Printf("Warning: %v for user %d", err, meal.UserID)
 	}
+}
 
-	return &usage, nil
-  }
+const (
+	// maxPromptSummary is how much of the prompt the generation log keeps
+	maxPromptSummary = 100
+	// maxGenerationError bounds the error messages in the generation log
+	maxGenerationError = 500
+)
 
-func incrementUserUsage(userID int64) error {
-  	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-  	defer cancel()
+// summarize collapses whitespace in s and cuts it to at most max runes
+func summarize(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	if runes := []rune(s); len(runes) > max {
+		return string(runes[:max-1]) + "…"
+	}
+	return s
+}
 
-  	_, err := db.DB.ExecContext(ctx,
-  		`UPDATE users_tracking
-  		 SET meal_count = meal_count + 1,
-  		     updated_at = datetime('now')
-  		 WHERE user_id = ?`,
-  		userID,
-  	)
+// recordGeneration logs a model call that began at started, whatever its
+// outcome, and writes the tokens to the usage ledger if the model answered
+func recordGeneration(meal *mealRequest, endpoint string, started time.Time, resp *llm.Response, genErr error) {
+	g := db.Generation{
+		UserID:        meal.UserID,
+		Endpoint:      endpoint,
+		PromptSummary: summarize(meal.Message, maxPromptSummary),
+		LatencyMs:     time.Since(started).Milliseconds(),
+		Status:        db.GenerationOK,
+		CreatedAt:     started,
+	}
+	if meal.ConversationID != 0 {
+		g.ConversationID = &meal.ConversationID
+	}
+	if resp != nil {
+		recordModelUsage(meal, endpoint, resp, genErr == nil)
+		g.Model = &resp.Model
+		g.InputTokens, g.OutputTokens = resp.Usage.InputTokens, resp.Usage.OutputTokens
+	}
+	if genErr != nil {
+		g.Status = db.GenerationFailed
+		if errors.Is(genErr, llm.ErrInvalidRecipe) {
+			g.Status = db.GenerationRejected
+		}
+		msg := summarize(genErr.Error(), maxGenerationError)
+		g.Error = &msg
+	}
 
-  	if err != nil {
-  		return fmt.Errorf("failed to increment usage: %w", err)
-  	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if err := db.RecordGeneration(ctx, g); err != nil {
+		log.Printf("Warning: %v for user %d", err, meal.UserID)
+	}
+}
 
-  	return nil
-  }
+// releaseMeal hands the quota back after a failed generation. It runs on
+// its own context since the request's may already be cancelled.
+func releaseMeal(meal *mealRequest) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
 
+	if err := db.ReleaseQuota(ctx, meal.Reservation); err != nil {
+		log.Printf("Warning: %v for user %d; it expires on its own", err, meal.UserID)
+	}
+}
 
 func HandleLLMRequest(c *gin.Context) {
-  	var req LLMRequest
-  	if err := c.ShouldBindJSON(&req); err != nil {
-  		ErrorResponse(c, http.StatusBadRequest, err.Error())
-  		return
-  	}
-
-  	// Get user ID from JWT token (set by AuthMiddleware)
-  	userID, exists := c.Get("user_id")
-  	if !exists {
-  		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
-  		return
-  	}
-
-  	// ✅ CHECK USAGE LIMIT - FETCH FROM DB
-  	usage, err := getUserUsage(userID.(int64))
-  	if err != nil {
-  		ErrorResponse(c, http.StatusInternalServerError, "Failed to check usage limits")
-  		return
-  	}
-
-  	if usage.MealCount >= usage.MaxMeals {
-  		ErrorResponse(c, http.StatusForbidden, fmt.Sprintf(
-  			"Usage limit reached. You've used %d/%d free meal generations.",
-  			usage.MealCount, usage.MaxMeals,
-  		))
-  		return
-  	}
-
-  	// Fetch user preferences
-  	prefs, err := getUserPreferences(userID.(int64))
-  	if err != nil {
-  		ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch preferences")
-  		return
-  	}
-
-  	// Load system prompt from environment
-  	systemPrompt := os.Getenv("LLM_SYSTEM_PROMPT")
-  	if systemPrompt == "" {
-  		systemPrompt = "You are a helpful meal planning assistant."
-  	}
-
-  	// Build user message with preferences
-  	userMessage := buildMealPrompt(req.Message, prefs)
-
-  	// Call the LLM service with both system and user prompts
-  	response, err := llm.CallAnthropic(systemPrompt, userMessage)
-  	if err != nil {
-  		ErrorResponse(c, http.StatusInternalServerError, err.Error())
-  		return
-  	}
-
-  	// ✅ INCREMENT USAGE AFTER SUCCESSFUL LLM CALL
-  	if err := incrementUserUsage(userID.(int64)); err != nil {
-  		// Log error but don't fail the request since user got their response
-  		fmt.Printf("Warning: Failed to increment usage for user %d: %v\n", userID, err)
-  	}
-
-  	SuccessResponse(c, gin.H{
-  		"response": response,
-  		"usage": gin.H{
-  			"used":      usage.MealCount + 1,
-  			"remaining": usage.MaxMeals - (usage.MealCount + 1),
-  			"limit":     usage.MaxMeals,
-  		},
-  	})
-  }
+	meal, ok := prepareMealRequest(c)
+	if !ok {
+		return
+	}
 
+	// Ask the configured LLM provider for a structured recipe, retrying on
+	// schema violations
+	ctx, cancel := generationContext(c)
+	defer cancel()
+
+	started := time.Now()
+	recipe, resp, err := llm.GenerateRecipe(ctx, meal.LLM, recipeAttempts())
+	recordGeneration(meal, "/llm", started, resp, err)
+	if err != nil {
+		releaseMeal(meal)
+		if errors.Is(err, llm.ErrInvalidRecipe) {
+			ErrorResponse(c, http.StatusBadGateway, err.Error())
+			return
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			ErrorResponse(c, http.StatusGatewayTimeout, "The model took too long to answer")
+			return
+		}
+		ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	// ✅ COUNT USAGE AFTER SUCCESSFUL LLM CALL
+	usage := commitMeal(meal)
+
+	recordTurn(meal, resp.Text)
+
+	data := gin.H{
+		"response": resp.Text,
+		"recipe":   recipe,
+		"usage":    usage,
+	}
+	if meal.ConversationID != 0 {
+		data["conversation_id"] = meal.ConversationID
+	}
+	SuccessResponse(c, data)
+}
 
 // buildMealPrompt constructs a meal planning prompt with user preferences
-func buildMealPrompt(ingredients string, prefs *UserPreferences) string {
+func buildMealPrompt(ingredients string, prefs *preferences.Preferences) string {
 	prompt := ingredients
 
 	if prefs != nil {
-		if prefs.DietaryRestrictions != "" {
-			prompt += fmt.Sprintf("\nDietary restrictions: %s", prefs.DietaryRestrictions)
+		if len(prefs.Allergies) > 0 {
+			prompt += fmt.Sprintf("\nAllergies (must be avoided entirely): %s", readableList(prefs.Allergies))
+		}
+		if len(prefs.Diets) > 0 {
+			prompt += fmt.Sprintf("\nDiets: %s", readableList(prefs.Diets))
+		}
+		if len(prefs.DislikedIngredients) > 0 {
+			prompt += fmt.Sprintf("\nDisliked ingredients: %s", strings.Join(prefs.DislikedIngredients, ", "))
+		}
+		if len(prefs.Cuisines) > 0 {
+			prompt += fmt.Sprintf("\nPreferred cuisines: %s", readableList(prefs.Cuisines))
+		}
+		if prefs.HouseholdSize > 0 {
+			prompt += fmt.Sprintf("\nHousehold size: %d", prefs.HouseholdSize)
+		}
+		if prefs.SkillLevel != "" {
+			prompt += fmt.Sprintf("\nCooking skill level: %s", prefs.SkillLevel)
+		}
+		if len(prefs.Equipment) > 0 {
+			prompt += fmt.Sprintf("\nAvailable equipment: %s", readableList(prefs.Equipment))
+		}
+		if prefs.Budget != "" {
+			prompt += fmt.Sprintf("\nBudget: %s", prefs.Budget)
+		}
+		if prefs.CalorieTarget > 0 {
+			prompt += fmt.Sprintf("\nCalorie target: about %d kcal per serving", prefs.CalorieTarget)
 		}
 		if prefs.MaxCookingTime > 0 {
 			prompt += fmt.Sprintf("\nMaximum cooking time: %d minutes", prefs.MaxCookingTime)
 		}
+		if prefs.Notes != "" {
+			prompt += fmt.Sprintf("\nOther dietary notes: %s", prefs.Notes)
+		}
 	}
 
 	return prompt
 }
 
+// readableList joins enumerated values as words, e.g. "tree nuts, sesame"
+func readableList(values []string) string {
+	return strings.ReplaceAll(strings.Join(values, ", "), "_", " ")
+}
+
 func LLMHealthCheck(c *gin.Context) {
-	apiKey := os.Getenv("ANTHROPIC_API_KEY")
-	if apiKey == "" {
+	if llm.Default == nil {
+		ErrorResponse(c, http.StatusServiceUnavailable, "LLM provider not configured")
+		return
+	}
+
+	info := llm.Default.Info()
+	if info.Provider == llm.ProviderAnthropic && os.Getenv("ANTHROPIC_API_KEY") == "" {
 		ErrorResponse(c, http.StatusServiceUnavailable, "ANTHROPIC_API_KEY not configured")
 		return
 	}
 
-	SuccessResponse(c, gin.H{"llm": "configured"})
+	SuccessResponse(c, gin.H{
+		"llm":   "configured",
+		"model": info,
+	})
 }
 
 // GetUsage returns the user's meal generation usage statistics
@@ -212,15 +407,38 @@ func GetUsage(c *gin.Context) {
 		return
 	}
 
-	usage, err := getUserUsage(userID.(int64))
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	usage, err := db.QuotaUsage(ctx, userID.(int64))
 	if err != nil {
 		ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch usage")
 		return
 	}
 
-	SuccessResponse(c, gin.H{
-		"used":      usage.MealCount,
-		"remaining": usage.MaxMeals - usage.MealCount,
-		"limit":     usage.MaxMeals,
-	})
+	data := usageBlock(usage)
+	data["in_progress"] = usage.Reserved
+	data["tokens"] = gin.H{"used": usage.Tokens, "limit": usage.TokenLimit}
+	data["cost_usd"] = gin.H{"used": usage.Cost, "limit": usage.CostLimit}
+	data["allowance"] = usage.Allowance
+	data["rollover"] = usage.Rollover
+	data["plan"] = usage.Plan
+	data["window"] = gin.H{
+		"start": usage.WindowStart.Format(time.RFC3339),
+		"end":   usage.WindowEnd.Format(time.RFC3339),
+	}
+	SuccessResponse(c, data)
+}
+
+// ListPlans returns the subscription plans on offer
+func ListPlans(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	plans, err := db.ListPlans(ctx)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch plans")
+		return
+	}
+	SuccessResponse(c, gin.H{"plans": plans})
 }
@@ -0,0 +1,60 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/llm"
+)
+
+// HandleLLMStream generates a meal like HandleLLMRequest but relays the
+// model output as Server-Sent Events. Each text fragment is sent as a
+// "delta" event, followed by a single "done" event carrying the usage block,
+// or an "error" event if the model call fails part way through. The quota
+// reserved for the request is only counted once the stream has completed
+// successfully, and handed back otherwise.
+func HandleLLMStream(c *gin.Context) {
+	meal, ok := prepareMealRequest(c)
+	if !ok {
+		return
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	ctx, cancel := generationContext(c)
+	defer cancel()
+
+	started := time.Now()
+	resp, err := llm.Stream(ctx, meal.LLM, func(text string) error {
+		c.SSEvent("delta", gin.H{"text": text})
+		c.Writer.Flush()
+		return ctx.Err()
+	})
+	recordGeneration(meal, "/llm/stream", started, resp, err)
+	if err != nil {
+		releaseMeal(meal)
+		c.SSEvent("error", gin.H{"status": "error", "message": err.Error()})
+		c.Writer.Flush()
+		return
+	}
+
+	usage := commitMeal(meal)
+
+	recordTurn(meal, resp.Text)
+
+	done := gin.H{
+		"status":   "ok",
+		"response": resp.Text,
+		"usage":    usage,
+	}
+	if meal.ConversationID != 0 {
+		done["conversation_id"] = meal.ConversationID
+	}
+	c.SSEvent("done", done)
+	c.Writer.Flush()
+}
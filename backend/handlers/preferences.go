@@ -2,26 +2,66 @@ package handlers
 
 import (
 	"context"
-	"database/sql"
-	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"mime"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	db "backend/database"
+	"backend/preferences"
 )
 
-type PreferencesRequest struct {
-	DietaryRestrictions string `json:"dietary_restrictions"`
-	MaxCookingTime      int    `json:"max_cooking_time"`
+// maxPreferencesBody bounds PUT and PATCH request bodies
+const maxPreferencesBody = 64 << 10
+
+// patchAttempts is how often an unconditional PATCH is retried when another
+// request saves the preferences between reading and writing them
+const patchAttempts = 3
+
+// staleRevisionMessage answers writes based on an outdated If-Match
+const staleRevisionMessage = "Preferences have changed since they were loaded. Reload them and try again."
+
+// preferencesResponse returns the preference fields at the top level of
+// the response, next to status, as the original endpoints did
+type preferencesResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	*preferences.Preferences
 }
 
-type PreferencesResponse struct {
-	DietaryRestrictions string `json:"dietary_restrictions"`
-	MaxCookingTime      int    `json:"max_cooking_time"`
+// ifMatch reports whether the If-Match header, if any, names the revision
+// of doc
+func ifMatch(c *gin.Context, doc *preferences.Document) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == doc.ETag {
+			return true
+		}
+	}
+	return false
 }
 
-// GetPreferences retrieves user preferences
+// preconditionFailed rejects a write based on an outdated revision
+func preconditionFailed(c *gin.Context, doc *preferences.Document) {
+	c.Header("ETag", doc.ETag)
+	ErrorResponse(c, http.StatusPreconditionFailed, staleRevisionMessage)
+}
+
+// sendPreferences writes the preferences with their ETag
+func sendPreferences(c *gin.Context, doc *preferences.Document, message string) {
+	c.Header("ETag", doc.ETag)
+	c.Header("Cache-Control", "no-cache")
+	c.JSON(http.StatusOK, preferencesResponse{Status: "ok", Message: message, Preferences: doc.Preferences})
+}
+
+// GetPreferences retrieves user preferences. The ETag header identifies
+// the revision for If-Match on later updates.
 func GetPreferences(c *gin.Context) {
 	userID, exists := c.Get("user_id")
 	if !exists {
@@ -32,42 +72,42 @@ func GetPreferences(c *gin.Context) {
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 	defer cancel()
 
-	var prefJSON string
-	err := db.DB.QueryRowContext(ctx,
-		"SELECT user_preference FROM user_preference WHERE user_id = ?",
-		userID,
-	).Scan(&prefJSON)
-
-	if err == sql.ErrNoRows {
-		// No preferences set yet
-		SuccessResponse(c, gin.H{
-			"dietary_restrictions": "",
-			"max_cooking_time":     0,
-		})
-		return
-	}
-
+	doc, err := preferences.Get(ctx, userID.(int64))
 	if err != nil {
-		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		log.Printf("Failed to load preferences for user %d: %v", userID, err)
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to load preferences")
 		return
 	}
 
-	var prefs PreferencesResponse
-	if err := json.Unmarshal([]byte(prefJSON), &prefs); err != nil {
-		ErrorResponse(c, http.StatusInternalServerError, "Failed to parse preferences")
+	if c.GetHeader("If-None-Match") == doc.ETag {
+		c.Header("ETag", doc.ETag)
+		c.Status(http.StatusNotModified)
 		return
 	}
+	sendPreferences(c, doc, "")
+}
 
-	SuccessResponse(c, gin.H{
-		"dietary_restrictions": prefs.DietaryRestrictions,
-		"max_cooking_time":     prefs.MaxCookingTime,
-	})
+// GetPreferenceSchema lists the schema version and the allowed values of
+// every enumerated preference
+func GetPreferenceSchema(c *gin.Context) {
+	SuccessResponse(c, gin.H{"schema": preferences.Schema()})
 }
 
-// UpdatePreferences sets or updates user preferences
+// UpdatePreferences replaces the user's preferences. Bodies in the original
+// format, with dietary_restrictions, are upgraded like stored ones. With
+// If-Match the update only applies to the revision named.
 func UpdatePreferences(c *gin.Context) {
-	var req PreferencesRequest
-	if err := c.ShouldBindJSON(&req); err != nil {
+	body, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPreferencesBody))
+	if err != nil {
+		ErrorResponse(c, http.StatusBadRequest, "Failed to read request body")
+		return
+	}
+	prefs, err := preferences.Parse(body)
+	if err != nil {
+		ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := prefs.Validate(); err != nil {
 		ErrorResponse(c, http.StatusBadRequest, err.Error())
 		return
 	}
@@ -78,34 +118,105 @@ func UpdatePreferences(c *gin.Context) {
 		return
 	}
 
-	// Convert preferences to JSON
-	prefJSON, err := json.Marshal(req)
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var saved *preferences.Document
+	if c.GetHeader("If-Match") == "" {
+		saved, err = preferences.Save(ctx, userID.(int64), prefs)
+	} else {
+		var current *preferences.Document
+		current, err = preferences.Get(ctx, userID.(int64))
+		if err == nil {
+			if !ifMatch(c, current) {
+				preconditionFailed(c, current)
+				return
+			}
+			saved, err = preferences.SaveIf(ctx, userID.(int64), prefs, current)
+		}
+	}
+	if errors.Is(err, preferences.ErrConflict) {
+		ErrorResponse(c, http.StatusPreconditionFailed, staleRevisionMessage)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to save preferences for user %d: %v", userID, err)
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to save preferences")
+		return
+	}
+
+	sendPreferences(c, saved, "Preferences updated successfully")
+}
+
+// PatchPreferences applies a JSON Merge Patch (RFC 7396) to the user's
+// preferences and returns the merged document. With If-Match the patch
+// only applies to the revision named; without it, concurrent changes to
+// other fields are kept.
+func PatchPreferences(c *gin.Context) {
+	// 1. Accept merge patches, and plain JSON for simple clients
+	mediaType, _, _ := mime.ParseMediaType(c.GetHeader("Content-Type"))
+	if mediaType != "application/merge-patch+json" && mediaType != "application/json" {
+		ErrorResponse(c, http.StatusUnsupportedMediaType, "Content-Type must be application/merge-patch+json")
+		return
+	}
+	patch, err := io.ReadAll(io.LimitReader(c.Request.Body, maxPreferencesBody))
 	if err != nil {
-		ErrorResponse(c, http.StatusInternalServerError, "Failed to encode preferences")
+		ErrorResponse(c, http.StatusBadRequest, "Failed to read request body")
 		return
 	}
 
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	conditional := c.GetHeader("If-Match") != ""
+
 	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
 	defer cancel()
 
-	// Upsert preferences (insert or update)
-	_, err = db.DB.ExecContext(ctx,
-		`INSERT INTO user_preference (user_id, user_preference, updated_at)
-		 VALUES (?, ?, datetime('now'))
-		 ON CONFLICT(user_id) DO UPDATE SET
-		 user_preference = excluded.user_preference,
-		 updated_at = excluded.updated_at`,
-		userID, string(prefJSON),
-	)
-
-	if err != nil {
-		ErrorResponse(c, http.StatusInternalServerError, "Failed to save preferences")
+	for attempt := 0; attempt < patchAttempts; attempt++ {
+		// 2. Read the current revision and check the precondition
+		current, err := preferences.Get(ctx, userID.(int64))
+		if err != nil {
+			log.Printf("Failed to load preferences for user %d: %v", userID, err)
+			ErrorResponse(c, http.StatusInternalServerError, "Failed to load preferences")
+			return
+		}
+		if !ifMatch(c, current) {
+			preconditionFailed(c, current)
+			return
+		}
+
+		// 3. Merge and validate the result as a whole
+		merged, err := preferences.Merge(current.Preferences, patch)
+		if err != nil {
+			ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+		if err := merged.Validate(); err != nil {
+			ErrorResponse(c, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		// 4. Save only if nothing changed in between
+		saved, err := preferences.SaveIf(ctx, userID.(int64), merged, current)
+		if errors.Is(err, preferences.ErrConflict) {
+			if conditional {
+				ErrorResponse(c, http.StatusPreconditionFailed, staleRevisionMessage)
+				return
+			}
+			continue
+		}
+		if err != nil {
+			log.Printf("Failed to save preferences for user %d: %v", userID, err)
+			ErrorResponse(c, http.StatusInternalServerError, "Failed to save preferences")
+			return
+		}
+
+		sendPreferences(c, saved, "Preferences updated successfully")
 		return
 	}
 
-	SuccessResponse(c, gin.H{
-		"message":              "Preferences updated successfully",
-		"dietary_restrictions": req.DietaryRestrictions,
-		"max_cooking_time":     req.MaxCookingTime,
-	})
+	ErrorResponse(c, http.StatusConflict, "Preferences are being changed by another request. Please try again.")
 }
@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/llm"
+	db "backend/database"
+)
+
+type SaveRecipeRequest struct {
+	Recipe    *llm.Recipe `json:"recipe" binding:"required"`
+	Favourite bool        `json:"favourite"`
+}
+
+type UpdateRecipeRequest struct {
+	Recipe *llm.Recipe `json:"recipe" binding:"required"`
+}
+
+type FavouriteRequest struct {
+	Favourite *bool `json:"favourite" binding:"required"`
+}
+
+type SavedRecipe struct {
+	ID        int64      `json:"id"`
+	Title     string     `json:"title"`
+	Recipe    llm.Recipe `json:"recipe"`
+	Favourite bool       `json:"favourite"`
+	CreatedAt string     `json:"created_at"`
+	UpdatedAt string     `json:"updated_at"`
+}
+
+const (
+	defaultPageSize = 20
+	maxPageSize     = 100
+)
+
+// Pagination reads ?page= and ?page_size= with sane defaults and bounds
+func Pagination(c *gin.Context) (page, pageSize int) {
+	page, _ = strconv.Atoi(c.Query("page"))
+	if page < 1 {
+		page = 1
+	}
+	pageSize, _ = strconv.Atoi(c.Query("page_size"))
+	if pageSize < 1 {
+		pageSize = defaultPageSize
+	}
+	if pageSize > maxPageSize {
+		pageSize = maxPageSize
+	}
+	return page, pageSize
+}
+
+// scanRecipe reads a recipes row selected by recipeColumns
+func scanRecipe(row interface{ Scan(...any) error }) (*SavedRecipe, error) {
+	var r SavedRecipe
+	var recipeJSON string
+	if err := row.Scan(&r.ID, &r.Title, &recipeJSON, &r.Favourite, &r.CreatedAt, &r.UpdatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(recipeJSON), &r.Recipe); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+const recipeColumns = "id, title, recipe, is_favourite, created_at, updated_at"
+
+// getRecipe fetches a saved recipe if it belongs to the user
+func getRecipe(ctx context.Context, recipeID, userID int64) (*SavedRecipe, error) {
+	return scanRecipe(db.DB.QueryRowContext(ctx,
+		"SELECT "+recipeColumns+" FROM recipes WHERE id = ? AND user_id = ?",
+		recipeID, userID,
+	))
+}
+
+// respondWithRecipe re-reads a recipe after a write and returns it
+func respondWithRecipe(c *gin.Context, ctx context.Context, recipeID, userID int64) {
+	recipe, err := getRecipe(ctx, recipeID, userID)
+	if err == sql.ErrNoRows {
+		ErrorResponse(c, http.StatusNotFound, "Recipe not found")
+		return
+	}
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	SuccessResponse(c, gin.H{"recipe": recipe})
+}
+
+// SaveRecipe stores a recipe, typically one returned by /llm, in the user's library
+func SaveRecipe(c *gin.Context) {
+	var req SaveRecipeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := llm.ValidateRecipe(req.Recipe); err != nil {
+		ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	recipeJSON, err := json.Marshal(req.Recipe)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to encode recipe")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx,
+		"INSERT INTO recipes (user_id, title, recipe, is_favourite) VALUES (?, ?, ?, ?)",
+		userID, req.Recipe.Title, string(recipeJSON), req.Favourite,
+	)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to save recipe")
+		return
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to get recipe ID")
+		return
+	}
+
+	respondWithRecipe(c, ctx, id, userID.(int64))
+}
+
+// ListRecipes returns a page of the user's saved recipes, newest first.
+// Pass ?favourites=true to only list favourites.
+func ListRecipes(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	page, pageSize := Pagination(c)
+	favouritesOnly := c.Query("favourites") == "true"
+
+	where := "WHERE user_id = ?"
+	if favouritesOnly {
+		where += " AND is_favourite = 1"
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var total int
+	if err := db.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM recipes "+where, userID).Scan(&total); err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	rows, err := db.DB.QueryContext(ctx,
+		"SELECT "+recipeColumns+" FROM recipes "+where+" ORDER BY created_at DESC, id DESC LIMIT ? OFFSET ?",
+		userID, pageSize, (page-1)*pageSize,
+	)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+	defer rows.Close()
+
+	recipes := []*SavedRecipe{}
+	for rows.Next() {
+		r, err := scanRecipe(rows)
+		if err != nil {
+			ErrorResponse(c, http.StatusInternalServerError, "Failed to read recipes")
+			return
+		}
+		recipes = append(recipes, r)
+	}
+	if err := rows.Err(); err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
+	SuccessResponse(c, gin.H{
+		"recipes": recipes,
+		"pagination": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+			"total":     total,
+		},
+	})
+}
+
+// GetRecipe returns a single saved recipe
+func GetRecipe(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	id, ok := idParam(c, "recipe")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	respondWithRecipe(c, ctx, id, userID.(int64))
+}
+
+// UpdateRecipe replaces the contents of a saved recipe
+func UpdateRecipe(c *gin.Context) {
+	var req UpdateRecipeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err := llm.ValidateRecipe(req.Recipe); err != nil {
+		ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	id, ok := idParam(c, "recipe")
+	if !ok {
+		return
+	}
+
+	recipeJSON, err := json.Marshal(req.Recipe)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to encode recipe")
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx,
+		`UPDATE recipes SET title = ?, recipe = ?, updated_at = datetime('now')
+		 WHERE id = ? AND user_id = ?`,
+		req.Recipe.Title, string(recipeJSON), id, userID,
+	)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to update recipe")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		ErrorResponse(c, http.StatusNotFound, "Recipe not found")
+		return
+	}
+
+	respondWithRecipe(c, ctx, id, userID.(int64))
+}
+
+// SetRecipeFavourite marks or unmarks a saved recipe as a favourite
+func SetRecipeFavourite(c *gin.Context) {
+	var req FavouriteRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	id, ok := idParam(c, "recipe")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx,
+		`UPDATE recipes SET is_favourite = ?, updated_at = datetime('now')
+		 WHERE id = ? AND user_id = ?`,
+		*req.Favourite, id, userID,
+	)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to update recipe")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		ErrorResponse(c, http.StatusNotFound, "Recipe not found")
+		return
+	}
+
+	respondWithRecipe(c, ctx, id, userID.(int64))
+}
+
+// DeleteRecipe removes a recipe from the user's library
+func DeleteRecipe(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+
+	id, ok := idParam(c, "recipe")
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	result, err := db.DB.ExecContext(ctx,
+		"DELETE FROM recipes WHERE id = ? AND user_id = ?",
+		id, userID,
+	)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to delete recipe")
+		return
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		ErrorResponse(c, http.StatusNotFound, "Recipe not found")
+		return
+	}
+
+	SuccessResponse(c, gin.H{"message": "Recipe deleted"})
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	db "backend/database"
+)
+
+const (
+	// defaultUsageDays is the range reported when ?from= is missing
+	defaultUsageDays = 30
+	// maxUsageDays bounds the range of a single report
+	maxUsageDays = 366
+)
+
+// UsageRange reads ?from= and ?to= as UTC dates (YYYY-MM-DD), both
+// inclusive, defaulting to the last 30 days. It writes an error response
+// and returns false when they are invalid.
+func UsageRange(c *gin.Context) (from, to time.Time, ok bool) {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+	to, from = today, today.AddDate(0, 0, 1-defaultUsageDays)
+
+	if v := c.Query("to"); v != "" {
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			ErrorResponse(c, http.StatusBadRequest, "to must be a date (YYYY-MM-DD)")
+			return from, to, false
+		}
+		to, from = t, t.AddDate(0, 0, 1-defaultUsageDays)
+	}
+	if v := c.Query("from"); v != "" {
+		f, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			ErrorResponse(c, http.StatusBadRequest, "from must be a date (YYYY-MM-DD)")
+			return from, to, false
+		}
+		from = f
+	}
+
+	if from.After(to) {
+		ErrorResponse(c, http.StatusBadRequest, "from must not be after to")
+		return from, to, false
+	}
+	if to.Sub(from) >= maxUsageDays*24*time.Hour {
+		ErrorResponse(c, http.StatusBadRequest, "The range must not exceed 366 days")
+		return from, to, false
+	}
+	return from, to, true
+}
+
+// UsageReport aggregates the ledger rows of f by day and model, and adds
+// totals per model and overall
+func UsageReport(ctx context.Context, f db.UsageFilter) (gin.H, error) {
+	lastDay := f.To
+	f.To = f.To.AddDate(0, 0, 1)
+
+	days, err := db.UsageByDay(ctx, f)
+	if err != nil {
+		return nil, err
+	}
+
+	var total db.UsageTotals
+	perModel := map[string]*db.UsageTotals{}
+	for _, d := range days {
+		total.Add(d.UsageTotals)
+		if perModel[d.Model] == nil {
+			perModel[d.Model] = &db.UsageTotals{}
+		}
+		perModel[d.Model].Add(d.UsageTotals)
+	}
+
+	type modelUsage struct {
+		Model string `json:"model"`
+		db.UsageTotals
+	}
+	models := make([]modelUsage, 0, len(perModel))
+	for model, totals := range perModel {
+		models = append(models, modelUsage{Model: model, UsageTotals: *totals})
+	}
+	sort.Slice(models, func(i, j int) bool { return models[i].Model < models[j].Model })
+
+	return gin.H{
+		"from":     f.From.Format(time.DateOnly),
+		"to":       lastDay.Format(time.DateOnly),
+		"days":     days,
+		"by_model": models,
+		"totals":   total,
+	}, nil
+}
+
+// GetUsageBreakdown reports the user's model usage, tokens and cost by day
+// and model
+func GetUsageBreakdown(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	from, to, ok := UsageRange(c)
+	if !ok {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	report, err := UsageReport(ctx, db.UsageFilter{UserID: userID.(int64), From: from, To: to})
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch usage")
+		return
+	}
+	SuccessResponse(c, report)
+}
+
+// UsageHistory returns a page of userID's generation log in the range read
+// by UsageRange, grouped into ?bucket=hour|day|week (default day). It
+// writes an error response and returns false when the request is invalid.
+func UsageHistory(c *gin.Context, userID int64) (gin.H, bool) {
+	bucket := c.DefaultQuery("bucket", db.BucketDay)
+	from, to, ok := UsageRange(c)
+	if !ok {
+		return nil, false
+	}
+	page, pageSize := Pagination(c)
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	filter := db.UsageFilter{UserID: userID, From: from, To: to.AddDate(0, 0, 1)}
+	buckets, total, err := db.GenerationHistory(ctx, filter, bucket, pageSize, (page-1)*pageSize)
+	if errors.Is(err, db.ErrUnknownBucket) {
+		ErrorResponse(c, http.StatusBadRequest, "bucket must be hour, day or week")
+		return nil, false
+	}
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Failed to fetch usage history")
+		return nil, false
+	}
+
+	return gin.H{
+		"from":    from.Format(time.DateOnly),
+		"to":      to.Format(time.DateOnly),
+		"bucket":  bucket,
+		"buckets": buckets,
+		"pagination": gin.H{
+			"page":      page,
+			"page_size": pageSize,
+			"total":     total,
+		},
+	}, true
+}
+
+// GetUsageHistory lists the user's meal generations, newest first, with
+// their prompt, tokens, latency and outcome, so they can see where their
+// quota went
+func GetUsageHistory(c *gin.Context) {
+	userID, exists := c.Get("user_id")
+	if !exists {
+		ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+		return
+	}
+	history, ok := UsageHistory(c, userID.(int64))
+	if !ok {
+		return
+	}
+	SuccessResponse(c, history)
+}
@@ -1,9 +1,13 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	db "backend/database"
 )
 
 // GetProfile returns the authenticated user's profile
@@ -17,11 +21,25 @@ func GetProfile(c *gin.Context) {
 
 	userEmail, _ := c.Get("user_email")
 
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	defer cancel()
+
+	var verifiedAt sql.NullString
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT email_verified_at FROM users WHERE id = ?",
+		userID,
+	).Scan(&verifiedAt)
+	if err != nil {
+		ErrorResponse(c, http.StatusInternalServerError, "Database error")
+		return
+	}
+
 	// Return user profile
 	SuccessResponse(c, gin.H{
 		"user": gin.H{
-			"id":    userID,
-			"email": userEmail,
+			"id":             userID,
+			"email":          userEmail,
+			"email_verified": verifiedAt.Valid,
 		},
 	})
 }
@@ -2,29 +2,148 @@ package llm
 
 import (
 	"context"
-	"os"
+	"encoding/json"
+
 	"github.com/anthropics/anthropic-sdk-go"
 	"github.com/anthropics/anthropic-sdk-go/option"
 )
 
-func CallAnthropic(systemPrompt string, userMessage string) (string, error) {
-	client := anthropic.NewClient(option.WithAPIKey(os.Getenv("ANTHROPIC_API_KEY")))
+// AnthropicProvider talks to the Anthropic Messages API
+type AnthropicProvider struct {
+	client    anthropic.Client
+	model     anthropic.Model
+	maxTokens int64
+}
 
-	ctx := context.Background()
-	resp, err := client.Messages.New(ctx, anthropic.MessageNewParams{
-		Model: anthropic.ModelClaudeSonnet4_5_20250929,
-		System: []anthropic.TextBlockParam{
-			{Text: systemPrompt},
-		},
-		Messages: []anthropic.MessageParam{
-			anthropic.NewUserMessage(anthropic.NewTextBlock(userMessage)),
-		},
-		MaxTokens: 1024,
-	})
+// NewAnthropicProvider creates a provider for the Anthropic API. The model
+// defaults to Sonnet 4.5 when cfg.Model is empty.
+func NewAnthropicProvider(cfg Config) *AnthropicProvider {
+	model := anthropic.Model(cfg.Model)
+	if model == "" {
+		model = anthropic.ModelClaudeSonnet4_5_20250929
+	}
+	maxTokens := cfg.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = DefaultMaxTokens
+	}
+
+	return &AnthropicProvider{
+		client:    anthropic.NewClient(option.WithAPIKey(cfg.APIKey)),
+		model:     model,
+		maxTokens: maxTokens,
+	}
+}
 
+func (p *AnthropicProvider) Info() ModelInfo {
+	return ModelInfo{
+		Provider:  ProviderAnthropic,
+		Model:     string(p.model),
+		MaxTokens: p.maxTokens,
+	}
+}
+
+func (p *AnthropicProvider) params(req Request) anthropic.MessageNewParams {
+	maxTokens := req.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = p.maxTokens
+	}
+
+	messages := make([]anthropic.MessageParam, 0, len(req.Messages))
+	for _, m := range req.Messages {
+		if m.Role == RoleAssistant {
+			messages = append(messages, anthropic.NewAssistantMessage(anthropic.NewTextBlock(m.Content)))
+		} else {
+			messages = append(messages, anthropic.NewUserMessage(anthropic.NewTextBlock(m.Content)))
+		}
+	}
+
+	params := anthropic.MessageNewParams{
+		Model:     p.model,
+		Messages:  messages,
+		MaxTokens: maxTokens,
+	}
+	if req.System != "" {
+		params.System = []anthropic.TextBlockParam{{Text: req.System}}
+	}
+	if req.Tool != nil {
+		tool := anthropic.ToolUnionParamOfTool(anthropic.ToolInputSchemaParam{
+			Properties: req.Tool.Properties,
+			Required:   req.Tool.Required,
+		}, req.Tool.Name)
+		tool.OfTool.Description = anthropic.String(req.Tool.Description)
+		params.Tools = []anthropic.ToolUnionParam{tool}
+		params.ToolChoice = anthropic.ToolChoiceParamOfTool(req.Tool.Name)
+	}
+	return params
+}
+
+func (p *AnthropicProvider) Complete(ctx context.Context, req Request) (*Response, error) {
+	resp, err := p.client.Messages.New(ctx, p.params(req))
 	if err != nil {
-		return "", err
+		return nil, err
 	}
+	return toResponse(resp), nil
+}
+
+func (p *AnthropicProvider) Stream(ctx context.Context, req Request, onDelta DeltaFunc) (*Response, error) {
+	stream := p.client.Messages.NewStreaming(ctx, p.params(req))
+	defer stream.Close()
 
-	return resp.Content[0].Text, nil
+	message := anthropic.Message{}
+	// partial returns what was received before err, so the tokens already
+	// spent can be recorded
+	partial := func(err error) (*Response, error) {
+		if message.ID == "" {
+			return nil, err
+		}
+		return toResponse(&message), err
+	}
+
+	for stream.Next() {
+		event := stream.Current()
+		if err := message.Accumulate(event); err != nil {
+			return partial(err)
+		}
+
+		if ev, ok := event.AsAny().(anthropic.ContentBlockDeltaEvent); ok {
+			if delta, ok := ev.Delta.AsAny().(anthropic.TextDelta); ok && onDelta != nil {
+				if err := onDelta(delta.Text); err != nil {
+					return partial(err)
+				}
+			}
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return partial(err)
+	}
+
+	return toResponse(&message), nil
+}
+
+// toResponse flattens the text blocks of an Anthropic message and picks out
+// the first tool call
+func toResponse(msg *anthropic.Message) *Response {
+	var text string
+	var toolInput json.RawMessage
+	for _, block := range msg.Content {
+		switch block.Type {
+		case "text":
+			text += block.Text
+		case "tool_use":
+			if toolInput == nil {
+				toolInput = block.Input
+			}
+		}
+	}
+
+	return &Response{
+		Text:       text,
+		ToolInput:  toolInput,
+		Model:      string(msg.Model),
+		StopReason: string(msg.StopReason),
+		Usage: Usage{
+			InputTokens:  msg.Usage.InputTokens,
+			OutputTokens: msg.Usage.OutputTokens,
+		},
+	}
 }
@@ -0,0 +1,165 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FakeProvider is a deterministic provider for tests and offline
+// development. By default it answers with a canned reply that echoes the
+// last user message.
+type FakeProvider struct {
+	mu sync.Mutex
+
+	// Reply, when set, produces the response text for a request
+	Reply func(req Request) string
+	// ToolReply, when set, produces the tool call arguments for requests
+	// that carry a Tool
+	ToolReply func(req Request) json.RawMessage
+	// Err, when set, is returned instead of a response
+	Err error
+	// FailAfter, when set with Err, streams that many words before
+	// failing with Err and returns the partial response with it
+	FailAfter int
+	// Delay, when set, is how long the provider takes before answering,
+	// or until the context is done
+	Delay time.Duration
+
+	// Requests records every request the provider has received
+	Requests []Request
+}
+
+// NewFakeProvider creates a fake provider with the default echo reply
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{}
+}
+
+func (p *FakeProvider) Info() ModelInfo {
+	return ModelInfo{
+		Provider:  ProviderFake,
+		Model:     "fake-model",
+		MaxTokens: DefaultMaxTokens,
+	}
+}
+
+func (p *FakeProvider) Complete(ctx context.Context, req Request) (*Response, error) {
+	return p.Stream(ctx, req, nil)
+}
+
+func (p *FakeProvider) Stream(ctx context.Context, req Request, onDelta DeltaFunc) (*Response, error) {
+	p.mu.Lock()
+	p.Requests = append(p.Requests, req)
+	reply, toolReply, fail, failAfter, delay := p.Reply, p.ToolReply, p.Err, p.FailAfter, p.Delay
+	p.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	if fail != nil && failAfter <= 0 {
+		return nil, fail
+	}
+
+	var toolInput json.RawMessage
+	if req.Tool != nil {
+		if toolReply != nil {
+			toolInput = toolReply(req)
+		} else if req.Tool.Name == RecipeToolName {
+			toolInput = fakeRecipe(lastUserMessage(req))
+		}
+	}
+
+	var text string
+	if reply != nil {
+		text = reply(req)
+	} else {
+		text = "Fake meal suggestion for: " + lastUserMessage(req)
+	}
+
+	// Emit one delta per word so streaming callers see several events
+	words := strings.SplitAfter(text, " ")
+	if fail != nil {
+		words = words[:min(failAfter, len(words))]
+	}
+	if onDelta != nil {
+		for i, w := range words {
+			err := ctx.Err()
+			if err == nil {
+				err = onDelta(w)
+			}
+			if err != nil {
+				return partialResponse(req, words[:i]), err
+			}
+		}
+	}
+	if fail != nil {
+		return partialResponse(req, words), fail
+	}
+
+	return &Response{
+		Text:       text,
+		ToolInput:  toolInput,
+		Model:      "fake-model",
+		StopReason: "end_turn",
+		Usage:      fakeUsage(req, text),
+	}, nil
+}
+
+// partialResponse is what a stream that stopped after words returns
+func partialResponse(req Request, words []string) *Response {
+	text := strings.Join(words, "")
+	return &Response{Text: text, Model: "fake-model", Usage: fakeUsage(req, text)}
+}
+
+// fakeUsage counts one token per word
+func fakeUsage(req Request, text string) Usage {
+	return Usage{
+		InputTokens:  int64(len(strings.Fields(req.System + " " + joinMessages(req.Messages)))),
+		OutputTokens: int64(len(strings.Fields(text))),
+	}
+}
+
+func lastUserMessage(req Request) string {
+	for i := len(req.Messages) - 1; i >= 0; i-- {
+		if req.Messages[i].Role == RoleUser {
+			return req.Messages[i].Content
+		}
+	}
+	return ""
+}
+
+func joinMessages(messages []Message) string {
+	parts := make([]string, 0, len(messages))
+	for _, m := range messages {
+		parts = append(parts, m.Content)
+	}
+	return strings.Join(parts, " ")
+}
+
+// fakeRecipe builds a small valid recipe around the user's request
+func fakeRecipe(request string) json.RawMessage {
+	recipe := Recipe{
+		Title:    "Fake recipe",
+		Servings: 2,
+		Ingredients: []Ingredient{
+			{Name: "rice", Quantity: 200, Unit: "g"},
+			{Name: "onion", Quantity: 1, Unit: ""},
+		},
+		Steps: []string{
+			"Cook the rice.",
+			"Fry the onion and serve with the rice. Request: " + request,
+		},
+		PrepMinutes: 5,
+		CookMinutes: 15,
+		Tags:        []string{"fake"},
+	}
+	data, _ := json.Marshal(recipe)
+	return data
+}
@@ -0,0 +1,39 @@
+package llm
+
+// charsPerToken is a rough average for English text, good enough to keep
+// replayed history under the model's context budget without a tokenizer
+const charsPerToken = 4
+
+// DefaultHistoryTokenBudget bounds the tokens spent replaying prior turns
+const DefaultHistoryTokenBudget = 4000
+
+// EstimateTokens approximates the number of tokens in text
+func EstimateTokens(text string) int {
+	return (len(text) + charsPerToken - 1) / charsPerToken
+}
+
+// TruncateHistory keeps the most recent messages whose estimated size fits
+// within budget tokens. The oldest turns are dropped first, and the result
+// always starts with a user message as the Messages API requires.
+func TruncateHistory(messages []Message, budget int) []Message {
+	if budget <= 0 {
+		budget = DefaultHistoryTokenBudget
+	}
+
+	start := len(messages)
+	used := 0
+	for i := len(messages) - 1; i >= 0; i-- {
+		cost := EstimateTokens(messages[i].Content)
+		if used+cost > budget {
+			break
+		}
+		used += cost
+		start = i
+	}
+
+	for start < len(messages) && messages[start].Role != RoleUser {
+		start++
+	}
+
+	return messages[start:]
+}
@@ -0,0 +1,44 @@
+package llm
+
+import "strings"
+
+// Price is what a model costs in US dollars per million tokens
+type Price struct {
+	InputPerMTok  float64 `json:"input_per_mtok"`
+	OutputPerMTok float64 `json:"output_per_mtok"`
+}
+
+// Prices maps model IDs to their price. A dated model ID such as
+// claude-sonnet-4-5-20250929 uses the longest entry it starts with.
+// Models missing here are recorded at zero cost.
+var Prices = map[string]Price{
+	"claude-opus-4-5":   {InputPerMTok: 5, OutputPerMTok: 25},
+	"claude-opus-4-1":   {InputPerMTok: 15, OutputPerMTok: 75},
+	"claude-opus-4":     {InputPerMTok: 15, OutputPerMTok: 75},
+	"claude-sonnet-4-5": {InputPerMTok: 3, OutputPerMTok: 15},
+	"claude-sonnet-4":   {InputPerMTok: 3, OutputPerMTok: 15},
+	"claude-haiku-4-5":  {InputPerMTok: 1, OutputPerMTok: 5},
+	"claude-3-5-haiku":  {InputPerMTok: 0.8, OutputPerMTok: 4},
+	"fake-model":        {},
+}
+
+// PriceOf looks up the price of model
+func PriceOf(model string) (Price, bool) {
+	best := ""
+	for id := range Prices {
+		if strings.HasPrefix(model, id) && len(id) > len(best) {
+			best = id
+		}
+	}
+	if best == "" {
+		return Price{}, false
+	}
+	return Prices[best], true
+}
+
+// CostMicros is what usage of model costs, in millionths of a US dollar
+func CostMicros(model string, usage Usage) int64 {
+	price, _ := PriceOf(model)
+	// Per million tokens in dollars is the same as per token in micros
+	return int64(float64(usage.InputTokens)*price.InputPerMTok + float64(usage.OutputTokens)*price.OutputPerMTok + 0.5)
+}
@@ -0,0 +1,161 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+)
+
+// Message roles understood by every provider
+const (
+	RoleUser      = "user"
+	RoleAssistant = "assistant"
+)
+
+// Message is a single turn in a conversation sent to a provider
+type Message struct {
+	Role    string `json:"role"`
+	Content string `json:"content"`
+}
+
+// Tool describes a function the model must call with JSON arguments
+// matching the given JSON schema properties
+type Tool struct {
+	Name        string
+	Description string
+	Properties  map[string]any
+	Required    []string
+}
+
+// Request is a provider-agnostic completion request
+type Request struct {
+	System    string
+	Messages  []Message
+	MaxTokens int64
+	// Tool, when set, forces the model to answer by calling this tool
+	Tool *Tool
+}
+
+// Usage reports the tokens consumed by a completion
+type Usage struct {
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+// Response is the result of a completion
+type Response struct {
+	Text       string `json:"text"`
+	Model      string `json:"model"`
+	StopReason string `json:"stop_reason"`
+	Usage      Usage  `json:"usage"`
+	// ToolInput holds the arguments of the forced tool call, if any
+	ToolInput json.RawMessage `json:"tool_input,omitempty"`
+}
+
+// ModelInfo describes the backend and model a provider talks to
+type ModelInfo struct {
+	Provider  string `json:"provider"`
+	Model     string `json:"model"`
+	MaxTokens int64  `json:"max_tokens"`
+}
+
+// DeltaFunc receives each text fragment as it is streamed. Returning an
+// error aborts the stream.
+type DeltaFunc func(text string) error
+
+// Provider is implemented by every LLM backend
+type Provider interface {
+	// Complete sends the request and waits for the whole response
+	Complete(ctx context.Context, req Request) (*Response, error)
+	// Stream sends the request, calls onDelta for every text fragment and
+	// returns the accumulated response once the model has finished. When
+	// the stream fails part way through, the response so far is returned
+	// with the error so its usage can still be recorded.
+	Stream(ctx context.Context, req Request, onDelta DeltaFunc) (*Response, error)
+	// Info reports the provider name, model and default token limit
+	Info() ModelInfo
+}
+
+// Config selects and configures a provider
+type Config struct {
+	Provider  string
+	Model     string
+	MaxTokens int64
+	APIKey    string
+}
+
+const (
+	ProviderAnthropic = "anthropic"
+	ProviderFake      = "fake"
+
+	DefaultMaxTokens int64 = 1024
+)
+
+// ErrNotConfigured is returned when no provider has been initialised
+var ErrNotConfigured = errors.New("llm provider not configured")
+
+// Default is the provider used by the package level helpers
+var Default Provider
+
+// ConfigFromEnv builds a Config from LLM_PROVIDER, LLM_MODEL, LLM_MAX_TOKENS
+// and ANTHROPIC_API_KEY
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Provider:  os.Getenv("LLM_PROVIDER"),
+		Model:     os.Getenv("LLM_MODEL"),
+		MaxTokens: DefaultMaxTokens,
+		APIKey:    os.Getenv("ANTHROPIC_API_KEY"),
+	}
+	if cfg.Provider == "" {
+		cfg.Provider = ProviderAnthropic
+	}
+	if v, err := strconv.ParseInt(os.Getenv("LLM_MAX_TOKENS"), 10, 64); err == nil && v > 0 {
+		cfg.MaxTokens = v
+	}
+	return cfg
+}
+
+// NewProvider creates the provider named in cfg
+func NewProvider(cfg Config) (Provider, error) {
+	if cfg.MaxTokens <= 0 {
+		cfg.MaxTokens = DefaultMaxTokens
+	}
+
+	switch cfg.Provider {
+	case ProviderAnthropic, "":
+		return NewAnthropicProvider(cfg), nil
+	case ProviderFake:
+		return NewFakeProvider(), nil
+	default:
+		return nil, fmt.Errorf("unknown llm provider %q", cfg.Provider)
+	}
+}
+
+// InitProvider creates the provider named in cfg and makes it the default
+func InitProvider(cfg Config) error {
+	p, err := NewProvider(cfg)
+	if err != nil {
+		return err
+	}
+	Default = p
+	return nil
+}
+
+// Complete runs req against the default provider
+func Complete(ctx context.Context, req Request) (*Response, error) {
+	if Default == nil {
+		return nil, ErrNotConfigured
+	}
+	return Default.Complete(ctx, req)
+}
+
+// Stream runs req against the default provider, relaying deltas to onDelta
+func Stream(ctx context.Context, req Request, onDelta DeltaFunc) (*Response, error) {
+	if Default == nil {
+		return nil, ErrNotConfigured
+	}
+	return Default.Stream(ctx, req, onDelta)
+}
@@ -0,0 +1,229 @@
+package llm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Ingredient is a single line of a recipe's ingredient list
+type Ingredient struct {
+	Name     string  `json:"name"`
+	Quantity float64 `json:"quantity"`
+	Unit     string  `json:"unit"`
+}
+
+// Recipe is the structured meal the model is asked to produce
+type Recipe struct {
+	Title       string       `json:"title"`
+	Servings    int          `json:"servings"`
+	Ingredients []Ingredient `json:"ingredients"`
+	Steps       []string     `json:"steps"`
+	PrepMinutes int          `json:"prep_minutes"`
+	CookMinutes int          `json:"cook_minutes"`
+	Tags        []string     `json:"tags"`
+}
+
+// RecipeToolName is the tool the model must call to return a recipe
+const RecipeToolName = "submit_recipe"
+
+// DefaultRecipeAttempts is how many times a schema violation is retried
+const DefaultRecipeAttempts = 3
+
+// ErrInvalidRecipe wraps every schema violation returned by ValidateRecipe
+var ErrInvalidRecipe = errors.New("invalid recipe")
+
+// RecipeTool is the JSON schema sent to the model for structured recipes
+var RecipeTool = &Tool{
+	Name:        RecipeToolName,
+	Description: "Submit a single recipe that answers the user's request.",
+	Properties: map[string]any{
+		"title": map[string]any{
+			"type":        "string",
+			"description": "Short name of the dish",
+		},
+		"servings": map[string]any{
+			"type":    "integer",
+			"minimum": 1,
+		},
+		"ingredients": map[string]any{
+			"type":     "array",
+			"minItems": 1,
+			"items": map[string]any{
+				"type": "object",
+				"properties": map[string]any{
+					"name":     map[string]any{"type": "string"},
+					"quantity": map[string]any{"type": "number", "minimum": 0},
+					"unit": map[string]any{
+						"type":        "string",
+						"description": "Unit of measure, e.g. g, ml, tbsp, or an empty string for countable items",
+					},
+				},
+				"required": []string{"name", "quantity", "unit"},
+			},
+		},
+		"steps": map[string]any{
+			"type":     "array",
+			"minItems": 1,
+			"items":    map[string]any{"type": "string"},
+		},
+		"prep_minutes": map[string]any{"type": "integer", "minimum": 0},
+		"cook_minutes": map[string]any{"type": "integer", "minimum": 0},
+		"tags": map[string]any{
+			"type":  "array",
+			"items": map[string]any{"type": "string"},
+		},
+	},
+	Required: []string{"title", "servings", "ingredients", "steps", "prep_minutes", "cook_minutes", "tags"},
+}
+
+// ParseRecipe decodes and validates the arguments of a recipe tool call
+func ParseRecipe(input json.RawMessage) (*Recipe, error) {
+	if len(input) == 0 {
+		return nil, fmt.Errorf("%w: model did not call %s", ErrInvalidRecipe, RecipeToolName)
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(input))
+	dec.DisallowUnknownFields()
+
+	var recipe Recipe
+	if err := dec.Decode(&recipe); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidRecipe, err)
+	}
+	if err := ValidateRecipe(&recipe); err != nil {
+		return nil, err
+	}
+	return &recipe, nil
+}
+
+// ValidateRecipe checks the constraints the JSON schema cannot enforce on
+// its own once decoded into Go types
+func ValidateRecipe(r *Recipe) error {
+	var problems []string
+
+	if strings.TrimSpace(r.Title) == "" {
+		problems = append(problems, "title is required")
+	}
+	if r.Servings < 1 {
+		problems = append(problems, "servings must be at least 1")
+	}
+	if len(r.Ingredients) == 0 {
+		problems = append(problems, "at least one ingredient is required")
+	}
+	for i, ing := range r.Ingredients {
+		if strings.TrimSpace(ing.Name) == "" {
+			problems = append(problems, fmt.Sprintf("ingredients[%d].name is required", i))
+		}
+		if ing.Quantity < 0 {
+			problems = append(problems, fmt.Sprintf("ingredients[%d].quantity must not be negative", i))
+		}
+	}
+	if len(r.Steps) == 0 {
+		problems = append(problems, "at least one step is required")
+	}
+	for i, step := range r.Steps {
+		if strings.TrimSpace(step) == "" {
+			problems = append(problems, fmt.Sprintf("steps[%d] is empty", i))
+		}
+	}
+	if r.PrepMinutes < 0 || r.CookMinutes < 0 {
+		problems = append(problems, "prep_minutes and cook_minutes must not be negative")
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalidRecipe, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Render formats the recipe as plain text for clients that do not use the
+// structured form
+func (r *Recipe) Render() string {
+	var b strings.Builder
+
+	fmt.Fprintf(&b, "%s\n", r.Title)
+	fmt.Fprintf(&b, "Serves %d | Prep %d min | Cook %d min\n", r.Servings, r.PrepMinutes, r.CookMinutes)
+	if len(r.Tags) > 0 {
+		fmt.Fprintf(&b, "Tags: %s\n", strings.Join(r.Tags, ", "))
+	}
+
+	b.WriteString("\nIngredients:\n")
+	for _, ing := range r.Ingredients {
+		b.WriteString("- ")
+		if ing.Quantity > 0 {
+			fmt.Fprintf(&b, "%g ", ing.Quantity)
+			if ing.Unit != "" {
+				fmt.Fprintf(&b, "%s ", ing.Unit)
+			}
+		}
+		b.WriteString(ing.Name)
+		b.WriteString("\n")
+	}
+
+	b.WriteString("\nMethod:\n")
+	for i, step := range r.Steps {
+		fmt.Fprintf(&b, "%d. %s\n", i+1, step)
+	}
+
+	return strings.TrimRight(b.String(), "\n")
+}
+
+// GenerateRecipe asks the default provider for a structured recipe, retrying
+// up to attempts times when the model's answer violates the schema. The
+// returned response carries the token usage summed over every attempt. It
+// is also returned with ErrInvalidRecipe, and with the error of a retry
+// that failed, so rejected attempts can be accounted for.
+func GenerateRecipe(ctx context.Context, req Request, attempts int) (*Recipe, *Response, error) {
+	if Default == nil {
+		return nil, nil, ErrNotConfigured
+	}
+	if attempts <= 0 {
+		attempts = DefaultRecipeAttempts
+	}
+
+	req.Tool = RecipeTool
+	original := req.Messages
+
+	var total Usage
+	var last *Response
+	var lastErr error
+	for i := 0; i < attempts; i++ {
+		resp, err := Default.Complete(ctx, req)
+		if err != nil {
+			if last != nil {
+				last.Usage = total
+			}
+			return nil, last, err
+		}
+		total.InputTokens += resp.Usage.InputTokens
+		total.OutputTokens += resp.Usage.OutputTokens
+
+		recipe, err := ParseRecipe(resp.ToolInput)
+		if err == nil {
+			resp.Usage = total
+			resp.Text = recipe.Render()
+			return recipe, resp, nil
+		}
+		last, lastErr = resp, err
+
+		// Tell the model what was wrong and ask again, appending to the last
+		// user turn so roles keep alternating
+		feedback := fmt.Sprintf(
+			"Your previous answer was rejected (%v). Call %s again with arguments that match its schema exactly.",
+			err, RecipeToolName,
+		)
+		retry := append([]Message{}, original...)
+		if n := len(retry); n > 0 && retry[n-1].Role == RoleUser {
+			retry[n-1].Content += "\n\n" + feedback
+		} else {
+			retry = append(retry, Message{Role: RoleUser, Content: feedback})
+		}
+		req.Messages = retry
+	}
+
+	last.Usage = total
+	return nil, last, lastErr
+}
@@ -0,0 +1,90 @@
+package mail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Sender delivers email. Implementations must be safe for concurrent use.
+type Sender interface {
+	Send(ctx context.Context, msg Message) error
+}
+
+// Config selects and configures a sender
+type Config struct {
+	Sender string
+	From   string
+	Dir    string
+}
+
+const (
+	SenderFile = "file"
+	SenderLog  = "log"
+
+	DefaultFrom = "no-reply@localhost"
+	DefaultDir  = "tmp/mail"
+)
+
+// ErrNotConfigured is returned when no sender has been initialised
+var ErrNotConfigured = errors.New("mail sender not configured")
+
+// Default is the sender used by the package level Send
+var Default Sender
+
+// ConfigFromEnv builds a Config from MAIL_SENDER, MAIL_FROM and MAIL_DIR
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Sender: os.Getenv("MAIL_SENDER"),
+		From:   os.Getenv("MAIL_FROM"),
+		Dir:    os.Getenv("MAIL_DIR"),
+	}
+	if cfg.Sender == "" {
+		cfg.Sender = SenderFile
+	}
+	if cfg.From == "" {
+		cfg.From = DefaultFrom
+	}
+	if cfg.Dir == "" {
+		cfg.Dir = DefaultDir
+	}
+	return cfg
+}
+
+// NewSender creates the sender named in cfg
+func NewSender(cfg Config) (Sender, error) {
+	switch cfg.Sender {
+	case SenderFile, "":
+		return NewFileSender(cfg.Dir, cfg.From)
+	case SenderLog:
+		return NewLogSender(cfg.From), nil
+	default:
+		return nil, fmt.Errorf("unknown mail sender %q", cfg.Sender)
+	}
+}
+
+// InitSender creates the sender named in cfg and makes it the default
+func InitSender(cfg Config) error {
+	s, err := NewSender(cfg)
+	if err != nil {
+		return err
+	}
+	Default = s
+	return nil
+}
+
+// Send delivers msg through the default sender
+func Send(ctx context.Context, msg Message) error {
+	if Default == nil {
+		return ErrNotConfigured
+	}
+	return Default.Send(ctx, msg)
+}
@@ -2,10 +2,12 @@ package main
 
 import (
 	"context"
+	"fmt"
 	"log"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"strings"
 	"syscall"
 	"time"
@@ -16,14 +18,26 @@ import (
 
 	"backend/handlers"
 	db "backend/database"
+	"backend/admin"
 	"backend/auth"
+	"backend/billing"
+	"backend/export"
 	"backend/middleware"
+	"backend/llm"
+	"backend/mail"
+	"backend/oidc"
+	"backend/preferences"
 )
 
 type Config struct {
 	Port            string
 	DatabaseURL     string
 	AnthropicAPIKey string
+	LLM             llm.Config
+	Mail            mail.Config
+	JWT             auth.KeyringConfig
+	OIDC            []oidc.Config
+	Billing         billing.Config
 }
 
 func loadConfig() *Config {
@@ -31,6 +45,11 @@ func loadConfig() *Config {
 		Port:            getEnvOrDefault("PORT", "8080"),
 		DatabaseURL:     os.Getenv("TURSO_DATABASE_URL"),
 		AnthropicAPIKey: os.Getenv("ANTHROPIC_API_KEY"),
+		LLM:             llm.ConfigFromEnv(),
+		Mail:            mail.ConfigFromEnv(),
+		JWT:             auth.KeyringConfigFromEnv(),
+		OIDC:            oidc.ConfigsFromEnv(),
+		Billing:         billing.ConfigFromEnv(),
 	}
 }
 
@@ -41,6 +60,78 @@ func getEnvOrDefault(key, defaultValue string) string {
 	return defaultValue
 }
 
+// runMigrateCommand implements the `migrate` subcommand
+func runMigrateCommand(args []string) error {
+	ctx := context.Background()
+
+	command := "status"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "status":
+		states, err := db.MigrationStatus(ctx)
+		if err != nil {
+			return err
+		}
+		for _, s := range states {
+			status := "pending"
+			if s.Applied() {
+				status = "applied " + s.AppliedAt
+			}
+			fmt.Printf("%04d_%-30s %s\n", s.Version, s.Name, status)
+		}
+		return nil
+
+	case "up":
+		applied, err := db.MigrateUp(ctx)
+		for _, m := range applied {
+			fmt.Printf("Applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("Database is up to date")
+		}
+		return err
+
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			n, err := strconv.Atoi(args[1])
+			if err != nil || n < 1 {
+				return fmt.Errorf("invalid step count %q", args[1])
+			}
+			steps = n
+		}
+		rolledBack, err := db.MigrateDown(ctx, steps)
+		for _, m := range rolledBack {
+			fmt.Printf("Rolled back %04d_%s\n", m.Version, m.Name)
+		}
+		return err
+
+	default:
+		return fmt.Errorf("unknown command %q (expected status, up or down)", command)
+	}
+}
+
+// runRoleCommand implements the `role <email> <role>` subcommand, used to
+// promote the first admin
+func runRoleCommand(args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("usage: role <email> <%s>", strings.Join(auth.Roles, "|"))
+	}
+	email, role := args[0], args[1]
+	if !auth.ValidRole(role) {
+		return fmt.Errorf("unknown role %q (expected %s)", role, strings.Join(auth.Roles, ", "))
+	}
+
+	if err := auth.SetRoleByEmail(context.Background(), email, role); err != nil {
+		return err
+	}
+	fmt.Printf("%s is now %s\n", email, role)
+	return nil
+}
+
 func main() {
 	// Load .env file
 	if err := godotenv.Load(); err != nil {
@@ -55,19 +146,71 @@ func main() {
 	}
 	defer db.DB.Close()
 
-	// Create users table with context
-	if err := db.CreateUsersTable(context.Background()); err != nil {
-		log.Fatalf("Failed to create users table: %v", err)
+	// `migrate status|up|down [n]` manages the schema without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrateCommand(os.Args[2:]); err != nil {
+			log.Fatalf("migrate: %v", err)
+		}
+		return
+	}
+
+	// `role <email> <role>` changes a user's role without starting the server
+	if len(os.Args) > 1 && os.Args[1] == "role" {
+		if _, err := db.MigrateUp(context.Background()); err != nil {
+			log.Fatalf("Failed to migrate database: %v", err)
+		}
+		if err := runRoleCommand(os.Args[2:]); err != nil {
+			log.Fatalf("role: %v", err)
+		}
+		return
+	}
+
+	if err := llm.InitProvider(cfg.LLM); err != nil {
+		log.Fatalf("Failed to initialise llm provider: %v", err)
+	}
+
+	if err := mail.InitSender(cfg.Mail); err != nil {
+		log.Fatalf("Failed to initialise mail sender: %v", err)
+	}
+
+	// Refuses to start in production without real signing keys
+	if err := auth.InitKeyring(cfg.JWT); err != nil {
+		log.Fatalf("Failed to load JWT signing keys: %v", err)
+	}
+
+	if err := oidc.InitProviders(cfg.OIDC); err != nil {
+		log.Fatalf("Failed to configure identity providers: %v", err)
+	}
+
+	// The fake provider hands out plans for free
+	if cfg.Billing.Provider == billing.ProviderFake && auth.Production() {
+		log.Fatalf("The fake billing provider cannot be used in production")
+	}
+	if err := billing.InitProvider(cfg.Billing); err != nil {
+		log.Fatalf("Failed to initialise billing provider: %v", err)
+	}
+
+	// Apply pending schema migrations
+	applied, err := db.MigrateUp(context.Background())
+	if err != nil {
+		log.Fatalf("Failed to migrate database: %v", err)
+	}
+	for _, m := range applied {
+		log.Printf("Applied migration %04d_%s", m.Version, m.Name)
 	}
 
-	if err := db.CreateUserPreferenceTable(context.Background()); err != nil {
-		log.Fatalf("Failed to create user_preference table: %v", err)
+	// Rewrite preferences saved by older versions in the current format
+	if n, err := preferences.MigrateStored(context.Background()); err != nil {
+		log.Printf("Warning: failed to migrate stored preferences: %v", err)
+	} else if n > 0 {
+		log.Printf("Migrated %d stored preferences to version %d", n, preferences.CurrentVersion)
 	}
 
-	if err := db.CreateUsersTrackingTable(context.Background()); err != nil {
-		log.Fatalf("Failed to create user_tracking table: %v", err)
+	// Pick up data exports interrupted by a restart
+	if err := export.ResumePending(context.Background()); err != nil {
+		log.Printf("Warning: failed to resume data exports: %v", err)
 	}
-  	
+
 	r := gin.Default()
 
 	// CORS configuration
@@ -79,8 +222,9 @@ func main() {
 
 	r.Use(cors.New(cors.Config{
 		AllowOrigins:     allowedOrigins,
-		AllowMethods:     []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Cookie"},
+		AllowMethods:     []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"},
+		AllowHeaders:     []string{"Origin", "Content-Type", "Authorization", "Cookie", "If-Match", "If-None-Match"},
+		ExposeHeaders:    []string{"ETag"},
 		AllowCredentials: true,
 		MaxAge:           12 * time.Hour,
 	}))
@@ -93,17 +237,100 @@ func main() {
 	// Echo endpoint
 	r.POST("/echo", handlers.Echo)
 
+	// Public keys for verifying our tokens
+	r.GET("/.well-known/jwks.json", auth.JWKS)
+
 	// Auth routes
 	r.POST("/auth/register", auth.Register)
 	r.POST("/auth/login", auth.Login)
+	r.POST("/auth/login/2fa", auth.LoginTwoFactor)
 	r.POST("/auth/logout", auth.Logout)
+	r.POST("/auth/refresh", auth.Refresh)
+	r.POST("/auth/logout-all", middleware.AuthMiddleware(), middleware.RequireSession(), auth.LogoutAll)
+	r.POST("/auth/forgot-password", auth.ForgotPassword)
+	r.POST("/auth/reset-password", auth.ResetPassword)
+	r.GET("/auth/verify", auth.VerifyEmail)
+	r.POST("/auth/resend-verification", middleware.AuthMiddleware(), middleware.RequireSession(), auth.ResendVerification)
+	r.POST("/auth/2fa/setup", middleware.AuthMiddleware(), middleware.RequireSession(), auth.SetupTwoFactor)
+	r.POST("/auth/2fa/verify", middleware.AuthMiddleware(), middleware.RequireSession(), auth.VerifyTwoFactor)
+	r.POST("/auth/2fa/disable", middleware.AuthMiddleware(), middleware.RequireSession(), auth.DisableTwoFactor)
+
+	// Login with an external identity provider (OpenID Connect)
+	r.GET("/auth/oidc/providers", auth.ListOIDCProviders)
+	r.GET("/auth/oidc/:provider/login", auth.OIDCLogin)
+	r.GET("/auth/oidc/:provider/callback", auth.OIDCCallback)
+	r.GET("/auth/oidc/:provider/link", middleware.AuthMiddleware(), middleware.RequireSession(), auth.OIDCLink)
+
+	// Admin routes: support staff can inspect, unlock and impersonate users
+	// and see their generation history, admins can also change quotas,
+	// plans, roles and account status
+	staff := r.Group("/admin", middleware.AuthMiddleware(), middleware.RequireSession(), middleware.RequireRole(auth.RoleSupport, auth.RoleAdmin))
+	staff.GET("/users", admin.ListUsers)
+	staff.GET("/users/:id", admin.GetUser)
+	staff.POST("/users/:id/unlock", admin.UnlockUser)
+	staff.POST("/users/:id/impersonate", admin.Impersonate)
+	staff.GET("/users/:id/usage/history", admin.UsageHistory)
+
+	admins := r.Group("/admin", middleware.AuthMiddleware(), middleware.RequireSession(), middleware.RequireRole(auth.RoleAdmin))
+	admins.PUT("/users/:id/quota", admin.SetQuota)
+	admins.PUT("/users/:id/plan", admin.SetPlan)
+	admins.GET("/usage", admin.UsageReport)
+	admins.PUT("/users/:id/role", admin.SetRole)
+	admins.POST("/users/:id/disable", admin.DisableUser)
+	admins.POST("/users/:id/enable", admin.EnableUser)
 
 	// Protected routes (require authentication)
-	r.POST("/llm", middleware.AuthMiddleware(), handlers.HandleLLMRequest)
-	r.GET("/api/profile", middleware.AuthMiddleware(), handlers.GetProfile)
-	r.GET("/api/preferences", middleware.AuthMiddleware(), handlers.GetPreferences)
-	r.PUT("/api/preferences", middleware.AuthMiddleware(), handlers.UpdatePreferences)
-	r.GET("/api/usage", middleware.AuthMiddleware(), handlers.GetUsage)
+	r.POST("/llm", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeLLM), middleware.RequireVerifiedEmail(), handlers.HandleLLMRequest)
+	r.POST("/llm/stream", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeLLM), middleware.RequireVerifiedEmail(), handlers.HandleLLMStream)
+	r.GET("/api/profile", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeProfileRead), handlers.GetProfile)
+	r.GET("/api/preferences", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopePreferencesRead), handlers.GetPreferences)
+	r.PUT("/api/preferences", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopePreferencesWrite), handlers.UpdatePreferences)
+	r.PATCH("/api/preferences", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopePreferencesWrite), handlers.PatchPreferences)
+	r.GET("/api/preferences/schema", handlers.GetPreferenceSchema)
+	r.GET("/api/usage", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeUsageRead), handlers.GetUsage)
+	r.GET("/api/usage/breakdown", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeUsageRead), handlers.GetUsageBreakdown)
+	r.GET("/api/usage/history", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeUsageRead), handlers.GetUsageHistory)
+	r.GET("/api/plans", handlers.ListPlans)
+
+	// API keys
+	r.POST("/api/keys", middleware.AuthMiddleware(), middleware.RequireSession(), auth.CreateAPIKey)
+	r.GET("/api/keys", middleware.AuthMiddleware(), middleware.RequireSession(), auth.ListAPIKeys)
+	r.DELETE("/api/keys/:id", middleware.AuthMiddleware(), middleware.RequireSession(), auth.RevokeAPIKey)
+
+	// Account management (session only, each change re-verifies the password)
+	r.PUT("/api/account/password", middleware.AuthMiddleware(), middleware.RequireSession(), auth.ChangePassword)
+	r.PUT("/api/account/email", middleware.AuthMiddleware(), middleware.RequireSession(), auth.ChangeEmail)
+	r.DELETE("/api/account", middleware.AuthMiddleware(), middleware.RequireSession(), auth.DeleteAccount)
+	r.GET("/api/account/export", middleware.AuthMiddleware(), middleware.RequireSession(), handlers.ExportAccount)
+	r.GET("/api/account/exports/:id", middleware.AuthMiddleware(), middleware.RequireSession(), handlers.GetExport)
+	r.GET("/api/account/exports/:id/download", middleware.AuthMiddleware(), middleware.RequireSession(), handlers.DownloadExport)
+
+	// Billing: checkouts need a login session; the webhook is authenticated
+	// by its signature
+	r.POST("/api/billing/checkout", middleware.AuthMiddleware(), middleware.RequireSession(), handlers.CreateCheckout)
+	r.GET("/api/billing/subscription", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeUsageRead), handlers.GetSubscription)
+	r.POST("/billing/webhook", handlers.BillingWebhook)
+	if cfg.Billing.Provider == billing.ProviderFake {
+		r.POST("/billing/fake/checkout/:id", handlers.CompleteFakeCheckout)
+	}
+
+	// Linked identity provider accounts
+	r.GET("/api/identities", middleware.AuthMiddleware(), middleware.RequireSession(), auth.ListIdentities)
+	r.DELETE("/api/identities/:id", middleware.AuthMiddleware(), middleware.RequireSession(), auth.UnlinkIdentity)
+
+	// Conversations
+	r.POST("/api/conversations", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeConversationsWrite), handlers.CreateConversation)
+	r.GET("/api/conversations", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeConversationsRead), handlers.ListConversations)
+	r.GET("/api/conversations/:id", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeConversationsRead), handlers.GetConversation)
+	r.DELETE("/api/conversations/:id", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeConversationsWrite), handlers.DeleteConversation)
+
+	// Recipe library
+	r.POST("/api/recipes", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeRecipesWrite), handlers.SaveRecipe)
+	r.GET("/api/recipes", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeRecipesRead), handlers.ListRecipes)
+	r.GET("/api/recipes/:id", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeRecipesRead), handlers.GetRecipe)
+	r.PUT("/api/recipes/:id", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeRecipesWrite), handlers.UpdateRecipe)
+	r.PUT("/api/recipes/:id/favourite", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeRecipesWrite), handlers.SetRecipeFavourite)
+	r.DELETE("/api/recipes/:id", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeRecipesWrite), handlers.DeleteRecipe)
 
 	// Create HTTP server
 	srv := &http.Server{
@@ -1,17 +1,34 @@
 package middleware
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/gin-gonic/gin"
 	"backend/auth"
 	"backend/handlers"
 )
 
-// AuthMiddleware verifies JWT token from httpOnly cookie and adds user info to context
+// Values of the "auth_method" context key
+const (
+	AuthMethodSession = "session"
+	AuthMethodAPIKey  = "api_key"
+)
+
+// AuthMiddleware verifies the JWT from the httpOnly cookie, or an API key
+// sent as "Authorization: Bearer <key>", and adds user info to context
 func AuthMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		// 1. Get token from cookie
+		// 1. Scripts authenticate with an API key instead of a cookie
+		if header := c.GetHeader("Authorization"); header != "" {
+			authenticateAPIKey(c, header)
+			return
+		}
+
+		// 2. Get token from cookie
 		token, err := c.Cookie("token")
 		if err != nil {
 			handlers.ErrorResponse(c, http.StatusUnauthorized, "Authentication required")
@@ -19,7 +36,7 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 2. Verify token
+		// 3. Verify token
 		claims, err := auth.VerifyToken(token)
 		if err != nil {
 			handlers.ErrorResponse(c, http.StatusUnauthorized, "Invalid or expired token")
@@ -27,11 +44,62 @@ func AuthMiddleware() gin.HandlerFunc {
 			return
 		}
 
-		// 3. Add user info to context
+		// 4. Reject tokens whose session has been revoked or has expired
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		active, impersonatorID, err := auth.SessionActive(ctx, claims.SessionID)
+		cancel()
+		if err != nil {
+			handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to verify session")
+			c.Abort()
+			return
+		}
+		if !active {
+			handlers.ErrorResponse(c, http.StatusUnauthorized, "Session has been revoked")
+			c.Abort()
+			return
+		}
+
+		// 5. Add user info to context
 		c.Set("user_id", claims.UserID)
 		c.Set("user_email", claims.Email)
+		c.Set("session_id", claims.SessionID)
+		c.Set("auth_method", AuthMethodSession)
+		if impersonatorID != 0 {
+			c.Set("impersonator_id", impersonatorID)
+		}
 
-		// 4. Continue to next handler
+		// 6. Continue to next handler
 		c.Next()
 	}
 }
+
+// authenticateAPIKey handles requests carrying an Authorization header
+func authenticateAPIKey(c *gin.Context, header string) {
+	key, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok || !auth.IsAPIKey(key) {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "Invalid authorization header")
+		c.Abort()
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+	identity, err := auth.AuthenticateAPIKey(ctx, key)
+	cancel()
+	if errors.Is(err, auth.ErrInvalidAPIKey) {
+		handlers.ErrorResponse(c, http.StatusUnauthorized, "Invalid, expired or revoked API key")
+		c.Abort()
+		return
+	}
+	if err != nil {
+		handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to verify API key")
+		c.Abort()
+		return
+	}
+
+	c.Set("user_id", identity.UserID)
+	c.Set("user_email", identity.Email)
+	c.Set("auth_method", AuthMethodAPIKey)
+	c.Set("api_key", identity)
+
+	c.Next()
+}
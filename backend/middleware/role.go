@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/auth"
+	"backend/handlers"
+)
+
+// RequireRole only lets users holding one of roles through. The role is
+// read from the database on every request so demotions apply at once. Must
+// run after AuthMiddleware and RequireSession.
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		userID, exists := c.Get("user_id")
+		if !exists {
+			handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+			c.Abort()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		role, err := auth.UserRole(ctx, userID.(int64))
+		cancel()
+		if err != nil {
+			handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to check role")
+			c.Abort()
+			return
+		}
+		if !slices.Contains(roles, role) {
+			handlers.ErrorResponse(c, http.StatusForbidden, "Insufficient permissions")
+			c.Abort()
+			return
+		}
+
+		c.Set("user_role", role)
+		c.Next()
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"backend/auth"
+	"backend/handlers"
+)
+
+// RequireScope limits API key requests to keys granted scope. Cookie
+// sessions are not restricted. Must run after AuthMiddleware.
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		value, exists := c.Get("api_key")
+		if !exists {
+			c.Next()
+			return
+		}
+
+		if identity, ok := value.(*auth.APIKeyIdentity); !ok || !identity.HasScope(scope) {
+			handlers.ErrorResponse(c, http.StatusForbidden, "API key is missing the "+scope+" scope")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
+
+// RequireSession rejects API keys and impersonation sessions on endpoints
+// that manage the account itself, such as creating further keys or changing
+// 2FA. Must run after AuthMiddleware.
+func RequireSession() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if method, _ := c.Get("auth_method"); method != AuthMethodSession {
+			handlers.ErrorResponse(c, http.StatusForbidden, "This endpoint requires a browser session")
+			c.Abort()
+			return
+		}
+		if _, impersonating := c.Get("impersonator_id"); impersonating {
+			handlers.ErrorResponse(c, http.StatusForbidden, "Not available while impersonating a user")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"backend/auth"
+	"backend/handlers"
+)
+
+// RequireVerifiedEmail blocks users who have not confirmed their email
+// address, unless verification is disabled by configuration. Must run after
+// AuthMiddleware.
+func RequireVerifiedEmail() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !auth.EmailVerificationRequired() {
+			c.Next()
+			return
+		}
+
+		userID, exists := c.Get("user_id")
+		if !exists {
+			handlers.ErrorResponse(c, http.StatusUnauthorized, "User not authenticated")
+			c.Abort()
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 5*time.Second)
+		verified, err := auth.EmailVerified(ctx, userID.(int64))
+		cancel()
+		if err != nil {
+			handlers.ErrorResponse(c, http.StatusInternalServerError, "Failed to check email verification")
+			c.Abort()
+			return
+		}
+		if !verified {
+			handlers.ErrorResponse(c, http.StatusForbidden, "Please verify your email address to continue")
+			c.Abort()
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,54 @@
+package preferences
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Merge applies a JSON Merge Patch (RFC 7396) to p and returns the result,
+// normalized but not validated. Fields missing from the patch are kept,
+// fields set to null are cleared and lists are replaced as a whole.
+func Merge(p *Preferences, patch []byte) (*Preferences, error) {
+	var changes any
+	if err := json.Unmarshal(patch, &changes); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	if _, ok := changes.(map[string]any); !ok {
+		return nil, fmt.Errorf("%w: merge patch must be a JSON object", ErrInvalid)
+	}
+
+	current, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	var target any
+	if err := json.Unmarshal(current, &target); err != nil {
+		return nil, err
+	}
+
+	merged, err := json.Marshal(mergePatch(target, changes))
+	if err != nil {
+		return nil, err
+	}
+	return Parse(merged)
+}
+
+// mergePatch is the MergePatch function from RFC 7396
+func mergePatch(target, patch any) any {
+	changes, ok := patch.(map[string]any)
+	if !ok {
+		return patch
+	}
+	object, ok := target.(map[string]any)
+	if !ok {
+		object = map[string]any{}
+	}
+	for name, value := range changes {
+		if value == nil {
+			delete(object, name)
+		} else {
+			object[name] = mergePatch(object[name], value)
+		}
+	}
+	return object
+}
@@ -0,0 +1,166 @@
+package preferences
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// upgrades[v-1] turns a version v document into version v+1
+var upgrades = []func(raw []byte) ([]byte, error){
+	upgradeV1,
+}
+
+// Parse decodes a stored or submitted preferences document, upgrading older
+// versions to CurrentVersion and normalizing the result. It does not
+// validate: stored data from an older version is returned as well as it
+// can be rather than rejected.
+//
+// Documents without a version are read as the current version, unless they
+// carry the dietary_restrictions field of the original schema.
+func Parse(raw []byte) (*Preferences, error) {
+	var header map[string]json.RawMessage
+	if err := json.Unmarshal(raw, &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+
+	version := CurrentVersion
+	if v, ok := header["version"]; ok {
+		if err := json.Unmarshal(v, &version); err != nil {
+			return nil, fmt.Errorf("%w: version must be a number", ErrInvalid)
+		}
+	} else if _, ok := header["dietary_restrictions"]; ok {
+		version = 1
+	}
+	if version < 1 || version > CurrentVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalid, version)
+	}
+
+	for v := version; v < CurrentVersion; v++ {
+		var err error
+		if raw, err = upgrades[v-1](raw); err != nil {
+			return nil, fmt.Errorf("%w: upgrading from version %d: %v", ErrInvalid, v, err)
+		}
+	}
+
+	p, err := decodeStrict[Preferences](raw)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	p.Version = CurrentVersion
+	p.Normalize()
+	return p, nil
+}
+
+// decodeStrict decodes raw into a T, rejecting fields T does not have
+func decodeStrict[T any](raw []byte) (*T, error) {
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+
+	var v T
+	if err := dec.Decode(&v); err != nil {
+		return nil, err
+	}
+	return &v, nil
+}
+
+// v1 is the original schema: one free-text field and a time limit
+type v1 struct {
+	Version             int    `json:"version"`
+	DietaryRestrictions string `json:"dietary_restrictions"`
+	MaxCookingTime      int    `json:"max_cooking_time"`
+}
+
+// Terms of the free-text dietary_restrictions field that map onto a diet
+// or an allergy. Anything else is kept in notes.
+var (
+	legacyDiets = map[string]string{
+		"vegetarian": "vegetarian", "veggie": "vegetarian",
+		"vegan":       "vegan",
+		"pescatarian": "pescatarian", "pescetarian": "pescatarian",
+		"keto": "keto", "ketogenic": "keto",
+		"paleo":       "paleo",
+		"low carb":    "low_carb",
+		"low fat":     "low_fat",
+		"gluten free": "gluten_free", "coeliac": "gluten_free", "celiac": "gluten_free",
+		"dairy free": "dairy_free", "lactose free": "dairy_free", "lactose intolerant": "dairy_free",
+		"halal":         "halal",
+		"kosher":        "kosher",
+		"mediterranean": "mediterranean",
+	}
+	legacyAllergies = map[string]string{
+		"celery":    "celery",
+		"shellfish": "crustaceans", "crustaceans": "crustaceans",
+		"egg": "eggs", "eggs": "eggs",
+		"fish":   "fish",
+		"gluten": "gluten",
+		"lupin":  "lupin",
+		"milk":   "milk", "dairy": "milk",
+		"molluscs": "molluscs",
+		"mustard":  "mustard",
+		"peanut":   "peanuts", "peanuts": "peanuts",
+		"sesame": "sesame",
+		"soy":    "soy", "soya": "soy",
+		"sulphites": "sulphites", "sulfites": "sulphites",
+		"nut": "tree_nuts", "nuts": "tree_nuts", "tree nut": "tree_nuts", "tree nuts": "tree_nuts",
+	}
+)
+
+// upgradeV1 splits the free-text dietary_restrictions into diets and
+// allergies where it can, keeping whatever it does not recognise as notes
+func upgradeV1(raw []byte) ([]byte, error) {
+	old, err := decodeStrict[v1](raw)
+	if err != nil {
+		return nil, err
+	}
+
+	p := Preferences{Version: 2, MaxCookingTime: old.MaxCookingTime}
+	if p.MaxCookingTime < 0 || p.MaxCookingTime > MaxCookingTimeMinutes {
+		p.MaxCookingTime = 0
+	}
+
+	var notes []string
+	for _, term := range strings.FieldsFunc(old.DietaryRestrictions, func(r rune) bool {
+		return r == ',' || r == ';' || r == '/' || r == '\n'
+	}) {
+		for _, part := range strings.Split(term, " and ") {
+			if diet, allergy, ok := legacyTerm(part); ok {
+				if diet != "" {
+					p.Diets = append(p.Diets, diet)
+				}
+				if allergy != "" {
+					p.Allergies = append(p.Allergies, allergy)
+				}
+			} else if part = strings.TrimSpace(part); part != "" {
+				notes = append(notes, part)
+			}
+		}
+	}
+	p.Notes = strings.Join(notes, ", ")
+	if len(p.Notes) > MaxNotesLength {
+		p.Notes = strings.ToValidUTF8(p.Notes[:MaxNotesLength], "")
+	}
+
+	return json.Marshal(p)
+}
+
+// legacyTerm recognises phrases such as "Vegan", "gluten-free",
+// "no nuts" or "peanut allergy"
+func legacyTerm(s string) (diet, allergy string, ok bool) {
+	s = strings.Join(strings.Fields(strings.ReplaceAll(strings.ToLower(s), "-", " ")), " ")
+	if diet, ok := legacyDiets[s]; ok {
+		return diet, "", true
+	}
+
+	for _, prefix := range []string{"no ", "allergic to ", "allergy to "} {
+		s = strings.TrimPrefix(s, prefix)
+	}
+	for _, suffix := range []string{" allergy", " allergies", " free"} {
+		s = strings.TrimSuffix(s, suffix)
+	}
+	if allergy, ok := legacyAllergies[s]; ok {
+		return "", allergy, true
+	}
+	return "", "", false
+}
@@ -0,0 +1,194 @@
+package preferences
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// CurrentVersion is the schema version written by this server. Bump it and
+// add an upgrade to upgrades whenever a field changes meaning or shape.
+const CurrentVersion = 2
+
+// ErrInvalid wraps every violation returned by Validate
+var ErrInvalid = errors.New("invalid preferences")
+
+// Preferences is the meal planning profile stored in
+// user_preference.user_preference. Zero values mean "no preference".
+type Preferences struct {
+	Version             int      `json:"version"`
+	Allergies           []string `json:"allergies"`
+	Diets               []string `json:"diets"`
+	DislikedIngredients []string `json:"disliked_ingredients"`
+	Cuisines            []string `json:"cuisines"`
+	HouseholdSize       int      `json:"household_size"`
+	SkillLevel          string   `json:"skill_level"`
+	Equipment           []string `json:"equipment"`
+	Budget              string   `json:"budget"`
+	// CalorieTarget is the aim per serving, in kcal
+	CalorieTarget  int    `json:"calorie_target"`
+	MaxCookingTime int    `json:"max_cooking_time"`
+	Notes          string `json:"notes"`
+}
+
+// Allowed values of the enumerated fields
+var (
+	Allergies = []string{
+		"celery", "crustaceans", "eggs", "fish", "gluten", "lupin", "milk",
+		"molluscs", "mustard", "peanuts", "sesame", "soy", "sulphites", "tree_nuts",
+	}
+	Diets = []string{
+		"vegetarian", "vegan", "pescatarian", "keto", "paleo", "low_carb",
+		"low_fat", "gluten_free", "dairy_free", "halal", "kosher", "mediterranean",
+	}
+	Cuisines = []string{
+		"african", "american", "british", "caribbean", "chinese", "french",
+		"greek", "indian", "italian", "japanese", "korean", "mediterranean",
+		"mexican", "middle_eastern", "spanish", "thai", "vietnamese",
+	}
+	SkillLevels = []string{"beginner", "intermediate", "advanced"}
+	Equipment   = []string{
+		"oven", "stovetop", "microwave", "air_fryer", "slow_cooker", "pressure_cooker",
+		"grill", "blender", "food_processor", "stand_mixer", "rice_cooker",
+	}
+	Budgets = []string{"low", "medium", "high"}
+)
+
+// Limits on the free-form and numeric fields
+const (
+	MaxDislikedIngredients = 50
+	MaxIngredientLength    = 50
+	MaxNotesLength         = 500
+	MaxHouseholdSize       = 20
+	MinCalorieTarget       = 100
+	MaxCalorieTarget       = 3000
+	MaxCookingTimeMinutes  = 24 * 60
+)
+
+// Default returns empty preferences at the current version
+func Default() *Preferences {
+	p := &Preferences{Version: CurrentVersion}
+	p.Normalize()
+	return p
+}
+
+// Normalize puts the preferences in canonical form: enumerated values in
+// lower snake case, ingredients in lower case, no duplicates and empty
+// lists instead of null
+func (p *Preferences) Normalize() {
+	p.Allergies = normalizeList(p.Allergies, enumValue)
+	p.Diets = normalizeList(p.Diets, enumValue)
+	p.DislikedIngredients = normalizeList(p.DislikedIngredients, ingredient)
+	p.Cuisines = normalizeList(p.Cuisines, enumValue)
+	p.Equipment = normalizeList(p.Equipment, enumValue)
+	p.SkillLevel = enumValue(p.SkillLevel)
+	p.Budget = enumValue(p.Budget)
+	p.Notes = strings.TrimSpace(p.Notes)
+}
+
+// Validate checks the preferences against the schema. Call Normalize first.
+func (p *Preferences) Validate() error {
+	var problems []string
+
+	if p.Version != CurrentVersion {
+		problems = append(problems, fmt.Sprintf("version must be %d", CurrentVersion))
+	}
+	problems = append(problems, checkEnum("allergies", p.Allergies, Allergies)...)
+	problems = append(problems, checkEnum("diets", p.Diets, Diets)...)
+	problems = append(problems, checkEnum("cuisines", p.Cuisines, Cuisines)...)
+	problems = append(problems, checkEnum("equipment", p.Equipment, Equipment)...)
+	if p.SkillLevel != "" {
+		problems = append(problems, checkEnum("skill_level", []string{p.SkillLevel}, SkillLevels)...)
+	}
+	if p.Budget != "" {
+		problems = append(problems, checkEnum("budget", []string{p.Budget}, Budgets)...)
+	}
+
+	if len(p.DislikedIngredients) > MaxDislikedIngredients {
+		problems = append(problems, fmt.Sprintf("disliked_ingredients allows at most %d entries", MaxDislikedIngredients))
+	}
+	for i, ing := range p.DislikedIngredients {
+		if len(ing) > MaxIngredientLength {
+			problems = append(problems, fmt.Sprintf("disliked_ingredients[%d] is longer than %d characters", i, MaxIngredientLength))
+		}
+	}
+	if len(p.Notes) > MaxNotesLength {
+		problems = append(problems, fmt.Sprintf("notes is longer than %d characters", MaxNotesLength))
+	}
+
+	if p.HouseholdSize < 0 || p.HouseholdSize > MaxHouseholdSize {
+		problems = append(problems, fmt.Sprintf("household_size must be between 1 and %d, or 0 for no preference", MaxHouseholdSize))
+	}
+	if p.CalorieTarget != 0 && (p.CalorieTarget < MinCalorieTarget || p.CalorieTarget > MaxCalorieTarget) {
+		problems = append(problems, fmt.Sprintf("calorie_target must be between %d and %d, or 0 for no preference", MinCalorieTarget, MaxCalorieTarget))
+	}
+	if p.MaxCookingTime < 0 || p.MaxCookingTime > MaxCookingTimeMinutes {
+		problems = append(problems, fmt.Sprintf("max_cooking_time must be between 0 and %d minutes", MaxCookingTimeMinutes))
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%w: %s", ErrInvalid, strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// Schema describes the current version and the allowed values, so clients
+// can build their forms without hard-coding them
+func Schema() map[string]any {
+	return map[string]any{
+		"version":      CurrentVersion,
+		"allergies":    Allergies,
+		"diets":        Diets,
+		"cuisines":     Cuisines,
+		"skill_levels": SkillLevels,
+		"equipment":    Equipment,
+		"budgets":      Budgets,
+		"limits": map[string]any{
+			"disliked_ingredients": MaxDislikedIngredients,
+			"ingredient_length":    MaxIngredientLength,
+			"notes_length":         MaxNotesLength,
+			"household_size":       MaxHouseholdSize,
+			"calorie_target_min":   MinCalorieTarget,
+			"calorie_target_max":   MaxCalorieTarget,
+			"max_cooking_time":     MaxCookingTimeMinutes,
+		},
+	}
+}
+
+func checkEnum(field string, values, allowed []string) []string {
+	var problems []string
+	for _, v := range values {
+		if !slices.Contains(allowed, v) {
+			problems = append(problems, fmt.Sprintf("%s: unknown value %q", field, v))
+		}
+	}
+	return problems
+}
+
+// enumValue turns "Tree-Nuts" or "tree nuts" into "tree_nuts"
+func enumValue(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.Join(strings.FieldsFunc(s, func(r rune) bool {
+		return r == ' ' || r == '-' || r == '_'
+	}), "_")
+	return s
+}
+
+// ingredient lower-cases an ingredient and collapses its whitespace
+func ingredient(s string) string {
+	return strings.Join(strings.Fields(strings.ToLower(s)), " ")
+}
+
+// normalizeList applies norm to every entry, dropping empty ones and
+// duplicates while keeping the original order
+func normalizeList(values []string, norm func(string) string) []string {
+	out := []string{}
+	for _, v := range values {
+		v = norm(v)
+		if v != "" && !slices.Contains(out, v) {
+			out = append(out, v)
+		}
+	}
+	return out
+}
@@ -0,0 +1,201 @@
+package preferences
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"log"
+
+	db "backend/database"
+)
+
+// ErrConflict is returned by SaveIf when the stored preferences changed
+// after they were read
+var ErrConflict = errors.New("preferences changed concurrently")
+
+// Document is the stored row behind a user's preferences
+type Document struct {
+	Preferences *Preferences
+	// ETag identifies this revision. It is derived from updated_at and the
+	// stored document, and is the same for every user without preferences.
+	ETag      string
+	UpdatedAt *string
+	// Exists is false when the user never saved preferences
+	Exists bool
+
+	raw string
+}
+
+// etag quotes a short digest of the revision for use as an HTTP ETag
+func etag(updatedAt, raw string) string {
+	sum := sha256.Sum256([]byte(updatedAt + "\n" + raw))
+	return `"` + hex.EncodeToString(sum[:12]) + `"`
+}
+
+// Get returns the user's preferences, upgraded to the current version,
+// along with the revision they were read at
+func Get(ctx context.Context, userID int64) (*Document, error) {
+	var raw, updatedAt string
+	err := db.DB.QueryRowContext(ctx,
+		"SELECT user_preference, updated_at FROM user_preference WHERE user_id = ?",
+		userID,
+	).Scan(&raw, &updatedAt)
+	if err == sql.ErrNoRows {
+		return &Document{Preferences: Default(), ETag: etag("", "")}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p, err := Parse([]byte(raw))
+	if err != nil {
+		return nil, err
+	}
+	return &Document{
+		Preferences: p,
+		ETag:        etag(updatedAt, raw),
+		UpdatedAt:   &updatedAt,
+		Exists:      true,
+		raw:         raw,
+	}, nil
+}
+
+// Load returns the user's preferences, upgraded to the current version.
+// Users who never set any get Default.
+func Load(ctx context.Context, userID int64) (*Preferences, error) {
+	doc, err := Get(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	return doc.Preferences, nil
+}
+
+// Save stores the user's preferences, replacing any previous ones
+func Save(ctx context.Context, userID int64, p *Preferences) (*Document, error) {
+	raw, err := encode(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var updatedAt string
+	err = db.DB.QueryRowContext(ctx,
+		`INSERT INTO user_preference (user_id, user_preference, updated_at)
+		 VALUES (?, ?, datetime('now'))
+		 ON CONFLICT(user_id) DO UPDATE SET
+		 user_preference = excluded.user_preference,
+		 updated_at = excluded.updated_at
+		 RETURNING updated_at`,
+		userID, raw,
+	).Scan(&updatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return saved(p, raw, updatedAt), nil
+}
+
+// SaveIf stores the user's preferences only if they are still at the
+// revision prev was read at, and returns ErrConflict otherwise
+func SaveIf(ctx context.Context, userID int64, p *Preferences, prev *Document) (*Document, error) {
+	raw, err := encode(p)
+	if err != nil {
+		return nil, err
+	}
+
+	var updatedAt string
+	if prev.Exists {
+		err = db.DB.QueryRowContext(ctx,
+			`UPDATE user_preference SET user_preference = ?, updated_at = datetime('now')
+			 WHERE user_id = ? AND user_preference = ? AND updated_at = ?
+			 RETURNING updated_at`,
+			raw, userID, prev.raw, *prev.UpdatedAt,
+		).Scan(&updatedAt)
+	} else {
+		err = db.DB.QueryRowContext(ctx,
+			`INSERT INTO user_preference (user_id, user_preference, updated_at)
+			 VALUES (?, ?, datetime('now'))
+			 ON CONFLICT(user_id) DO NOTHING
+			 RETURNING updated_at`,
+			userID, raw,
+		).Scan(&updatedAt)
+	}
+	if err == sql.ErrNoRows {
+		return nil, ErrConflict
+	}
+	if err != nil {
+		return nil, err
+	}
+	return saved(p, raw, updatedAt), nil
+}
+
+func encode(p *Preferences) (string, error) {
+	p.Version = CurrentVersion
+	raw, err := json.Marshal(p)
+	return string(raw), err
+}
+
+func saved(p *Preferences, raw, updatedAt string) *Document {
+	return &Document{
+		Preferences: p,
+		ETag:        etag(updatedAt, raw),
+		UpdatedAt:   &updatedAt,
+		Exists:      true,
+		raw:         raw,
+	}
+}
+
+// MigrateStored rewrites every stored document older than CurrentVersion
+// in the current format and returns how many were upgraded. updated_at is
+// left alone since the user did not change anything. Documents that cannot
+// be parsed are logged and skipped; Load reports them when they are used.
+func MigrateStored(ctx context.Context) (int, error) {
+	rows, err := db.DB.QueryContext(ctx,
+		`SELECT user_id, user_preference FROM user_preference
+		 WHERE COALESCE(json_extract(user_preference, '$.version'), 1) < ?`,
+		CurrentVersion,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	type stored struct {
+		userID int64
+		raw    string
+	}
+	var outdated []stored
+	for rows.Next() {
+		var s stored
+		if err := rows.Scan(&s.userID, &s.raw); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		outdated = append(outdated, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	migrated := 0
+	for _, s := range outdated {
+		p, err := Parse([]byte(s.raw))
+		if err != nil {
+			log.Printf("Skipping preferences of user %d: %v", s.userID, err)
+			continue
+		}
+		raw, err := json.Marshal(p)
+		if err != nil {
+			return migrated, err
+		}
+		if _, err := db.DB.ExecContext(ctx,
+			"UPDATE user_preference SET user_preference = ? WHERE user_id = ? AND user_preference = ?",
+			string(raw), s.userID, s.raw,
+		); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Store keeps timestamped events per key for sliding-window rate limits.
+// The in-process MemoryStore is the default; a shared implementation (e.g.
+// backed by Redis or the database) can be plugged in for multiple instances.
+type Store interface {
+	// Hit records an event for key at now. Events older than window may be
+	// discarded.
+	Hit(ctx context.Context, key string, now time.Time, window time.Duration) error
+	// Recent returns the number of events for key in (now-window, now] and
+	// the time of the latest one
+	Recent(ctx context.Context, key string, now time.Time, window time.Duration) (count int, last time.Time, err error)
+	// Reset forgets every event for key
+	Reset(ctx context.Context, key string) error
+}
+
+// sweepEvery is how often Hit drops keys whose events have all expired,
+// so keys that are never used again do not stay in memory
+const sweepEvery = time.Minute
+
+// MemoryStore is a Store held in process memory
+type MemoryStore struct {
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+// entry holds the events for one key and the longest window they were
+// recorded with
+type entry struct {
+	events []time.Time
+	window time.Duration
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{entries: map[string]*entry{}}
+}
+
+// Len returns the number of keys with events held in memory
+func (s *MemoryStore) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.entries)
+}
+
+// prune drops events at or before cutoff; callers must hold mu
+func (s *MemoryStore) prune(key string, cutoff time.Time) *entry {
+	e := s.entries[key]
+	if e == nil {
+		return nil
+	}
+	i := 0
+	for i < len(e.events) && !e.events[i].After(cutoff) {
+		i++
+	}
+	e.events = e.events[i:]
+	if len(e.events) == 0 {
+		delete(s.entries, key)
+		return nil
+	}
+	return e
+}
+
+// sweep drops every key whose events have all left their window; callers
+// must hold mu
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, e := range s.entries {
+		if !e.events[len(e.events)-1].After(now.Add(-e.window)) {
+			delete(s.entries, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+func (s *MemoryStore) Hit(ctx context.Context, key string, now time.Time, window time.Duration) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if now.Sub(s.lastSweep) >= sweepEvery {
+		s.sweep(now)
+	}
+
+	e := s.prune(key, now.Add(-window))
+	if e == nil {
+		e = &entry{}
+		s.entries[key] = e
+	}
+	e.events = append(e.events, now)
+	e.window = max(e.window, window)
+	return nil
+}
+
+func (s *MemoryStore) Recent(ctx context.Context, key string, now time.Time, window time.Duration) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.prune(key, now.Add(-window))
+	if e == nil {
+		return 0, time.Time{}, nil
+	}
+	return len(e.events), e.events[len(e.events)-1], nil
+}
+
+func (s *MemoryStore) Reset(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.entries, key)
+	return nil
+}
@@ -0,0 +1,193 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	db "backend/database"
+)
+
+// deleteJSON sends a DELETE request with a JSON body
+func deleteJSON(router http.Handler, path string, payload any, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest("DELETE", path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// countRows counts rows in table matching where
+func countRows(t *testing.T, table, where string, args ...any) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var n int
+	if err := db.DB.QueryRowContext(ctx, "SELECT COUNT(*) FROM "+table+" WHERE "+where, args...).Scan(&n); err != nil {
+		t.Fatalf("Failed to count %s: %v", table, err)
+	}
+	return n
+}
+
+// TestE2E_AccountManagement tests changing the password and email and
+// deleting the account
+func TestE2E_AccountManagement(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+	sender := useCaptureSender(t)
+
+	email := "account_test@example.com"
+	newEmail := "account_test_new@example.com"
+	takenEmail := "account_taken@example.com"
+	password := "testpass123"
+	newPassword := "newpass456"
+	defer cleanupTestDB(t, email)
+	defer cleanupTestDB(t, newEmail)
+	defer cleanupTestDB(t, takenEmail)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": password})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Register failed: %d %s", w.Code, w.Body.String())
+	}
+	userID := registeredUserID(t, w)
+	cookie := tokenCookie(w)
+	otherDevice := tokenCookie(postJSON(router, "/auth/login", map[string]string{"email": email, "password": password}))
+	postJSON(router, "/auth/register", map[string]string{"email": takenEmail, "password": password})
+
+	t.Run("1. Changes need the current password", func(t *testing.T) {
+		w := putJSON(router, "/api/account/password", map[string]string{"current_password": "wrong", "new_password": newPassword}, cookie)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401, got %d", w.Code)
+		}
+		w = putJSON(router, "/api/account/email", map[string]string{"current_password": "wrong", "email": newEmail}, cookie)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401, got %d", w.Code)
+		}
+	})
+
+	t.Run("2. Change password", func(t *testing.T) {
+		w := putJSON(router, "/api/account/password", map[string]string{"current_password": password, "new_password": newPassword}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Change password failed: %d %s", w.Code, w.Body.String())
+		}
+
+		if w := getWithCookies(router, "/api/profile", otherDevice); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected other sessions to be revoked, got %d", w.Code)
+		}
+		cookie = tokenCookie(w)
+		if w := getWithCookies(router, "/api/profile", cookie); w.Code != http.StatusOK {
+			t.Errorf("Expected caller to get a new session, got %d", w.Code)
+		}
+
+		if w := postJSON(router, "/auth/login", map[string]string{"email": email, "password": password}); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected old password to fail, got %d", w.Code)
+		}
+		if w := postJSON(router, "/auth/login", map[string]string{"email": email, "password": newPassword}); w.Code != http.StatusOK {
+			t.Errorf("Expected new password to work, got %d", w.Code)
+		}
+		if msg := sender.last(email); msg == nil || msg.Subject != "Your password was changed" {
+			t.Error("Expected a password change notification")
+		}
+	})
+
+	t.Run("3. Change email", func(t *testing.T) {
+		w := putJSON(router, "/api/account/email", map[string]string{"current_password": newPassword, "email": takenEmail}, cookie)
+		if w.Code != http.StatusConflict {
+			t.Errorf("Expected 409 for a taken email, got %d", w.Code)
+		}
+
+		old := cookie
+		w = putJSON(router, "/api/account/email", map[string]string{"current_password": newPassword, "email": newEmail}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Change email failed: %d %s", w.Code, w.Body.String())
+		}
+		cookie = tokenCookie(w)
+
+		if w := getWithCookies(router, "/api/profile", old); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected previous session to be revoked, got %d", w.Code)
+		}
+		w = getWithCookies(router, "/api/profile", cookie)
+		var profile struct {
+			User struct {
+				Email string `json:"email"`
+			} `json:"user"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &profile)
+		if profile.User.Email != newEmail {
+			t.Errorf("Expected profile email %s, got %s", newEmail, profile.User.Email)
+		}
+
+		if msg := sender.last(newEmail); msg == nil || msg.Subject != "Confirm your email address" {
+			t.Error("Expected a verification email to the new address")
+		}
+		if msg := sender.last(email); msg == nil || msg.Subject != "Your email address was changed" {
+			t.Error("Expected the old address to be notified")
+		}
+	})
+
+	t.Run("4. Delete account erases all data", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		// Without foreign keys nothing cascades, so every table must be
+		// cleared explicitly
+		if _, err := db.DB.ExecContext(ctx, "PRAGMA foreign_keys = OFF"); err != nil {
+			t.Fatalf("Failed to disable foreign keys: %v", err)
+		}
+		defer db.DB.ExecContext(context.Background(), "PRAGMA foreign_keys = ON")
+
+		for _, stmt := range []string{
+			"INSERT INTO user_preference (user_id, user_preference) VALUES (?, '{}')",
+			"INSERT INTO users_tracking (user_id, meal_count) VALUES (?, 3)",
+			"INSERT INTO conversations (user_id, title) VALUES (?, 'Dinner')",
+			"INSERT INTO messages (conversation_id, role, content) SELECT id, 'user', 'hi' FROM conversations WHERE user_id = ?",
+			"INSERT INTO recipes (user_id, title, recipe) VALUES (?, 'Soup', '{}')",
+		} {
+			if _, err := db.DB.ExecContext(ctx, stmt, userID); err != nil {
+				t.Fatalf("Failed to seed data: %v", err)
+			}
+		}
+
+		w := deleteJSON(router, "/api/account", map[string]string{"current_password": "wrong"}, cookie)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 with the wrong password, got %d", w.Code)
+		}
+
+		w = deleteJSON(router, "/api/account", map[string]string{"current_password": newPassword}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Delete failed: %d %s", w.Code, w.Body.String())
+		}
+
+		for _, table := range []string{"users_tracking", "user_preference", "conversations", "recipes", "sessions", "email_verifications"} {
+			if n := countRows(t, table, "user_id = ?", userID); n != 0 {
+				t.Errorf("Expected %s to be empty, found %d rows", table, n)
+			}
+		}
+		if n := countRows(t, "messages", "content = 'hi'"); n != 0 {
+			t.Errorf("Expected messages to be deleted, found %d", n)
+		}
+		if n := countRows(t, "users", "id = ?", userID); n != 0 {
+			t.Error("Expected the user to be deleted")
+		}
+		if n := countRows(t, "audit_log", "user_id = ? AND action != 'account_deleted'", userID); n != 0 {
+			t.Errorf("Expected audit entries about the user to be erased, found %d", n)
+		}
+
+		if w := getWithCookies(router, "/api/profile", cookie); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected session to end, got %d", w.Code)
+		}
+		if w := postJSON(router, "/auth/login", map[string]string{"email": newEmail, "password": newPassword}); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected login to fail after deletion, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,228 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/auth"
+	db "backend/database"
+)
+
+// registerStaff registers a user with the given role and returns their
+// session cookie. The user is removed when the test ends.
+func registerStaff(t *testing.T, router *gin.Engine, email, role string) *http.Cookie {
+	t.Cleanup(func() { cleanupTestDB(t, email) })
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": "staffpass123"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Register %s failed: %d %s", email, w.Code, w.Body.String())
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := auth.SetRoleByEmail(ctx, email, role); err != nil {
+		t.Fatalf("Failed to set role: %v", err)
+	}
+	return tokenCookie(w)
+}
+
+// putJSON sends a PUT request with the given cookies
+func putJSON(router http.Handler, path string, payload any, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest("PUT", path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// auditCount counts audit entries for a user and action
+func auditCount(t *testing.T, userID int64, action string) int {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var n int
+	if err := db.DB.QueryRowContext(ctx,
+		"SELECT COUNT(*) FROM audit_log WHERE user_id = ? AND action = ?", userID, action,
+	).Scan(&n); err != nil {
+		t.Fatalf("Failed to count audit entries: %v", err)
+	}
+	return n
+}
+
+// TestE2E_AdminAPI tests role checks and each admin action
+func TestE2E_AdminAPI(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+
+	userEmail := "admin_api_user@example.com"
+	userPassword := "testpass123"
+	defer cleanupTestDB(t, userEmail)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": userEmail, "password": userPassword})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Register failed: %d %s", w.Code, w.Body.String())
+	}
+	userID := registeredUserID(t, w)
+	userCookie := tokenCookie(w)
+
+	adminCookie := registerStaff(t, router, "admin_api_admin@example.com", auth.RoleAdmin)
+	supportCookie := registerStaff(t, router, "admin_api_support@example.com", auth.RoleSupport)
+	userPath := fmt.Sprintf("/admin/users/%d", userID)
+
+	t.Run("1. Regular users are refused", func(t *testing.T) {
+		if w := getWithCookies(router, "/admin/users", userCookie); w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("2. Support can search and view users", func(t *testing.T) {
+		w := getWithCookies(router, "/admin/users?q=admin_api_user", supportCookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("List failed: %d %s", w.Code, w.Body.String())
+		}
+		var list struct {
+			Users []struct {
+				ID    int64  `json:"id"`
+				Email string `json:"email"`
+			} `json:"users"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &list)
+		if len(list.Users) != 1 || list.Users[0].ID != userID {
+			t.Fatalf("Expected only the searched user, got %s", w.Body.String())
+		}
+
+		w = getWithCookies(router, userPath, supportCookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Get failed: %d %s", w.Code, w.Body.String())
+		}
+		if auditCount(t, userID, "user_viewed") != 1 {
+			t.Error("Expected viewing a user to be audited")
+		}
+	})
+
+	t.Run("3. Only admins change quotas", func(t *testing.T) {
+		if w := putJSON(router, userPath+"/quota", map[string]int{"max_meals": 50}, supportCookie); w.Code != http.StatusForbidden {
+			t.Fatalf("Expected 403 for support, got %d", w.Code)
+		}
+
+		w := putJSON(router, userPath+"/quota", map[string]int{"max_meals": 50}, adminCookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Quota change failed: %d %s", w.Code, w.Body.String())
+		}
+
+		w = getWithCookies(router, "/api/usage", userCookie)
+		var usage struct {
+			Limit int `json:"limit"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &usage)
+		if usage.Limit != 50 {
+			t.Errorf("Expected limit 50, got %d", usage.Limit)
+		}
+		if auditCount(t, userID, "quota_changed") != 1 {
+			t.Error("Expected quota change to be audited")
+		}
+	})
+
+	t.Run("4. Impersonation", func(t *testing.T) {
+		w := postJSON(router, userPath+"/impersonate", nil, supportCookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Impersonate failed: %d %s", w.Code, w.Body.String())
+		}
+		impersonation := tokenCookie(w)
+
+		w = getWithCookies(router, "/api/profile", impersonation)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected profile as user, got %d", w.Code)
+		}
+		var profile struct {
+			User struct {
+				Email string `json:"email"`
+			} `json:"user"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &profile)
+		if profile.User.Email != userEmail {
+			t.Errorf("Expected to see %s, got %s", userEmail, profile.User.Email)
+		}
+
+		if w := getWithCookies(router, "/api/keys", impersonation); w.Code != http.StatusForbidden {
+			t.Errorf("Expected account settings to be blocked while impersonating, got %d", w.Code)
+		}
+		if auditCount(t, userID, "impersonation_started") != 1 {
+			t.Error("Expected impersonation to be audited")
+		}
+
+		staffID := registeredUserIDByEmail(t, "admin_api_admin@example.com")
+		if w := postJSON(router, fmt.Sprintf("/admin/users/%d/impersonate", staffID), nil, supportCookie); w.Code != http.StatusForbidden {
+			t.Errorf("Expected staff impersonation to be refused, got %d", w.Code)
+		}
+	})
+
+	t.Run("5. Disable and enable", func(t *testing.T) {
+		w := postJSON(router, userPath+"/disable", map[string]string{"reason": "abuse"}, adminCookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Disable failed: %d %s", w.Code, w.Body.String())
+		}
+
+		if w := getWithCookies(router, "/api/profile", userCookie); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected existing session to be rejected, got %d", w.Code)
+		}
+		login := postJSON(router, "/auth/login", map[string]string{"email": userEmail, "password": userPassword})
+		if login.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 on login while disabled, got %d", login.Code)
+		}
+
+		if w := postJSON(router, userPath+"/enable", nil, adminCookie); w.Code != http.StatusOK {
+			t.Fatalf("Enable failed: %d %s", w.Code, w.Body.String())
+		}
+		login = postJSON(router, "/auth/login", map[string]string{"email": userEmail, "password": userPassword})
+		if login.Code != http.StatusOK {
+			t.Errorf("Expected login after enable, got %d", login.Code)
+		}
+		if auditCount(t, userID, "account_disabled") != 1 || auditCount(t, userID, "account_enabled") != 1 {
+			t.Error("Expected disable and enable to be audited")
+		}
+	})
+
+	t.Run("6. Role changes", func(t *testing.T) {
+		w := putJSON(router, userPath+"/role", map[string]string{"role": "superuser"}, adminCookie)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for unknown role, got %d", w.Code)
+		}
+
+		w = putJSON(router, userPath+"/role", map[string]string{"role": auth.RoleSupport}, adminCookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Role change failed: %d %s", w.Code, w.Body.String())
+		}
+
+		login := postJSON(router, "/auth/login", map[string]string{"email": userEmail, "password": userPassword})
+		if w := getWithCookies(router, "/admin/users", tokenCookie(login)); w.Code != http.StatusOK {
+			t.Errorf("Expected promoted user to reach admin API, got %d", w.Code)
+		}
+	})
+}
+
+// registeredUserIDByEmail looks up a user's ID
+func registeredUserIDByEmail(t *testing.T, email string) int64 {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var id int64
+	if err := db.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE email = ?", email).Scan(&id); err != nil {
+		t.Fatalf("Failed to find user %s: %v", email, err)
+	}
+	return id
+}
@@ -0,0 +1,159 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/auth"
+	db "backend/database"
+)
+
+// requestWithAPIKey sends a request authenticated with an API key
+func requestWithAPIKey(router http.Handler, method, path, key string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	req.Header.Set("Authorization", "Bearer "+key)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestE2E_APIKeys tests creating, using, scoping, expiring and revoking API keys
+func TestE2E_APIKeys(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+
+	testEmail := "api_keys_test@example.com"
+	testPassword := "testpass123"
+	defer cleanupTestDB(t, testEmail)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": testEmail, "password": testPassword})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Register failed: %d %s", w.Code, w.Body.String())
+	}
+	cookie := tokenCookie(w)
+
+	type createdKey struct {
+		Key    string `json:"key"`
+		APIKey struct {
+			ID     int64    `json:"id"`
+			Prefix string   `json:"prefix"`
+			Scopes []string `json:"scopes"`
+		} `json:"api_key"`
+	}
+	create := func(t *testing.T, payload map[string]any) createdKey {
+		w := postJSON(router, "/api/keys", payload, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Create key failed: %d %s", w.Code, w.Body.String())
+		}
+		var resp createdKey
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp
+	}
+
+	var profileKey createdKey
+
+	t.Run("1. Create key", func(t *testing.T) {
+		profileKey = create(t, map[string]any{
+			"name":            "cron",
+			"scopes":          []string{auth.ScopeProfileRead},
+			"expires_in_days": 30,
+		})
+		if !strings.HasPrefix(profileKey.Key, profileKey.APIKey.Prefix+"_") {
+			t.Errorf("Expected key %q to start with prefix %q", profileKey.Key, profileKey.APIKey.Prefix)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		var stored string
+		db.DB.QueryRowContext(ctx, "SELECT key_hash FROM api_keys WHERE id = ?", profileKey.APIKey.ID).Scan(&stored)
+		if stored == "" || stored == profileKey.Key {
+			t.Error("Expected the key to be stored hashed")
+		}
+	})
+
+	t.Run("2. Invalid scopes are rejected", func(t *testing.T) {
+		w := postJSON(router, "/api/keys", map[string]any{"name": "bad", "scopes": []string{"admin"}}, cookie)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("3. Key authenticates within its scopes", func(t *testing.T) {
+		w := requestWithAPIKey(router, "GET", "/api/profile", profileKey.Key)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected profile access, got %d %s", w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), testEmail) {
+			t.Errorf("Expected profile of key owner, got %s", w.Body.String())
+		}
+
+		if w := requestWithAPIKey(router, "GET", "/api/preferences", profileKey.Key); w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403 outside scope, got %d", w.Code)
+		}
+		if w := requestWithAPIKey(router, "GET", "/api/profile", profileKey.Key+"x"); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for wrong key, got %d", w.Code)
+		}
+	})
+
+	t.Run("4. Keys cannot manage keys", func(t *testing.T) {
+		if w := requestWithAPIKey(router, "GET", "/api/keys", profileKey.Key); w.Code != http.StatusForbidden {
+			t.Errorf("Expected 403, got %d", w.Code)
+		}
+	})
+
+	t.Run("5. List shows prefix and last use, not the secret", func(t *testing.T) {
+		w := getWithCookies(router, "/api/keys", cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("List failed: %d %s", w.Code, w.Body.String())
+		}
+		if strings.Contains(w.Body.String(), profileKey.Key) {
+			t.Error("List must not include the full key")
+		}
+
+		var resp struct {
+			APIKeys []struct {
+				Prefix     string  `json:"prefix"`
+				LastUsedAt *string `json:"last_used_at"`
+			} `json:"api_keys"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.APIKeys) != 1 || resp.APIKeys[0].Prefix != profileKey.APIKey.Prefix {
+			t.Fatalf("Unexpected keys: %s", w.Body.String())
+		}
+		if resp.APIKeys[0].LastUsedAt == nil {
+			t.Error("Expected last_used_at to be recorded")
+		}
+	})
+
+	t.Run("6. Expired keys are rejected", func(t *testing.T) {
+		expiring := create(t, map[string]any{"name": "short", "scopes": []string{auth.ScopeProfileRead}, "expires_in_days": 1})
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		db.DB.ExecContext(ctx, "UPDATE api_keys SET expires_at = datetime('now', '-1 minute') WHERE id = ?", expiring.APIKey.ID)
+
+		if w := requestWithAPIKey(router, "GET", "/api/profile", expiring.Key); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for expired key, got %d", w.Code)
+		}
+	})
+
+	t.Run("7. Revoked keys are rejected", func(t *testing.T) {
+		req := httptest.NewRequest("DELETE", fmt.Sprintf("/api/keys/%d", profileKey.APIKey.ID), nil)
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Revoke failed: %d %s", w.Code, w.Body.String())
+		}
+
+		if w := requestWithAPIKey(router, "GET", "/api/profile", profileKey.Key); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 after revoke, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,358 @@
+package test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"backend/audit"
+	"backend/billing"
+	db "backend/database"
+)
+
+const testWebhookSecret = "test-webhook-secret"
+
+// useFakeBilling installs a fake payment provider that delivers its
+// webhook events to router
+func useFakeBilling(t *testing.T, router *gin.Engine) *billing.FakeProvider {
+	fake := billing.NewFakeProvider(billing.Config{
+		WebhookSecret:   testWebhookSecret,
+		CheckoutBaseURL: "http://pay.test/checkout",
+	})
+	fake.Deliver = func(ctx context.Context, header http.Header, body []byte) error {
+		w := postWebhook(router, header, body)
+		if w.Code != http.StatusOK {
+			return fmt.Errorf("webhook returned %d: %s", w.Code, w.Body.String())
+		}
+		return nil
+	}
+
+	previous := billing.Default
+	billing.Default = fake
+	t.Cleanup(func() { billing.Default = previous })
+	return fake
+}
+
+// postWebhook delivers a webhook with the given headers
+func postWebhook(router http.Handler, header http.Header, body []byte) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("POST", "/billing/webhook", bytes.NewReader(body))
+	req.Header = header.Clone()
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestBilling_Signature tests webhook signature verification
+func TestBilling_Signature(t *testing.T) {
+	body := []byte(`{"id":"evt_1"}`)
+	now := time.Now()
+
+	if err := billing.VerifySignature("secret", billing.Sign("secret", body, now), body, now); err != nil {
+		t.Errorf("Expected a valid signature, got %v", err)
+	}
+
+	rotated := billing.Sign("old-secret", body, now) + ",v1=" + strings.Split(billing.Sign("secret", body, now), "v1=")[1]
+	if err := billing.VerifySignature("secret", rotated, body, now); err != nil {
+		t.Errorf("Expected any matching v1 value to be accepted, got %v", err)
+	}
+
+	for name, header := range map[string]string{
+		"wrong secret": billing.Sign("other", body, now),
+		"too old":      billing.Sign("secret", body, now.Add(-time.Hour)),
+		"no signature": fmt.Sprintf("t=%d", now.Unix()),
+		"empty":        "",
+	} {
+		if err := billing.VerifySignature("secret", header, body, now); err != billing.ErrInvalidSignature {
+			t.Errorf("%s: expected ErrInvalidSignature, got %v", name, err)
+		}
+	}
+	if err := billing.VerifySignature("secret", billing.Sign("secret", body, now), []byte(`{"id":"evt_2"}`), now); err == nil {
+		t.Error("Expected a changed body to be rejected")
+	}
+}
+
+// TestE2E_Billing tests checkout, webhook driven plan changes and
+// idempotent event handling
+func TestE2E_Billing(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+
+	email := "billing_user@example.com"
+	defer cleanupTestDB(t, email)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"})
+	userID := registeredUserID(t, w)
+	cookie := tokenCookie(w)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	currentPlan := func(t *testing.T) *db.Usage {
+		usage, err := db.QuotaUsage(ctx, userID)
+		if err != nil {
+			t.Fatalf("Failed to read usage: %v", err)
+		}
+		return usage
+	}
+
+	t.Run("1. Checkout needs a configured provider", func(t *testing.T) {
+		previous := billing.Default
+		billing.Default = nil
+		defer func() { billing.Default = previous }()
+
+		if w := postJSON(router, "/api/billing/checkout", map[string]string{"plan": "pro"}, cookie); w.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected 503, got %d", w.Code)
+		}
+	})
+
+	fake := useFakeBilling(t, router)
+	var checkout struct {
+		Checkout billing.CheckoutSession `json:"checkout"`
+	}
+
+	t.Run("2. Only paid plans can be bought", func(t *testing.T) {
+		if w := postJSON(router, "/api/billing/checkout", map[string]string{"plan": "free"}, cookie); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for the free plan, got %d", w.Code)
+		}
+		if w := postJSON(router, "/api/billing/checkout", map[string]string{"plan": "gold"}, cookie); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown plan, got %d", w.Code)
+		}
+
+		w := postJSON(router, "/api/billing/checkout", map[string]string{"plan": "pro"}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		json.Unmarshal(w.Body.Bytes(), &checkout)
+		if checkout.Checkout.URL != "http://pay.test/checkout/"+checkout.Checkout.ID {
+			t.Errorf("Unexpected checkout: %s", w.Body.String())
+		}
+		if currentPlan(t).Plan.ID != "free" {
+			t.Error("Expected the plan to change only after payment")
+		}
+
+		if w := postJSON(router, "/api/billing/checkout", map[string]string{"plan": "team"}, cookie); w.Code != http.StatusConflict {
+			t.Errorf("Expected 409 while a checkout is open, got %d", w.Code)
+		}
+	})
+
+	var completed *billing.Event
+	t.Run("3. A completed checkout upgrades the plan", func(t *testing.T) {
+		setMealLimit(t, userID, 5)
+
+		w := postJSON(router, "/billing/fake/checkout/"+checkout.Checkout.ID, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Event *billing.Event `json:"event"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		completed = resp.Event
+
+		usage := currentPlan(t)
+		if usage.Plan.ID != "pro" || usage.Allowance != 300 {
+			t.Errorf("Expected the pro allowance to replace the admin quota, got %+v", usage)
+		}
+		if auditCount(t, userID, audit.ActionPlanChanged) != 1 {
+			t.Error("Expected the plan change to be audited")
+		}
+
+		w = getWithCookies(router, "/api/billing/subscription", cookie)
+		if !strings.Contains(w.Body.String(), `"status":"active"`) || !strings.Contains(w.Body.String(), `"plan_id":"pro"`) {
+			t.Errorf("Expected an active pro subscription, got %s", w.Body.String())
+		}
+
+		if w := postJSON(router, "/billing/fake/checkout/"+checkout.Checkout.ID, nil); w.Code != http.StatusNotFound {
+			t.Errorf("Expected a checkout to complete only once, got %d", w.Code)
+		}
+	})
+
+	t.Run("4. Redelivered events are applied once", func(t *testing.T) {
+		if completed == nil {
+			t.Skip("No event to redeliver")
+		}
+		if _, err := fake.ChangePlan(ctx, completed.Data.SubscriptionID, "team"); err != nil {
+			t.Fatalf("Change failed: %v", err)
+		}
+		if currentPlan(t).Plan.ID != "team" {
+			t.Fatal("Expected the team plan")
+		}
+
+		// The original checkout event again must not undo the change
+		body, _ := json.Marshal(completed)
+		header := http.Header{}
+		header.Set(billing.SignatureHeader, billing.Sign(testWebhookSecret, body, time.Now()))
+		w := postWebhook(router, header, body)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"duplicate":true`) {
+			t.Errorf("Expected a duplicate acknowledgement, got %d %s", w.Code, w.Body.String())
+		}
+		if currentPlan(t).Plan.ID != "team" || auditCount(t, userID, audit.ActionPlanChanged) != 2 {
+			t.Error("Expected the redelivery to change nothing")
+		}
+	})
+
+	t.Run("5. Older events arriving late are ignored", func(t *testing.T) {
+		late := &billing.Event{
+			ID:      "evt_late",
+			Type:    billing.EventSubscriptionUpdated,
+			Created: time.Now().Add(-time.Hour).Unix(),
+			Data:    billing.EventData{PlanID: "pro", SubscriptionID: completed.Data.SubscriptionID},
+		}
+		if err := fake.Emit(ctx, late); err != nil {
+			t.Fatalf("Delivery failed: %v", err)
+		}
+		if currentPlan(t).Plan.ID != "team" {
+			t.Error("Expected the stale event not to change the plan")
+		}
+	})
+
+	t.Run("6. Forged events are rejected", func(t *testing.T) {
+		forged := &billing.Event{
+			ID:      "evt_forged",
+			Type:    billing.EventSubscriptionUpdated,
+			Created: time.Now().Unix(),
+			Data:    billing.EventData{PlanID: "pro", SubscriptionID: completed.Data.SubscriptionID},
+		}
+		body, _ := json.Marshal(forged)
+
+		header := http.Header{}
+		header.Set(billing.SignatureHeader, billing.Sign("wrong-secret", body, time.Now()))
+		if w := postWebhook(router, header, body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for a bad signature, got %d", w.Code)
+		}
+		if w := postWebhook(router, http.Header{}, body); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 without a signature, got %d", w.Code)
+		}
+		if currentPlan(t).Plan.ID != "team" {
+			t.Error("Expected forged events to change nothing")
+		}
+	})
+
+	t.Run("7. Subscribers cannot check out again", func(t *testing.T) {
+		if w := postJSON(router, "/api/billing/checkout", map[string]string{"plan": "pro"}, cookie); w.Code != http.StatusConflict {
+			t.Errorf("Expected 409, got %d", w.Code)
+		}
+
+		// A second checkout completed anyway must not replace the
+		// subscription being tracked
+		session, err := fake.CreateCheckout(ctx, billing.CheckoutRequest{UserID: userID, PlanID: "pro"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := db.DB.ExecContext(ctx,
+			"INSERT INTO checkout_sessions (id, user_id, provider, plan_id) VALUES (?, ?, ?, 'pro')",
+			session.ID, userID, billing.ProviderFake,
+		); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fake.CompleteCheckout(ctx, session.ID); err == nil {
+			t.Error("Expected the webhook to refuse a second subscription")
+		}
+		if n := countRows(t, "subscriptions", "user_id = ? AND subscription_id = ?", userID, completed.Data.SubscriptionID); n != 1 {
+			t.Error("Expected the first subscription to stay tracked")
+		}
+		if currentPlan(t).Plan.ID != "team" {
+			t.Error("Expected the plan to be unchanged")
+		}
+		if _, err := db.DB.ExecContext(ctx, "DELETE FROM checkout_sessions WHERE id = ?", session.ID); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	t.Run("8. Cancelling returns the user to the free plan", func(t *testing.T) {
+		if _, err := db.DB.ExecContext(ctx, "UPDATE users_tracking SET rollover = 40 WHERE user_id = ?", userID); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fake.Cancel(ctx, completed.Data.SubscriptionID); err != nil {
+			t.Fatalf("Cancel failed: %v", err)
+		}
+
+		usage := currentPlan(t)
+		if usage.Plan.ID != db.DefaultPlan || usage.Rollover != 0 || usage.Limit != 20 {
+			t.Errorf("Expected the free plan without rollover, got %+v", usage)
+		}
+		w := getWithCookies(router, "/api/billing/subscription", cookie)
+		if !strings.Contains(w.Body.String(), `"status":"canceled"`) || !strings.Contains(w.Body.String(), `"plan_id":"free"`) {
+			t.Errorf("Expected a canceled subscription on the free plan, got %s", w.Body.String())
+		}
+
+		// A new checkout is allowed again
+		if w := postJSON(router, "/api/billing/checkout", map[string]string{"plan": "pro"}, cookie); w.Code != http.StatusOK {
+			t.Errorf("Expected 200, got %d", w.Code)
+		}
+
+		// An abandoned checkout stops blocking new ones after CheckoutTTL
+		if _, err := db.DB.ExecContext(ctx,
+			"UPDATE checkout_sessions SET created_at = datetime('now', '-2 hours') WHERE user_id = ? AND status = 'open'", userID,
+		); err != nil {
+			t.Fatal(err)
+		}
+		if w := postJSON(router, "/api/billing/checkout", map[string]string{"plan": "pro"}, cookie); w.Code != http.StatusOK {
+			t.Errorf("Expected an expired checkout not to block, got %d", w.Code)
+		}
+	})
+}
+
+// TestE2E_BillingAccountDeletion tests that deleting an account cancels
+// its subscription with the provider
+func TestE2E_BillingAccountDeletion(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+	fake := useFakeBilling(t, router)
+
+	email := "billing_delete@example.com"
+	defer cleanupTestDB(t, email)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"})
+	userID := registeredUserID(t, w)
+	cookie := tokenCookie(w)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	session, err := billing.StartCheckout(ctx, userID, "pro", "http://app.test/ok", "http://app.test/cancel")
+	if err != nil {
+		t.Fatalf("Checkout failed: %v", err)
+	}
+	completed, err := fake.CompleteCheckout(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("Payment failed: %v", err)
+	}
+	subscriptionID := completed.Data.SubscriptionID
+
+	t.Run("1. Deletion is refused when the subscription cannot be cancelled", func(t *testing.T) {
+		previous := billing.Default
+		billing.Default = nil
+		w := deleteJSON(router, "/api/account", map[string]string{"current_password": "testpass123"}, cookie)
+		billing.Default = previous
+
+		if w.Code != http.StatusServiceUnavailable {
+			t.Errorf("Expected 503, got %d: %s", w.Code, w.Body.String())
+		}
+		if n := countRows(t, "users", "id = ?", userID); n != 1 {
+			t.Error("Expected the account to be kept")
+		}
+	})
+
+	t.Run("2. Deleting the account cancels the subscription", func(t *testing.T) {
+		w := deleteJSON(router, "/api/account", map[string]string{"current_password": "testpass123"}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if _, err := fake.ChangePlan(ctx, subscriptionID, "team"); !errors.Is(err, billing.ErrUnknownSession) {
+			t.Errorf("Expected the provider to have cancelled the subscription, got %v", err)
+		}
+		if n := countRows(t, "subscriptions", "user_id = ?", userID); n != 0 {
+			t.Error("Expected the subscription record to be deleted")
+		}
+	})
+}
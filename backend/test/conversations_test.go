@@ -0,0 +1,199 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"backend/llm"
+)
+
+// TestHistory_Truncate tests that replayed history drops the oldest turns
+// first and always starts with a user message
+func TestHistory_Truncate(t *testing.T) {
+	// Each message is 10 estimated tokens
+	turn := strings.Repeat("x", 40)
+	messages := []llm.Message{
+		{Role: llm.RoleUser, Content: turn},
+		{Role: llm.RoleAssistant, Content: turn},
+		{Role: llm.RoleUser, Content: turn},
+		{Role: llm.RoleAssistant, Content: turn},
+	}
+
+	if kept := llm.TruncateHistory(messages, 40); len(kept) != 4 {
+		t.Errorf("Expected everything to fit, kept %d", len(kept))
+	}
+	if kept := llm.TruncateHistory(messages, 20); len(kept) != 2 || kept[0].Role != llm.RoleUser {
+		t.Errorf("Expected the last turn only, got %+v", kept)
+	}
+
+	// Three messages fit, but the oldest of them is an assistant reply
+	kept := llm.TruncateHistory(messages, 35)
+	if len(kept) != 2 || kept[0].Role != llm.RoleUser || &kept[0] != &messages[2] {
+		t.Errorf("Expected the history to start at the last user message, got %+v", kept)
+	}
+
+	if kept := llm.TruncateHistory(messages, 5); len(kept) != 0 {
+		t.Errorf("Expected nothing to fit, kept %d", len(kept))
+	}
+}
+
+// TestE2E_Conversations tests conversation CRUD and multi-turn replay
+func TestE2E_Conversations(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+	fake := useFakeLLM(t)
+
+	email := "conversations_user@example.com"
+	otherEmail := "conversations_other@example.com"
+	defer cleanupTestDB(t, email)
+	defer cleanupTestDB(t, otherEmail)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"})
+	cookie := tokenCookie(w)
+	markEmailVerified(t, email)
+
+	w = postJSON(router, "/auth/register", map[string]string{"email": otherEmail, "password": "testpass123"})
+	otherCookie := tokenCookie(w)
+	markEmailVerified(t, otherEmail)
+
+	type conversation struct {
+		ID    int64  `json:"id"`
+		Title string `json:"title"`
+	}
+	create := func(t *testing.T, title string) conversation {
+		w := postJSON(router, "/api/conversations", map[string]string{"title": title}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Create failed: %d %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Conversation conversation `json:"conversation"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Conversation
+	}
+
+	var conv conversation
+	t.Run("1. Create and list conversations", func(t *testing.T) {
+		titled := create(t, "Weeknight dinners")
+		if titled.ID == 0 || titled.Title != "Weeknight dinners" {
+			t.Errorf("Unexpected conversation: %+v", titled)
+		}
+		conv = create(t, "")
+
+		w := getWithCookies(router, "/api/conversations", cookie)
+		var resp struct {
+			Conversations []conversation `json:"conversations"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.Conversations) != 2 {
+			t.Errorf("Expected 2 conversations, got %s", w.Body.String())
+		}
+
+		w = getWithCookies(router, "/api/conversations", otherCookie)
+		if !strings.Contains(w.Body.String(), `"conversations":[]`) {
+			t.Errorf("Expected other users to see none, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("2. Follow-up messages replay the earlier turns", func(t *testing.T) {
+		body := map[string]any{"message": "something with chickpeas", "conversation_id": conv.ID}
+		if w := postJSON(router, "/llm", body, cookie); w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		firstReply := fake.Requests[len(fake.Requests)-1]
+		if len(firstReply.Messages) != 1 {
+			t.Errorf("Expected no history on the first turn, got %d messages", len(firstReply.Messages))
+		}
+
+		body["message"] = "make it spicier"
+		w := postJSON(router, "/llm", body, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), fmt.Sprintf(`"conversation_id":%d`, conv.ID)) {
+			t.Errorf("Expected the conversation ID in the response, got %s", w.Body.String())
+		}
+
+		sent := fake.Requests[len(fake.Requests)-1].Messages
+		if len(sent) != 3 || sent[0].Role != llm.RoleUser || sent[0].Content != "something with chickpeas" ||
+			sent[1].Role != llm.RoleAssistant || !strings.Contains(sent[1].Content, "Fake recipe") ||
+			!strings.HasPrefix(sent[2].Content, "make it spicier") {
+			t.Errorf("Expected the first turn to be replayed before the new message, got %+v", sent)
+		}
+	})
+
+	t.Run("3. The transcript and title are stored", func(t *testing.T) {
+		w := getWithCookies(router, fmt.Sprintf("/api/conversations/%d", conv.ID), cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+		var resp struct {
+			Conversation conversation `json:"conversation"`
+			Messages     []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Conversation.Title != "something with chickpeas" {
+			t.Errorf("Expected the title to come from the first message, got %q", resp.Conversation.Title)
+		}
+		if len(resp.Messages) != 4 || resp.Messages[2].Content != "make it spicier" || resp.Messages[3].Role != llm.RoleAssistant {
+			t.Errorf("Expected two stored turns, got %+v", resp.Messages)
+		}
+	})
+
+	t.Run("4. Long histories are truncated to the budget", func(t *testing.T) {
+		long := create(t, "")
+		send := func(message string) []llm.Message {
+			body := map[string]any{"message": message, "conversation_id": long.ID}
+			if w := postJSON(router, "/llm", body, cookie); w.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+			return fake.Requests[len(fake.Requests)-1].Messages
+		}
+		// The first turn alone is over the budget below
+		send(strings.Repeat("leftovers ", 200))
+		send("something quick")
+
+		t.Setenv("LLM_HISTORY_TOKEN_BUDGET", "400")
+		sent := send("and a dessert")
+		if len(sent) != 3 || sent[0].Role != llm.RoleUser || sent[0].Content != "something quick" ||
+			sent[1].Role != llm.RoleAssistant || !strings.HasPrefix(sent[2].Content, "and a dessert") {
+			t.Errorf("Expected only the last turn to be replayed, got %+v", sent)
+		}
+	})
+
+	t.Run("5. Other users cannot use or see the conversation", func(t *testing.T) {
+		path := fmt.Sprintf("/api/conversations/%d", conv.ID)
+		if w := getWithCookies(router, path, otherCookie); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 on get, got %d", w.Code)
+		}
+		if w := requestWithCookies(router, "DELETE", path, otherCookie); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 on delete, got %d", w.Code)
+		}
+		body := map[string]any{"message": "hello", "conversation_id": conv.ID}
+		if w := postJSON(router, "/llm", body, otherCookie); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 on /llm, got %d", w.Code)
+		}
+	})
+
+	t.Run("6. Deleting removes the conversation and its messages", func(t *testing.T) {
+		path := fmt.Sprintf("/api/conversations/%d", conv.ID)
+		if w := requestWithCookies(router, "DELETE", path, cookie); w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if w := getWithCookies(router, path, cookie); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 after delete, got %d", w.Code)
+		}
+		if n := countRows(t, "messages", "conversation_id = ?", conv.ID); n != 0 {
+			t.Errorf("Expected the messages to be deleted, found %d", n)
+		}
+		if w := getWithCookies(router, "/api/conversations/abc", cookie); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an invalid ID, got %d", w.Code)
+		}
+	})
+}
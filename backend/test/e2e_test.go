@@ -6,12 +6,14 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"os"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 
+	"backend/admin"
 	"backend/auth"
 	"backend/handlers"
 	"backend/middleware"
@@ -50,35 +52,126 @@ func setupTestRouter() *gin.Engine {
 	r.GET("/health", handlers.HealthCheck)
 	r.GET("/health/db", handlers.DBHealthCheck)
 
+	r.GET("/.well-known/jwks.json", auth.JWKS)
+
 	// Auth routes
 	r.POST("/auth/register", auth.Register)
 	r.POST("/auth/login", auth.Login)
+	r.POST("/auth/login/2fa", auth.LoginTwoFactor)
+	r.POST("/auth/logout", auth.Logout)
+	r.POST("/auth/refresh", auth.Refresh)
+	r.POST("/auth/logout-all", middleware.AuthMiddleware(), middleware.RequireSession(), auth.LogoutAll)
+	r.POST("/auth/forgot-password", auth.ForgotPassword)
+	r.POST("/auth/reset-password", auth.ResetPassword)
+	r.GET("/auth/verify", auth.VerifyEmail)
+	r.POST("/auth/2fa/setup", middleware.AuthMiddleware(), middleware.RequireSession(), auth.SetupTwoFactor)
+	r.POST("/auth/2fa/verify", middleware.AuthMiddleware(), middleware.RequireSession(), auth.VerifyTwoFactor)
+	r.POST("/auth/2fa/disable", middleware.AuthMiddleware(), middleware.RequireSession(), auth.DisableTwoFactor)
+	r.GET("/auth/oidc/providers", auth.ListOIDCProviders)
+	r.GET("/auth/oidc/:provider/login", auth.OIDCLogin)
+	r.GET("/auth/oidc/:provider/callback", auth.OIDCCallback)
+	r.GET("/auth/oidc/:provider/link", middleware.AuthMiddleware(), middleware.RequireSession(), auth.OIDCLink)
+
+	// Admin routes
+	staff := r.Group("/admin", middleware.AuthMiddleware(), middleware.RequireSession(), middleware.RequireRole(auth.RoleSupport, auth.RoleAdmin))
+	staff.GET("/users", admin.ListUsers)
+	staff.GET("/users/:id", admin.GetUser)
+	staff.POST("/users/:id/unlock", admin.UnlockUser)
+	staff.POST("/users/:id/impersonate", admin.Impersonate)
+	staff.GET("/users/:id/usage/history", admin.UsageHistory)
+
+	admins := r.Group("/admin", middleware.AuthMiddleware(), middleware.RequireSession(), middleware.RequireRole(auth.RoleAdmin))
+	admins.PUT("/users/:id/quota", admin.SetQuota)
+	admins.PUT("/users/:id/plan", admin.SetPlan)
+	admins.GET("/usage", admin.UsageReport)
+	admins.PUT("/users/:id/role", admin.SetRole)
+	admins.POST("/users/:id/disable", admin.DisableUser)
+	admins.POST("/users/:id/enable", admin.EnableUser)
 
 	// Protected routes
-	r.GET("/api/profile", middleware.AuthMiddleware(), handlers.GetProfile)
+	r.GET("/api/profile", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeProfileRead), handlers.GetProfile)
+	r.GET("/api/preferences", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopePreferencesRead), handlers.GetPreferences)
+	r.PUT("/api/preferences", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopePreferencesWrite), handlers.UpdatePreferences)
+	r.PATCH("/api/preferences", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopePreferencesWrite), handlers.PatchPreferences)
+	r.GET("/api/preferences/schema", handlers.GetPreferenceSchema)
+	r.GET("/api/usage", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeUsageRead), handlers.GetUsage)
+	r.GET("/api/usage/breakdown", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeUsageRead), handlers.GetUsageBreakdown)
+	r.GET("/api/usage/history", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeUsageRead), handlers.GetUsageHistory)
+	r.GET("/api/plans", handlers.ListPlans)
+	r.POST("/llm", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeLLM), middleware.RequireVerifiedEmail(), handlers.HandleLLMRequest)
+	r.POST("/llm/stream", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeLLM), middleware.RequireVerifiedEmail(), handlers.HandleLLMStream)
+	r.POST("/api/conversations", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeConversationsWrite), handlers.CreateConversation)
+	r.GET("/api/conversations", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeConversationsRead), handlers.ListConversations)
+	r.GET("/api/conversations/:id", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeConversationsRead), handlers.GetConversation)
+	r.DELETE("/api/conversations/:id", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeConversationsWrite), handlers.DeleteConversation)
+	r.POST("/api/recipes", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeRecipesWrite), handlers.SaveRecipe)
+	r.GET("/api/recipes", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeRecipesRead), handlers.ListRecipes)
+	r.GET("/api/recipes/:id", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeRecipesRead), handlers.GetRecipe)
+	r.PUT("/api/recipes/:id", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeRecipesWrite), handlers.UpdateRecipe)
+	r.PUT("/api/recipes/:id/favourite", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeRecipesWrite), handlers.SetRecipeFavourite)
+	r.DELETE("/api/recipes/:id", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeRecipesWrite), handlers.DeleteRecipe)
+	r.POST("/api/keys", middleware.AuthMiddleware(), middleware.RequireSession(), auth.CreateAPIKey)
+	r.GET("/api/keys", middleware.AuthMiddleware(), middleware.RequireSession(), auth.ListAPIKeys)
+	r.DELETE("/api/keys/:id", middleware.AuthMiddleware(), middleware.RequireSession(), auth.RevokeAPIKey)
+	r.PUT("/api/account/password", middleware.AuthMiddleware(), middleware.RequireSession(), auth.ChangePassword)
+	r.PUT("/api/account/email", middleware.AuthMiddleware(), middleware.RequireSession(), auth.ChangeEmail)
+	r.DELETE("/api/account", middleware.AuthMiddleware(), middleware.RequireSession(), auth.DeleteAccount)
+	r.GET("/api/account/export", middleware.AuthMiddleware(), middleware.RequireSession(), handlers.ExportAccount)
+	r.GET("/api/account/exports/:id", middleware.AuthMiddleware(), middleware.RequireSession(), handlers.GetExport)
+	r.GET("/api/account/exports/:id/download", middleware.AuthMiddleware(), middleware.RequireSession(), handlers.DownloadExport)
+	r.POST("/api/billing/checkout", middleware.AuthMiddleware(), middleware.RequireSession(), handlers.CreateCheckout)
+	r.GET("/api/billing/subscription", middleware.AuthMiddleware(), middleware.RequireScope(auth.ScopeUsageRead), handlers.GetSubscription)
+	r.POST("/billing/webhook", handlers.BillingWebhook)
+	r.POST("/billing/fake/checkout/:id", handlers.CompleteFakeCheckout)
+	r.GET("/api/identities", middleware.AuthMiddleware(), middleware.RequireSession(), auth.ListIdentities)
+	r.DELETE("/api/identities/:id", middleware.AuthMiddleware(), middleware.RequireSession(), auth.UnlinkIdentity)
 
 	return r
 }
 
-// setupTestDB initializes the database for testing
+// setupTestDB initializes the database for testing. Without
+// TURSO_DATABASE_URL the tests run against an in-memory SQLite database.
 func setupTestDB(t *testing.T) {
 	// Load .env file
 	if err := godotenv.Load("../.env"); err != nil {
 		t.Logf("Warning: No .env file found: %v", err)
 	}
+	if os.Getenv("TURSO_DATABASE_URL") == "" {
+		t.Setenv("TURSO_DATABASE_URL", ":memory:")
+	}
+
+	// Close any connection left by a previous test
+	if db.DB != nil {
+		db.DB.Close()
+	}
 
 	// Initialize database
 	if err := db.InitDB(); err != nil {
 		t.Fatalf("Failed to initialize database: %v", err)
 	}
 
-	// Create users table
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	// Apply schema migrations
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	if err := db.CreateUsersTable(ctx); err != nil {
-		t.Fatalf("Failed to create users table: %v", err)
+	if _, err := db.MigrateUp(ctx); err != nil {
+		t.Fatalf("Failed to migrate database: %v", err)
+	}
+}
+
+// tokenCookie returns the auth cookie set by a response, if any
+func tokenCookie(w *httptest.ResponseRecorder) *http.Cookie {
+	return namedCookie(w, "token")
+}
+
+// namedCookie returns the cookie with the given name set by a response
+func namedCookie(w *httptest.ResponseRecorder, name string) *http.Cookie {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
 	}
+	return nil
 }
 
 // cleanupTestDB removes test data
@@ -140,8 +233,12 @@ func TestE2E_CompleteAuthFlow(t *testing.T) {
 		}
 
 		// Verify response
-		if resp.Token == "" {
-			t.Error("Expected token in response, got empty string")
+		cookie := tokenCookie(w)
+		if cookie == nil || cookie.Value == "" {
+			t.Fatal("Expected token cookie in response")
+		}
+		if !cookie.HttpOnly {
+			t.Error("Expected token cookie to be httpOnly")
 		}
 		if resp.User.Email != testEmail {
 			t.Errorf("Expected email %s, got %s", testEmail, resp.User.Email)
@@ -154,7 +251,7 @@ func TestE2E_CompleteAuthFlow(t *testing.T) {
 		}
 
 		t.Logf("✓ User registered successfully with ID: %d", resp.User.ID)
-		t.Logf("✓ Token received: %s...", resp.Token[:20])
+		t.Logf("✓ Token cookie received: %s...", cookie.Value[:20])
 	})
 
 	t.Run("2. Login with correct credentials", func(t *testing.T) {
@@ -182,8 +279,9 @@ func TestE2E_CompleteAuthFlow(t *testing.T) {
 		}
 
 		// Verify response
-		if resp.Token == "" {
-			t.Error("Expected token in response, got empty string")
+		cookie := tokenCookie(w)
+		if cookie == nil || cookie.Value == "" {
+			t.Fatal("Expected token cookie in response")
 		}
 		if resp.User.Email != testEmail {
 			t.Errorf("Expected email %s, got %s", testEmail, resp.User.Email)
@@ -193,7 +291,7 @@ func TestE2E_CompleteAuthFlow(t *testing.T) {
 		}
 
 		t.Logf("✓ Login successful")
-		t.Logf("✓ Token received: %s...", resp.Token[:20])
+		t.Logf("✓ Token cookie received: %s...", cookie.Value[:20])
 	})
 
 	t.Run("3. Access protected route with valid token", func(t *testing.T) {
@@ -209,13 +307,14 @@ func TestE2E_CompleteAuthFlow(t *testing.T) {
 		loginW := httptest.NewRecorder()
 		router.ServeHTTP(loginW, loginReq)
 
-		var loginResp AuthResponse
-		json.Unmarshal(loginW.Body.Bytes(), &loginResp)
-		token := loginResp.Token
+		cookie := tokenCookie(loginW)
+		if cookie == nil {
+			t.Fatalf("Expected token cookie from login. Body: %s", loginW.Body.String())
+		}
 
 		// Now access protected route
 		req := httptest.NewRequest("GET", "/api/profile", nil)
-		req.Header.Set("Authorization", "Bearer "+token)
+		req.AddCookie(cookie)
 		w := httptest.NewRecorder()
 
 		router.ServeHTTP(w, req)
@@ -353,8 +452,8 @@ func TestE2E_CompleteAuthFlow(t *testing.T) {
 			t.Fatalf("Failed to parse response: %v", err)
 		}
 
-		if resp.Message != "Authorization header required" {
-			t.Errorf("Expected 'Authorization header required' error, got %s", resp.Message)
+		if resp.Message != "Authentication required" {
+			t.Errorf("Expected 'Authentication required' error, got %s", resp.Message)
 		}
 
 		t.Logf("✓ Missing token correctly rejected")
@@ -362,7 +461,7 @@ func TestE2E_CompleteAuthFlow(t *testing.T) {
 
 	t.Run("8. Reject protected route with invalid token", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/api/profile", nil)
-		req.Header.Set("Authorization", "Bearer invalid-token-here")
+		req.AddCookie(&http.Cookie{Name: "token", Value: "invalid-token-here"})
 		w := httptest.NewRecorder()
 
 		router.ServeHTTP(w, req)
@@ -0,0 +1,181 @@
+package test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	db "backend/database"
+	"backend/export"
+)
+
+// readArchive unzips an export into a map of file name to content
+func readArchive(t *testing.T, data []byte) map[string][]byte {
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("Export is not a valid ZIP: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatalf("Failed to open %s: %v", f.Name, err)
+		}
+		files[f.Name], _ = io.ReadAll(rc)
+		rc.Close()
+	}
+	return files
+}
+
+// TestE2E_DataExport tests the immediate and background personal data export
+func TestE2E_DataExport(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+
+	email := "export_test@example.com"
+	otherEmail := "export_other@example.com"
+	defer cleanupTestDB(t, email)
+	defer cleanupTestDB(t, otherEmail)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Register failed: %d %s", w.Code, w.Body.String())
+	}
+	userID := registeredUserID(t, w)
+	cookie := tokenCookie(w)
+	otherCookie := tokenCookie(postJSON(router, "/auth/register", map[string]string{"email": otherEmail, "password": "testpass123"}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, stmt := range []string{
+		`INSERT INTO user_preference (user_id, user_preference) VALUES (?, '{"dietary_restrictions":"vegan","max_cooking_time":30}')`,
+		"INSERT INTO conversations (user_id, title) VALUES (?, 'Dinner ideas')",
+		"INSERT INTO messages (conversation_id, role, content) SELECT id, 'user', 'Something quick' FROM conversations WHERE user_id = ?",
+		"INSERT INTO messages (conversation_id, role, content) SELECT id, 'assistant', 'Try a stir fry' FROM conversations WHERE user_id = ?",
+		`INSERT INTO recipes (user_id, title, recipe) VALUES (?, 'Stir fry', '{"title":"Stir fry"}')`,
+	} {
+		if _, err := db.DB.ExecContext(ctx, stmt, userID); err != nil {
+			t.Fatalf("Failed to seed data: %v", err)
+		}
+	}
+
+	t.Run("1. Small accounts download immediately", func(t *testing.T) {
+		w := getWithCookies(router, "/api/account/export", cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Export failed: %d %s", w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); ct != "application/zip" {
+			t.Errorf("Expected application/zip, got %s", ct)
+		}
+		if !strings.Contains(w.Header().Get("Content-Disposition"), "attachment") {
+			t.Error("Expected the export to be sent as an attachment")
+		}
+
+		files := readArchive(t, w.Body.Bytes())
+		var manifest export.Manifest
+		if err := json.Unmarshal(files[export.ManifestName], &manifest); err != nil {
+			t.Fatalf("Invalid manifest: %v", err)
+		}
+		if manifest.UserID != userID || manifest.FormatVersion != export.FormatVersion {
+			t.Errorf("Unexpected manifest header: %+v", manifest)
+		}
+
+		records := map[string]int{}
+		for _, f := range manifest.Files {
+			content, ok := files[f.Name]
+			if !ok {
+				t.Errorf("Manifest lists missing file %s", f.Name)
+				continue
+			}
+			sum := sha256.Sum256(content)
+			if hex.EncodeToString(sum[:]) != f.SHA256 {
+				t.Errorf("Checksum mismatch for %s", f.Name)
+			}
+			records[f.Name] = f.Records
+		}
+		if records["conversations.json"] != 1 || records["recipes.json"] != 1 || records["profile.json"] != 1 {
+			t.Errorf("Unexpected record counts: %v", records)
+		}
+
+		var conversations []struct {
+			Title    string `json:"title"`
+			Messages []struct {
+				Role    string `json:"role"`
+				Content string `json:"content"`
+			} `json:"messages"`
+		}
+		json.Unmarshal(files["conversations.json"], &conversations)
+		if len(conversations) != 1 || len(conversations[0].Messages) != 2 {
+			t.Errorf("Expected one conversation with two messages, got %s", files["conversations.json"])
+		}
+
+		var prefs struct {
+			Preferences struct {
+				DietaryRestrictions string `json:"dietary_restrictions"`
+			} `json:"preferences"`
+		}
+		json.Unmarshal(files["preferences.json"], &prefs)
+		if prefs.Preferences.DietaryRestrictions != "vegan" {
+			t.Errorf("Expected preferences to be embedded as JSON, got %s", files["preferences.json"])
+		}
+
+		for name, content := range files {
+			if bytes.Contains(content, []byte("hashed_password")) || bytes.Contains(content, []byte("token_hash")) {
+				t.Errorf("%s contains secrets", name)
+			}
+		}
+	})
+
+	t.Run("2. Large accounts are exported in the background", func(t *testing.T) {
+		previous := export.SyncLimit
+		export.SyncLimit = 1
+		defer func() { export.SyncLimit = previous }()
+
+		w := getWithCookies(router, "/api/account/export", cookie)
+		if w.Code != http.StatusAccepted {
+			t.Fatalf("Expected 202, got %d %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Export export.Job `json:"export"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		path := fmt.Sprintf("/api/account/exports/%d", resp.Export.ID)
+		if loc := w.Header().Get("Location"); loc != path {
+			t.Errorf("Expected Location %s, got %s", path, loc)
+		}
+
+		if w := getWithCookies(router, path, otherCookie); w.Code != http.StatusNotFound {
+			t.Errorf("Expected other users to get 404, got %d", w.Code)
+		}
+
+		deadline := time.Now().Add(5 * time.Second)
+		for resp.Export.Status != export.StatusReady {
+			if time.Now().After(deadline) {
+				t.Fatalf("Export did not finish, last status %s", resp.Export.Status)
+			}
+			time.Sleep(20 * time.Millisecond)
+			w := getWithCookies(router, path, cookie)
+			json.Unmarshal(w.Body.Bytes(), &resp)
+		}
+
+		w = getWithCookies(router, path+"/download", cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Download failed: %d %s", w.Code, w.Body.String())
+		}
+		if _, ok := readArchive(t, w.Body.Bytes())["recipes.json"]; !ok {
+			t.Error("Expected recipes.json in the background export")
+		}
+		if auditCount(t, userID, "data_exported") != 2 {
+			t.Error("Expected both exports to be audited")
+		}
+	})
+}
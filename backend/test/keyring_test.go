@@ -0,0 +1,160 @@
+package test
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+
+	"backend/auth"
+)
+
+// writePrivateKey writes key as a PKCS#8 PEM file in dir
+func writePrivateKey(t *testing.T, dir, name string, key any) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+	data := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, name), data, 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+}
+
+// writeKeyring writes a keyring file and returns its path
+func writeKeyring(t *testing.T, dir string, ring map[string]any) string {
+	data, _ := json.Marshal(ring)
+	path := filepath.Join(dir, "keyring.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatalf("Failed to write keyring: %v", err)
+	}
+	return path
+}
+
+// useKeyring installs a keyring for the test
+func useKeyring(t *testing.T, cfg auth.KeyringConfig) {
+	if err := auth.InitKeyring(cfg); err != nil {
+		t.Fatalf("Failed to load keyring: %v", err)
+	}
+	t.Cleanup(func() { auth.SetKeyring(nil) })
+}
+
+// TestKeyring_Rotation tests signing with kid headers, accepting previous
+// keys during rotation, JWKS and production checks
+func TestKeyring_Rotation(t *testing.T) {
+	dir := t.TempDir()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate RSA key: %v", err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate Ed25519 key: %v", err)
+	}
+	writePrivateKey(t, dir, "rsa.pem", rsaKey)
+	writePrivateKey(t, dir, "ed.pem", edKey)
+
+	keys := []map[string]string{
+		{"kid": "rsa-1", "alg": "RS256", "private_key_file": "rsa.pem"},
+		{"kid": "ed-2", "alg": "EdDSA", "private_key_file": "ed.pem"},
+		{"kid": "hs-0", "alg": "HS256", "secret": "an-old-shared-secret-of-32-bytes!!"},
+	}
+
+	var rsaToken string
+
+	t.Run("1. Tokens carry the signing kid", func(t *testing.T) {
+		useKeyring(t, auth.KeyringConfig{File: writeKeyring(t, dir, map[string]any{"signing_kid": "rsa-1", "keys": keys})})
+
+		rsaToken, err = auth.GenerateToken(1, "keyring@example.com", 1)
+		if err != nil {
+			t.Fatalf("GenerateToken failed: %v", err)
+		}
+		parsed, _, err := jwt.NewParser().ParseUnverified(rsaToken, &auth.Claims{})
+		if err != nil {
+			t.Fatalf("Failed to parse token: %v", err)
+		}
+		if parsed.Header["kid"] != "rsa-1" || parsed.Method.Alg() != "RS256" {
+			t.Errorf("Expected RS256 token with kid rsa-1, got %v %s", parsed.Header["kid"], parsed.Method.Alg())
+		}
+	})
+
+	t.Run("2. Previous keys verify after rotation", func(t *testing.T) {
+		useKeyring(t, auth.KeyringConfig{File: writeKeyring(t, dir, map[string]any{"signing_kid": "ed-2", "keys": keys})})
+
+		if _, err := auth.VerifyToken(rsaToken); err != nil {
+			t.Errorf("Expected token from previous key to verify: %v", err)
+		}
+		token, _ := auth.GenerateToken(1, "keyring@example.com", 1)
+		if claims, err := auth.VerifyToken(token); err != nil || claims.UserID != 1 {
+			t.Errorf("Expected EdDSA token to verify: %v", err)
+		}
+	})
+
+	t.Run("3. Retired keys no longer verify", func(t *testing.T) {
+		useKeyring(t, auth.KeyringConfig{File: writeKeyring(t, dir, map[string]any{"signing_kid": "ed-2", "keys": keys[1:]})})
+
+		if _, err := auth.VerifyToken(rsaToken); !errors.Is(err, auth.ErrUnknownKey) {
+			t.Errorf("Expected ErrUnknownKey, got %v", err)
+		}
+	})
+
+	t.Run("4. Algorithm must match the key", func(t *testing.T) {
+		useKeyring(t, auth.KeyringConfig{File: writeKeyring(t, dir, map[string]any{"signing_kid": "ed-2", "keys": keys})})
+
+		// An HS256 token naming the RSA key, signed with its public modulus
+		forged := jwt.NewWithClaims(jwt.SigningMethodHS256, &auth.Claims{
+			UserID: 1,
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			},
+		})
+		forged.Header["kid"] = "rsa-1"
+		signed, _ := forged.SignedString(rsaKey.PublicKey.N.Bytes())
+		if _, err := auth.VerifyToken(signed); err == nil {
+			t.Error("Expected algorithm confusion to be rejected")
+		}
+	})
+
+	t.Run("5. JWKS publishes only public keys", func(t *testing.T) {
+		useKeyring(t, auth.KeyringConfig{File: writeKeyring(t, dir, map[string]any{"signing_kid": "ed-2", "keys": keys})})
+
+		router := setupTestRouter()
+		w := getWithCookies(router, "/.well-known/jwks.json")
+		if w.Code != http.StatusOK {
+			t.Fatalf("JWKS failed: %d", w.Code)
+		}
+
+		var set auth.JWKSet
+		json.Unmarshal(w.Body.Bytes(), &set)
+		kids := map[string]string{}
+		for _, k := range set.Keys {
+			kids[k.KeyID] = k.KeyType
+		}
+		if len(kids) != 2 || kids["rsa-1"] != "RSA" || kids["ed-2"] != "OKP" {
+			t.Errorf("Expected RSA and OKP keys only, got %v", kids)
+		}
+	})
+
+	t.Run("6. Production requires configured keys", func(t *testing.T) {
+		if _, err := auth.NewKeyring(auth.KeyringConfig{Production: true}); !errors.Is(err, auth.ErrNoSigningKey) {
+			t.Errorf("Expected ErrNoSigningKey without keys, got %v", err)
+		}
+		if _, err := auth.NewKeyring(auth.KeyringConfig{Production: true, Secret: "short", KID: "k"}); err == nil {
+			t.Error("Expected a short secret to be rejected in production")
+		}
+		if _, err := auth.NewKeyring(auth.KeyringConfig{Secret: "short", KID: "k"}); err != nil {
+			t.Errorf("Expected short secret to be allowed in development: %v", err)
+		}
+	})
+}
@@ -0,0 +1,163 @@
+package test
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// sseEvent is one Server-Sent Event
+type sseEvent struct {
+	Name string
+	Data string
+}
+
+// parseSSE splits a text/event-stream body into its events
+func parseSSE(t *testing.T, body string) []sseEvent {
+	var events []sseEvent
+	var current sseEvent
+	scanner := bufio.NewScanner(strings.NewReader(body))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if current.Name != "" || current.Data != "" {
+				events = append(events, current)
+			}
+			current = sseEvent{}
+		case strings.HasPrefix(line, "event:"):
+			current.Name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			current.Data += strings.TrimSpace(strings.TrimPrefix(line, "data:"))
+		default:
+			t.Errorf("Unexpected line in event stream: %q", line)
+		}
+	}
+	if current.Name != "" || current.Data != "" {
+		events = append(events, current)
+	}
+	return events
+}
+
+// TestE2E_MealStream tests the Server-Sent Events protocol of /llm/stream
+func TestE2E_MealStream(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+	fake := useFakeLLM(t)
+
+	email := "stream_user@example.com"
+	defer cleanupTestDB(t, email)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"})
+	userID := registeredUserID(t, w)
+	cookie := tokenCookie(w)
+	markEmailVerified(t, email)
+
+	t.Run("1. Deltas are followed by a single done event", func(t *testing.T) {
+		w := postJSON(router, "/llm/stream", map[string]string{"message": "rice and peas"}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if ct := w.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/event-stream") {
+			t.Errorf("Expected an event stream, got %q", ct)
+		}
+
+		events := parseSSE(t, w.Body.String())
+		if len(events) < 3 {
+			t.Fatalf("Expected several deltas and a done event, got %+v", events)
+		}
+
+		var text strings.Builder
+		for _, e := range events[:len(events)-1] {
+			if e.Name != "delta" {
+				t.Fatalf("Expected only deltas before the last event, got %q", e.Name)
+			}
+			var delta struct {
+				Text string `json:"text"`
+			}
+			if err := json.Unmarshal([]byte(e.Data), &delta); err != nil {
+				t.Fatalf("Invalid delta %q: %v", e.Data, err)
+			}
+			text.WriteString(delta.Text)
+		}
+
+		last := events[len(events)-1]
+		if last.Name != "done" {
+			t.Fatalf("Expected the stream to end with done, got %q", last.Name)
+		}
+		var done struct {
+			Status   string `json:"status"`
+			Response string `json:"response"`
+			Usage    struct {
+				Used      int `json:"used"`
+				Remaining int `json:"remaining"`
+				Limit     int `json:"limit"`
+			} `json:"usage"`
+		}
+		if err := json.Unmarshal([]byte(last.Data), &done); err != nil {
+			t.Fatalf("Invalid done event %q: %v", last.Data, err)
+		}
+		if done.Status != "ok" || done.Response != text.String() || !strings.Contains(done.Response, "rice and peas") {
+			t.Errorf("Expected the full reply in done, got %+v (deltas %q)", done, text.String())
+		}
+		if done.Usage.Used != 1 || done.Usage.Limit == 0 || done.Usage.Remaining != done.Usage.Limit-1 {
+			t.Errorf("Expected the usage block in done, got %+v", done.Usage)
+		}
+		if n := countRows(t, "users_tracking", "user_id = ? AND meal_count = 1", userID); n != 1 {
+			t.Error("Expected the streamed meal to be counted")
+		}
+	})
+
+	t.Run("2. A provider failure sends an error event and counts nothing", func(t *testing.T) {
+		fake.Err = errors.New("model unavailable")
+		w := postJSON(router, "/llm/stream", map[string]string{"message": "rice"}, cookie)
+		fake.Err = nil
+
+		events := parseSSE(t, w.Body.String())
+		if len(events) != 1 || events[0].Name != "error" {
+			t.Fatalf("Expected a single error event, got %+v", events)
+		}
+		var failure struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+		}
+		json.Unmarshal([]byte(events[0].Data), &failure)
+		if failure.Status != "error" || failure.Message != "model unavailable" {
+			t.Errorf("Expected the provider error, got %s", events[0].Data)
+		}
+
+		if n := countRows(t, "users_tracking", "user_id = ? AND meal_count = 1", userID); n != 1 {
+			t.Error("Expected meal_count to be unchanged")
+		}
+		if n := countRows(t, "quota_reservations", "user_id = ?", userID); n != 0 {
+			t.Errorf("Expected the reservation to be released, found %d", n)
+		}
+	})
+
+	t.Run("3. A stream that fails part way records the tokens spent", func(t *testing.T) {
+		fake.Err = errors.New("connection reset")
+		fake.FailAfter = 2
+		w := postJSON(router, "/llm/stream", map[string]string{"message": "rice"}, cookie)
+		fake.Err, fake.FailAfter = nil, 0
+
+		events := parseSSE(t, w.Body.String())
+		if len(events) != 3 || events[0].Name != "delta" || events[1].Name != "delta" || events[2].Name != "error" {
+			t.Fatalf("Expected two deltas and an error event, got %+v", events)
+		}
+
+		where := "user_id = ? AND endpoint = '/llm/stream' AND succeeded = 0 AND input_tokens > 0 AND output_tokens = 2"
+		if n := countRows(t, "llm_usage_events", where, userID); n != 1 {
+			t.Errorf("Expected the partial usage to be recorded, found %d", n)
+		}
+		where = "user_id = ? AND status = 'failed' AND output_tokens = 2 AND error = 'connection reset'"
+		if n := countRows(t, "generation_log", where, userID); n != 1 {
+			t.Errorf("Expected the failed generation to be logged with its tokens, found %d", n)
+		}
+		if n := countRows(t, "users_tracking", "user_id = ? AND meal_count = 1", userID); n != 1 {
+			t.Error("Expected meal_count to be unchanged")
+		}
+	})
+}
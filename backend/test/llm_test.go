@@ -0,0 +1,78 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+
+	"backend/llm"
+)
+
+// TestE2E_MealGeneration tests /llm against the fake provider
+func TestE2E_MealGeneration(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+	fake := useFakeLLM(t)
+
+	email := "llm_user@example.com"
+	defer cleanupTestDB(t, email)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"})
+	userID := registeredUserID(t, w)
+	cookie := tokenCookie(w)
+	markEmailVerified(t, email)
+
+	type mealResponse struct {
+		Response string `json:"response"`
+		Recipe   struct {
+			Title string `json:"title"`
+		} `json:"recipe"`
+		Usage struct {
+			Used      int `json:"used"`
+			Remaining int `json:"remaining"`
+			Limit     int `json:"limit"`
+		} `json:"usage"`
+	}
+
+	t.Run("1. The fake provider answers deterministically", func(t *testing.T) {
+		w := postJSON(router, "/llm", map[string]string{"message": "leftover rice and eggs"}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp mealResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+
+		if resp.Recipe.Title != "Fake recipe" || !strings.Contains(resp.Response, "Fake recipe") ||
+			!strings.Contains(resp.Response, "leftover rice and eggs") {
+			t.Errorf("Expected the fake recipe for the request, got %s", w.Body.String())
+		}
+		if resp.Usage.Used != 1 || resp.Usage.Remaining != resp.Usage.Limit-1 {
+			t.Errorf("Expected one meal used, got %+v", resp.Usage)
+		}
+
+		if len(fake.Requests) != 1 {
+			t.Fatalf("Expected one call to the provider, got %d", len(fake.Requests))
+		}
+		sent := fake.Requests[0]
+		if sent.System == "" || len(sent.Messages) != 1 || sent.Messages[0].Role != llm.RoleUser ||
+			!strings.HasPrefix(sent.Messages[0].Content, "leftover rice and eggs") {
+			t.Errorf("Expected the prompt to be sent as one user message, got %+v", sent)
+		}
+	})
+
+	t.Run("2. Each generation counts one meal", func(t *testing.T) {
+		w := postJSON(router, "/llm", map[string]string{"message": "tofu"}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp mealResponse
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Usage.Used != 2 {
+			t.Errorf("Expected 2 meals used, got %+v", resp.Usage)
+		}
+		if n := countRows(t, "users_tracking", "user_id = ? AND meal_count = 2", userID); n != 1 {
+			t.Error("Expected meal_count to be 2")
+		}
+	})
+}
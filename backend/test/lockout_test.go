@@ -0,0 +1,145 @@
+package test
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"backend/auth"
+	"backend/ratelimit"
+	db "backend/database"
+)
+
+// useLoginGuard swaps the login guard for the test
+func useLoginGuard(t *testing.T, guard *auth.LoginGuard) {
+	previous := auth.Guard
+	auth.Guard = guard
+	t.Cleanup(func() { auth.Guard = previous })
+}
+
+// TestMemoryStore_Sweep tests that keys which are never hit again are
+// dropped once their events expire
+func TestMemoryStore_Sweep(t *testing.T) {
+	ctx := context.Background()
+	store := ratelimit.NewMemoryStore()
+	start := time.Now()
+
+	// One key per sprayed email, as Guard.Fail records them
+	for i := 0; i < 100; i++ {
+		if err := store.Hit(ctx, fmt.Sprintf("login:account:user%d@example.com", i), start, time.Minute); err != nil {
+			t.Fatalf("Hit failed: %v", err)
+		}
+	}
+	store.Hit(ctx, "login:ip:198.51.100.1", start, time.Hour)
+	if n := store.Len(); n != 101 {
+		t.Fatalf("Expected 101 keys, got %d", n)
+	}
+
+	// Keys are kept until they expire
+	store.Hit(ctx, "login:ip:198.51.100.2", start.Add(30*time.Second), time.Minute)
+	if n := store.Len(); n != 102 {
+		t.Errorf("Expected no keys to be dropped yet, got %d", n)
+	}
+
+	later := start.Add(2 * time.Minute)
+	store.Hit(ctx, "login:ip:198.51.100.3", later, time.Minute)
+	if n := store.Len(); n != 2 {
+		t.Errorf("Expected only the hour-long and the new key to be left, got %d", n)
+	}
+	if count, _, _ := store.Recent(ctx, "login:ip:198.51.100.1", later, time.Hour); count != 1 {
+		t.Errorf("Expected the key with a longer window to keep its event, got %d", count)
+	}
+}
+
+// TestE2E_LoginLockout tests backoff, lockout and admin unlock
+func TestE2E_LoginLockout(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+
+	testEmail := "lockout_test@example.com"
+	testPassword := "testpass123"
+	defer cleanupTestDB(t, testEmail)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": testEmail, "password": testPassword})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Register failed: %d %s", w.Code, w.Body.String())
+	}
+	userID := registeredUserID(t, w)
+
+	login := func(password string) *httptest.ResponseRecorder {
+		return postJSON(router, "/auth/login", map[string]string{"email": testEmail, "password": password})
+	}
+
+	t.Run("1. Backoff after free attempts", func(t *testing.T) {
+		guard := auth.NewLoginGuard(ratelimit.NewMemoryStore())
+		guard.FreeAttempts = 2
+		guard.BaseBackoff = time.Hour
+		useLoginGuard(t, guard)
+
+		for i := 0; i < 2; i++ {
+			if w := login("wrong"); w.Code != http.StatusUnauthorized {
+				t.Fatalf("Attempt %d: expected 401, got %d", i+1, w.Code)
+			}
+		}
+
+		w := login(testPassword)
+		if w.Code != http.StatusTooManyRequests {
+			t.Fatalf("Expected 429 during backoff, got %d", w.Code)
+		}
+		if w.Header().Get("Retry-After") == "" {
+			t.Error("Expected Retry-After header")
+		}
+	})
+
+	t.Run("2. Lock after repeated failures", func(t *testing.T) {
+		guard := auth.NewLoginGuard(ratelimit.NewMemoryStore())
+		guard.FreeAttempts = 100
+		guard.LockAfter = 3
+		useLoginGuard(t, guard)
+
+		for i := 0; i < 2; i++ {
+			if w := login("wrong"); w.Code != http.StatusUnauthorized {
+				t.Fatalf("Attempt %d: expected 401, got %d", i+1, w.Code)
+			}
+		}
+		if w := login("wrong"); w.Code != http.StatusLocked {
+			t.Fatalf("Expected 423 when lock triggers, got %d", w.Code)
+		}
+
+		// Even the right password is refused while locked
+		if w := login(testPassword); w.Code != http.StatusLocked {
+			t.Fatalf("Expected 423 while locked, got %d", w.Code)
+		}
+
+		var entries int
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		db.DB.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM audit_log WHERE user_id = ? AND action = 'account_locked'", userID,
+		).Scan(&entries)
+		if entries != 1 {
+			t.Errorf("Expected 1 audit entry, got %d", entries)
+		}
+	})
+
+	t.Run("3. Admin unlock", func(t *testing.T) {
+		path := fmt.Sprintf("/admin/users/%d/unlock", userID)
+
+		if w := postJSON(router, path, nil); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected 401 without a session, got %d", w.Code)
+		}
+
+		staffCookie := registerStaff(t, router, "lockout_support@example.com", auth.RoleSupport)
+		w := postJSON(router, path, nil, staffCookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Unlock failed: %d %s", w.Code, w.Body.String())
+		}
+
+		if w := login(testPassword); w.Code != http.StatusOK {
+			t.Fatalf("Expected login to work after unlock, got %d", w.Code)
+		}
+	})
+}
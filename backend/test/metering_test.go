@@ -0,0 +1,268 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/audit"
+	"backend/auth"
+	db "backend/database"
+	"backend/llm"
+)
+
+// TestMetering_Cost tests model price lookup
+func TestMetering_Cost(t *testing.T) {
+	usage := llm.Usage{InputTokens: 1_000_000, OutputTokens: 100_000}
+	if cost := llm.CostMicros("claude-sonnet-4-5-20250929", usage); cost != 4_500_000 {
+		t.Errorf("Expected $4.50 for Sonnet 4.5, got %d micros", cost)
+	}
+	if cost := llm.CostMicros("claude-opus-4-1-20250805", usage); cost != 22_500_000 {
+		t.Errorf("Expected the longest matching price for Opus 4.1, got %d micros", cost)
+	}
+	if _, ok := llm.PriceOf("unknown-model"); ok {
+		t.Error("Expected no price for an unknown model")
+	}
+	if cost := llm.CostMicros("unknown-model", usage); cost != 0 {
+		t.Errorf("Expected unknown models to cost nothing, got %d", cost)
+	}
+}
+
+// TestE2E_UsageMetering tests the token ledger, its aggregates and token
+// and cost limits
+func TestE2E_UsageMetering(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+	fake := useFakeLLM(t)
+
+	previous := llm.Prices["fake-model"]
+	llm.Prices["fake-model"] = llm.Price{InputPerMTok: 1, OutputPerMTok: 2}
+	defer func() { llm.Prices["fake-model"] = previous }()
+
+	email := "metering_user@example.com"
+	defer cleanupTestDB(t, email)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"})
+	userID := registeredUserID(t, w)
+	cookie := tokenCookie(w)
+	markEmailVerified(t, email)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ledger := func(t *testing.T) (requests int, tokens, cost int64) {
+		err := db.DB.QueryRowContext(ctx,
+			`SELECT COUNT(*), COALESCE(SUM(input_tokens + output_tokens), 0), COALESCE(SUM(cost_micros), 0)
+			 FROM llm_usage_events WHERE user_id = ?`, userID,
+		).Scan(&requests, &tokens, &cost)
+		if err != nil {
+			t.Fatalf("Failed to read the ledger: %v", err)
+		}
+		return
+	}
+
+	type usageResponse struct {
+		Used      int `json:"used"`
+		Remaining int `json:"remaining"`
+		Tokens    struct {
+			Used  int64  `json:"used"`
+			Limit *int64 `json:"limit"`
+		} `json:"tokens"`
+		Cost struct {
+			Used  float64  `json:"used"`
+			Limit *float64 `json:"limit"`
+		} `json:"cost_usd"`
+	}
+	getUsage := func(t *testing.T) usageResponse {
+		w := getWithCookies(router, "/api/usage", cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Usage failed: %d %s", w.Code, w.Body.String())
+		}
+		var usage usageResponse
+		json.Unmarshal(w.Body.Bytes(), &usage)
+		return usage
+	}
+
+	t.Run("1. Every model call is recorded with its cost", func(t *testing.T) {
+		if w := postJSON(router, "/llm", map[string]string{"message": "rice and tofu"}, cookie); w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if w := postJSON(router, "/llm/stream", map[string]string{"message": "rice and tofu"}, cookie); w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+
+		var input, output, cost int64
+		err := db.DB.QueryRowContext(ctx,
+			`SELECT input_tokens, output_tokens, cost_micros FROM llm_usage_events
+			 WHERE user_id = ? AND endpoint = '/llm/stream' AND model = 'fake-model' AND succeeded = 1`, userID,
+		).Scan(&input, &output, &cost)
+		if err != nil {
+			t.Fatalf("Expected the streamed call in the ledger: %v", err)
+		}
+		if input == 0 || output == 0 || cost != input+2*output {
+			t.Errorf("Expected the cost to follow the price, got %d in, %d out, %d micros", input, output, cost)
+		}
+
+		requests, tokens, total := ledger(t)
+		if requests != 2 {
+			t.Fatalf("Expected 2 ledger entries, got %d", requests)
+		}
+		usage := getUsage(t)
+		if usage.Tokens.Used != tokens || usage.Tokens.Limit != nil || usage.Cost.Limit != nil {
+			t.Errorf("Expected %d tokens without limits, got %+v", tokens, usage)
+		}
+		if usage.Cost.Used != float64(total)/1e6 {
+			t.Errorf("Expected $%f, got %+v", float64(total)/1e6, usage)
+		}
+	})
+
+	t.Run("2. Rejected recipes are recorded but not counted as meals", func(t *testing.T) {
+		fake.ToolReply = func(req llm.Request) json.RawMessage { return json.RawMessage(`{"title": ""}`) }
+		w := postJSON(router, "/llm", map[string]string{"message": "rice"}, cookie)
+		fake.ToolReply = nil
+		if w.Code != http.StatusBadGateway {
+			t.Fatalf("Expected 502, got %d", w.Code)
+		}
+		if n := countRows(t, "llm_usage_events", "user_id = ? AND succeeded = 0 AND input_tokens > 0", userID); n != 1 {
+			t.Errorf("Expected the rejected call in the ledger, found %d", n)
+		}
+		if usage := getUsage(t); usage.Used != 2 {
+			t.Errorf("Expected 2 meals, got %d", usage.Used)
+		}
+
+		// A retry that fails outright still leaves the rejected attempt's
+		// tokens in the ledger
+		fake.ToolReply = func(req llm.Request) json.RawMessage {
+			fake.Err = errors.New("model unavailable")
+			return json.RawMessage(`{"title": ""}`)
+		}
+		w = postJSON(router, "/llm", map[string]string{"message": "rice"}, cookie)
+		fake.ToolReply, fake.Err = nil, nil
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected 500, got %d", w.Code)
+		}
+		if n := countRows(t, "llm_usage_events", "user_id = ? AND succeeded = 0 AND input_tokens > 0", userID); n != 2 {
+			t.Errorf("Expected the failed retry's tokens in the ledger, found %d rejected calls", n)
+		}
+		if n := countRows(t, "generation_log", "user_id = ? AND status = 'failed' AND input_tokens > 0", userID); n != 1 {
+			t.Errorf("Expected the failed retry in the generation log with its tokens, found %d", n)
+		}
+	})
+
+	t.Run("3. Usage is aggregated by day and model", func(t *testing.T) {
+		w := getWithCookies(router, "/api/usage/breakdown", cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var report struct {
+			Days []struct {
+				Day      string `json:"day"`
+				Model    string `json:"model"`
+				Requests int    `json:"requests"`
+			} `json:"days"`
+			ByModel []struct {
+				Model    string `json:"model"`
+				Requests int    `json:"requests"`
+			} `json:"by_model"`
+			Totals struct {
+				Requests     int   `json:"requests"`
+				InputTokens  int64 `json:"input_tokens"`
+				OutputTokens int64 `json:"output_tokens"`
+			} `json:"totals"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &report)
+
+		requests, tokens, _ := ledger(t)
+		today := time.Now().UTC().Format(time.DateOnly)
+		if len(report.Days) != 1 || report.Days[0].Day != today || report.Days[0].Model != "fake-model" {
+			t.Errorf("Expected one row for today and fake-model, got %s", w.Body.String())
+		}
+		if len(report.ByModel) != 1 || report.Totals.Requests != requests ||
+			report.Totals.InputTokens+report.Totals.OutputTokens != tokens {
+			t.Errorf("Expected totals matching the ledger, got %s", w.Body.String())
+		}
+
+		if w := getWithCookies(router, "/api/usage/breakdown?from=2020-01-01&to=2020-01-31", cookie); w.Code != http.StatusOK ||
+			!strings.Contains(w.Body.String(), `"days":[]`) {
+			t.Errorf("Expected an empty report for a past month, got %d %s", w.Code, w.Body.String())
+		}
+		for _, query := range []string{"from=yesterday", "from=2024-02-01&to=2024-01-01", "from=2020-01-01&to=2024-01-01"} {
+			if w := getWithCookies(router, "/api/usage/breakdown?"+query, cookie); w.Code != http.StatusBadRequest {
+				t.Errorf("Expected 400 for %s, got %d", query, w.Code)
+			}
+		}
+	})
+
+	t.Run("4. Admins see usage across users", func(t *testing.T) {
+		adminCookie := registerStaff(t, router, "metering_admin@example.com", auth.RoleAdmin)
+
+		w := getWithCookies(router, "/admin/usage", cookie)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected users to be refused, got %d", w.Code)
+		}
+
+		w = getWithCookies(router, fmt.Sprintf("/admin/usage?user_id=%d", userID), adminCookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var report struct {
+			Totals struct {
+				Requests int `json:"requests"`
+			} `json:"totals"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &report)
+		if requests, _, _ := ledger(t); report.Totals.Requests != requests {
+			t.Errorf("Expected %d requests, got %s", requests, w.Body.String())
+		}
+		if auditCount(t, userID, audit.ActionUsageReported) != 1 {
+			t.Error("Expected the report to be audited")
+		}
+	})
+
+	t.Run("5. Token limits stop new generations", func(t *testing.T) {
+		adminCookie := registerStaff(t, router, "metering_admin2@example.com", auth.RoleAdmin)
+		_, tokens, _ := ledger(t)
+
+		w := putJSON(router, fmt.Sprintf("/admin/users/%d/quota", userID), map[string]int64{"max_tokens": tokens}, adminCookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+
+		w = postJSON(router, "/llm", map[string]string{"message": "rice"}, cookie)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "tokens") {
+			t.Errorf("Expected the token limit to apply, got %d %s", w.Code, w.Body.String())
+		}
+		usage := getUsage(t)
+		if usage.Remaining != 0 || usage.Tokens.Limit == nil || *usage.Tokens.Limit != tokens {
+			t.Errorf("Expected no meals remaining under the token limit, got %+v", usage)
+		}
+		if n := countRows(t, "users_tracking", "user_id = ? AND meals_override IS NULL", userID); n != 1 {
+			t.Error("Expected the meal allowance to be left alone")
+		}
+	})
+
+	t.Run("6. Cost limits stop new generations", func(t *testing.T) {
+		adminCookie := registerStaff(t, router, "metering_admin3@example.com", auth.RoleAdmin)
+		putJSON(router, fmt.Sprintf("/admin/users/%d/quota", userID), map[string]int64{"max_tokens": 1_000_000}, adminCookie)
+		if w := postJSON(router, "/llm", map[string]string{"message": "rice"}, cookie); w.Code != http.StatusOK {
+			t.Fatalf("Expected a higher token limit to allow generations, got %d", w.Code)
+		}
+
+		w := putJSON(router, fmt.Sprintf("/admin/users/%d/quota", userID), map[string]float64{"max_cost_usd": 0.0001}, adminCookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		w = postJSON(router, "/llm", map[string]string{"message": "rice"}, cookie)
+		if w.Code != http.StatusForbidden || !strings.Contains(w.Body.String(), "model usage") {
+			t.Errorf("Expected the cost limit to apply, got %d %s", w.Code, w.Body.String())
+		}
+		if usage := getUsage(t); usage.Cost.Limit == nil || *usage.Cost.Limit != 0.0001 {
+			t.Errorf("Expected a $0.0001 limit, got %+v", usage)
+		}
+	})
+}
@@ -0,0 +1,214 @@
+package test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	db "backend/database"
+)
+
+// schemaSnapshot returns the definition of every table and index, keyed by
+// name
+func schemaSnapshot(t *testing.T) map[string]string {
+	rows, err := db.DB.Query("SELECT name, COALESCE(sql, '') FROM sqlite_master WHERE type IN ('table', 'index') AND name NOT LIKE 'sqlite_%'")
+	if err != nil {
+		t.Fatalf("Failed to read the schema: %v", err)
+	}
+	defer rows.Close()
+
+	schema := map[string]string{}
+	for rows.Next() {
+		var name, sql string
+		if err := rows.Scan(&name, &sql); err != nil {
+			t.Fatalf("Failed to read the schema: %v", err)
+		}
+		schema[name] = sql
+	}
+	return schema
+}
+
+// diffSchema describes how got differs from want
+func diffSchema(want, got map[string]string) []string {
+	var diffs []string
+	for name, sql := range want {
+		if other, ok := got[name]; !ok {
+			diffs = append(diffs, "missing "+name)
+		} else if other != sql {
+			diffs = append(diffs, "changed "+name+":\n"+sql+"\n=>\n"+other)
+		}
+	}
+	for name := range got {
+		if _, ok := want[name]; !ok {
+			diffs = append(diffs, "unexpected "+name)
+		}
+	}
+	return diffs
+}
+
+// TestMigrations tests the migration runner against an empty database
+func TestMigrations(t *testing.T) {
+	if db.DB != nil {
+		db.DB.Close()
+	}
+	if err := db.Open(":memory:"); err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	migrations, err := db.LoadMigrations()
+	if err != nil {
+		t.Fatalf("Failed to load migrations: %v", err)
+	}
+	for i, m := range migrations {
+		if m.Version != i+1 || m.Name == "" || strings.TrimSpace(m.Up) == "" || strings.TrimSpace(m.Down) == "" {
+			t.Errorf("Expected migration %d to have both directions, got %+v", i+1, m)
+		}
+	}
+
+	t.Run("1. Status lists every migration as pending", func(t *testing.T) {
+		states, err := db.MigrationStatus(ctx)
+		if err != nil {
+			t.Fatalf("Failed to get status: %v", err)
+		}
+		if len(states) != len(migrations) {
+			t.Fatalf("Expected %d migrations, got %d", len(migrations), len(states))
+		}
+		for i, s := range states {
+			if s.Version != migrations[i].Version || s.Name != migrations[i].Name || s.Applied() {
+				t.Errorf("Expected %04d_%s to be pending, got %+v", migrations[i].Version, migrations[i].Name, s)
+			}
+		}
+	})
+
+	var full map[string]string
+	t.Run("2. Up applies every migration once", func(t *testing.T) {
+		applied, err := db.MigrateUp(ctx)
+		if err != nil {
+			t.Fatalf("Failed to migrate: %v", err)
+		}
+		if len(applied) != len(migrations) {
+			t.Errorf("Expected %d migrations to be applied, got %d", len(migrations), len(applied))
+		}
+		full = schemaSnapshot(t)
+
+		applied, err = db.MigrateUp(ctx)
+		if err != nil || len(applied) != 0 {
+			t.Errorf("Expected a second run to do nothing, got %d applied, err %v", len(applied), err)
+		}
+		if diffs := diffSchema(full, schemaSnapshot(t)); len(diffs) != 0 {
+			t.Errorf("Expected a second run to leave the schema alone:\n%s", strings.Join(diffs, "\n"))
+		}
+
+		states, _ := db.MigrationStatus(ctx)
+		for _, s := range states {
+			if !s.Applied() {
+				t.Errorf("Expected %04d_%s to be applied", s.Version, s.Name)
+			}
+		}
+		if n := countRows(t, "schema_migrations_lock", "1 = 1"); n != 0 {
+			t.Errorf("Expected the lock to be released, found %d", n)
+		}
+	})
+
+	t.Run("3. Down rolls back the last n migrations", func(t *testing.T) {
+		rolledBack, err := db.MigrateDown(ctx, 2)
+		if err != nil {
+			t.Fatalf("Failed to roll back: %v", err)
+		}
+		last := len(migrations) - 1
+		if len(rolledBack) != 2 || rolledBack[0].Version != migrations[last].Version || rolledBack[1].Version != migrations[last-1].Version {
+			t.Fatalf("Expected the last two migrations newest first, got %+v", rolledBack)
+		}
+
+		states, _ := db.MigrationStatus(ctx)
+		for i, s := range states {
+			if s.Applied() != (i < last-1) {
+				t.Errorf("Unexpected state for %04d_%s: applied %v", s.Version, s.Name, s.Applied())
+			}
+		}
+
+		applied, err := db.MigrateUp(ctx)
+		if err != nil || len(applied) != 2 {
+			t.Fatalf("Expected the two migrations to be reapplied, got %d, err %v", len(applied), err)
+		}
+		if diffs := diffSchema(full, schemaSnapshot(t)); len(diffs) != 0 {
+			t.Errorf("Expected the schema to be restored:\n%s", strings.Join(diffs, "\n"))
+		}
+	})
+
+	t.Run("4. Every migration round-trips", func(t *testing.T) {
+		// schemas[n] is the schema with the first n migrations applied
+		schemas := make([]map[string]string, len(migrations)+1)
+		schemas[len(migrations)] = schemaSnapshot(t)
+		for n := len(migrations) - 1; n >= 0; n-- {
+			m := migrations[n]
+			if _, err := db.MigrateDown(ctx, 1); err != nil {
+				t.Fatalf("Rollback of %04d_%s failed: %v", m.Version, m.Name, err)
+			}
+			schemas[n] = schemaSnapshot(t)
+		}
+
+		empty := schemas[0]
+		want := map[string]string{"schema_migrations": empty["schema_migrations"], "schema_migrations_lock": empty["schema_migrations_lock"]}
+		if diffs := diffSchema(want, empty); len(diffs) != 0 {
+			t.Errorf("Expected only the migration tables to be left:\n%s", strings.Join(diffs, "\n"))
+		}
+
+		// Roll back and reapply the last k migrations for every k, so each
+		// down also runs against a schema its up rebuilt
+		for k := 0; k <= len(migrations); k++ {
+			if _, err := db.MigrateUp(ctx); err != nil {
+				t.Fatalf("Reapplying the last %d migrations failed: %v", k, err)
+			}
+			if diffs := diffSchema(full, schemaSnapshot(t)); len(diffs) != 0 {
+				t.Errorf("Reapplying the last %d migrations changed the schema:\n%s", k, strings.Join(diffs, "\n"))
+			}
+			if k == len(migrations) {
+				break
+			}
+
+			n := len(migrations) - k - 1
+			m := migrations[n]
+			if _, err := db.MigrateDown(ctx, k+1); err != nil {
+				t.Fatalf("Rolling back to before %04d_%s failed: %v", m.Version, m.Name, err)
+			}
+			if diffs := diffSchema(schemas[n], schemaSnapshot(t)); len(diffs) != 0 {
+				t.Errorf("%04d_%s does not round-trip:\n%s", m.Version, m.Name, strings.Join(diffs, "\n"))
+			}
+		}
+	})
+
+	t.Run("5. A held lock is refused and a stale one is cleared", func(t *testing.T) {
+		timeout := db.MigrationLockTimeout
+		db.MigrationLockTimeout = time.Second
+		defer func() { db.MigrationLockTimeout = timeout }()
+
+		if _, err := db.DB.Exec("INSERT INTO schema_migrations_lock (id, owner) VALUES (1, 'other-instance')"); err != nil {
+			t.Fatalf("Failed to take the lock: %v", err)
+		}
+		if _, err := db.MigrateDown(ctx, 1); !errors.Is(err, db.ErrMigrationLocked) {
+			t.Errorf("Expected ErrMigrationLocked from down, got %v", err)
+		}
+		if _, err := db.MigrateUp(ctx); !errors.Is(err, db.ErrMigrationLocked) {
+			t.Errorf("Expected ErrMigrationLocked from up, got %v", err)
+		}
+		if diffs := diffSchema(full, schemaSnapshot(t)); len(diffs) != 0 {
+			t.Errorf("Expected nothing to change while locked:\n%s", strings.Join(diffs, "\n"))
+		}
+
+		if _, err := db.DB.Exec("UPDATE schema_migrations_lock SET locked_at = datetime('now', '-1 hour')"); err != nil {
+			t.Fatalf("Failed to age the lock: %v", err)
+		}
+		if _, err := db.MigrateUp(ctx); err != nil {
+			t.Errorf("Expected a stale lock to be cleared, got %v", err)
+		}
+		if n := countRows(t, "schema_migrations_lock", "1 = 1"); n != 0 {
+			t.Errorf("Expected the lock to be released, found %d", n)
+		}
+	})
+}
@@ -0,0 +1,443 @@
+package test
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+
+	"backend/auth"
+	db "backend/database"
+	"backend/oidc"
+)
+
+const (
+	mockClientID     = "ai-ceo"
+	mockClientSecret = "mock-secret"
+	mockRedirectURL  = "http://localhost:8080/auth/oidc/mock/callback"
+)
+
+// mockGrant is an authorization code issued by the mock provider
+type mockGrant struct {
+	nonce, challenge, redirectURI string
+	subject, email                string
+	emailVerified                 bool
+}
+
+// mockIdP is a minimal OpenID Connect provider. The authorize endpoint logs
+// in as the configured user without showing a consent screen.
+type mockIdP struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu            sync.Mutex
+	subject       string
+	email         string
+	emailVerified bool
+	// nonce, when set, replaces the nonce in issued ID tokens
+	nonce  string
+	codes  map[string]mockGrant
+	issued int
+}
+
+func newMockIdP(t *testing.T) *mockIdP {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	idp := &mockIdP{key: key, codes: map[string]mockGrant{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/jwks", idp.jwks)
+	idp.server = httptest.NewServer(mux)
+	t.Cleanup(idp.server.Close)
+
+	if err := oidc.InitProviders([]oidc.Config{{
+		Name:         "mock",
+		Issuer:       idp.server.URL,
+		ClientID:     mockClientID,
+		ClientSecret: mockClientSecret,
+		RedirectURL:  mockRedirectURL,
+	}}); err != nil {
+		t.Fatalf("Failed to configure provider: %v", err)
+	}
+	t.Cleanup(func() { oidc.InitProviders(nil) })
+	return idp
+}
+
+// loginAs sets the user the next authorization is issued for
+func (idp *mockIdP) loginAs(subject, email string, verified bool) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.subject, idp.email, idp.emailVerified = subject, email, verified
+}
+
+func (idp *mockIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{
+		"issuer":                           idp.server.URL,
+		"authorization_endpoint":           idp.server.URL + "/authorize",
+		"token_endpoint":                   idp.server.URL + "/token",
+		"jwks_uri":                         idp.server.URL + "/jwks",
+		"code_challenge_methods_supported": []string{"S256"},
+	})
+}
+
+func (idp *mockIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != mockClientID || q.Get("response_type") != "code" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" ||
+		!strings.Contains(q.Get("scope"), "openid") {
+		http.Error(w, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	idp.mu.Lock()
+	idp.issued++
+	code := fmt.Sprintf("code-%d", idp.issued)
+	idp.codes[code] = mockGrant{
+		nonce:         q.Get("nonce"),
+		challenge:     q.Get("code_challenge"),
+		redirectURI:   q.Get("redirect_uri"),
+		subject:       idp.subject,
+		email:         idp.email,
+		emailVerified: idp.emailVerified,
+	}
+	idp.mu.Unlock()
+
+	back := q.Get("redirect_uri") + "?" + url.Values{"code": {code}, "state": {q.Get("state")}}.Encode()
+	http.Redirect(w, r, back, http.StatusFound)
+}
+
+func (idp *mockIdP) token(w http.ResponseWriter, r *http.Request) {
+	id, secret, ok := r.BasicAuth()
+	if !ok || id != mockClientID || secret != mockClientSecret {
+		w.WriteHeader(http.StatusUnauthorized)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_client"})
+		return
+	}
+	r.ParseForm()
+
+	idp.mu.Lock()
+	grant, ok := idp.codes[r.PostForm.Get("code")]
+	delete(idp.codes, r.PostForm.Get("code"))
+	nonce := grant.nonce
+	if idp.nonce != "" {
+		nonce = idp.nonce
+	}
+	idp.mu.Unlock()
+
+	if !ok || r.PostForm.Get("grant_type") != "authorization_code" ||
+		r.PostForm.Get("redirect_uri") != grant.redirectURI ||
+		oidc.CodeChallenge(r.PostForm.Get("code_verifier")) != grant.challenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            idp.server.URL,
+		"aud":            mockClientID,
+		"sub":            grant.subject,
+		"email":          grant.email,
+		"email_verified": grant.emailVerified,
+		"nonce":          nonce,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+	})
+	token.Header["kid"] = "mock-1"
+	idToken, _ := token.SignedString(idp.key)
+
+	json.NewEncoder(w).Encode(map[string]any{
+		"access_token": "mock-access-token",
+		"token_type":   "Bearer",
+		"id_token":     idToken,
+		"expires_in":   300,
+	})
+}
+
+func (idp *mockIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]any{"keys": []map[string]string{{
+		"kty": "RSA",
+		"kid": "mock-1",
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(idp.key.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(idp.key.E)).Bytes()),
+	}}})
+}
+
+// oidcLogin starts a login at path, follows the provider's redirect and
+// returns the router's response to the callback
+func oidcLogin(t *testing.T, router *gin.Engine, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	w := getWithCookies(router, path, cookies...)
+	if w.Code != http.StatusFound {
+		t.Fatalf("Expected redirect to provider, got %d %s", w.Code, w.Body.String())
+	}
+	stateCookie := namedCookie(w, "oidc_state")
+
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := client.Get(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("Authorize request failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("Expected redirect from provider, got %d", resp.StatusCode)
+	}
+
+	callback, _ := url.Parse(resp.Header.Get("Location"))
+	return getWithCookies(router, callback.RequestURI(), stateCookie)
+}
+
+// requestWithCookies sends a request without a body
+func requestWithCookies(router http.Handler, method, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for _, cookie := range cookies {
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// markEmailVerified verifies an email without going through the mail flow
+func markEmailVerified(t *testing.T, email string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.DB.ExecContext(ctx, "UPDATE users SET email_verified_at = datetime('now') WHERE email = ?", email); err != nil {
+		t.Fatalf("Failed to verify email: %v", err)
+	}
+}
+
+// TestE2E_OIDCLogin tests login, sign-up, linking and the state and nonce
+// checks against a mock identity provider
+func TestE2E_OIDCLogin(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+	idp := newMockIdP(t)
+	t.Setenv("APP_BASE_URL", "http://app.test")
+
+	newEmail := "oidc_new@example.com"
+	localEmail := "oidc_local@example.com"
+	localPassword := "testpass123"
+	defer cleanupTestDB(t, newEmail)
+	defer cleanupTestDB(t, localEmail)
+
+	var newUserCookie *http.Cookie
+
+	t.Run("1. First login creates an account", func(t *testing.T) {
+		idp.loginAs("sub-new", newEmail, true)
+		w := oidcLogin(t, router, "/auth/oidc/mock/login?return_to=/recipes")
+		if w.Code != http.StatusFound {
+			t.Fatalf("Callback failed: %d %s", w.Code, w.Body.String())
+		}
+		if loc := w.Header().Get("Location"); loc != "http://app.test/recipes" {
+			t.Errorf("Expected redirect to the app, got %s", loc)
+		}
+
+		newUserCookie = tokenCookie(w)
+		w = getWithCookies(router, "/api/profile", newUserCookie)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), newEmail) {
+			t.Fatalf("Expected profile of new user, got %d %s", w.Code, w.Body.String())
+		}
+
+		login := postJSON(router, "/auth/login", map[string]string{"email": newEmail, "password": ""})
+		if login.Code == http.StatusOK {
+			t.Error("Expected password login to fail for a provider-only account")
+		}
+	})
+
+	t.Run("2. Later logins reuse the account", func(t *testing.T) {
+		w := oidcLogin(t, router, "/auth/oidc/mock/login")
+		if w.Code != http.StatusFound || tokenCookie(w) == nil {
+			t.Fatalf("Second login failed: %d %s", w.Code, w.Body.String())
+		}
+		if loc := w.Header().Get("Location"); loc != "http://app.test/" {
+			t.Errorf("Expected default return path, got %s", loc)
+		}
+
+		w = getWithCookies(router, "/api/identities", newUserCookie)
+		var list struct {
+			Identities []struct {
+				ID       int64  `json:"id"`
+				Provider string `json:"provider"`
+			} `json:"identities"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &list)
+		if len(list.Identities) != 1 || list.Identities[0].Provider != "mock" {
+			t.Errorf("Expected one linked identity, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("3. State must match and is single-use", func(t *testing.T) {
+		w := getWithCookies(router, "/auth/oidc/mock/login")
+		stateCookie := namedCookie(w, "oidc_state")
+		client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+		resp, err := client.Get(w.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Authorize request failed: %v", err)
+		}
+		resp.Body.Close()
+		callback, _ := url.Parse(resp.Header.Get("Location"))
+
+		if w := getWithCookies(router, callback.RequestURI()); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 without state cookie, got %d", w.Code)
+		}
+		if w := getWithCookies(router, callback.RequestURI(), stateCookie); w.Code != http.StatusFound {
+			t.Fatalf("Expected callback with state cookie to succeed, got %d %s", w.Code, w.Body.String())
+		}
+		if w := getWithCookies(router, callback.RequestURI(), stateCookie); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected replayed callback to be rejected, got %d", w.Code)
+		}
+	})
+
+	t.Run("4. ID tokens with the wrong nonce are rejected", func(t *testing.T) {
+		idp.mu.Lock()
+		idp.nonce = "someone-elses-nonce"
+		idp.mu.Unlock()
+		defer func() {
+			idp.mu.Lock()
+			idp.nonce = ""
+			idp.mu.Unlock()
+		}()
+
+		if w := oidcLogin(t, router, "/auth/oidc/mock/login"); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("5. Existing accounts link only with a verified email", func(t *testing.T) {
+		w := postJSON(router, "/auth/register", map[string]string{"email": localEmail, "password": localPassword})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Register failed: %d %s", w.Code, w.Body.String())
+		}
+		localID := registeredUserID(t, w)
+
+		idp.loginAs("sub-local", localEmail, true)
+		if w := oidcLogin(t, router, "/auth/oidc/mock/login"); w.Code != http.StatusConflict {
+			t.Fatalf("Expected 409 while the local email is unverified, got %d", w.Code)
+		}
+
+		markEmailVerified(t, localEmail)
+		w = oidcLogin(t, router, "/auth/oidc/mock/login")
+		if w.Code != http.StatusFound {
+			t.Fatalf("Expected login to link the verified account, got %d %s", w.Code, w.Body.String())
+		}
+		if auditCount(t, localID, "identity_linked") != 1 {
+			t.Error("Expected linking to be audited")
+		}
+	})
+
+	t.Run("6. Linking from settings", func(t *testing.T) {
+		login := postJSON(router, "/auth/login", map[string]string{"email": localEmail, "password": localPassword})
+		localCookie := tokenCookie(login)
+
+		idp.loginAs("sub-new", newEmail, true)
+		if w := oidcLogin(t, router, "/auth/oidc/mock/link", localCookie); w.Code != http.StatusConflict {
+			t.Errorf("Expected 409 linking another user's identity, got %d", w.Code)
+		}
+
+		idp.loginAs("sub-local-2", "other@example.com", false)
+		w := oidcLogin(t, router, "/auth/oidc/mock/link?return_to=/settings", localCookie)
+		if w.Code != http.StatusFound || w.Header().Get("Location") != "http://app.test/settings" {
+			t.Fatalf("Link failed: %d %s", w.Code, w.Body.String())
+		}
+		if tokenCookie(w) != nil {
+			t.Error("Expected linking to keep the current session")
+		}
+
+		w = getWithCookies(router, "/api/identities", localCookie)
+		var list struct {
+			Identities []struct {
+				ID int64 `json:"id"`
+			} `json:"identities"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &list)
+		if len(list.Identities) != 2 {
+			t.Fatalf("Expected two identities, got %s", w.Body.String())
+		}
+
+		w = requestWithCookies(router, "DELETE", fmt.Sprintf("/api/identities/%d", list.Identities[1].ID), localCookie)
+		if w.Code != http.StatusOK {
+			t.Errorf("Unlink failed: %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("7. The only sign-in method cannot be unlinked", func(t *testing.T) {
+		w := getWithCookies(router, "/api/identities", newUserCookie)
+		var list struct {
+			Identities []struct {
+				ID int64 `json:"id"`
+			} `json:"identities"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &list)
+		if len(list.Identities) != 1 {
+			t.Fatalf("Expected one identity, got %s", w.Body.String())
+		}
+
+		w = requestWithCookies(router, "DELETE", fmt.Sprintf("/api/identities/%d", list.Identities[0].ID), newUserCookie)
+		if w.Code != http.StatusConflict {
+			t.Errorf("Expected 409, got %d", w.Code)
+		}
+	})
+
+	t.Run("8. Return paths stay on the app", func(t *testing.T) {
+		idp.loginAs("sub-new", newEmail, true)
+		w := oidcLogin(t, router, "/auth/oidc/mock/login?return_to=//evil.example.com")
+		if loc := w.Header().Get("Location"); loc != "http://app.test/" {
+			t.Errorf("Expected open redirect to be refused, got %s", loc)
+		}
+	})
+
+	t.Run("9. Unknown providers", func(t *testing.T) {
+		if w := getWithCookies(router, "/auth/oidc/nope/login"); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("10. Locked accounts cannot sign in with a provider", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		var userID int64
+		if err := db.DB.QueryRowContext(ctx, "SELECT id FROM users WHERE email = ?", newEmail).Scan(&userID); err != nil {
+			t.Fatalf("Failed to find user: %v", err)
+		}
+		if err := auth.LockAccount(ctx, userID, time.Hour); err != nil {
+			t.Fatalf("Failed to lock account: %v", err)
+		}
+
+		idp.loginAs("sub-new", newEmail, true)
+		w := oidcLogin(t, router, "/auth/oidc/mock/login")
+		if w.Code != http.StatusLocked || tokenCookie(w) != nil {
+			t.Errorf("Expected 423 without a session, got %d %s", w.Code, w.Body.String())
+		}
+
+		if err := auth.UnlockAccount(ctx, userID); err != nil {
+			t.Fatalf("Failed to unlock account: %v", err)
+		}
+		if w := oidcLogin(t, router, "/auth/oidc/mock/login"); w.Code != http.StatusFound || tokenCookie(w) == nil {
+			t.Errorf("Expected login after unlock, got %d %s", w.Code, w.Body.String())
+		}
+	})
+}
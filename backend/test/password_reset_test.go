@@ -0,0 +1,168 @@
+package test
+
+import (
+	"context"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+
+	"backend/auth"
+	"backend/mail"
+	"backend/ratelimit"
+)
+
+// captureSender records outgoing mail instead of delivering it
+type captureSender struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (s *captureSender) Send(ctx context.Context, msg mail.Message) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.messages = append(s.messages, msg)
+	return nil
+}
+
+// last returns the most recent message sent to the address
+func (s *captureSender) last(to string) *mail.Message {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := len(s.messages) - 1; i >= 0; i-- {
+		if s.messages[i].To == to {
+			return &s.messages[i]
+		}
+	}
+	return nil
+}
+
+// useCaptureSender swaps the default mail sender for the test
+func useCaptureSender(t *testing.T) *captureSender {
+	sender := &captureSender{}
+	previous := mail.Default
+	mail.Default = sender
+	t.Cleanup(func() { mail.Default = previous })
+	return sender
+}
+
+// useResetGuard swaps the forgot-password limiter for the test
+func useResetGuard(t *testing.T, guard *auth.ResetLimiter) {
+	previous := auth.ResetGuard
+	auth.ResetGuard = guard
+	t.Cleanup(func() { auth.ResetGuard = previous })
+}
+
+var tokenPattern = regexp.MustCompile(`token=([A-Za-z0-9_-]+)`)
+
+// TestE2E_PasswordReset tests the forgot/reset password flow
+func TestE2E_PasswordReset(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+	sender := useCaptureSender(t)
+	useResetGuard(t, auth.NewResetLimiter(ratelimit.NewMemoryStore()))
+
+	testEmail := "reset_test@example.com"
+	defer cleanupTestDB(t, testEmail)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": testEmail, "password": "oldpass123"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Register failed: %d %s", w.Code, w.Body.String())
+	}
+	oldSession := tokenCookie(w)
+
+	t.Run("1. Unknown email gets the same response", func(t *testing.T) {
+		w := postJSON(router, "/auth/forgot-password", map[string]string{"email": "nobody@example.com"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", w.Code)
+		}
+		if sender.last("nobody@example.com") != nil {
+			t.Error("Expected no mail for an unknown address")
+		}
+	})
+
+	t.Run("2. A failed email gets the same response", func(t *testing.T) {
+		mail.Default = nil
+		w := postJSON(router, "/auth/forgot-password", map[string]string{"email": testEmail})
+		mail.Default = sender
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "If that email is registered") {
+			t.Errorf("Expected the generic response, got %d %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("3. Reset password with emailed token", func(t *testing.T) {
+		if w := postJSON(router, "/auth/forgot-password", map[string]string{"email": testEmail}); w.Code != http.StatusOK {
+			t.Fatalf("Forgot password failed: %d %s", w.Code, w.Body.String())
+		}
+
+		msg := sender.last(testEmail)
+		if msg == nil {
+			t.Fatal("Expected a reset email")
+		}
+		match := tokenPattern.FindStringSubmatch(msg.Body)
+		if match == nil {
+			t.Fatalf("No token in email body: %s", msg.Body)
+		}
+		token := match[1]
+
+		w := postJSON(router, "/auth/reset-password", map[string]string{"token": token, "password": "newpass123"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Reset failed: %d %s", w.Code, w.Body.String())
+		}
+
+		// Token is single-use
+		w = postJSON(router, "/auth/reset-password", map[string]string{"token": token, "password": "another123"})
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected reused token to be rejected, got %d", w.Code)
+		}
+
+		// Old password no longer works, new one does
+		if w := postJSON(router, "/auth/login", map[string]string{"email": testEmail, "password": "oldpass123"}); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected old password to fail, got %d", w.Code)
+		}
+		if w := postJSON(router, "/auth/login", map[string]string{"email": testEmail, "password": "newpass123"}); w.Code != http.StatusOK {
+			t.Errorf("Expected new password to work, got %d", w.Code)
+		}
+
+		// Existing sessions were revoked
+		if w := getWithCookies(router, "/api/profile", oldSession); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected old session to be revoked, got %d", w.Code)
+		}
+	})
+	t.Run("4. Requests are limited per email and per IP", func(t *testing.T) {
+		guard := auth.NewResetLimiter(ratelimit.NewMemoryStore())
+		guard.MaxPerEmail = 2
+		guard.MaxPerIP = 4
+		useResetGuard(t, guard)
+
+		forgot := func(email string) int {
+			return postJSON(router, "/auth/forgot-password", map[string]string{"email": email}).Code
+		}
+
+		for i := 0; i < 2; i++ {
+			if code := forgot(testEmail); code != http.StatusOK {
+				t.Fatalf("Request %d: expected 200, got %d", i+1, code)
+			}
+		}
+		before := len(sender.messages)
+		w := postJSON(router, "/auth/forgot-password", map[string]string{"email": strings.ToUpper(testEmail)})
+		if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") == "" {
+			t.Errorf("Expected 429 with Retry-After for the same email, got %d", w.Code)
+		}
+		if len(sender.messages) != before {
+			t.Error("Expected no email once the limit is reached")
+		}
+
+		// Unknown emails count the same, so the limit reveals nothing
+		if code := forgot("nobody1@example.com"); code != http.StatusOK {
+			t.Errorf("Expected another email to be allowed, got %d", code)
+		}
+		if code := forgot("nobody2@example.com"); code != http.StatusOK {
+			t.Errorf("Expected another email to be allowed, got %d", code)
+		}
+		if code := forgot("nobody3@example.com"); code != http.StatusTooManyRequests {
+			t.Errorf("Expected 429 once the IP limit is reached, got %d", code)
+		}
+	})
+}
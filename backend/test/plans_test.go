@@ -0,0 +1,271 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"backend/audit"
+	"backend/auth"
+	db "backend/database"
+)
+
+// sqlTime formats t like SQLite's datetime('now')
+func sqlTime(t time.Time) string {
+	return t.UTC().Format("2006-01-02 15:04:05")
+}
+
+// setQuotaWindow backdates the user's signup and records used meals in
+// the window starting at periodStart
+func setQuotaWindow(t *testing.T, userID int64, signup, periodStart time.Time, used int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.DB.ExecContext(ctx, "UPDATE users SET created_at = ? WHERE id = ?", sqlTime(signup), userID); err != nil {
+		t.Fatalf("Failed to backdate signup: %v", err)
+	}
+	if _, err := db.DB.ExecContext(ctx,
+		`INSERT INTO users_tracking (user_id, meal_count, period_start) VALUES (?, ?, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET meal_count = excluded.meal_count,
+		   period_start = excluded.period_start, rollover = 0, meals_override = NULL`,
+		userID, used, sqlTime(periodStart),
+	); err != nil {
+		t.Fatalf("Failed to set quota window: %v", err)
+	}
+}
+
+// TestQuota_Window tests window boundaries for both periods
+func TestQuota_Window(t *testing.T) {
+	at := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name       string
+		anchor     string
+		now        string
+		period     string
+		start, end string
+	}{
+		{"daily before the signup time", "2024-01-01T18:30:00Z", "2024-01-03T09:00:00Z", db.PeriodDaily,
+			"2024-01-02T18:30:00Z", "2024-01-03T18:30:00Z"},
+		{"daily on the boundary", "2024-01-01T18:30:00Z", "2024-01-03T18:30:00Z", db.PeriodDaily,
+			"2024-01-03T18:30:00Z", "2024-01-04T18:30:00Z"},
+		{"monthly in the first window", "2024-03-15T12:00:00Z", "2024-03-20T00:00:00Z", db.PeriodMonthly,
+			"2024-03-15T12:00:00Z", "2024-04-15T12:00:00Z"},
+		{"monthly across a year", "2023-12-10T08:00:00Z", "2024-01-09T08:00:00Z", db.PeriodMonthly,
+			"2023-12-10T08:00:00Z", "2024-01-10T08:00:00Z"},
+		{"monthly clamps to short months", "2024-01-31T10:00:00Z", "2024-02-29T12:00:00Z", db.PeriodMonthly,
+			"2024-02-29T10:00:00Z", "2024-03-31T10:00:00Z"},
+		{"monthly before the clamped day", "2024-01-31T10:00:00Z", "2024-02-29T09:00:00Z", db.PeriodMonthly,
+			"2024-01-31T10:00:00Z", "2024-02-29T10:00:00Z"},
+		{"clock behind signup", "2024-01-31T10:00:00Z", "2024-01-30T10:00:00Z", db.PeriodDaily,
+			"2024-01-31T10:00:00Z", "2024-02-01T10:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, end := db.Window(at(tt.anchor), at(tt.now), tt.period)
+			if !start.Equal(at(tt.start)) || !end.Equal(at(tt.end)) {
+				t.Errorf("Expected %s to %s, got %s to %s", tt.start, tt.end,
+					start.Format(time.RFC3339), end.Format(time.RFC3339))
+			}
+		})
+	}
+}
+
+// TestE2E_PlansAndWindows tests plan reporting, window resets and rollover
+func TestE2E_PlansAndWindows(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+
+	email := "plans_user@example.com"
+	defer cleanupTestDB(t, email)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"})
+	userID := registeredUserID(t, w)
+	cookie := tokenCookie(w)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	type usageResponse struct {
+		Used      int     `json:"used"`
+		Remaining int     `json:"remaining"`
+		Limit     int     `json:"limit"`
+		Allowance int     `json:"allowance"`
+		Rollover  int     `json:"rollover"`
+		ResetsAt  string  `json:"resets_at"`
+		Plan      db.Plan `json:"plan"`
+		Window    struct {
+			Start string `json:"start"`
+			End   string `json:"end"`
+		} `json:"window"`
+	}
+	getUsage := func(t *testing.T) usageResponse {
+		w := getWithCookies(router, "/api/usage", cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Usage failed: %d %s", w.Code, w.Body.String())
+		}
+		var usage usageResponse
+		json.Unmarshal(w.Body.Bytes(), &usage)
+		return usage
+	}
+
+	t.Run("1. Plans are listed publicly", func(t *testing.T) {
+		w := getWithCookies(router, "/api/plans")
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+		var resp struct {
+			Plans []db.Plan `json:"plans"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		ids := map[string]bool{}
+		for _, p := range resp.Plans {
+			ids[p.ID] = true
+		}
+		if len(resp.Plans) != 3 || !ids["free"] || !ids["pro"] || !ids["team"] {
+			t.Errorf("Expected the free, pro and team plans, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("2. New users are on the free plan", func(t *testing.T) {
+		usage := getUsage(t)
+		if usage.Plan.ID != db.DefaultPlan || usage.Plan.Period != db.PeriodMonthly {
+			t.Errorf("Expected the monthly free plan, got %+v", usage.Plan)
+		}
+		if usage.Limit != 20 || usage.Allowance != 20 || usage.Remaining != 20 || usage.Rollover != 0 {
+			t.Errorf("Unexpected usage: %+v", usage)
+		}
+		if usage.ResetsAt == "" || usage.ResetsAt != usage.Window.End {
+			t.Errorf("Expected resets_at to be the end of the window, got %+v", usage)
+		}
+		end, err := time.Parse(time.RFC3339, usage.ResetsAt)
+		if err != nil || end.Before(time.Now().AddDate(0, 0, 27)) || end.After(time.Now().AddDate(0, 1, 1)) {
+			t.Errorf("Expected the window to end in about a month, got %s", usage.ResetsAt)
+		}
+	})
+
+	t.Run("3. Monthly windows reset and roll over up to the cap", func(t *testing.T) {
+		if err := db.SetUserPlan(ctx, userID, "pro"); err != nil {
+			t.Fatalf("Failed to change plan: %v", err)
+		}
+		now := time.Now().UTC()
+		signup := now.AddDate(0, -3, -5)
+		previous, _ := db.Window(signup, now.AddDate(0, -1, 0), db.PeriodMonthly)
+
+		setQuotaWindow(t, userID, signup, previous, 200)
+		usage := getUsage(t)
+		if usage.Used != 0 || usage.Rollover != 100 || usage.Limit != 400 {
+			t.Errorf("Expected 100 unused meals to carry over, got %+v", usage)
+		}
+		if start, _ := db.Window(signup, now, db.PeriodMonthly); usage.Window.Start != start.Format(time.RFC3339) {
+			t.Errorf("Expected the window to start at %s, got %s", start.Format(time.RFC3339), usage.Window.Start)
+		}
+
+		// A second read must not roll over again
+		if again := getUsage(t); again.Rollover != 100 || again.Limit != 400 {
+			t.Errorf("Expected the rollover to be stable, got %+v", again)
+		}
+
+		setQuotaWindow(t, userID, signup, previous, 0)
+		if usage := getUsage(t); usage.Rollover != 150 {
+			t.Errorf("Expected the rollover to be capped at 150, got %+v", usage)
+		}
+
+		// A window that went entirely unused carries over as well
+		older, _ := db.Window(signup, now.AddDate(0, -2, 0), db.PeriodMonthly)
+		setQuotaWindow(t, userID, signup, older, 300)
+		if usage := getUsage(t); usage.Rollover != 150 {
+			t.Errorf("Expected the skipped window to carry over, got %+v", usage)
+		}
+	})
+
+	t.Run("4. Daily windows reset at the signup time", func(t *testing.T) {
+		if err := db.SetUserPlan(ctx, userID, "team"); err != nil {
+			t.Fatalf("Failed to change plan: %v", err)
+		}
+		now := time.Now().UTC()
+		signup := now.Add(-75 * time.Hour)
+		current, end := db.Window(signup, now, db.PeriodDaily)
+
+		setQuotaWindow(t, userID, signup, current, 100)
+		if _, err := db.ReserveQuota(ctx, userID); !errors.Is(err, db.ErrQuotaExceeded) {
+			t.Fatalf("Expected the daily allowance to be used up, got %v", err)
+		}
+
+		setQuotaWindow(t, userID, signup, current.Add(-24*time.Hour), 100)
+		usage := getUsage(t)
+		if usage.Used != 0 || usage.Limit != 100 || usage.Rollover != 0 {
+			t.Errorf("Expected a fresh daily allowance without rollover, got %+v", usage)
+		}
+		if usage.ResetsAt != end.Format(time.RFC3339) {
+			t.Errorf("Expected a reset at %s, got %s", end.Format(time.RFC3339), usage.ResetsAt)
+		}
+		if _, err := db.ReserveQuota(ctx, userID); err != nil {
+			t.Errorf("Expected a reservation in the new window, got %v", err)
+		}
+	})
+
+	t.Run("5. A reservation committed after its window ended counts in the next one", func(t *testing.T) {
+		if err := db.SetUserPlan(ctx, userID, "pro"); err != nil {
+			t.Fatalf("Failed to change plan: %v", err)
+		}
+		now := time.Now().UTC()
+		signup := now.AddDate(0, -3, -5)
+		current, _ := db.Window(signup, now, db.PeriodMonthly)
+		previous, _ := db.Window(signup, now.AddDate(0, -1, 0), db.PeriodMonthly)
+
+		setQuotaWindow(t, userID, signup, current, 200)
+		reservation, err := db.ReserveQuota(ctx, userID)
+		if err != nil {
+			t.Fatalf("Failed to reserve: %v", err)
+		}
+
+		// The window ends while the meal is being generated
+		setQuotaWindow(t, userID, signup, previous, 200)
+		usage, err := db.CommitQuota(ctx, reservation)
+		if err != nil {
+			t.Fatalf("Failed to commit: %v", err)
+		}
+		if usage.Used != 1 || usage.Rollover != 100 {
+			t.Errorf("Expected the meal to count in the new window only, got used %d and rollover %d", usage.Used, usage.Rollover)
+		}
+	})
+
+	t.Run("6. Admins can change plans and override allowances", func(t *testing.T) {
+		adminCookie := registerStaff(t, router, "plans_admin@example.com", auth.RoleAdmin)
+		userPath := fmt.Sprintf("/admin/users/%d", userID)
+
+		w := putJSON(router, userPath+"/plan", map[string]string{"plan": "gold"}, adminCookie)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown plan, got %d", w.Code)
+		}
+
+		w = putJSON(router, userPath+"/plan", map[string]string{"plan": "free"}, adminCookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if auditCount(t, userID, audit.ActionPlanChanged) != 1 {
+			t.Error("Expected the plan change to be audited")
+		}
+
+		w = putJSON(router, userPath+"/quota", map[string]int{"max_meals": 7}, adminCookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		usage := getUsage(t)
+		if usage.Plan.ID != "free" || usage.Allowance != 7 || usage.Plan.MealsPerPeriod != 20 {
+			t.Errorf("Expected the override to replace the plan allowance, got %+v", usage)
+		}
+	})
+}
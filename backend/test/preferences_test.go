@@ -0,0 +1,343 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	db "backend/database"
+	"backend/preferences"
+)
+
+// patchPreferences sends a merge patch with optional extra headers
+func patchPreferences(router http.Handler, body string, headers map[string]string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("PATCH", "/api/preferences", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/merge-patch+json")
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+	for _, cookie := range cookies {
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestPreferences_ParseLegacy tests upgrading documents in the original
+// free-text format
+func TestPreferences_ParseLegacy(t *testing.T) {
+	p, err := preferences.Parse([]byte(`{"dietary_restrictions":"Vegan, gluten-free; no nuts and peanut allergy, hates coriander","max_cooking_time":45}`))
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if p.Version != preferences.CurrentVersion {
+		t.Errorf("Expected version %d, got %d", preferences.CurrentVersion, p.Version)
+	}
+	if !slices.Equal(p.Diets, []string{"vegan", "gluten_free"}) {
+		t.Errorf("Unexpected diets: %v", p.Diets)
+	}
+	if !slices.Equal(p.Allergies, []string{"tree_nuts", "peanuts"}) {
+		t.Errorf("Unexpected allergies: %v", p.Allergies)
+	}
+	if p.Notes != "hates coriander" || p.MaxCookingTime != 45 {
+		t.Errorf("Expected unrecognised text in notes and the time limit kept, got %q, %d", p.Notes, p.MaxCookingTime)
+	}
+	if err := p.Validate(); err != nil {
+		t.Errorf("Expected upgraded preferences to be valid: %v", err)
+	}
+
+	if _, err := preferences.Parse([]byte(`{"version":99}`)); err == nil {
+		t.Error("Expected an unknown version to be rejected")
+	}
+	if _, err := preferences.Parse([]byte(`{"version":2,"dietary_restrictions":"vegan"}`)); err == nil {
+		t.Error("Expected old fields in a current document to be rejected")
+	}
+}
+
+// TestE2E_Preferences tests reading, validating and saving preferences
+func TestE2E_Preferences(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+
+	email := "preferences_test@example.com"
+	defer cleanupTestDB(t, email)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Register failed: %d %s", w.Code, w.Body.String())
+	}
+	userID := registeredUserID(t, w)
+	cookie := tokenCookie(w)
+
+	t.Run("1. Defaults before anything is saved", func(t *testing.T) {
+		w := getWithCookies(router, "/api/preferences", cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Get failed: %d %s", w.Code, w.Body.String())
+		}
+		var prefs preferences.Preferences
+		json.Unmarshal(w.Body.Bytes(), &prefs)
+		if prefs.Version != preferences.CurrentVersion || prefs.Allergies == nil || len(prefs.Allergies) != 0 {
+			t.Errorf("Expected empty preferences at the current version, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("2. Invalid preferences are rejected", func(t *testing.T) {
+		w := putJSON(router, "/api/preferences", map[string]any{
+			"version":        preferences.CurrentVersion,
+			"allergies":      []string{"kryptonite"},
+			"household_size": 50,
+			"skill_level":    "wizard",
+		}, cookie)
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("Expected 400, got %d", w.Code)
+		}
+		for _, want := range []string{"kryptonite", "household_size", "skill_level"} {
+			if !strings.Contains(w.Body.String(), want) {
+				t.Errorf("Expected the error to mention %s: %s", want, w.Body.String())
+			}
+		}
+
+		if w := putJSON(router, "/api/preferences", map[string]any{"favourite_colour": "blue"}, cookie); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected unknown fields to be rejected, got %d", w.Code)
+		}
+	})
+
+	t.Run("3. Valid preferences are normalized and saved", func(t *testing.T) {
+		w := putJSON(router, "/api/preferences", map[string]any{
+			"version":              preferences.CurrentVersion,
+			"allergies":            []string{"Tree-Nuts", "sesame", "tree nuts"},
+			"diets":                []string{"Halal"},
+			"disliked_ingredients": []string{"  Blue   Cheese "},
+			"cuisines":             []string{"middle eastern"},
+			"household_size":       4,
+			"skill_level":          "beginner",
+			"equipment":            []string{"oven", "air fryer"},
+			"budget":               "low",
+			"calorie_target":       600,
+			"max_cooking_time":     30,
+		}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Update failed: %d %s", w.Code, w.Body.String())
+		}
+
+		w = getWithCookies(router, "/api/preferences", cookie)
+		var prefs preferences.Preferences
+		json.Unmarshal(w.Body.Bytes(), &prefs)
+		if !slices.Equal(prefs.Allergies, []string{"tree_nuts", "sesame"}) ||
+			!slices.Equal(prefs.DislikedIngredients, []string{"blue cheese"}) ||
+			!slices.Equal(prefs.Equipment, []string{"oven", "air_fryer"}) ||
+			prefs.Cuisines[0] != "middle_eastern" || prefs.HouseholdSize != 4 || prefs.CalorieTarget != 600 {
+			t.Errorf("Unexpected saved preferences: %s", w.Body.String())
+		}
+	})
+
+	t.Run("4. Old request bodies are still accepted", func(t *testing.T) {
+		w := putJSON(router, "/api/preferences", map[string]any{
+			"dietary_restrictions": "vegetarian",
+			"max_cooking_time":     20,
+		}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Update failed: %d %s", w.Code, w.Body.String())
+		}
+		var prefs preferences.Preferences
+		json.Unmarshal(w.Body.Bytes(), &prefs)
+		if !slices.Equal(prefs.Diets, []string{"vegetarian"}) || prefs.MaxCookingTime != 20 {
+			t.Errorf("Unexpected response: %s", w.Body.String())
+		}
+	})
+
+	t.Run("5. Stored documents are migrated", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		legacy := `{"dietary_restrictions":"pescatarian, shellfish allergy","max_cooking_time":15}`
+		if _, err := db.DB.ExecContext(ctx,
+			"UPDATE user_preference SET user_preference = ?, updated_at = '2020-01-01 00:00:00' WHERE user_id = ?",
+			legacy, userID,
+		); err != nil {
+			t.Fatalf("Failed to store legacy preferences: %v", err)
+		}
+
+		if n, err := preferences.MigrateStored(ctx); err != nil || n < 1 {
+			t.Fatalf("Expected the legacy document to be migrated, got %d, %v", n, err)
+		}
+
+		var raw, updatedAt string
+		db.DB.QueryRowContext(ctx,
+			"SELECT user_preference, updated_at FROM user_preference WHERE user_id = ?", userID,
+		).Scan(&raw, &updatedAt)
+		stored, err := preferences.Parse([]byte(raw))
+		if err != nil {
+			t.Fatalf("Migrated document does not parse: %v", err)
+		}
+		if !strings.Contains(raw, `"version":2`) || !slices.Equal(stored.Diets, []string{"pescatarian"}) ||
+			!slices.Equal(stored.Allergies, []string{"crustaceans"}) {
+			t.Errorf("Unexpected migrated document: %s", raw)
+		}
+		if updatedAt != "2020-01-01 00:00:00" {
+			t.Errorf("Expected updated_at to be kept, got %s", updatedAt)
+		}
+	})
+
+	t.Run("6. Schema lists the allowed values", func(t *testing.T) {
+		w := getWithCookies(router, "/api/preferences/schema")
+		var resp struct {
+			Schema struct {
+				Version   int      `json:"version"`
+				Allergies []string `json:"allergies"`
+			} `json:"schema"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Schema.Version != preferences.CurrentVersion || !slices.Contains(resp.Schema.Allergies, "peanuts") {
+			t.Errorf("Unexpected schema: %s", w.Body.String())
+		}
+	})
+}
+
+// TestE2E_PreferencesPatch tests partial updates and If-Match
+func TestE2E_PreferencesPatch(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+
+	email := "preferences_patch@example.com"
+	defer cleanupTestDB(t, email)
+
+	cookie := tokenCookie(postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"}))
+	w := getWithCookies(router, "/api/preferences", cookie)
+	initial := w.Header().Get("ETag")
+	if initial == "" {
+		t.Fatal("Expected an ETag on GET")
+	}
+
+	var etag string
+	t.Run("1. Patch changes only the given fields", func(t *testing.T) {
+		w := patchPreferences(router, `{"max_cooking_time": 30, "allergies": ["sesame"]}`, map[string]string{"If-Match": initial}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Patch failed: %d %s", w.Code, w.Body.String())
+		}
+		etag = w.Header().Get("ETag")
+		if etag == "" || etag == initial {
+			t.Errorf("Expected a new ETag, got %q", etag)
+		}
+
+		w = patchPreferences(router, `{"diets": ["Vegan"]}`, nil, cookie)
+		var prefs preferences.Preferences
+		json.Unmarshal(w.Body.Bytes(), &prefs)
+		if prefs.MaxCookingTime != 30 || !slices.Equal(prefs.Allergies, []string{"sesame"}) || !slices.Equal(prefs.Diets, []string{"vegan"}) {
+			t.Errorf("Expected earlier fields to be kept, got %s", w.Body.String())
+		}
+		etag = w.Header().Get("ETag")
+	})
+
+	t.Run("2. Null clears a field", func(t *testing.T) {
+		w := patchPreferences(router, `{"max_cooking_time": null}`, map[string]string{"If-Match": etag}, cookie)
+		var prefs preferences.Preferences
+		json.Unmarshal(w.Body.Bytes(), &prefs)
+		if w.Code != http.StatusOK || prefs.MaxCookingTime != 0 || len(prefs.Allergies) != 1 {
+			t.Errorf("Unexpected result: %d %s", w.Code, w.Body.String())
+		}
+		etag = w.Header().Get("ETag")
+	})
+
+	t.Run("3. Stale revisions are rejected", func(t *testing.T) {
+		w := patchPreferences(router, `{"budget": "low"}`, map[string]string{"If-Match": initial}, cookie)
+		if w.Code != http.StatusPreconditionFailed {
+			t.Errorf("Expected 412 for PATCH, got %d", w.Code)
+		}
+		if w.Header().Get("ETag") != etag {
+			t.Error("Expected the 412 to carry the current ETag")
+		}
+
+		req := httptest.NewRequest("PUT", "/api/preferences", strings.NewReader(`{"version": 2}`))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("If-Match", initial)
+		req.AddCookie(cookie)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusPreconditionFailed {
+			t.Errorf("Expected 412 for PUT, got %d", w.Code)
+		}
+
+		req = httptest.NewRequest("GET", "/api/preferences", nil)
+		req.Header.Set("If-None-Match", etag)
+		req.AddCookie(cookie)
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusNotModified {
+			t.Errorf("Expected 304 for an unchanged revision, got %d", w.Code)
+		}
+	})
+
+	t.Run("4. Invalid patches are rejected", func(t *testing.T) {
+		for body, want := range map[string]int{
+			`{"budget": "infinite"}`:      http.StatusBadRequest,
+			`{"favourite_colour": "red"}`: http.StatusBadRequest,
+			`["not", "an", "object"]`:     http.StatusBadRequest,
+		} {
+			if w := patchPreferences(router, body, nil, cookie); w.Code != want {
+				t.Errorf("%s: expected %d, got %d", body, want, w.Code)
+			}
+		}
+
+		req := httptest.NewRequest("PATCH", "/api/preferences", strings.NewReader(`{"budget": "low"}`))
+		req.Header.Set("Content-Type", "text/plain")
+		req.AddCookie(cookie)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		if w.Code != http.StatusUnsupportedMediaType {
+			t.Errorf("Expected 415, got %d", w.Code)
+		}
+	})
+
+	t.Run("5. Concurrent patches to different fields are all kept", func(t *testing.T) {
+		patches := map[string]string{
+			"household_size": `{"household_size": 3}`,
+			"calorie_target": `{"calorie_target": 500}`,
+			"skill_level":    `{"skill_level": "advanced"}`,
+			"budget":         `{"budget": "high"}`,
+		}
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		applied := map[string]bool{}
+		for field, body := range patches {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w := patchPreferences(router, body, nil, cookie)
+				if w.Code != http.StatusOK && w.Code != http.StatusConflict {
+					t.Errorf("%s: unexpected status %d", field, w.Code)
+				}
+				mu.Lock()
+				applied[field] = w.Code == http.StatusOK
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		var prefs preferences.Preferences
+		json.Unmarshal(getWithCookies(router, "/api/preferences", cookie).Body.Bytes(), &prefs)
+		got := map[string]bool{
+			"household_size": prefs.HouseholdSize == 3,
+			"calorie_target": prefs.CalorieTarget == 500,
+			"skill_level":    prefs.SkillLevel == "advanced",
+			"budget":         prefs.Budget == "high",
+		}
+		for field, ok := range applied {
+			if ok && !got[field] {
+				t.Errorf("Update to %s was lost", field)
+			}
+		}
+		if !slices.Equal(prefs.Diets, []string{"vegan"}) {
+			t.Errorf("Expected untouched fields to be kept, got %v", prefs.Diets)
+		}
+	})
+}
@@ -0,0 +1,208 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"testing"
+	"time"
+
+	db "backend/database"
+	"backend/llm"
+)
+
+// setMealLimit gives the user a quota of limit meal generations in the
+// current window, none used
+func setMealLimit(t *testing.T, userID int64, limit int) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	if _, err := db.DB.ExecContext(ctx,
+		`INSERT INTO users_tracking (user_id, meal_count, meals_override) VALUES (?, 0, ?)
+		 ON CONFLICT(user_id) DO UPDATE SET meal_count = 0, rollover = 0, meals_override = excluded.meals_override`,
+		userID, limit,
+	); err != nil {
+		t.Fatalf("Failed to set meal limit: %v", err)
+	}
+}
+
+// TestQuota_ReserveCommitRelease tests the reservation API directly
+func TestQuota_ReserveCommitRelease(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+
+	email := "quota_api@example.com"
+	defer cleanupTestDB(t, email)
+	userID := registeredUserID(t, postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"}))
+	setMealLimit(t, userID, 2)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first, err := db.ReserveQuota(ctx, userID)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	second, err := db.ReserveQuota(ctx, userID)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if _, err := db.ReserveQuota(ctx, userID); !errors.Is(err, db.ErrQuotaExceeded) {
+		t.Fatalf("Expected reservations to count against the limit, got %v", err)
+	}
+
+	usage, err := db.CommitQuota(ctx, first)
+	if err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+	if usage.Used != 1 || usage.Reserved != 1 || usage.Remaining() != 0 {
+		t.Errorf("Unexpected usage after commit: %+v", usage)
+	}
+	if _, err := db.CommitQuota(ctx, first); !errors.Is(err, db.ErrReservationNotFound) {
+		t.Errorf("Expected a second commit to fail, got %v", err)
+	}
+
+	if err := db.ReleaseQuota(ctx, second); err != nil {
+		t.Fatalf("Release failed: %v", err)
+	}
+	if usage, _ := db.QuotaUsage(ctx, userID); usage.Used != 1 || usage.Reserved != 0 {
+		t.Errorf("Expected the released unit to be free again, got %+v", usage)
+	}
+
+	// A reservation that ran past its TTL can no longer be committed, since
+	// another request may hold the unit by then
+	late, err := db.ReserveQuota(ctx, userID)
+	if err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if _, err := db.DB.ExecContext(ctx,
+		"UPDATE quota_reservations SET expires_at = datetime('now', '-1 seconds') WHERE id = ?", late.ID,
+	); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.CommitQuota(ctx, late); !errors.Is(err, db.ErrReservationNotFound) {
+		t.Errorf("Expected an expired reservation not to commit, got %v", err)
+	}
+	if usage, _ := db.QuotaUsage(ctx, userID); usage.Used != 1 {
+		t.Errorf("Expected the expired reservation not to count, got %+v", usage)
+	}
+
+	// Reservations left behind stop counting once they expire
+	previous := db.ReservationTTL
+	db.ReservationTTL = -time.Second
+	defer func() { db.ReservationTTL = previous }()
+	if _, err := db.ReserveQuota(ctx, userID); err != nil {
+		t.Fatalf("Reserve failed: %v", err)
+	}
+	if usage, _ := db.QuotaUsage(ctx, userID); usage.Reserved != 0 || usage.Remaining() != 1 {
+		t.Errorf("Expected expired reservations to be ignored, got %+v", usage)
+	}
+}
+
+// TestE2E_QuotaConcurrency fires parallel meal requests at a small quota.
+// Run with -race to also check the handlers for data races.
+func TestE2E_QuotaConcurrency(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+	fake := useFakeLLM(t)
+
+	email := "quota_race@example.com"
+	defer cleanupTestDB(t, email)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"})
+	userID := registeredUserID(t, w)
+	cookie := tokenCookie(w)
+	markEmailVerified(t, email)
+
+	const limit = 5
+	const requests = 20
+	setMealLimit(t, userID, limit)
+
+	// A slow model keeps every request in flight at the same time
+	fake.Reply = func(req llm.Request) string {
+		time.Sleep(50 * time.Millisecond)
+		return "A meal"
+	}
+
+	t.Run("1. Parallel requests never exceed the limit", func(t *testing.T) {
+		var wg sync.WaitGroup
+		var mu sync.Mutex
+		codes := map[int]int{}
+		for i := 0; i < requests; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				w := postJSON(router, "/llm", map[string]string{"message": "rice"}, cookie)
+				mu.Lock()
+				codes[w.Code]++
+				mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		if codes[http.StatusOK] != limit || codes[http.StatusForbidden] != requests-limit {
+			t.Errorf("Expected %d successes and %d refusals, got %v", limit, requests-limit, codes)
+		}
+		if n := countRows(t, "users_tracking", "user_id = ? AND meal_count = ?", userID, limit); n != 1 {
+			t.Error("Expected meal_count to equal the limit exactly")
+		}
+		if n := countRows(t, "quota_reservations", "user_id = ?", userID); n != 0 {
+			t.Errorf("Expected no reservations left over, found %d", n)
+		}
+	})
+
+	t.Run("2. Failed generations give the quota back", func(t *testing.T) {
+		setMealLimit(t, userID, 1)
+
+		fake.Err = errors.New("model unavailable")
+		w := postJSON(router, "/llm", map[string]string{"message": "rice"}, cookie)
+		fake.Err = nil
+		if w.Code != http.StatusInternalServerError {
+			t.Fatalf("Expected 500, got %d", w.Code)
+		}
+
+		w = getWithCookies(router, "/api/usage", cookie)
+		var usage struct {
+			Used       int `json:"used"`
+			Remaining  int `json:"remaining"`
+			InProgress int `json:"in_progress"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &usage)
+		if usage.Used != 0 || usage.Remaining != 1 || usage.InProgress != 0 {
+			t.Errorf("Expected the failed request not to count, got %s", w.Body.String())
+		}
+
+		if w := postJSON(router, "/llm", map[string]string{"message": "rice"}, cookie); w.Code != http.StatusOK {
+			t.Errorf("Expected the remaining meal to be usable, got %d", w.Code)
+		}
+	})
+
+	t.Run("3. Generations are cut off before their reservation expires", func(t *testing.T) {
+		setMealLimit(t, userID, 1)
+
+		previous := db.ReservationTTL
+		db.ReservationTTL = 2 * time.Second
+		fake.Delay = time.Minute
+		defer func() {
+			db.ReservationTTL = previous
+			fake.Delay = 0
+		}()
+
+		started := time.Now()
+		w := postJSON(router, "/llm", map[string]string{"message": "rice"}, cookie)
+		if w.Code != http.StatusGatewayTimeout {
+			t.Fatalf("Expected 504, got %d: %s", w.Code, w.Body.String())
+		}
+		if elapsed := time.Since(started); elapsed >= db.ReservationTTL {
+			t.Errorf("Expected the model call to end before the reservation expired, took %s", elapsed)
+		}
+		if n := countRows(t, "users_tracking", "user_id = ? AND meal_count = 0", userID); n != 1 {
+			t.Error("Expected the timed out generation not to count")
+		}
+		if n := countRows(t, "quota_reservations", "user_id = ?", userID); n != 0 {
+			t.Errorf("Expected the reservation to be released, found %d", n)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+package test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"backend/llm"
+)
+
+// TestRecipe_Parse tests decoding and validating recipe tool input
+func TestRecipe_Parse(t *testing.T) {
+	valid := `{"title": "Dal", "servings": 2, "ingredients": [{"name": "lentils", "quantity": 200, "unit": "g"}],
+		"steps": ["Simmer the lentils."], "prep_minutes": 5, "cook_minutes": 25, "tags": []}`
+	recipe, err := llm.ParseRecipe(json.RawMessage(valid))
+	if err != nil {
+		t.Fatalf("Expected a valid recipe, got %v", err)
+	}
+	if recipe.Title != "Dal" || len(recipe.Ingredients) != 1 || recipe.CookMinutes != 25 {
+		t.Errorf("Unexpected recipe: %+v", recipe)
+	}
+
+	for name, input := range map[string]string{
+		"no tool call":     ``,
+		"malformed":        `{"title": `,
+		"unknown field":    `{"title": "Dal", "calories": 300}`,
+		"missing title":    `{"servings": 2, "ingredients": [{"name": "rice"}], "steps": ["Cook."]}`,
+		"no servings":      `{"title": "Dal", "ingredients": [{"name": "rice"}], "steps": ["Cook."]}`,
+		"no ingredients":   `{"title": "Dal", "servings": 2, "ingredients": [], "steps": ["Cook."]}`,
+		"unnamed item":     `{"title": "Dal", "servings": 2, "ingredients": [{"name": " "}], "steps": ["Cook."]}`,
+		"negative amount":  `{"title": "Dal", "servings": 2, "ingredients": [{"name": "rice", "quantity": -1}], "steps": ["Cook."]}`,
+		"empty step":       `{"title": "Dal", "servings": 2, "ingredients": [{"name": "rice"}], "steps": [""]}`,
+		"negative minutes": `{"title": "Dal", "servings": 2, "ingredients": [{"name": "rice"}], "steps": ["Cook."], "cook_minutes": -5}`,
+	} {
+		if _, err := llm.ParseRecipe(json.RawMessage(input)); !errors.Is(err, llm.ErrInvalidRecipe) {
+			t.Errorf("%s: expected ErrInvalidRecipe, got %v", name, err)
+		}
+	}
+
+	err = llm.ValidateRecipe(&llm.Recipe{Servings: 0})
+	for _, problem := range []string{"title is required", "servings must be at least 1", "at least one ingredient", "at least one step"} {
+		if err == nil || !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected %q to be reported, got %v", problem, err)
+		}
+	}
+}
+
+// TestE2E_StructuredRecipes tests that /llm returns validated recipes and
+// retries schema violations with feedback
+func TestE2E_StructuredRecipes(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+	fake := useFakeLLM(t)
+
+	email := "recipes_llm@example.com"
+	defer cleanupTestDB(t, email)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"})
+	userID := registeredUserID(t, w)
+	cookie := tokenCookie(w)
+	markEmailVerified(t, email)
+
+	t.Run("1. A valid recipe is returned and rendered as text", func(t *testing.T) {
+		fake.ToolReply = func(req llm.Request) json.RawMessage {
+			return json.RawMessage(`{"title": "Egg fried rice", "servings": 2,
+				"ingredients": [{"name": "rice", "quantity": 250, "unit": "g"}, {"name": "eggs", "quantity": 2, "unit": ""}],
+				"steps": ["Scramble the eggs.", "Fry the rice with the eggs."],
+				"prep_minutes": 5, "cook_minutes": 10, "tags": ["quick"]}`)
+		}
+		defer func() { fake.ToolReply = nil }()
+
+		w := postJSON(router, "/llm", map[string]string{"message": "rice and eggs"}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Response string     `json:"response"`
+			Recipe   llm.Recipe `json:"recipe"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+
+		if resp.Recipe.Title != "Egg fried rice" || len(resp.Recipe.Steps) != 2 {
+			t.Errorf("Expected the structured recipe, got %+v", resp.Recipe)
+		}
+		for _, line := range []string{
+			"Egg fried rice",
+			"Serves 2 | Prep 5 min | Cook 10 min",
+			"Tags: quick",
+			"- 250 g rice",
+			"- 2 eggs",
+			"1. Scramble the eggs.",
+			"2. Fry the rice with the eggs.",
+		} {
+			if !strings.Contains(resp.Response, line) {
+				t.Errorf("Expected %q in the rendered recipe:\n%s", line, resp.Response)
+			}
+		}
+
+		sent := fake.Requests[len(fake.Requests)-1]
+		if sent.Tool == nil || sent.Tool.Name != llm.RecipeToolName {
+			t.Errorf("Expected the recipe tool to be offered, got %+v", sent.Tool)
+		}
+	})
+
+	t.Run("2. Schema violations are retried with feedback", func(t *testing.T) {
+		calls := 0
+		fake.ToolReply = func(req llm.Request) json.RawMessage {
+			calls++
+			if calls == 1 {
+				return json.RawMessage(`{"title": "", "servings": 0}`)
+			}
+			return json.RawMessage(`{"title": "Second try", "servings": 1, "ingredients": [{"name": "rice", "quantity": 1, "unit": "cup"}],
+				"steps": ["Cook."], "prep_minutes": 0, "cook_minutes": 15, "tags": []}`)
+		}
+		defer func() { fake.ToolReply = nil }()
+
+		before := len(fake.Requests)
+		w := postJSON(router, "/llm", map[string]string{"message": "rice"}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected the retry to succeed, got %d: %s", w.Code, w.Body.String())
+		}
+		if !strings.Contains(w.Body.String(), "Second try") {
+			t.Errorf("Expected the second answer, got %s", w.Body.String())
+		}
+
+		requests := fake.Requests[before:]
+		if len(requests) != 2 {
+			t.Fatalf("Expected 2 attempts, got %d", len(requests))
+		}
+		retry := requests[1].Messages
+		feedback := retry[len(retry)-1]
+		if feedback.Role != llm.RoleUser || !strings.HasPrefix(feedback.Content, "rice") ||
+			!strings.Contains(feedback.Content, "Your previous answer was rejected") ||
+			!strings.Contains(feedback.Content, "title is required") {
+			t.Errorf("Expected the problems to be appended to the user turn, got %q", feedback.Content)
+		}
+	})
+
+	t.Run("3. Giving up after the last attempt returns 502", func(t *testing.T) {
+		t.Setenv("LLM_RECIPE_MAX_ATTEMPTS", "2")
+		fake.ToolReply = func(req llm.Request) json.RawMessage { return json.RawMessage(`{"title": "No steps"}`) }
+		defer func() { fake.ToolReply = nil }()
+
+		before := len(fake.Requests)
+		w := postJSON(router, "/llm", map[string]string{"message": "rice"}, cookie)
+		if w.Code != http.StatusBadGateway || !strings.Contains(w.Body.String(), "invalid recipe") {
+			t.Errorf("Expected 502, got %d: %s", w.Code, w.Body.String())
+		}
+		if n := len(fake.Requests) - before; n != 2 {
+			t.Errorf("Expected LLM_RECIPE_MAX_ATTEMPTS attempts, got %d", n)
+		}
+		if n := countRows(t, "users_tracking", "user_id = ? AND meal_count = 2", userID); n != 1 {
+			t.Error("Expected the failed generation not to count")
+		}
+	})
+}
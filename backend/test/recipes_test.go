@@ -0,0 +1,195 @@
+package test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+
+	"backend/handlers"
+	"backend/llm"
+)
+
+// testRecipe returns a small valid recipe called title
+func testRecipe(title string) *llm.Recipe {
+	return &llm.Recipe{
+		Title:       title,
+		Servings:    2,
+		Ingredients: []llm.Ingredient{{Name: "rice", Quantity: 200, Unit: "g"}},
+		Steps:       []string{"Cook the rice."},
+		CookMinutes: 15,
+		Tags:        []string{},
+	}
+}
+
+// TestE2E_RecipeLibrary tests saving, listing, editing and deleting recipes
+func TestE2E_RecipeLibrary(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+
+	email := "library_user@example.com"
+	otherEmail := "library_other@example.com"
+	defer cleanupTestDB(t, email)
+	defer cleanupTestDB(t, otherEmail)
+
+	cookie := tokenCookie(postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"}))
+	otherCookie := tokenCookie(postJSON(router, "/auth/register", map[string]string{"email": otherEmail, "password": "testpass123"}))
+
+	save := func(t *testing.T, recipe *llm.Recipe, favourite bool) handlers.SavedRecipe {
+		w := postJSON(router, "/api/recipes", map[string]any{"recipe": recipe, "favourite": favourite}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Save failed: %d %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Recipe handlers.SavedRecipe `json:"recipe"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		return resp.Recipe
+	}
+	type page struct {
+		Recipes    []handlers.SavedRecipe `json:"recipes"`
+		Pagination struct {
+			Page     int `json:"page"`
+			PageSize int `json:"page_size"`
+			Total    int `json:"total"`
+		} `json:"pagination"`
+	}
+	list := func(t *testing.T, query string) page {
+		w := getWithCookies(router, "/api/recipes?"+query, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("List failed: %d %s", w.Code, w.Body.String())
+		}
+		var p page
+		json.Unmarshal(w.Body.Bytes(), &p)
+		return p
+	}
+
+	var saved []handlers.SavedRecipe
+	t.Run("1. Save recipes", func(t *testing.T) {
+		for i := 1; i <= 5; i++ {
+			saved = append(saved, save(t, testRecipe(fmt.Sprintf("Recipe %d", i)), i == 2))
+		}
+		if saved[0].ID == 0 || saved[0].Title != "Recipe 1" || saved[0].Recipe.Ingredients[0].Name != "rice" {
+			t.Errorf("Unexpected saved recipe: %+v", saved[0])
+		}
+		if !saved[1].Favourite || saved[0].Favourite {
+			t.Error("Expected only the second recipe to be a favourite")
+		}
+
+		invalid := testRecipe("")
+		if w := postJSON(router, "/api/recipes", map[string]any{"recipe": invalid}, cookie); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an invalid recipe, got %d", w.Code)
+		}
+		if w := postJSON(router, "/api/recipes", map[string]any{}, cookie); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 without a recipe, got %d", w.Code)
+		}
+	})
+
+	t.Run("2. List recipes newest first, a page at a time", func(t *testing.T) {
+		first := list(t, "page_size=2")
+		if first.Pagination.Total != 5 || first.Pagination.PageSize != 2 || len(first.Recipes) != 2 {
+			t.Fatalf("Unexpected first page: %+v", first)
+		}
+		if first.Recipes[0].Title != "Recipe 5" || first.Recipes[1].Title != "Recipe 4" {
+			t.Errorf("Expected the newest first, got %q and %q", first.Recipes[0].Title, first.Recipes[1].Title)
+		}
+
+		last := list(t, "page=3&page_size=2")
+		if last.Pagination.Page != 3 || len(last.Recipes) != 1 || last.Recipes[0].Title != "Recipe 1" {
+			t.Errorf("Unexpected last page: %+v", last)
+		}
+		if beyond := list(t, "page=4&page_size=2"); len(beyond.Recipes) != 0 {
+			t.Errorf("Expected an empty page past the end, got %d", len(beyond.Recipes))
+		}
+		if capped := list(t, "page_size=1000"); capped.Pagination.PageSize != 100 {
+			t.Errorf("Expected the page size to be capped at 100, got %d", capped.Pagination.PageSize)
+		}
+
+		favourites := list(t, "favourites=true")
+		if favourites.Pagination.Total != 1 || favourites.Recipes[0].Title != "Recipe 2" {
+			t.Errorf("Expected only the favourite, got %+v", favourites)
+		}
+	})
+
+	t.Run("3. Get, edit and favourite a recipe", func(t *testing.T) {
+		path := fmt.Sprintf("/api/recipes/%d", saved[0].ID)
+
+		w := getWithCookies(router, path, cookie)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"title":"Recipe 1"`) {
+			t.Fatalf("Expected the recipe, got %d %s", w.Code, w.Body.String())
+		}
+
+		edited := testRecipe("Recipe 1, improved")
+		edited.Steps = append(edited.Steps, "Add soy sauce.")
+		w = putJSON(router, path, map[string]any{"recipe": edited}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var resp struct {
+			Recipe handlers.SavedRecipe `json:"recipe"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Recipe.Title != "Recipe 1, improved" || len(resp.Recipe.Recipe.Steps) != 2 {
+			t.Errorf("Expected the edit to be saved, got %+v", resp.Recipe)
+		}
+		if w := putJSON(router, path, map[string]any{"recipe": testRecipe(" ")}, cookie); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an invalid edit, got %d", w.Code)
+		}
+
+		w = putJSON(router, path+"/favourite", map[string]bool{"favourite": true}, cookie)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"favourite":true`) {
+			t.Errorf("Expected the recipe to become a favourite, got %d %s", w.Code, w.Body.String())
+		}
+		if w := putJSON(router, path+"/favourite", map[string]any{}, cookie); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 without favourite, got %d", w.Code)
+		}
+		if favourites := list(t, "favourites=true"); favourites.Pagination.Total != 2 {
+			t.Errorf("Expected 2 favourites, got %d", favourites.Pagination.Total)
+		}
+	})
+
+	t.Run("4. Other users cannot read, edit or delete the recipe", func(t *testing.T) {
+		path := fmt.Sprintf("/api/recipes/%d", saved[0].ID)
+
+		if w := getWithCookies(router, path, otherCookie); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 on get, got %d", w.Code)
+		}
+		if w := putJSON(router, path, map[string]any{"recipe": testRecipe("Stolen")}, otherCookie); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 on edit, got %d", w.Code)
+		}
+		if w := putJSON(router, path+"/favourite", map[string]bool{"favourite": false}, otherCookie); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 on favourite, got %d", w.Code)
+		}
+		if w := requestWithCookies(router, "DELETE", path, otherCookie); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 on delete, got %d", w.Code)
+		}
+		if w := getWithCookies(router, "/api/recipes", otherCookie); !strings.Contains(w.Body.String(), `"recipes":[]`) {
+			t.Errorf("Expected other users to see no recipes, got %s", w.Body.String())
+		}
+
+		w := getWithCookies(router, path, cookie)
+		if !strings.Contains(w.Body.String(), `"title":"Recipe 1, improved"`) || !strings.Contains(w.Body.String(), `"favourite":true`) {
+			t.Errorf("Expected the recipe to be untouched, got %s", w.Body.String())
+		}
+	})
+
+	t.Run("5. Delete a recipe", func(t *testing.T) {
+		path := fmt.Sprintf("/api/recipes/%d", saved[0].ID)
+		if w := requestWithCookies(router, "DELETE", path, cookie); w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if w := getWithCookies(router, path, cookie); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 after delete, got %d", w.Code)
+		}
+		if w := requestWithCookies(router, "DELETE", path, cookie); w.Code != http.StatusNotFound {
+			t.Errorf("Expected a second delete to return 404, got %d", w.Code)
+		}
+		if w := getWithCookies(router, "/api/recipes/abc", cookie); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an invalid ID, got %d", w.Code)
+		}
+		if remaining := list(t, ""); remaining.Pagination.Total != 4 {
+			t.Errorf("Expected 4 recipes left, got %d", remaining.Pagination.Total)
+		}
+	})
+}
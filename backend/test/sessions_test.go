@@ -0,0 +1,122 @@
+package test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// postJSON sends a JSON request with the given cookies
+func postJSON(router http.Handler, path string, payload any, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(payload)
+	req := httptest.NewRequest("POST", path, bytes.NewBuffer(body))
+	req.Header.Set("Content-Type", "application/json")
+	for _, cookie := range cookies {
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// getWithCookies sends a GET request with the given cookies
+func getWithCookies(router http.Handler, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+	req := httptest.NewRequest("GET", path, nil)
+	for _, cookie := range cookies {
+		if cookie != nil {
+			req.AddCookie(cookie)
+		}
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+// TestE2E_SessionLifecycle tests refresh token rotation and revocation
+func TestE2E_SessionLifecycle(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+
+	testEmail := "session_test@example.com"
+	testPassword := "testpass123"
+	defer cleanupTestDB(t, testEmail)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": testEmail, "password": testPassword})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Register failed: %d %s", w.Code, w.Body.String())
+	}
+	access := tokenCookie(w)
+	refresh := namedCookie(w, "refresh_token")
+	if access == nil || refresh == nil {
+		t.Fatal("Expected access and refresh cookies after registration")
+	}
+
+	t.Run("1. Refresh rotates both tokens", func(t *testing.T) {
+		w := postJSON(router, "/auth/refresh", nil, refresh)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+		}
+
+		newAccess := tokenCookie(w)
+		newRefresh := namedCookie(w, "refresh_token")
+		if newAccess == nil || newRefresh == nil {
+			t.Fatal("Expected new cookies from refresh")
+		}
+		if newRefresh.Value == refresh.Value {
+			t.Error("Expected refresh token to be rotated")
+		}
+
+		if w := getWithCookies(router, "/api/profile", newAccess); w.Code != http.StatusOK {
+			t.Fatalf("Expected new access token to work, got %d", w.Code)
+		}
+
+		// Replaying the old refresh token revokes the whole session
+		if w := postJSON(router, "/auth/refresh", nil, refresh); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected reused refresh token to be rejected, got %d", w.Code)
+		}
+		if w := getWithCookies(router, "/api/profile", newAccess); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected access token of revoked session to be rejected, got %d", w.Code)
+		}
+	})
+
+	t.Run("2. Logout revokes the session", func(t *testing.T) {
+		w := postJSON(router, "/auth/login", map[string]string{"email": testEmail, "password": testPassword})
+		access, refresh := tokenCookie(w), namedCookie(w, "refresh_token")
+
+		if w := postJSON(router, "/auth/logout", nil, access, refresh); w.Code != http.StatusOK {
+			t.Fatalf("Logout failed: %d", w.Code)
+		}
+		if w := getWithCookies(router, "/api/profile", access); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected access token to be rejected after logout, got %d", w.Code)
+		}
+		if w := postJSON(router, "/auth/refresh", nil, refresh); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected refresh token to be rejected after logout, got %d", w.Code)
+		}
+	})
+
+	t.Run("3. Logout all devices", func(t *testing.T) {
+		first := tokenCookie(postJSON(router, "/auth/login", map[string]string{"email": testEmail, "password": testPassword}))
+		second := tokenCookie(postJSON(router, "/auth/login", map[string]string{"email": testEmail, "password": testPassword}))
+
+		if w := postJSON(router, "/auth/logout-all", nil, first); w.Code != http.StatusOK {
+			t.Fatalf("Logout all failed: %d %s", w.Code, w.Body.String())
+		}
+		if w := getWithCookies(router, "/api/profile", second); w.Code != http.StatusUnauthorized {
+			t.Fatalf("Expected other device to be signed out, got %d", w.Code)
+		}
+	})
+}
+
+// registeredUserID reads the user ID from a register or login response
+func registeredUserID(t *testing.T, w *httptest.ResponseRecorder) int64 {
+	t.Helper()
+	var resp AuthResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to parse response: %v", err)
+	}
+	return resp.User.ID
+}
@@ -0,0 +1,207 @@
+package test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/auth"
+	"backend/ratelimit"
+	"backend/totp"
+)
+
+// totpCode returns the code offset steps from now, so successive steps can
+// be used without tripping replay protection
+func totpCode(t *testing.T, secret string, offset int64) string {
+	code, err := totp.CodeAt(secret, totp.Step(time.Now())+offset)
+	if err != nil {
+		t.Fatalf("Failed to generate TOTP code: %v", err)
+	}
+	return code
+}
+
+// TestE2E_TwoFactor tests TOTP enrolment, two-step login, recovery codes
+// and disabling 2FA
+func TestE2E_TwoFactor(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+	useLoginGuard(t, auth.NewLoginGuard(ratelimit.NewMemoryStore()))
+
+	testEmail := "two_factor_test@example.com"
+	testPassword := "testpass123"
+	defer cleanupTestDB(t, testEmail)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": testEmail, "password": testPassword})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Register failed: %d %s", w.Code, w.Body.String())
+	}
+	cookie := tokenCookie(w)
+
+	login := func() *httptest.ResponseRecorder {
+		return postJSON(router, "/auth/login", map[string]string{"email": testEmail, "password": testPassword})
+	}
+
+	var secret string
+	var recoveryCodes []string
+	var challenge string
+
+	t.Run("1. Setup returns secret and provisioning URI", func(t *testing.T) {
+		w := postJSON(router, "/auth/2fa/setup", nil, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Setup failed: %d %s", w.Code, w.Body.String())
+		}
+
+		var resp struct {
+			Secret     string `json:"secret"`
+			OTPAuthURI string `json:"otpauth_uri"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if resp.Secret == "" || !strings.HasPrefix(resp.OTPAuthURI, "otpauth://totp/") {
+			t.Fatalf("Unexpected setup response: %s", w.Body.String())
+		}
+		secret = resp.Secret
+
+		// Not enabled until verified
+		if w := login(); tokenCookie(w) == nil {
+			t.Error("Expected login to set a cookie before 2FA is confirmed")
+		}
+	})
+
+	t.Run("2. Verify rejects a wrong code", func(t *testing.T) {
+		w := postJSON(router, "/auth/2fa/verify", map[string]string{"code": "000000"}, cookie)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("3. Verify enables 2FA and returns recovery codes", func(t *testing.T) {
+		w := postJSON(router, "/auth/2fa/verify", map[string]string{"code": totpCode(t, secret, 0)}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Verify failed: %d %s", w.Code, w.Body.String())
+		}
+
+		var resp struct {
+			RecoveryCodes []string `json:"recovery_codes"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if len(resp.RecoveryCodes) != auth.RecoveryCodeCount {
+			t.Fatalf("Expected %d recovery codes, got %d", auth.RecoveryCodeCount, len(resp.RecoveryCodes))
+		}
+		recoveryCodes = resp.RecoveryCodes
+
+		if w := postJSON(router, "/auth/2fa/setup", nil, cookie); w.Code != http.StatusConflict {
+			t.Errorf("Expected 409 when setting up again, got %d", w.Code)
+		}
+	})
+
+	t.Run("4. Login returns a challenge instead of a session", func(t *testing.T) {
+		w := login()
+		if w.Code != http.StatusOK {
+			t.Fatalf("Login failed: %d %s", w.Code, w.Body.String())
+		}
+		if tokenCookie(w) != nil {
+			t.Fatal("Expected no session cookie before the second factor")
+		}
+
+		var resp struct {
+			TwoFactorRequired bool   `json:"two_factor_required"`
+			ChallengeToken    string `json:"challenge_token"`
+		}
+		json.Unmarshal(w.Body.Bytes(), &resp)
+		if !resp.TwoFactorRequired || resp.ChallengeToken == "" {
+			t.Fatalf("Expected a challenge, got %s", w.Body.String())
+		}
+		challenge = resp.ChallengeToken
+
+		// The challenge token is not an access token
+		forged := &http.Cookie{Name: "token", Value: challenge}
+		if w := getWithCookies(router, "/api/profile", forged); w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected challenge token to be rejected as access token, got %d", w.Code)
+		}
+	})
+
+	t.Run("5. Wrong code is rejected", func(t *testing.T) {
+		w := postJSON(router, "/auth/login/2fa", map[string]string{"challenge_token": challenge, "code": "000000"})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401, got %d", w.Code)
+		}
+	})
+
+	t.Run("6. TOTP code completes login and cannot be replayed", func(t *testing.T) {
+		code := totpCode(t, secret, 1)
+		w := postJSON(router, "/auth/login/2fa", map[string]string{"challenge_token": challenge, "code": code})
+		if w.Code != http.StatusOK {
+			t.Fatalf("2FA login failed: %d %s", w.Code, w.Body.String())
+		}
+		session := tokenCookie(w)
+		if session == nil {
+			t.Fatal("Expected session cookie after 2FA")
+		}
+		if w := getWithCookies(router, "/api/profile", session); w.Code != http.StatusOK {
+			t.Errorf("Expected profile access, got %d", w.Code)
+		}
+
+		w = postJSON(router, "/auth/login/2fa", map[string]string{"challenge_token": challenge, "code": code})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected replayed code to be rejected, got %d", w.Code)
+		}
+	})
+
+	t.Run("7. Recovery code works once", func(t *testing.T) {
+		code := strings.ToUpper(recoveryCodes[0])
+		w := postJSON(router, "/auth/login/2fa", map[string]string{"challenge_token": challenge, "code": code})
+		if w.Code != http.StatusOK {
+			t.Fatalf("Recovery login failed: %d %s", w.Code, w.Body.String())
+		}
+
+		w = postJSON(router, "/auth/login/2fa", map[string]string{"challenge_token": challenge, "code": code})
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected used recovery code to be rejected, got %d", w.Code)
+		}
+	})
+
+	t.Run("8. Disable requires password and code", func(t *testing.T) {
+		w := postJSON(router, "/auth/2fa/disable", map[string]string{"password": "wrong", "code": recoveryCodes[1]}, cookie)
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("Expected 401 for wrong password, got %d", w.Code)
+		}
+
+		w = postJSON(router, "/auth/2fa/disable", map[string]string{"password": testPassword, "code": recoveryCodes[1]}, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Disable failed: %d %s", w.Code, w.Body.String())
+		}
+
+		if w := login(); tokenCookie(w) == nil {
+			t.Error("Expected password-only login after disabling 2FA")
+		}
+	})
+}
+
+// TestTOTP_RFC6238Vectors checks codes against the SHA-1 test vectors from
+// RFC 6238 appendix B, truncated to six digits
+func TestTOTP_RFC6238Vectors(t *testing.T) {
+	secret := "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ" // "12345678901234567890"
+	vectors := map[int64]string{
+		59:         "287082",
+		1111111109: "081804",
+		1111111111: "050471",
+		1234567890: "005924",
+		2000000000: "279037",
+	}
+
+	for unix, want := range vectors {
+		got, err := totp.Code(secret, time.Unix(unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d) failed: %v", unix, err)
+		}
+		if got != want {
+			t.Errorf("Code(%d) = %s, want %s", unix, got, want)
+		}
+		if _, ok := totp.Validate(secret, want, time.Unix(unix+30, 0)); !ok {
+			t.Errorf("Expected code for %d to validate one step later", unix)
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"backend/audit"
+	"backend/auth"
+	db "backend/database"
+	"backend/llm"
+)
+
+type historyResponse struct {
+	Bucket  string `json:"bucket"`
+	Buckets []struct {
+		Start        time.Time       `json:"start"`
+		End          time.Time       `json:"end"`
+		Requests     int             `json:"requests"`
+		Succeeded    int             `json:"succeeded"`
+		Failed       int             `json:"failed"`
+		InputTokens  int64           `json:"input_tokens"`
+		AvgLatencyMs int64           `json:"avg_latency_ms"`
+		Generations  []db.Generation `json:"generations"`
+	} `json:"buckets"`
+	Pagination struct {
+		Total int `json:"total"`
+	} `json:"pagination"`
+}
+
+// TestE2E_UsageHistory tests the generation log and its paginated,
+// bucketed history
+func TestE2E_UsageHistory(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+	fake := useFakeLLM(t)
+
+	email := "history_user@example.com"
+	defer cleanupTestDB(t, email)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": email, "password": "testpass123"})
+	userID := registeredUserID(t, w)
+	cookie := tokenCookie(w)
+	markEmailVerified(t, email)
+
+	getHistory := func(t *testing.T, query string) historyResponse {
+		w := getWithCookies(router, "/api/usage/history?"+query, cookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("History failed: %d %s", w.Code, w.Body.String())
+		}
+		var history historyResponse
+		json.Unmarshal(w.Body.Bytes(), &history)
+		return history
+	}
+
+	t.Run("1. Every generation is logged with its outcome", func(t *testing.T) {
+		prompt := "Leftover rice,\n  two eggs and " + strings.Repeat("spring onions ", 20)
+		if w := postJSON(router, "/llm", map[string]string{"message": prompt}, cookie); w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		if w := postJSON(router, "/llm/stream", map[string]string{"message": "tofu"}, cookie); w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", w.Code)
+		}
+
+		fake.ToolReply = func(req llm.Request) json.RawMessage { return json.RawMessage(`{"title": ""}`) }
+		postJSON(router, "/llm", map[string]string{"message": "rejected"}, cookie)
+		fake.ToolReply = nil
+
+		fake.Err = errors.New("model unavailable")
+		postJSON(router, "/llm", map[string]string{"message": "failed"}, cookie)
+		fake.Err = nil
+
+		history := getHistory(t, "")
+		if history.Bucket != db.BucketDay || len(history.Buckets) != 1 || history.Pagination.Total != 4 {
+			t.Fatalf("Expected 4 generations in today's bucket, got %+v", history)
+		}
+		today := history.Buckets[0]
+		if today.Requests != 4 || today.Succeeded != 2 || today.Failed != 2 {
+			t.Errorf("Expected 2 succeeded and 2 failed, got %+v", today)
+		}
+
+		generations := today.Generations
+		if len(generations) != 4 {
+			t.Fatalf("Expected 4 generations, got %d", len(generations))
+		}
+		failed, rejected, streamed, first := generations[0], generations[1], generations[2], generations[3]
+		if failed.Status != db.GenerationFailed || failed.Error == nil || *failed.Error != "model unavailable" || failed.Model != nil {
+			t.Errorf("Expected a failed generation without a model, got %+v", failed)
+		}
+		if rejected.Status != db.GenerationRejected || rejected.InputTokens == 0 {
+			t.Errorf("Expected a rejected generation with its tokens, got %+v", rejected)
+		}
+		if streamed.Endpoint != "/llm/stream" || streamed.Status != db.GenerationOK {
+			t.Errorf("Expected the streamed generation, got %+v", streamed)
+		}
+		summary := []rune(first.PromptSummary)
+		if len(summary) != 100 || !strings.HasPrefix(first.PromptSummary, "Leftover rice, two eggs and spring") ||
+			summary[99] != '…' {
+			t.Errorf("Expected a shortened single-line summary, got %q", first.PromptSummary)
+		}
+		if first.Model == nil || *first.Model != "fake-model" || first.InputTokens == 0 || first.OutputTokens == 0 {
+			t.Errorf("Expected the model and tokens, got %+v", first)
+		}
+	})
+
+	t.Run("2. History is paginated and grouped into buckets", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		yesterday := time.Now().UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+		for i, latency := range []int64{100, 300, 500} {
+			err := db.RecordGeneration(ctx, db.Generation{
+				UserID:        userID,
+				Endpoint:      "/llm",
+				PromptSummary: fmt.Sprintf("older %d", i),
+				InputTokens:   10,
+				LatencyMs:     latency,
+				Status:        db.GenerationOK,
+				CreatedAt:     yesterday.Add(time.Duration(i) * time.Hour),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		// The second page holds the last of today and two of yesterday's,
+		// and the bucket totals still cover all of yesterday
+		history := getHistory(t, "page=2&page_size=3")
+		if history.Pagination.Total != 7 || len(history.Buckets) != 2 {
+			t.Fatalf("Expected two buckets of 7 generations, got %+v", history)
+		}
+		older := history.Buckets[1]
+		if !older.Start.Equal(yesterday) || !older.End.Equal(yesterday.AddDate(0, 0, 1)) {
+			t.Errorf("Expected yesterday's bucket, got %s to %s", older.Start, older.End)
+		}
+		if len(older.Generations) != 2 || older.Generations[0].PromptSummary != "older 2" {
+			t.Errorf("Expected the two newest of yesterday on this page, got %+v", older.Generations)
+		}
+		if older.Requests != 3 || older.InputTokens != 30 || older.AvgLatencyMs != 300 {
+			t.Errorf("Expected totals for all of yesterday, got %+v", older)
+		}
+
+		hourly := getHistory(t, "bucket=hour&from="+yesterday.Format(time.DateOnly)+"&to="+yesterday.Format(time.DateOnly))
+		if len(hourly.Buckets) != 3 || hourly.Pagination.Total != 3 || hourly.Buckets[0].End.Sub(hourly.Buckets[0].Start) != time.Hour {
+			t.Errorf("Expected three hourly buckets for yesterday, got %+v", hourly)
+		}
+
+		weekly := getHistory(t, "bucket=week")
+		for _, b := range weekly.Buckets {
+			if b.Start.Weekday() != time.Monday || b.End.Sub(b.Start) != 7*24*time.Hour {
+				t.Errorf("Expected weeks from Monday, got %s to %s", b.Start, b.End)
+			}
+		}
+
+		if w := getWithCookies(router, "/api/usage/history?bucket=month", cookie); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected 400 for an unknown bucket, got %d", w.Code)
+		}
+	})
+
+	t.Run("3. Support can see a user's history", func(t *testing.T) {
+		supportCookie := registerStaff(t, router, "history_support@example.com", auth.RoleSupport)
+		path := fmt.Sprintf("/admin/users/%d/usage/history", userID)
+
+		if w := getWithCookies(router, path, cookie); w.Code != http.StatusForbidden {
+			t.Errorf("Expected users to be refused, got %d", w.Code)
+		}
+		if w := getWithCookies(router, "/admin/users/999999/usage/history", supportCookie); w.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for an unknown user, got %d", w.Code)
+		}
+
+		w := getWithCookies(router, path, supportCookie)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+		}
+		var history historyResponse
+		json.Unmarshal(w.Body.Bytes(), &history)
+		if history.Pagination.Total != 7 {
+			t.Errorf("Expected the user's 7 generations, got %s", w.Body.String())
+		}
+		if auditCount(t, userID, audit.ActionUsageHistoryViewed) != 1 {
+			t.Error("Expected the lookup to be audited")
+		}
+	})
+}
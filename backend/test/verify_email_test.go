@@ -0,0 +1,65 @@
+package test
+
+import (
+	"net/http"
+	"testing"
+
+	"backend/llm"
+)
+
+// useFakeLLM makes the default provider a deterministic fake for the test
+func useFakeLLM(t *testing.T) *llm.FakeProvider {
+	fake := llm.NewFakeProvider()
+	previous := llm.Default
+	llm.Default = fake
+	t.Cleanup(func() { llm.Default = previous })
+	return fake
+}
+
+// TestE2E_EmailVerification tests that unverified users can log in but
+// cannot generate meals until they confirm their email
+func TestE2E_EmailVerification(t *testing.T) {
+	setupTestDB(t)
+	router := setupTestRouter()
+	sender := useCaptureSender(t)
+	useFakeLLM(t)
+
+	testEmail := "verify_test@example.com"
+	defer cleanupTestDB(t, testEmail)
+
+	w := postJSON(router, "/auth/register", map[string]string{"email": testEmail, "password": "testpass123"})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Register failed: %d %s", w.Code, w.Body.String())
+	}
+	session := tokenCookie(w)
+
+	t.Run("1. Unverified user cannot call /llm", func(t *testing.T) {
+		w := postJSON(router, "/llm", map[string]string{"message": "rice"}, session)
+		if w.Code != http.StatusForbidden {
+			t.Fatalf("Expected status 403, got %d. Body: %s", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("2. Verification link unlocks /llm", func(t *testing.T) {
+		msg := sender.last(testEmail)
+		if msg == nil {
+			t.Fatal("Expected a verification email")
+		}
+		match := tokenPattern.FindStringSubmatch(msg.Body)
+		if match == nil {
+			t.Fatalf("No token in email body: %s", msg.Body)
+		}
+
+		if w := getWithCookies(router, "/auth/verify?token="+match[1]); w.Code != http.StatusOK {
+			t.Fatalf("Verify failed: %d %s", w.Code, w.Body.String())
+		}
+		if w := getWithCookies(router, "/auth/verify?token="+match[1]); w.Code != http.StatusBadRequest {
+			t.Errorf("Expected used token to be rejected, got %d", w.Code)
+		}
+
+		w := postJSON(router, "/llm", map[string]string{"message": "rice"}, session)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d. Body: %s", w.Code, w.Body.String())
+		}
+	})
+}